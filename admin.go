@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	adminSessionCookie  = "meetupbot_admin" // adminSessionCookie holds the signed session of the admin UI
+	adminSessionTTL     = 12 * time.Hour    // adminSessionTTL is how long a sign-in lasts
+	telegramLoginMaxAge = 24 * time.Hour    // telegramLoginMaxAge is how old Login Widget data may be
+)
+
+// adminTokenActor is the actor of audit log entries made after signing in with ADMIN_TOKEN.
+// Telegram usernames have at least five characters, so it can't be mistaken for a user.
+var adminTokenActor = &tgbotapi.User{UserName: "web"}
+
+//go:embed web/*.html
+var adminTemplateFiles embed.FS
+
+// adminTemplates are the pages of the admin UI, each executed by its file name
+var adminTemplates = template.Must(template.ParseFS(adminTemplateFiles, "web/*.html"))
+
+// adminNotices are the confirmations shown after a successful action, by the done parameter
+var adminNotices = map[string]string{
+	"saved":    "Event saved.",
+	"removed":  "User removed from the registrations.",
+	"promoted": "User promoted from the waitlist.",
+}
+
+// AdminUI serves the web admin dashboard under /admin/.
+// Admins sign in with ADMIN_TOKEN or with the Telegram Login Widget, in which case
+// their role decides what they can do, like with the bot commands.
+type AdminUI struct {
+	db        Repository
+	bot       Sender
+	token     string // token is ADMIN_TOKEN, empty disables the sign-in with a token
+	botToken  string // botToken verifies the Login Widget data
+	botName   string // botName is the username of the bot for the Login Widget
+	publicURL string // publicURL is PUBLIC_URL, the Login Widget redirects there; empty hides the widget
+	key       []byte // key signs the session cookies and CSRF tokens
+}
+
+// NewAdminUI creates the admin UI. Changing the admin or the bot token signs everybody out.
+func NewAdminUI(db Repository, bot Sender, token, botToken, botName, publicURL string) *AdminUI {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("meetupbot admin session\x00" + botToken))
+	return &AdminUI{db: db, bot: bot, token: token, botToken: botToken, botName: botName, publicURL: publicURL, key: mac.Sum(nil)}
+}
+
+// telegramLogin reports whether the Telegram Login Widget is offered
+func (a *AdminUI) telegramLogin() bool {
+	return a.botName != "" && a.publicURL != ""
+}
+
+// adminSession is the signed-in admin, stored in the session cookie
+type adminSession struct {
+	UserID   int    `json:"id"`       // UserID is the Telegram ID, 0 for a sign-in with ADMIN_TOKEN
+	Username string `json:"username"` // Username is the Telegram username
+	Expires  int64  `json:"exp"`      // Expires is the Unix time the session ends
+	csrf     string // csrf is the token forms of the session must send
+}
+
+// actor returns the Telegram user recorded in the audit log for actions of the session
+func (s *adminSession) actor() *tgbotapi.User {
+	if s.UserID == 0 {
+		return adminTokenActor
+	}
+	return &tgbotapi.User{ID: s.UserID, UserName: s.Username}
+}
+
+// adminPage is the data of every admin template
+type adminPage struct {
+	Title         string
+	User          string
+	CSRF          string
+	Notice        string
+	Error         string
+	Events        []eventSummary
+	Event         *eventSummary
+	Registrations []UserRegistration
+	Waitlist      []WaitlistEntry
+	BotName       string
+	AuthURL       string
+	TokenLogin    bool
+}
+
+// Handler returns the HTTP handler of the admin UI, it expects to be mounted at /admin/
+func (a *AdminUI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/login", a.handleLoginPage)
+	mux.HandleFunc("POST /admin/login", a.handleTokenLogin)
+	mux.HandleFunc("GET /admin/login/telegram", a.handleTelegramLogin)
+	mux.HandleFunc("POST /admin/logout", a.handleLogout)
+	mux.HandleFunc("GET /admin/{$}", a.authorized(PermEvents, a.handleEvents))
+	mux.HandleFunc("GET /admin/events/{id}", a.authorized(PermEvents, a.handleEvent))
+	mux.HandleFunc("GET /admin/events/{id}/stats", a.authorized(PermEvents, a.handleEventStats))
+	mux.HandleFunc("POST /admin/events/{id}", a.authorized(PermEvents, a.handleEventUpdate))
+	mux.HandleFunc("POST /admin/events/{id}/remove", a.authorized(PermRegistrations, a.handleRemove))
+	mux.HandleFunc("POST /admin/events/{id}/promote", a.authorized(PermRegistrations, a.handlePromote))
+	mux.HandleFunc("GET /admin/events/{id}/export", a.authorized(PermExport, a.handleExport))
+	return mux
+}
+
+// authorized wraps a handler that requires a session whose admin has the permission.
+// Forms must send the CSRF token of the session.
+func (a *AdminUI) authorized(perm Permission, next func(http.ResponseWriter, *http.Request, *adminSession)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := a.session(r)
+		if s == nil {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Sign in first", http.StatusUnauthorized)
+			return
+		}
+		// The role is checked on every request, a revoked role takes effect immediately
+		if s.UserID != 0 && !HasPermission(r.Context(), a.db, s.actor(), perm) {
+			http.Error(w, "You don't have permission for this page", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPost && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(s.csrf)) {
+			http.Error(w, "Invalid form, reload the page", http.StatusForbidden)
+			return
+		}
+		next(w, r, s)
+	}
+}
+
+// sign returns the hex HMAC of the parts with the session key
+func (a *AdminUI) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// session returns the valid session of the request, or nil
+func (a *AdminUI) session(r *http.Request) *adminSession {
+	cookie, err := r.Cookie(adminSessionCookie)
+	if err != nil {
+		return nil
+	}
+	payload, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign("session", payload))) {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	var s adminSession
+	if err := json.Unmarshal(data, &s); err != nil || time.Now().Unix() > s.Expires {
+		return nil
+	}
+	s.csrf = a.sign("csrf", cookie.Value)
+	return &s
+}
+
+// startSession sets the session cookie and sends the admin to the dashboard
+func (a *AdminUI) startSession(w http.ResponseWriter, r *http.Request, s adminSession) {
+	s.Expires = time.Now().Add(adminSessionTTL).Unix()
+	data, _ := json.Marshal(s)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    payload + "." + a.sign("session", payload),
+		Path:     "/admin/",
+		Expires:  time.Unix(s.Expires, 0),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+// isHTTPS reports whether the client sees the request as HTTPS, also behind a TLS-terminating proxy
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// render executes a template, the status is sent before so errors can only be logged
+func (a *AdminUI) render(w http.ResponseWriter, status int, name string, page adminPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := adminTemplates.ExecuteTemplate(w, name, page); err != nil {
+		slog.Error("Failed to render admin page", "template", name, "error", err)
+	}
+}
+
+// serverError logs the error and answers with a generic message
+func (a *AdminUI) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Error("Admin UI request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	http.Error(w, "Internal server error, see the bot log", http.StatusInternalServerError)
+}
+
+// handleLoginPage shows the sign-in form and the Telegram Login Widget
+func (a *AdminUI) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	a.render(w, http.StatusOK, "login.html", a.loginPage(r, ""))
+}
+
+// loginPage returns the data of the sign-in page with an optional error.
+// The widget redirects to PUBLIC_URL, the Host header of the request is not trusted.
+func (a *AdminUI) loginPage(r *http.Request, message string) adminPage {
+	page := adminPage{Title: "Sign in", Error: message, TokenLogin: a.token != ""}
+	if a.telegramLogin() {
+		page.BotName = a.botName
+		page.AuthURL = a.publicURL + "/admin/login/telegram"
+	}
+	return page
+}
+
+// handleTokenLogin signs in with ADMIN_TOKEN
+func (a *AdminUI) handleTokenLogin(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		slog.Warn("Admin UI sign-in with a wrong token", "remote_addr", r.RemoteAddr)
+		a.render(w, http.StatusUnauthorized, "login.html", a.loginPage(r, "Wrong token."))
+		return
+	}
+	slog.Info("Admin UI sign-in with the admin token", "remote_addr", r.RemoteAddr)
+	a.startSession(w, r, adminSession{})
+}
+
+// handleTelegramLogin signs in with the data the Telegram Login Widget redirects with
+func (a *AdminUI) handleTelegramLogin(w http.ResponseWriter, r *http.Request) {
+	if !a.telegramLogin() {
+		http.NotFound(w, r)
+		return
+	}
+	user, err := checkTelegramLogin(r.URL.Query(), a.botToken, time.Now())
+	if err != nil {
+		slog.Warn("Admin UI sign-in with invalid Telegram data", "remote_addr", r.RemoteAddr, "error", err)
+		a.render(w, http.StatusUnauthorized, "login.html", a.loginPage(r, "Telegram sign-in failed, try again."))
+		return
+	}
+	if !HasPermission(r.Context(), a.db, user, PermEvents) {
+		slog.Warn("Admin UI sign-in without permission", "user_id", user.ID, "username", user.UserName)
+		a.render(w, http.StatusForbidden, "login.html", a.loginPage(r, "Your Telegram account has no organizer role."))
+		return
+	}
+	slog.Info("Admin UI sign-in with Telegram", "user_id", user.ID, "username", user.UserName)
+	a.startSession(w, r, adminSession{UserID: user.ID, Username: user.UserName})
+}
+
+// handleLogout ends the session. The form sends the CSRF token, so other sites can't sign the admin out.
+func (a *AdminUI) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if s := a.session(r); s != nil && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(s.csrf)) {
+		http.Error(w, "Invalid form, reload the page", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: adminSessionCookie, Path: "/admin/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// checkTelegramLogin verifies the data of the Telegram Login Widget and returns the signed-in user,
+// see https://core.telegram.org/widgets/login#checking-authorization
+func checkTelegramLogin(values url.Values, botToken string, now time.Time) (*tgbotapi.User, error) {
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, errors.New("no hash")
+	}
+	var lines []string
+	for key := range values {
+		if key != "hash" {
+			lines = append(lines, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(lines)
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(hash))) {
+		return nil, errors.New("hash mismatch")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid auth_date")
+	}
+	if now.Sub(time.Unix(authDate, 0)) > telegramLoginMaxAge {
+		return nil, errors.New("data is outdated")
+	}
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+	return &tgbotapi.User{
+		ID:        id,
+		UserName:  values.Get("username"),
+		FirstName: values.Get("first_name"),
+		LastName:  values.Get("last_name"),
+	}, nil
+}
+
+// newPage returns the page data every page of a session starts with
+func (a *AdminUI) newPage(r *http.Request, s *adminSession, title string) adminPage {
+	user := "admin token"
+	if s.UserID != 0 {
+		user = "@" + s.Username
+		if s.Username == "" {
+			user = strconv.Itoa(s.UserID)
+		}
+	}
+	return adminPage{Title: title, User: user, CSRF: s.csrf, Notice: adminNotices[r.URL.Query().Get("done")]}
+}
+
+// adminEvent returns the event in the id path parameter with its counts, or nil if there is none
+func (a *AdminUI) adminEvent(r *http.Request) (*eventSummary, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, nil
+	}
+	events, err := eventSummaries(r.Context(), a.db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].ID == id {
+			return &events[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// handleEvents lists the events
+func (a *AdminUI) handleEvents(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	events, err := eventSummaries(r.Context(), a.db)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	page := a.newPage(r, s, "Events")
+	page.Events = events
+	a.render(w, http.StatusOK, "events.html", page)
+}
+
+// handleEvent shows an event with its registrations and waitlist
+func (a *AdminUI) handleEvent(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	a.renderEvent(w, r, s, http.StatusOK, "")
+}
+
+// renderEvent renders the event page, with an error message after a failed form
+func (a *AdminUI) renderEvent(w http.ResponseWriter, r *http.Request, s *adminSession, status int, message string) {
+	ctx := r.Context()
+	event, err := a.adminEvent(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if event == nil {
+		http.NotFound(w, r)
+		return
+	}
+	registrations, err := a.db.GetEventRegistrations(ctx, event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	waitlist, err := a.db.GetWaitlistForEvent(ctx, event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	page := a.newPage(r, s, event.Name)
+	page.Error = message
+	page.Event = event
+	page.Waitlist = waitlist
+	page.Registrations = registrations
+	a.render(w, status, "event.html", page)
+}
+
+// handleEventStats returns the counts of an event as JSON, the event page polls them
+func (a *AdminUI) handleEventStats(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	event, err := a.adminEvent(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if event == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(event)
+}
+
+// handleEventUpdate saves the name, date and capacity of an event
+func (a *AdminUI) handleEventUpdate(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	ctx := r.Context()
+	event, err := a.adminEvent(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if event == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	dateStr := strings.TrimSpace(r.PostFormValue("date"))
+	date, dateErr := time.Parse("2006-01-02", dateStr)
+	capacity, capacityErr := strconv.Atoi(strings.TrimSpace(r.PostFormValue("capacity")))
+	switch {
+	case name == "":
+		a.renderEvent(w, r, s, http.StatusBadRequest, "The name is required.")
+		return
+	case dateErr != nil:
+		a.renderEvent(w, r, s, http.StatusBadRequest, "The date must be YYYY-MM-DD.")
+		return
+	case capacityErr != nil || capacity <= 0:
+		a.renderEvent(w, r, s, http.StatusBadRequest, "The capacity must be a positive number.")
+		return
+	case capacity < event.Registered:
+		a.renderEvent(w, r, s, http.StatusBadRequest, fmt.Sprintf("%d users are registered, remove some before lowering the capacity.", event.Registered))
+		return
+	}
+
+	if err := a.db.UpdateEvent(ctx, event.ID, name, date, capacity); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	audit(ctx, a.db, AuditEventUpdate, s.actor(), 0, "", event.ID, fmt.Sprintf("%s;%s;%d", name, dateStr, capacity))
+	// New seats go to the waitlist like seats freed by a cancellation
+	if capacity > event.Capacity && capacity > event.Registered {
+		notifyWaitlist(ctx, a.bot, a.db, event.ID)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/events/%d?done=saved", event.ID), http.StatusSeeOther)
+}
+
+// handleRemove cancels the registration of a user like /remove, and notifies the waitlist.
+// The registration is addressed by its row, imported ones may have no Telegram ID.
+// The row is cancelled rather than deleted like /remove does, so it stays in the history of the event.
+func (a *AdminUI) handleRemove(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	ctx := r.Context()
+	event, err := a.adminEvent(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if event == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, _ := strconv.Atoi(r.PostFormValue("registration_id"))
+	reg, err := a.db.GetRegistrationByID(ctx, id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if reg == nil || reg.EventID != event.ID || reg.Registred != 1 {
+		a.renderEvent(w, r, s, http.StatusConflict, "The user is not registered anymore.")
+		return
+	}
+
+	if err := a.db.CancelRegistrationByID(ctx, reg.ID); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if err := a.db.DecrementEventRegistrationCount(ctx, event.ID); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	audit(ctx, a.db, AuditAdminRemove, s.actor(), reg.TelegramID, reg.Username, event.ID, "registered")
+	notifyWaitlist(ctx, a.bot, a.db, event.ID)
+	http.Redirect(w, r, fmt.Sprintf("/admin/events/%d?done=removed", event.ID), http.StatusSeeOther)
+}
+
+// handlePromote registers a user from the waitlist, also when the event is full.
+// Like booking from a waitlist notification, missing mandatory fields are asked in a dialog.
+func (a *AdminUI) handlePromote(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	ctx := r.Context()
+	event, err := a.adminEvent(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if event == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !event.Active {
+		a.renderEvent(w, r, s, http.StatusConflict, "Only the waitlist of the current event can be promoted.")
+		return
+	}
+	telegramID, _ := strconv.Atoi(r.PostFormValue("telegram_id"))
+	waitlist, err := a.db.GetWaitlistForEvent(ctx, event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	var entry *WaitlistEntry
+	for i := range waitlist {
+		if waitlist[i].TelegramID == telegramID {
+			entry = &waitlist[i]
+		}
+	}
+	if entry == nil {
+		a.renderEvent(w, r, s, http.StatusConflict, "The user is not on the waitlist anymore.")
+		return
+	}
+
+	if err := a.promote(ctx, s, event.ID, *entry); err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/events/%d?done=promoted", event.ID), http.StatusSeeOther)
+}
+
+// promote moves a waitlist entry to the registrations and tells the user
+func (a *AdminUI) promote(ctx context.Context, s *adminSession, eventID int, entry WaitlistEntry) error {
+	registered, _, err := a.db.IsUserRegistered(ctx, entry.TelegramID, eventID)
+	if err != nil {
+		return err
+	}
+	_, name, email, err := a.db.HasUserInfo(ctx, entry.TelegramID)
+	if err != nil {
+		return err
+	}
+	if !registered {
+		// Users who never registered before have no name yet
+		if name == "" {
+			name = entry.Username
+		}
+		reg := UserRegistration{
+			TelegramID:       entry.TelegramID,
+			Username:         entry.Username,
+			Name:             name,
+			RegistrationDate: time.Now(),
+			Email:            email,
+			EventID:          eventID,
+			Registred:        1,
+		}
+		if err := a.db.RegisterUser(ctx, reg); err != nil {
+			return err
+		}
+		if err := a.db.UpdateEventRegistrationCount(ctx, eventID); err != nil {
+			return err
+		}
+	}
+	if err := a.db.RemoveFromWaitlist(ctx, entry.TelegramID, eventID); err != nil {
+		return err
+	}
+	audit(ctx, a.db, AuditWaitlistPromote, s.actor(), entry.TelegramID, entry.Username, eventID, "")
+
+	lang := languageForUserID(ctx, a.db, entry.TelegramID)
+	switch {
+	case AppConfig.HasMandatoryField("name") && (name == "" || name == entry.Username):
+		DialogMgr.SetState(entry.TelegramID, WaitingForName, eventID)
+		sendMessage(a.bot, entry.ChatID, T(lang, "booked_ask_name"))
+	case AppConfig.HasMandatoryField("email") && email == "":
+		DialogMgr.SetState(entry.TelegramID, WaitingForEmail, eventID)
+		sendMessage(a.bot, entry.ChatID, T(lang, "booked_ask_email"))
+	default:
+		// Like after booking in the bot, the ticket follows the registration
+		sendMessage(a.bot, entry.ChatID, T(lang, "waitlist_promoted"))
+		event, err := a.db.GetLatestEvent(ctx)
+		if err != nil {
+			return err
+		}
+		if event != nil && event.id == eventID {
+			sendTicket(a.bot, entry.ChatID, lang, event, entry.TelegramID)
+		}
+	}
+	return nil
+}
+
+// handleExport downloads the registrations of an event as CSV or JSON
+func (a *AdminUI) handleExport(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "csv" && format != "json" {
+		http.Error(w, "Unknown format, use csv or json", http.StatusBadRequest)
+		return
+	}
+	rows, err := eventRegistrations(r.Context(), a.db, id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	slog.Info("Admin UI export", "event_id", id, "format", format, "user_id", s.UserID, "username", s.Username)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="registrations_event_%d.%s"`, id, format))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		writeRegistrationsJSON(w, rows)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writeRegistrationsCSV(w, rows)
+}
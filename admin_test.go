@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// signTelegramLogin adds the hash the Telegram Login Widget would send with values
+func signTelegramLogin(values url.Values, botToken string) url.Values {
+	var lines []string
+	for key := range values {
+		lines = append(lines, key+"="+values.Get(key))
+	}
+	sort.Strings(lines)
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return values
+}
+
+// telegramLoginValues returns the Login Widget data of a test user signed at authDate
+func telegramLoginValues(user int, authDate time.Time) url.Values {
+	return signTelegramLogin(url.Values{
+		"id":         {strconv.Itoa(testUsers[user].ID)},
+		"username":   {testUsers[user].UserName},
+		"first_name": {testUsers[user].FirstName},
+		"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+	}, "123:test")
+}
+
+func TestCheckTelegramLogin(t *testing.T) {
+	now := time.Now()
+
+	user, err := checkTelegramLogin(telegramLoginValues(3, now), "123:test", now)
+	if err != nil || user.ID != 3 || user.UserName != "boss" {
+		t.Fatalf("checkTelegramLogin = %+v, %v", user, err)
+	}
+
+	tampered := telegramLoginValues(3, now)
+	tampered.Set("id", "1")
+	if _, err := checkTelegramLogin(tampered, "123:test", now); err == nil {
+		t.Error("tampered data accepted")
+	}
+	if _, err := checkTelegramLogin(telegramLoginValues(3, now), "123:other", now); err == nil {
+		t.Error("data signed for another bot accepted")
+	}
+	if _, err := checkTelegramLogin(telegramLoginValues(3, now.Add(-2*telegramLoginMaxAge)), "123:test", now); err == nil {
+		t.Error("outdated data accepted")
+	}
+}
+
+// adminClient is a browser signed in to the admin UI under test
+type adminClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+}
+
+// get requests path and returns the status and body
+func (c *adminClient) get(path string) (int, string) {
+	c.t.Helper()
+	resp, err := c.client.Get(c.server.URL + path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// post submits a form to path and returns the status and body
+func (c *adminClient) post(path string, form url.Values) (int, string) {
+	c.t.Helper()
+	resp, err := c.client.PostForm(c.server.URL+path, form)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// csrfPattern finds the CSRF token in a page
+var csrfPattern = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// csrf returns the CSRF token from the page at path
+func (c *adminClient) csrf(path string) string {
+	c.t.Helper()
+	_, body := c.get(path)
+	m := csrfPattern.FindStringSubmatch(body)
+	if m == nil {
+		c.t.Fatalf("no CSRF token on %s:\n%s", path, body)
+	}
+	return m[1]
+}
+
+func newAdminClient(t *testing.T, admin *AdminUI) *adminClient {
+	server := httptest.NewServer(admin.Handler())
+	t.Cleanup(server.Close)
+	jar, _ := cookiejar.New(nil)
+	return &adminClient{t: t, server: server, client: &http.Client{Jar: jar}}
+}
+
+func TestAdminUI(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 1))
+	event := mustEvent(t, db)
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", Email: "ivan@example.com", EventID: event.id, Registred: 1}))
+	mustNoError(t, db.UpdateEventRegistrationCount(ctx, event.id))
+	mustNoError(t, db.AddToWaitlist(ctx, 2, 2, "anna", event.id))
+
+	admin := NewAdminUI(db, sender, "secret", "123:test", "meetup_test_bot", "https://meetup.example.com")
+	c := newAdminClient(t, admin)
+	eventPath := "/admin/events/" + strconv.Itoa(event.id)
+
+	if _, body := c.get("/admin/"); !strings.Contains(body, "Admin token") || !strings.Contains(body, "meetup_test_bot") ||
+		!strings.Contains(body, `data-auth-url="https://meetup.example.com/admin/login/telegram"`) {
+		t.Fatalf("dashboard without a session is not the sign-in page:\n%s", body)
+	}
+	if code, _ := c.post("/admin/login", url.Values{"token": {"wrong"}}); code != http.StatusUnauthorized {
+		t.Errorf("sign-in with a wrong token = %d", code)
+	}
+	code, body := c.post("/admin/login", url.Values{"token": {"secret"}})
+	if code != http.StatusOK || !strings.Contains(body, "Meetup") || !strings.Contains(body, "1</span> / 1") {
+		t.Fatalf("dashboard after sign-in = %d:\n%s", code, body)
+	}
+
+	t.Run("Stats", func(t *testing.T) {
+		_, body := c.get(eventPath + "/stats")
+		var stats eventSummary
+		if err := json.Unmarshal([]byte(body), &stats); err != nil || stats.Registered != 1 || stats.Waitlist != 1 || !stats.Active {
+			t.Errorf("stats = %+v, %v", stats, err)
+		}
+	})
+
+	t.Run("CSRF", func(t *testing.T) {
+		if code, _ := c.post(eventPath+"/remove", url.Values{"registration_id": {"1"}}); code != http.StatusForbidden {
+			t.Errorf("remove without CSRF token = %d", code)
+		}
+		if registered, _, _ := db.IsUserRegistered(ctx, 1, event.id); !registered {
+			t.Error("user removed without CSRF token")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		sender.reset()
+		code, body := c.post(eventPath+"/remove", url.Values{"csrf": {c.csrf(eventPath)}, "registration_id": {"1"}})
+		if code != http.StatusOK || !strings.Contains(body, adminNotices["removed"]) {
+			t.Fatalf("remove = %d:\n%s", code, body)
+		}
+		if event := mustEvent(t, db); event.registrationCount != 0 {
+			t.Errorf("registrationCount = %d, want 0", event.registrationCount)
+		}
+		if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "waitlist_spot_available") {
+			t.Errorf("messages = %q, want the waitlist notified", texts)
+		}
+		entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditAdminRemove)})
+		if len(entries) != 1 || entries[0].ActorUsername != "web" || entries[0].TargetID != 1 {
+			t.Errorf("audit entries = %+v", entries)
+		}
+	})
+
+	t.Run("Promote", func(t *testing.T) {
+		sender.reset()
+		code, body := c.post(eventPath+"/promote", url.Values{"csrf": {c.csrf(eventPath)}, "telegram_id": {"2"}})
+		if code != http.StatusOK || !strings.Contains(body, adminNotices["promoted"]) {
+			t.Fatalf("promote = %d:\n%s", code, body)
+		}
+		if registered, _, _ := db.IsUserRegistered(ctx, 2, event.id); !registered {
+			t.Error("anna is not registered")
+		}
+		if inWaitlist, _ := db.IsUserInWaitlist(ctx, 2, event.id); inWaitlist {
+			t.Error("anna is still on the waitlist")
+		}
+		// anna has no saved name, the bot asks for it like after booking from the waitlist
+		if len(sender.messages) != 1 || sender.messages[0].ChatID != 2 || sender.messages[0].Text != T("en", "booked_ask_name") {
+			t.Errorf("messages = %+v", sender.messages)
+		}
+		if state, eventID := DialogMgr.GetState(2); state != WaitingForName || eventID != event.id {
+			t.Errorf("dialog state = %v for event %d, want WaitingForName", state, eventID)
+		}
+	})
+
+	t.Run("Edit", func(t *testing.T) {
+		form := url.Values{"csrf": {c.csrf(eventPath)}, "name": {"Go Meetup"}, "date": {"2030-05-01"}, "capacity": {"0"}}
+		if code, body := c.post(eventPath, form); code != http.StatusBadRequest || !strings.Contains(body, "capacity must be") {
+			t.Errorf("edit with capacity 0 = %d:\n%s", code, body)
+		}
+		form.Set("capacity", "20")
+		if code, body := c.post(eventPath, form); code != http.StatusOK || !strings.Contains(body, adminNotices["saved"]) {
+			t.Fatalf("edit = %d:\n%s", code, body)
+		}
+		if event := mustEvent(t, db); event.name != "Go Meetup" || event.capacity != 20 || event.date.Format("2006-01-02") != "2030-05-01" {
+			t.Errorf("event after edit = %+v", event)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		code, body := c.get(eventPath + "/export?format=json")
+		var rows []exportedRegistration
+		if err := json.Unmarshal([]byte(body), &rows); code != http.StatusOK || err != nil || len(rows) != 2 {
+			t.Errorf("export = %d, %+v, %v", code, rows, err)
+		}
+		if code, _ := c.get(eventPath + "/export?format=xml"); code != http.StatusBadRequest {
+			t.Errorf("export as xml = %d", code)
+		}
+	})
+
+	t.Run("RemoveImported", func(t *testing.T) {
+		// Imported registrations without a Telegram ID are removed one by one
+		mustNoError(t, db.ImportRegistrations(ctx, []RegistrationImport{{EventID: event.id, Registrations: []UserRegistration{
+			{Username: "petr", Name: "Sidorov Petr", Registred: 1},
+			{Name: "Smirnov Oleg", Registred: 1},
+		}}}))
+		registrations, _ := db.GetEventRegistrations(ctx, event.id)
+		var imported []UserRegistration
+		for _, reg := range registrations {
+			if reg.TelegramID == 0 {
+				imported = append(imported, reg)
+			}
+		}
+		if len(imported) != 2 {
+			t.Fatalf("imported registrations = %+v", imported)
+		}
+		before := mustEvent(t, db).registrationCount
+
+		code, body := c.post(eventPath+"/remove", url.Values{"csrf": {c.csrf(eventPath)}, "registration_id": {strconv.Itoa(imported[0].ID)}})
+		if code != http.StatusOK || !strings.Contains(body, adminNotices["removed"]) {
+			t.Fatalf("remove = %d:\n%s", code, body)
+		}
+		removed, _ := db.GetRegistrationByID(ctx, imported[0].ID)
+		kept, _ := db.GetRegistrationByID(ctx, imported[1].ID)
+		if removed.Registred != 0 || kept.Registred != 1 || mustEvent(t, db).registrationCount != before-1 {
+			t.Errorf("after removing %s: removed %+v, kept %+v", imported[0].Name, removed, kept)
+		}
+		if code, _ := c.post(eventPath+"/remove", url.Values{"csrf": {c.csrf(eventPath)}, "registration_id": {strconv.Itoa(imported[0].ID)}}); code != http.StatusConflict {
+			t.Errorf("removing twice = %d", code)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		csrf := c.csrf(eventPath)
+		if code, _ := c.post("/admin/logout", nil); code != http.StatusForbidden {
+			t.Errorf("sign-out without CSRF token = %d", code)
+		}
+		if _, body := c.get("/admin/"); strings.Contains(body, "Admin token") {
+			t.Fatal("signed out without CSRF token")
+		}
+		c.post("/admin/logout", url.Values{"csrf": {csrf}})
+		if _, body := c.get("/admin/"); !strings.Contains(body, "Admin token") {
+			t.Errorf("dashboard after sign-out:\n%s", body)
+		}
+	})
+}
+
+// TestAdminUIPromoteSendsTicket checks that a promoted user with nothing left to fill in gets the ticket right away
+func TestAdminUIPromoteSendsTicket(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	event := mustEvent(t, db)
+	// olga cancelled her registration before, her name and email are kept
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 4, Username: "olga", Name: "Ivanova Olga", Email: "olga@example.com", EventID: event.id}))
+	mustNoError(t, db.AddToWaitlist(ctx, 4, 4, "olga", event.id))
+	c := newAdminClient(t, NewAdminUI(db, sender, "secret", "123:test", "meetup_test_bot", ""))
+	c.post("/admin/login", url.Values{"token": {"secret"}})
+	eventPath := "/admin/events/" + strconv.Itoa(event.id)
+
+	sender.reset()
+	if code, _ := c.post(eventPath+"/promote", url.Values{"csrf": {c.csrf(eventPath)}, "telegram_id": {"4"}}); code != http.StatusOK {
+		t.Fatalf("promote = %d", code)
+	}
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "waitlist_promoted") {
+		t.Errorf("messages = %q", texts)
+	}
+	if len(sender.other) != 1 {
+		t.Fatalf("sent %+v, want the ticket", sender.other)
+	}
+	if photo, ok := sender.other[0].(tgbotapi.PhotoConfig); !ok || photo.ChatID != 4 || photo.Caption != T("en", "ticket_caption", "Meetup", event.date.Format("02.01.2006")) {
+		t.Errorf("ticket = %+v", sender.other[0])
+	}
+}
+
+func TestAdminUITelegramLogin(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now(), 10))
+	c := newAdminClient(t, NewAdminUI(db, sender, "", "123:test", "meetup_test_bot", "https://meetup.example.com"))
+
+	// Without ADMIN_TOKEN only the Login Widget is offered
+	if code, _ := c.post("/admin/login", url.Values{"token": {""}}); code != http.StatusUnauthorized {
+		t.Errorf("sign-in with an empty token = %d", code)
+	}
+	if code, _ := c.get("/admin/login/telegram?" + telegramLoginValues(1, time.Now()).Encode()); code != http.StatusForbidden {
+		t.Errorf("sign-in of a user without a role = %d", code)
+	}
+
+	// boss is in ADMIN_USERS and becomes an owner
+	code, body := c.get("/admin/login/telegram?" + telegramLoginValues(3, time.Now()).Encode())
+	if code != http.StatusOK || !strings.Contains(body, "@boss") || !strings.Contains(body, "Meetup") {
+		t.Fatalf("dashboard after Telegram sign-in = %d:\n%s", code, body)
+	}
+
+	// A volunteer may not open the dashboard, the role is checked on every request
+	mustNoError(t, db.SetUserRole(ctx, UserRole{TelegramID: 3, Username: "boss", Role: string(RoleVolunteer)}))
+	if code, _ := c.get("/admin/"); code != http.StatusForbidden {
+		t.Errorf("dashboard of a volunteer = %d", code)
+	}
+
+	// Without PUBLIC_URL the widget has no callback to send the user to
+	c = newAdminClient(t, NewAdminUI(db, sender, "secret", "123:test", "meetup_test_bot", ""))
+	if _, body := c.get("/admin/"); strings.Contains(body, "telegram-widget") {
+		t.Errorf("sign-in page without PUBLIC_URL offers the widget:\n%s", body)
+	}
+	if code, _ := c.get("/admin/login/telegram?" + telegramLoginValues(3, time.Now()).Encode()); code != http.StatusNotFound {
+		t.Errorf("Telegram sign-in without PUBLIC_URL = %d", code)
+	}
+}
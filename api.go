@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so a leaked key is easy to recognize
+const apiKeyPrefix = "mbk_"
+
+const (
+	APIScopeEvents = "events" // APIScopeEvents reads events and their counts, safe for a public website
+	APIScopeFull   = "full"   // APIScopeFull also reads registrations, the waitlist and check-ins with personal data
+)
+
+const (
+	apiDefaultPerPage = 50  // apiDefaultPerPage is the page size without a per_page parameter
+	apiMaxPerPage     = 200 // apiMaxPerPage is the largest allowed page size
+)
+
+// openAPISpec describes the API, it is served at /api/v1/openapi.json
+//
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// newAPIKey returns a new random API key
+func newAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the hash an API key is stored and looked up by
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validAPIScope checks if scope is a known API key scope
+func validAPIScope(scope string) bool {
+	return scope == APIScopeEvents || scope == APIScopeFull
+}
+
+// API serves the read-only JSON API under /api/v1/. Every request except the
+// OpenAPI description needs an API key, see the apikeys subcommands.
+type API struct {
+	db Repository
+}
+
+// NewAPI creates the API on the repository
+func NewAPI(db Repository) *API {
+	return &API{db: db}
+}
+
+// apiRoute is an endpoint of the API and the scope its key needs, an empty scope needs no key
+type apiRoute struct {
+	pattern string
+	scope   string
+	handler http.HandlerFunc
+}
+
+// routes lists the endpoints, the OpenAPI description documents each of them
+func (a *API) routes() []apiRoute {
+	return []apiRoute{
+		{"GET /api/v1/openapi.json", "", a.handleOpenAPI},
+		{"GET /api/v1/events", APIScopeEvents, a.handleEvents},
+		{"GET /api/v1/events/current", APIScopeEvents, a.handleCurrentEvent},
+		{"GET /api/v1/events/{id}", APIScopeEvents, a.handleEvent},
+		{"GET /api/v1/events/{id}/registrations", APIScopeFull, a.handleRegistrations},
+		{"GET /api/v1/events/{id}/waitlist", APIScopeFull, a.handleWaitlist},
+		{"GET /api/v1/events/{id}/checkins", APIScopeFull, a.handleCheckins},
+	}
+}
+
+// Handler returns the HTTP handler of the API, it expects to be mounted at /api/
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range a.routes() {
+		handler := route.handler
+		if route.scope != "" {
+			handler = a.authorized(route.scope, handler)
+		}
+		mux.HandleFunc(route.pattern, handler)
+	}
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIJSONError(w, http.StatusNotFound, "not found")
+	})
+
+	// Websites may call the API from the browser with an events key
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-API-Key")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized wraps a handler that requires an active API key with the scope.
+// A full key can do everything an events key can.
+func (a *API) authorized(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if token == "" {
+			writeAPIJSONError(w, http.StatusUnauthorized, "missing API key")
+			return
+		}
+		key, err := a.db.GetAPIKeyByHash(r.Context(), hashAPIKey(token))
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		if key == nil || !key.RevokedAt.IsZero() {
+			writeAPIJSONError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		if scope == APIScopeFull && key.Scope != APIScopeFull {
+			writeAPIJSONError(w, http.StatusForbidden, "the API key has no access to personal data")
+			return
+		}
+		slog.Debug("API request", "method", r.Method, "path", r.URL.Path, "api_key", key.Name)
+		next(w, r)
+	}
+}
+
+// apiPage is a page of a list, with what a client needs to fetch the others
+type apiPage struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+}
+
+// apiWaitlistEntry is a waitlist entry as returned by the API
+type apiWaitlistEntry struct {
+	Position   int       `json:"position"`
+	TelegramID int       `json:"telegram_id"`
+	Username   string    `json:"username"`
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+// paginate returns the page of items the page and per_page query parameters select
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T) (apiPage, bool) {
+	page, perPage := 1, apiDefaultPerPage
+	if s := r.URL.Query().Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeAPIJSONError(w, http.StatusBadRequest, "page must be a positive number")
+			return apiPage{}, false
+		}
+		page = n
+	}
+	if s := r.URL.Query().Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeAPIJSONError(w, http.StatusBadRequest, "per_page must be between 1 and "+strconv.Itoa(apiMaxPerPage))
+			return apiPage{}, false
+		}
+		perPage = n
+	}
+	// Pages past the end are empty, checked before multiplying so a huge page can't overflow
+	start := len(items)
+	if page-1 < len(items)/perPage+1 {
+		start = min((page-1)*perPage, len(items))
+	}
+	end := min(start+perPage, len(items))
+	return apiPage{Data: items[start:end], Page: page, PerPage: perPage, Total: len(items)}, true
+}
+
+// writeAPIJSON writes a successful JSON response
+func writeAPIJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIJSONError writes an error response like {"error": "..."}
+func writeAPIJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// serverError logs the error and answers with a generic message
+func (a *API) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Error("API request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeAPIJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// event returns the event in the id path parameter, it answers with 404 if there is none
+func (a *API) event(w http.ResponseWriter, r *http.Request) (*eventSummary, bool) {
+	events, err := eventSummaries(r.Context(), a.db)
+	if err != nil {
+		a.serverError(w, r, err)
+		return nil, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		for i := range events {
+			if events[i].ID == id {
+				return &events[i], true
+			}
+		}
+	}
+	writeAPIJSONError(w, http.StatusNotFound, "event not found")
+	return nil, false
+}
+
+// handleOpenAPI serves the OpenAPI description
+func (a *API) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// handleEvents lists the events, newest first
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := eventSummaries(r.Context(), a.db)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if page, ok := paginate(w, r, events); ok {
+		writeAPIJSON(w, page)
+	}
+}
+
+// handleCurrentEvent returns the active event, the one users register for in the bot
+func (a *API) handleCurrentEvent(w http.ResponseWriter, r *http.Request) {
+	events, err := eventSummaries(r.Context(), a.db)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	for _, event := range events {
+		if event.Active {
+			writeAPIJSON(w, event)
+			return
+		}
+	}
+	writeAPIJSONError(w, http.StatusNotFound, "no active event")
+}
+
+// handleEvent returns an event
+func (a *API) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if event, ok := a.event(w, r); ok {
+		writeAPIJSON(w, event)
+	}
+}
+
+// handleRegistrations lists the registrations of an event, cancelled ones included
+func (a *API) handleRegistrations(w http.ResponseWriter, r *http.Request) {
+	event, ok := a.event(w, r)
+	if !ok {
+		return
+	}
+	rows, err := eventRegistrations(r.Context(), a.db, event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if page, ok := paginate(w, r, rows); ok {
+		writeAPIJSON(w, page)
+	}
+}
+
+// handleCheckins lists the users checked in at an event, walk-ins included
+func (a *API) handleCheckins(w http.ResponseWriter, r *http.Request) {
+	event, ok := a.event(w, r)
+	if !ok {
+		return
+	}
+	rows, err := eventRegistrations(r.Context(), a.db, event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	checkins := []exportedRegistration{}
+	for _, row := range rows {
+		if row.Visited {
+			checkins = append(checkins, row)
+		}
+	}
+	if page, ok := paginate(w, r, checkins); ok {
+		writeAPIJSON(w, page)
+	}
+}
+
+// handleWaitlist lists the waitlist of an event in order
+func (a *API) handleWaitlist(w http.ResponseWriter, r *http.Request) {
+	event, ok := a.event(w, r)
+	if !ok {
+		return
+	}
+	waitlist, err := a.db.GetWaitlistForEvent(r.Context(), event.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	entries := make([]apiWaitlistEntry, 0, len(waitlist))
+	for i, entry := range waitlist {
+		entries = append(entries, apiWaitlistEntry{
+			Position:   i + 1,
+			TelegramID: entry.TelegramID,
+			Username:   entry.Username,
+			JoinedAt:   entry.JoinedDate,
+		})
+	}
+	if page, ok := paginate(w, r, entries); ok {
+		writeAPIJSON(w, page)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiGet requests path with the API key and decodes the JSON response into v
+func apiGet(t *testing.T, server *httptest.Server, path, key string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// addTestAPIKey stores an API key with the scope and returns it
+func addTestAPIKey(t *testing.T, db Repository, name, scope string) string {
+	t.Helper()
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddAPIKey(context.Background(), APIKey{Name: name, Hash: hashAPIKey(key), Scope: scope, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAPI(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	now := time.Now().Truncate(time.Second)
+	mustNoError(t, db.AddEvent(ctx, "Past", now.AddDate(0, -1, 0), 50))
+	mustNoError(t, db.MarkEventsAsPast(ctx))
+	mustNoError(t, db.AddEvent(ctx, "Current", now.AddDate(0, 0, 7), 2))
+	event := mustEvent(t, db)
+	for _, reg := range []UserRegistration{
+		{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", Email: "ivan@example.com", EventID: event.id, Registred: 1, Visited: 1},
+		{TelegramID: 2, Username: "anna", Name: "Petrova Anna", EventID: event.id, Registred: 1},
+	} {
+		mustNoError(t, db.RegisterUser(ctx, reg))
+		mustNoError(t, db.UpdateEventRegistrationCount(ctx, event.id))
+	}
+	mustNoError(t, db.AddToWaitlist(ctx, 3, 3, "boss", event.id))
+
+	eventsKey := addTestAPIKey(t, db, "website", APIScopeEvents)
+	fullKey := addTestAPIKey(t, db, "crm", APIScopeFull)
+	revokedKey := addTestAPIKey(t, db, "old", APIScopeFull)
+	keys, _ := db.GetAPIKeys(ctx)
+	mustNoError(t, db.RevokeAPIKey(ctx, keys[2].ID, now))
+
+	server := httptest.NewServer(NewAPI(db).Handler())
+	defer server.Close()
+
+	t.Run("Auth", func(t *testing.T) {
+		var body map[string]string
+		if code := apiGet(t, server, "/api/v1/events", "", &body); code != http.StatusUnauthorized || body["error"] == "" {
+			t.Errorf("without a key = %d, %v", code, body)
+		}
+		if code := apiGet(t, server, "/api/v1/events", "mbk_unknown", nil); code != http.StatusUnauthorized {
+			t.Errorf("with an unknown key = %d", code)
+		}
+		if code := apiGet(t, server, "/api/v1/events", revokedKey, nil); code != http.StatusUnauthorized {
+			t.Errorf("with a revoked key = %d", code)
+		}
+		if code := apiGet(t, server, "/api/v1/events/1/registrations", eventsKey, nil); code != http.StatusForbidden {
+			t.Errorf("registrations with an events key = %d", code)
+		}
+
+		// The X-API-Key header works too
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/events", nil)
+		req.Header.Set("X-API-Key", eventsKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("with X-API-Key = %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		var page struct {
+			apiPage
+			Data []eventSummary `json:"data"`
+		}
+		if code := apiGet(t, server, "/api/v1/events", eventsKey, &page); code != http.StatusOK {
+			t.Fatalf("events = %d", code)
+		}
+		if page.Total != 2 || len(page.Data) != 2 || page.Data[0].Name != "Current" || page.Data[0].SeatsLeft != 0 || page.Data[0].Waitlist != 1 || page.Data[0].CheckedIn != 1 {
+			t.Errorf("events = %+v", page)
+		}
+
+		apiGet(t, server, "/api/v1/events?page=2&per_page=1", eventsKey, &page)
+		if page.Total != 2 || page.Page != 2 || len(page.Data) != 1 || page.Data[0].Name != "Past" || page.Data[0].Active {
+			t.Errorf("second page = %+v", page)
+		}
+		apiGet(t, server, "/api/v1/events?page=5", eventsKey, &page)
+		if len(page.Data) != 0 {
+			t.Errorf("page after the last = %+v", page)
+		}
+		if code := apiGet(t, server, "/api/v1/events?page=4611686018427387904&per_page=200", eventsKey, &page); code != http.StatusOK || len(page.Data) != 0 {
+			t.Errorf("page whose offset overflows = %d, %+v", code, page)
+		}
+		if code := apiGet(t, server, "/api/v1/events?per_page=1000", eventsKey, nil); code != http.StatusBadRequest {
+			t.Errorf("per_page=1000 = %d", code)
+		}
+
+		var current eventSummary
+		if code := apiGet(t, server, "/api/v1/events/current", eventsKey, &current); code != http.StatusOK || current.ID != event.id || current.Registered != 2 {
+			t.Errorf("current event = %d, %+v", code, current)
+		}
+		if code := apiGet(t, server, "/api/v1/events/99", eventsKey, nil); code != http.StatusNotFound {
+			t.Errorf("unknown event = %d", code)
+		}
+	})
+
+	t.Run("PersonalData", func(t *testing.T) {
+		var registrations struct {
+			Data []exportedRegistration `json:"data"`
+		}
+		apiGet(t, server, "/api/v1/events/2/registrations", fullKey, &registrations)
+		if len(registrations.Data) != 2 || registrations.Data[0].EventID != event.id {
+			t.Errorf("registrations = %+v", registrations)
+		}
+
+		var checkins struct {
+			Data []exportedRegistration `json:"data"`
+		}
+		apiGet(t, server, "/api/v1/events/2/checkins", fullKey, &checkins)
+		if len(checkins.Data) != 1 || checkins.Data[0].Username != "ivan" {
+			t.Errorf("checkins = %+v", checkins)
+		}
+
+		var waitlist struct {
+			Data []apiWaitlistEntry `json:"data"`
+		}
+		apiGet(t, server, "/api/v1/events/2/waitlist", fullKey, &waitlist)
+		if len(waitlist.Data) != 1 || waitlist.Data[0].Position != 1 || waitlist.Data[0].Username != "boss" {
+			t.Errorf("waitlist = %+v", waitlist)
+		}
+	})
+
+	t.Run("CORS", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, server.URL+"/api/v1/events", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("preflight = %d, %v", resp.StatusCode, resp.Header)
+		}
+	})
+}
+
+// TestOpenAPIDocumentsRoutes checks that the OpenAPI description and the routes agree
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	routes := NewAPI(NewMemoryRepository()).routes()
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route.pattern, " ")
+		path = strings.TrimPrefix(path, "/api/v1")
+		if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("%s is not documented", route.pattern)
+		}
+	}
+	if len(spec.Paths) != len(routes) {
+		t.Errorf("the description has %d paths, the API %d routes", len(spec.Paths), len(routes))
+	}
+
+	server := httptest.NewServer(NewAPI(NewMemoryRepository()).Handler())
+	defer server.Close()
+	var served map[string]interface{}
+	if code := apiGet(t, server, "/api/v1/openapi.json", "", &served); code != http.StatusOK || served["openapi"] != "3.0.3" {
+		t.Errorf("openapi.json = %d, %v", code, served["openapi"])
+	}
+}
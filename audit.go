@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// AuditAction names a state-changing action recorded in the audit log
+type AuditAction string
+
+const (
+	AuditRegister           AuditAction = "register"            // User registered for an event
+	AuditRegistrationUpdate AuditAction = "registration_update" // Registered user pressed "register" again
+	AuditProfileUpdate      AuditAction = "profile_update"      // User entered name or email in the dialog
+	AuditCancel             AuditAction = "cancel"              // User cancelled their registration
+	AuditDialogCancel       AuditAction = "dialog_cancel"       // Registration removed because the dialog was abandoned
+	AuditAdminRemove        AuditAction = "admin_remove"        // Admin removed a user with /remove
+	AuditWaitlistJoin       AuditAction = "waitlist_join"       // User joined the waitlist
+	AuditWaitlistLeave      AuditAction = "waitlist_leave"      // User left the waitlist
+	AuditWaitlistBook       AuditAction = "waitlist_book"       // User booked a freed spot from the waitlist
+	AuditCheckin            AuditAction = "checkin"             // User was marked as visited
+	AuditEventCreate        AuditAction = "event_create"        // Admin created an event
+	AuditEventUpdate        AuditAction = "event_update"        // Admin changed an event in the admin UI
+	AuditWaitlistPromote    AuditAction = "waitlist_promote"    // Admin registered a user from the waitlist
+	AuditRoleGrant          AuditAction = "role_grant"          // Owner granted a role
+	AuditRoleRevoke         AuditAction = "role_revoke"         // Owner revoked a role
+	AuditTemplateUpdate     AuditAction = "template_update"     // Admin saved or reset a message template
+	AuditUserBan            AuditAction = "user_ban"            // Admin banned a user
+	AuditUserUnban          AuditAction = "user_unban"          // Admin lifted a ban
+	AuditAPIKeyCreate       AuditAction = "api_key_create"      // Admin created an API key
+	AuditAPIKeyRevoke       AuditAction = "api_key_revoke"      // Admin revoked an API key
+	AuditImport             AuditAction = "import"              // Admin imported registrations from a CSV file
+	AuditReferral           AuditAction = "referral"            // User started the bot with the link of another user
+	AuditCheckinUndo        AuditAction = "checkin_undo"        // Volunteer took back a check-in in the check-in mode
+	AuditImportClaim        AuditAction = "import_claim"        // User got the imported registrations of their username on /start
+)
+
+// defaultAuditLimit is the number of entries /log shows when no limit is given
+const defaultAuditLimit = 20
+
+// removedNotRegistered is the details of an AuditAdminRemove that found no registration to cancel
+const removedNotRegistered = "not registered"
+
+// audit appends an entry to the audit log.
+// A failure to write the log is logged but never interrupts the action itself.
+func audit(ctx context.Context, db Repository, action AuditAction, actor *tgbotapi.User, targetID int, targetUsername string, eventID int, details string) {
+	entry := AuditEntry{
+		CreatedAt:      time.Now(),
+		Action:         string(action),
+		TargetID:       targetID,
+		TargetUsername: targetUsername,
+		EventID:        eventID,
+		Details:        details,
+	}
+	if actor != nil {
+		entry.ActorID = actor.ID
+		entry.ActorUsername = actor.UserName
+	}
+	if err := db.AddAuditEntry(ctx, entry); err != nil {
+		slog.Error("Failed to write audit log entry", "action", action, "user_id", targetID, "event_id", eventID, "error", err)
+	}
+	// A removal that cancelled nothing is kept for the log only, it is no cancellation to count or report
+	if action == AuditAdminRemove && details == removedNotRegistered {
+		return
+	}
+	Metrics.observeAction(action, details)
+	enqueueWebhooks(ctx, db, entry)
+}
+
+// auditSelf records an action a user performed on their own registration
+func auditSelf(ctx context.Context, db Repository, action AuditAction, user *tgbotapi.User, eventID int, details string) {
+	audit(ctx, db, action, user, user.ID, user.UserName, eventID, details)
+}
+
+// parseAuditFilter parses /log arguments like "action=cancel user=@name event=3 since=2024-01-31 limit=50 csv".
+// Returns the filter and whether a CSV export was requested.
+func parseAuditFilter(args string) (AuditFilter, bool, error) {
+	filter := AuditFilter{Limit: defaultAuditLimit}
+	asCSV, limited := false, false
+	for _, arg := range strings.Fields(args) {
+		if strings.ToLower(arg) == "csv" {
+			asCSV = true
+			continue
+		}
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return filter, false, fmt.Errorf("invalid filter %q", arg)
+		}
+		key, value := strings.ToLower(parts[0]), parts[1]
+		switch key {
+		case "action":
+			filter.Action = value
+		case "user":
+			value = strings.TrimPrefix(value, "@")
+			if id, err := strconv.Atoi(value); err == nil {
+				filter.UserID = id
+			} else {
+				filter.Username = value
+			}
+		case "event":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, false, fmt.Errorf("invalid event %q", value)
+			}
+			filter.EventID = id
+		case "since":
+			since, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return filter, false, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+			}
+			filter.Since = since
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return filter, false, fmt.Errorf("invalid limit %q", value)
+			}
+			filter.Limit = limit
+			limited = true
+		default:
+			return filter, false, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	// The CSV export is for the whole log unless a limit was given explicitly
+	if asCSV && !limited {
+		filter.Limit = 0
+	}
+	return filter, asCSV, nil
+}
+
+// formatAuditUser formats the user of an audit entry as @username or the Telegram ID
+func formatAuditUser(id int, username string) string {
+	if username != "" {
+		return "@" + username
+	}
+	if id == 0 {
+		return "-"
+	}
+	return strconv.Itoa(id)
+}
+
+// formatAuditEntry formats an entry as a single line for the /log message
+func formatAuditEntry(entry AuditEntry) string {
+	line := entry.CreatedAt.Format("02.01.2006 15:04:05") + " " + entry.Action + " " +
+		formatAuditUser(entry.ActorID, entry.ActorUsername)
+	if entry.TargetID != entry.ActorID || entry.TargetUsername != entry.ActorUsername {
+		line += " → " + formatAuditUser(entry.TargetID, entry.TargetUsername)
+	}
+	if entry.EventID != 0 {
+		line += " #" + strconv.Itoa(entry.EventID)
+	}
+	if entry.Details != "" {
+		line += " (" + entry.Details + ")"
+	}
+	return line
+}
+
+// writeAuditCSV writes the audit log entries as CSV
+func writeAuditCSV(buf *bytes.Buffer, entries []AuditEntry) error {
+	// Write UTF-8 BOM for better Excel compatibility
+	buf.WriteString("\xEF\xBB\xBF")
+
+	writer := csv.NewWriter(buf)
+	header := []string{"id", "created_at", "action", "actor_id", "actor_username", "target_id", "target_username", "event_id", "details"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row := []string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.CreatedAt.Format(time.RFC3339),
+			entry.Action,
+			strconv.Itoa(entry.ActorID),
+			entry.ActorUsername,
+			strconv.Itoa(entry.TargetID),
+			entry.TargetUsername,
+			strconv.Itoa(entry.EventID),
+			entry.Details,
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
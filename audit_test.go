@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	for _, test := range []struct {
+		args  string
+		want  AuditFilter
+		asCSV bool
+	}{
+		{"", AuditFilter{Limit: defaultAuditLimit}, false},
+		{"action=cancel user=@Ivan event=3 limit=5", AuditFilter{Action: "cancel", Username: "Ivan", EventID: 3, Limit: 5}, false},
+		{"user=42 since=2024-01-31", AuditFilter{UserID: 42, Since: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), Limit: defaultAuditLimit}, false},
+		// The CSV export is the whole log unless limited explicitly
+		{"CSV", AuditFilter{}, true},
+		{"csv limit=100", AuditFilter{Limit: 100}, true},
+		{"CSV LIMIT=5", AuditFilter{Limit: 5}, true},
+	} {
+		filter, asCSV, err := parseAuditFilter(test.args)
+		if err != nil || filter != test.want || asCSV != test.asCSV {
+			t.Errorf("parseAuditFilter(%q) = %+v, %v, %v", test.args, filter, asCSV, err)
+		}
+	}
+
+	for _, args := range []string{"cancel", "event=x", "since=31.01.2024", "limit=0", "limit=-1", "color=red"} {
+		if _, _, err := parseAuditFilter(args); err == nil {
+			t.Errorf("parseAuditFilter(%q) accepted", args)
+		}
+	}
+}
+
+func TestLogCommand(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	send := func(user int, text string) []string {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: text}.update(1))
+		return sender.texts()
+	}
+
+	if texts := send(3, "/log"); len(texts) != 1 || texts[0] != T("en", "log_empty") {
+		t.Errorf("/log of an empty log = %q", texts)
+	}
+	auditSelf(ctx, db, AuditRegister, testUsers[1], 1, "")
+	auditSelf(ctx, db, AuditCancel, testUsers[1], 1, "")
+	audit(ctx, db, AuditAdminRemove, testUsers[3], 2, "anna", 2, "registered")
+
+	if texts := send(1, "/log"); len(texts) != 1 || texts[0] != T("en", "permission_denied") {
+		t.Errorf("/log of a user = %q", texts)
+	}
+
+	// Entries are shown oldest first
+	texts := send(3, "/log")
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], N("en", "log_header", 3)) {
+		t.Fatalf("/log = %q", texts)
+	}
+	lines := strings.Split(texts[0], "\n")[1:]
+	if len(lines) != 3 || !strings.Contains(lines[0], " register @ivan #1") || !strings.HasSuffix(lines[2], " admin_remove @boss → @anna #2 (registered)") {
+		t.Errorf("/log lines = %q", lines)
+	}
+
+	for args, want := range map[string]int{
+		"action=cancel": 1,
+		"user=@ivan":    2,
+		"user=2":        1,
+		"event=1":       2,
+		"limit=1":       1,
+	} {
+		if texts := send(3, "/log "+args); len(texts) != 1 || !strings.HasPrefix(texts[0], N("en", "log_header", want)) {
+			t.Errorf("/log %s = %q, want %d entries", args, texts, want)
+		}
+	}
+	if texts := send(3, "/log action=ban"); len(texts) != 1 || texts[0] != T("en", "log_empty") {
+		t.Errorf("/log without matches = %q", texts)
+	}
+	if texts := send(3, "/log event=x"); len(texts) != 1 || !strings.HasPrefix(texts[0], T("en", "log_invalid_filter", `invalid event "x"`)) {
+		t.Errorf("/log with an invalid filter = %q", texts)
+	}
+
+	send(3, "/log csv user=@ivan")
+	doc, ok := sender.other[0].(tgbotapi.DocumentConfig)
+	if !ok || doc.Caption != N("en", "log_caption", 2) {
+		t.Fatalf("/log csv sent %+v", sender.other)
+	}
+	rows := strings.Split(strings.TrimSpace(string(doc.File.(tgbotapi.FileBytes).Bytes)), "\n")
+	if len(rows) != 3 || !strings.HasPrefix(rows[0], "\xEF\xBB\xBFid,created_at,action,") || !strings.Contains(rows[1], ",cancel,1,ivan,1,ivan,1,") {
+		t.Errorf("CSV = %q", rows)
+	}
+}
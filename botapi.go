@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// apiEndpointTransport sends the Bot API requests to the server at base instead of api.telegram.org,
+// e.g. to a self-hosted Bot API server or a stand-in used by tests.
+// tgbotapi has the endpoint hard-coded, so the request URL is rewritten: the /bot<token>/<method> path
+// is appended to the path of base.
+func apiEndpointTransport(base *url.URL, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = base.Scheme
+		r.URL.Host = base.Host
+		r.URL.Path = strings.TrimSuffix(base.Path, "/") + r.URL.Path
+		r.Host = base.Host
+		return next.RoundTrip(r)
+	})
+}
+
+// errFileTooLarge is returned by downloadFile for a file over the limit
+var errFileTooLarge = errors.New("file is too large")
+
+// downloadFile fetches a file sent to the bot, at most limit bytes.
+// The HTTP client of the Bot API is used, so the download goes to TELEGRAM_API_URL as well.
+func downloadFile(ctx context.Context, bot Sender, fileID string, limit int64) ([]byte, error) {
+	link, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	client := http.DefaultClient
+	if api, ok := bot.(*tgbotapi.BotAPI); ok && api.Client != nil {
+		client = api.Client
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error of the client repeats the URL, which contains the bot token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestAPIEndpointTransport(t *testing.T) {
+	fake := newFakeBotAPI(t, "123:test")
+	base, err := url.Parse(fake.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithClient("123:test", &http.Client{Transport: apiEndpointTransport(base, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Self.UserName != "meetup_test_bot" {
+		t.Errorf("Self = %+v, want the fake bot", bot.Self)
+	}
+
+	if _, err := bot.Send(tgbotapi.NewMessage(42, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	fake.WaitForMessage(t, 42, "hello")
+
+	// A wrong token gets the error Telegram answers with
+	if _, err := tgbotapi.NewBotAPIWithClient("123:wrong", &http.Client{Transport: apiEndpointTransport(base, nil)}); err == nil {
+		t.Error("NewBotAPIWithClient with a wrong token succeeded")
+	}
+}
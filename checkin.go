@@ -0,0 +1,358 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	checkinPrefix          = "imhere_"        // checkinPrefix starts the /start parameter of the check-in links
+	checkinMACSize         = 9                // checkinMACSize is the number of bytes of the HMAC kept in a code, 12 characters in base64
+	checkinCodePeriod      = 5 * time.Minute  // checkinCodePeriod is how long a check-in code is shown before the next one
+	checkinRefreshInterval = 15 * time.Second // checkinRefreshInterval is how often the /qrcode messages are checked for a new code
+	checkinDisplayTTL      = 12 * time.Hour   // checkinDisplayTTL is how long a /qrcode message is kept up to date
+)
+
+var (
+	errCheckinCodeInvalid    = errors.New("invalid check-in code")
+	errCheckinCodeExpired    = errors.New("check-in code expired")
+	errCheckinCodeWrongEvent = errors.New("check-in code of another event")
+)
+
+// checkinWindow returns the number of the period of the check-in codes at t
+func checkinWindow(t time.Time) int64 {
+	return t.Unix() / int64(checkinCodePeriod/time.Second)
+}
+
+// checkinMAC signs the event and the period of a check-in code, with the key of the tickets
+func checkinMAC(eventID int, window int64) []byte {
+	mac := hmac.New(sha256.New, ticketKey())
+	fmt.Fprintf(mac, "imhere:%d:%d", eventID, window)
+	return mac.Sum(nil)[:checkinMACSize]
+}
+
+// checkinCode returns the check-in code of an event shown at t, like "imhere_7_5912345_<signature>"
+func checkinCode(eventID int, t time.Time) string {
+	window := checkinWindow(t)
+	return fmt.Sprintf("%s%d_%d_%s", checkinPrefix, eventID, window,
+		base64.RawURLEncoding.EncodeToString(checkinMAC(eventID, window)))
+}
+
+// checkCheckinCode checks that a code is signed by the bot, is for the event and is current at now.
+// The code of the previous period is still accepted, so a code scanned just before it changed works.
+func checkCheckinCode(code string, eventID int, now time.Time) error {
+	parts := strings.SplitN(strings.TrimPrefix(code, checkinPrefix), "_", 3)
+	if !strings.HasPrefix(code, checkinPrefix) || len(parts) != 3 {
+		return errCheckinCodeInvalid
+	}
+	codeEventID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return errCheckinCodeInvalid
+	}
+	window, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return errCheckinCodeInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, checkinMAC(codeEventID, window)) {
+		return errCheckinCodeInvalid
+	}
+	if codeEventID != eventID {
+		return errCheckinCodeWrongEvent
+	}
+	if current := checkinWindow(now); window != current && window != current-1 {
+		return errCheckinCodeExpired
+	}
+	return nil
+}
+
+// checkinQRCode returns the PNG of the QR code with the check-in link of an event shown at t
+func checkinQRCode(eventID int, t time.Time) ([]byte, error) {
+	link, err := Links.Start(CheckinPayload(checkinCode(eventID, t)))
+	if err != nil {
+		return nil, err
+	}
+	return qrcode.Encode(link, qrcode.Medium, 256)
+}
+
+// qrDisplay is a /qrcode message that is kept showing the current check-in code
+type qrDisplay struct {
+	chatID    int64
+	messageID int
+	eventID   int
+	lang      string
+	window    int64     // window is the period of the code shown
+	until     time.Time // until is when the message stops being updated
+}
+
+// CheckinQRCodes keeps the /qrcode messages up to date: every checkinCodePeriod the
+// photo of each message is replaced with the new code. There is one message per chat.
+type CheckinQRCodes struct {
+	mu       sync.Mutex
+	displays map[int64]*qrDisplay // displays maps chat IDs to their message
+	now      func() time.Time
+}
+
+// NewCheckinQRCodes creates an empty set of /qrcode messages
+func NewCheckinQRCodes() *CheckinQRCodes {
+	return &CheckinQRCodes{displays: make(map[int64]*qrDisplay), now: time.Now}
+}
+
+// Show sends the current check-in QR code of the event to the chat and keeps it up to date.
+// The message shown in the chat before stops being updated.
+func (q *CheckinQRCodes) Show(bot Sender, chatID int64, lang string, event *Event) error {
+	now := q.now()
+	png, err := checkinQRCode(event.id, now)
+	if err != nil {
+		return err
+	}
+	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{Name: "qrcode_event.png", Bytes: png})
+	photo.Caption = N(lang, "qrcode_caption", int(checkinCodePeriod/time.Minute))
+	sent, err := bot.Send(photo)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	previous := q.displays[chatID]
+	q.displays[chatID] = &qrDisplay{
+		chatID:    chatID,
+		messageID: sent.MessageID,
+		eventID:   event.id,
+		lang:      lang,
+		window:    checkinWindow(now),
+		until:     now.Add(checkinDisplayTTL),
+	}
+	q.mu.Unlock()
+	if previous != nil {
+		q.stop(bot, previous)
+	}
+	return nil
+}
+
+// Refresh replaces the photos of the messages whose code has changed. Messages of an
+// event that is not active anymore, and messages older than checkinDisplayTTL, are stopped.
+func (q *CheckinQRCodes) Refresh(ctx context.Context, bot Sender, db Repository) {
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		slog.Error("Failed to get the event of the check-in QR codes", "error", err)
+		return
+	}
+	now := q.now()
+	window := checkinWindow(now)
+
+	var stale, due []qrDisplay
+	q.mu.Lock()
+	for chatID, display := range q.displays {
+		switch {
+		case event == nil || event.id != display.eventID || now.After(display.until):
+			delete(q.displays, chatID)
+			stale = append(stale, *display)
+		case display.window != window:
+			display.window = window
+			due = append(due, *display)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, display := range stale {
+		q.stop(bot, &display)
+	}
+	for _, display := range due {
+		if err := q.update(bot, display, now); err != nil {
+			// The message is most likely deleted, /qrcode sends a new one
+			slog.Warn("Failed to update the check-in QR code", "chat_id", display.chatID, "message_id", display.messageID, "error", err)
+			q.mu.Lock()
+			if current := q.displays[display.chatID]; current != nil && current.messageID == display.messageID {
+				delete(q.displays, display.chatID)
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// Run refreshes the messages every checkinRefreshInterval until the context is cancelled
+func (q *CheckinQRCodes) Run(ctx context.Context, bot Sender, db Repository) {
+	ticker := time.NewTicker(checkinRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.Refresh(ctx, bot, db)
+		}
+	}
+}
+
+// update replaces the photo of a message with the code current at now.
+// tgbotapi has no config for editMessageMedia, so the photo is uploaded as an attachment.
+func (q *CheckinQRCodes) update(bot Sender, display qrDisplay, now time.Time) error {
+	png, err := checkinQRCode(display.eventID, now)
+	if err != nil {
+		return err
+	}
+	media, err := json.Marshal(map[string]string{
+		"type":    "photo",
+		"media":   "attach://qrcode",
+		"caption": N(display.lang, "qrcode_caption", int(checkinCodePeriod/time.Minute)),
+	})
+	if err != nil {
+		return err
+	}
+	params := map[string]string{
+		"chat_id":    strconv.FormatInt(display.chatID, 10),
+		"message_id": strconv.Itoa(display.messageID),
+		"media":      string(media),
+	}
+	_, err = bot.UploadFile("editMessageMedia", params, "qrcode", tgbotapi.FileBytes{Name: "qrcode_event.png", Bytes: png})
+	return err
+}
+
+// stop tells in the caption of a message that its code is not updated anymore
+func (q *CheckinQRCodes) stop(bot Sender, display *qrDisplay) {
+	bot.Send(tgbotapi.NewEditMessageCaption(display.chatID, display.messageID, T(display.lang, "qrcode_stopped")))
+}
+
+// The check-in mode of the volunteers, see /checkin: they look attendees up by name
+// and mark them as arrived, for people who can't scan the QR code at the door.
+const (
+	checkinCallbackPrefix = "checkin:" // checkinCallbackPrefix starts the data of the check-in mode buttons, "checkin:<registration>:<visited>"
+	checkinMinQuery       = 2          // checkinMinQuery is the shortest search in the check-in mode
+	checkinMaxResults     = 5          // checkinMaxResults is the number of attendees shown for a search
+)
+
+// checkinAttendees returns the registrations of an event expected at the door or already
+// there: the registered users and the walk-ins
+func checkinAttendees(ctx context.Context, db Repository, eventID int) ([]UserRegistration, error) {
+	registrations, err := db.GetEventRegistrations(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	var attendees []UserRegistration
+	for _, reg := range registrations {
+		if reg.Registred == 1 || reg.Visited == 1 {
+			attendees = append(attendees, reg)
+		}
+	}
+	return attendees, nil
+}
+
+// checkinCounter returns the "arrived X of Y" line of the check-in mode
+func checkinCounter(lang string, attendees []UserRegistration) string {
+	arrived := 0
+	for _, reg := range attendees {
+		arrived += reg.Visited
+	}
+	return T(lang, "checkin_counter", arrived, len(attendees))
+}
+
+// matchesCheckinQuery reports whether a word of the name, the whole name, the username
+// or the email of an attendee starts with the lowercased query
+func matchesCheckinQuery(reg UserRegistration, query string) bool {
+	name := strings.ToLower(reg.Name)
+	candidates := append(strings.Fields(name), name, strings.ToLower(reg.Username), strings.ToLower(reg.Email))
+	for _, candidate := range candidates {
+		if candidate != "" && strings.HasPrefix(candidate, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchCheckinAttendees returns the attendees matching a search, sorted by name.
+// A leading @ searches the usernames only.
+func searchCheckinAttendees(attendees []UserRegistration, query string) []UserRegistration {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	var found []UserRegistration
+	for _, reg := range attendees {
+		if username, ok := strings.CutPrefix(query, "@"); ok {
+			if strings.HasPrefix(strings.ToLower(reg.Username), username) {
+				found = append(found, reg)
+			}
+		} else if matchesCheckinQuery(reg, query) {
+			found = append(found, reg)
+		}
+	}
+	slices.SortFunc(found, func(a, b UserRegistration) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), cmp.Compare(a.Username, b.Username))
+	})
+	return found
+}
+
+// checkinResult returns the text and the button of an attendee found in the check-in mode.
+// The button addresses the registration by its row, imported ones may have no Telegram ID.
+func checkinResult(lang string, reg UserRegistration) (string, *tgbotapi.InlineKeyboardMarkup) {
+	text := reg.Name
+	if reg.Username != "" {
+		text = strings.TrimSpace(text + " @" + reg.Username)
+	}
+	if reg.Email != "" {
+		text += ", " + reg.Email
+	}
+	if reg.Registred == 0 {
+		text += " (" + T(lang, "checkin_walk_in") + ")"
+	}
+	status, button, visited := "checkin_status_waiting", "button_checkin_arrive", 1
+	if reg.Visited == 1 {
+		status, button, visited = "checkin_status_arrived", "button_checkin_undo", 0
+	}
+	text += "\n" + T(lang, status)
+	data := fmt.Sprintf("%s%d:%d", checkinCallbackPrefix, reg.ID, visited)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(T(lang, button), data)))
+	return text, &keyboard
+}
+
+// parseCheckinCallback parses the data of a check-in mode button
+func parseCheckinCallback(data string) (registrationID, visited int, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(data, checkinCallbackPrefix), ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	registrationID, err := strconv.Atoi(parts[0])
+	if err != nil || registrationID <= 0 {
+		return 0, 0, false
+	}
+	visited, err = strconv.Atoi(parts[1])
+	if err != nil || (visited != 0 && visited != 1) {
+		return 0, 0, false
+	}
+	return registrationID, visited, true
+}
+
+// parseWalkIn parses a walk-in sent in the check-in mode, like "+ Ivanov Ivan @ivan ivan@example.com".
+// The username and the email are optional and can be in any order; a name or a username is required.
+func parseWalkIn(text string) (UserRegistration, bool) {
+	var reg UserRegistration
+	var name []string
+	for _, word := range strings.Fields(strings.TrimPrefix(strings.TrimSpace(text), "+")) {
+		switch {
+		case strings.HasPrefix(word, "@") && len(word) > 1 && reg.Username == "":
+			reg.Username = word[1:]
+		case strings.Contains(word, "@"):
+			if !ValidateEmail(word) || reg.Email != "" {
+				return reg, false
+			}
+			reg.Email = word
+		default:
+			name = append(name, word)
+		}
+	}
+	reg.Name = strings.Join(name, " ")
+	return reg, reg.Name != "" || reg.Username != ""
+}
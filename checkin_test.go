@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestCheckinCode(t *testing.T) {
+	AppConfig = &Config{BotToken: "123:abc"}
+	now := time.Date(2030, 1, 1, 19, 2, 0, 0, time.UTC)
+	code := checkinCode(7, now)
+	if len(code) > 64 {
+		t.Errorf("code %q is longer than a /start parameter", code)
+	}
+
+	for _, test := range []struct {
+		name string
+		code string
+		want error
+	}{
+		{"current", code, nil},
+		{"end of the period", checkinCode(7, now.Add(-2*time.Minute).Add(checkinCodePeriod-time.Second)), nil},
+		{"previous period", checkinCode(7, now.Add(-checkinCodePeriod)), nil},
+		{"two periods ago", checkinCode(7, now.Add(-2*checkinCodePeriod)), errCheckinCodeExpired},
+		{"next period", checkinCode(7, now.Add(checkinCodePeriod)), errCheckinCodeExpired},
+		{"another event", checkinCode(8, now), errCheckinCodeWrongEvent},
+		{"forged", code[:len(code)-2] + "AA", errCheckinCodeInvalid},
+		{"static", "imhere", errCheckinCodeInvalid},
+	} {
+		if err := checkCheckinCode(test.code, 7, now); err != test.want {
+			t.Errorf("%s: checkCheckinCode(%q) = %v, want %v", test.name, test.code, err, test.want)
+		}
+	}
+}
+
+func TestCheckinQRCodes(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	now := time.Now()
+	QRCodes.now = func() time.Time { return now }
+
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/qrcode"}.update(1))
+	if len(sender.other) != 1 {
+		t.Fatalf("/qrcode sent %+v", sender.other)
+	}
+	photo, ok := sender.other[0].(tgbotapi.PhotoConfig)
+	if !ok || photo.Caption != N("en", "qrcode_caption", 5) {
+		t.Fatalf("/qrcode sent %+v", sender.other[0])
+	}
+
+	// Nothing changes within the period
+	QRCodes.Refresh(ctx, sender, db)
+	if len(sender.uploads) != 0 {
+		t.Errorf("code replaced within its period: %+v", sender.uploads)
+	}
+
+	// The photo of the message is replaced with the next code
+	now = now.Add(checkinCodePeriod)
+	QRCodes.Refresh(ctx, sender, db)
+	if len(sender.uploads) != 1 {
+		t.Fatalf("uploads after a period = %+v", sender.uploads)
+	}
+	upload := sender.uploads[0]
+	var media map[string]string
+	mustNoError(t, json.Unmarshal([]byte(upload.params["media"]), &media))
+	if upload.endpoint != "editMessageMedia" || upload.params["chat_id"] != "3" || upload.params["message_id"] != "1" ||
+		media["media"] != "attach://qrcode" || len(upload.file.Bytes) == 0 {
+		t.Errorf("upload = %+v, media %v", upload, media)
+	}
+
+	// A new /qrcode in the chat stops the previous message
+	sender.reset()
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/qrcode"}.update(2))
+	if len(sender.other) != 2 {
+		t.Fatalf("second /qrcode sent %+v", sender.other)
+	}
+	if edit, ok := sender.other[1].(tgbotapi.EditMessageCaptionConfig); !ok || edit.MessageID != 1 || edit.Caption != T("en", "qrcode_stopped") {
+		t.Errorf("previous message edited with %+v", sender.other[1])
+	}
+
+	// The message stops with the event
+	sender.reset()
+	mustNoError(t, db.MarkEventsAsPast(ctx))
+	now = now.Add(checkinCodePeriod)
+	QRCodes.Refresh(ctx, sender, db)
+	if len(sender.uploads) != 0 || len(sender.other) != 1 || len(QRCodes.displays) != 0 {
+		t.Errorf("after the event: uploads %+v, sent %+v", sender.uploads, sender.other)
+	}
+
+	// An expired code doesn't check in
+	mustNoError(t, db.AddEvent(ctx, "Next meetup", time.Now().AddDate(0, 0, 7), 10))
+	sender.reset()
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "/start " + checkinCode(2, time.Now().Add(-2*checkinCodePeriod))}.update(3))
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "checkin_code_expired") {
+		t.Errorf("expired code = %q", texts)
+	}
+}
+
+func TestCheckinMode(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	mustNoError(t, db.SetUserRole(ctx, UserRole{TelegramID: 2, Username: "anna", Role: string(RoleVolunteer)}))
+	for _, reg := range []UserRegistration{
+		{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", Email: "ivan@example.com", EventID: 1, Registred: 1},
+		{TelegramID: 4, Username: "maria", Name: "Petrova Maria", EventID: 1, Registred: 1},
+		{TelegramID: 5, Username: "olga", Name: "Ivanova Olga", EventID: 1, Registred: 0},
+	} {
+		mustNoError(t, db.RegisterUser(ctx, reg))
+	}
+	// Imported without a Telegram ID
+	mustNoError(t, db.ImportRegistrations(ctx, []RegistrationImport{{EventID: 1, Registrations: []UserRegistration{
+		{Username: "petr", Name: "Sidorov Petr", Registred: 1},
+	}}}))
+
+	send := func(user int, text string) {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: text}.update(1))
+	}
+	press := func(user int, data string) {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, data: data}.update(1))
+	}
+	visited := func(registrationID int) int {
+		reg, _ := db.GetRegistrationByID(ctx, registrationID)
+		return reg.Visited
+	}
+	button := func(msg tgbotapi.MessageConfig) string {
+		keyboard, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+		if !ok {
+			return ""
+		}
+		return *keyboard.InlineKeyboard[0][0].CallbackData
+	}
+
+	send(1, "/checkin")
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "permission_denied") {
+		t.Errorf("/checkin of a user = %q", texts)
+	}
+
+	send(2, "/checkin")
+	if texts := sender.texts(); len(texts) != 2 || texts[0] != T("en", "checkin_mode", "Meetup", checkinMinQuery) || texts[1] != T("en", "checkin_counter", 0, 3) {
+		t.Fatalf("/checkin = %q", texts)
+	}
+
+	send(2, "i")
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "checkin_query_short", checkinMinQuery) {
+		t.Errorf("short search = %q", texts)
+	}
+
+	// Cancelled registrations are not found
+	for _, query := range []string{"iv", "IVANOV I", "ivan@ex", "@iv"} {
+		send(2, query)
+		if len(sender.messages) != 1 || !strings.HasPrefix(sender.messages[0].Text, "Ivanov Ivan @ivan, ivan@example.com\n") || button(sender.messages[0]) != "checkin:1:1" {
+			t.Errorf("search %q = %q", query, sender.texts())
+		}
+	}
+	send(2, "sid")
+	if len(sender.messages) != 1 || button(sender.messages[0]) != "checkin:4:1" {
+		t.Errorf("registration without a Telegram ID = %+v", sender.messages)
+	}
+	send(2, "nobody")
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "checkin_not_found", "nobody") {
+		t.Errorf("search without results = %q", texts)
+	}
+
+	// The button marks the attendee, edits the result and the counter
+	press(2, "checkin:1:1")
+	if visited(1) != 1 || len(sender.answers) != 1 || sender.answers[0].Text != T("en", "checkin_counter", 1, 3) || len(sender.other) != 2 {
+		t.Fatalf("arrived: visited %d, answers %+v, sent %+v", visited(1), sender.answers, sender.other)
+	}
+	result := sender.other[0].(tgbotapi.EditMessageTextConfig)
+	if !strings.HasSuffix(result.Text, T("en", "checkin_status_arrived")) || *result.ReplyMarkup.InlineKeyboard[0][0].CallbackData != "checkin:1:0" {
+		t.Errorf("result edited with %+v", result)
+	}
+	if counter := sender.other[1].(tgbotapi.EditMessageTextConfig); counter.MessageID != 2 || counter.Text != T("en", "checkin_counter", 1, 3) {
+		t.Errorf("counter edited with %+v", counter)
+	}
+	press(2, "checkin:1:1")
+	press(2, "checkin:1:0")
+	entries, _ := db.GetAuditLog(ctx, AuditFilter{UserID: 1})
+	if visited(1) != 0 || len(entries) != 2 || entries[0].Action != string(AuditCheckinUndo) || entries[1].Action != string(AuditCheckin) || entries[1].Details != "manual" {
+		t.Errorf("after undo: visited %d, audit log %+v", visited(1), entries)
+	}
+
+	press(1, "checkin:1:1")
+	if visited(1) != 0 || len(sender.answers) != 1 || sender.answers[0].Text != T("en", "permission_denied") {
+		t.Errorf("button pressed by a user: visited %d, answers %+v", visited(1), sender.answers)
+	}
+
+	// Walk-ins
+	send(2, "+")
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "checkin_walk_in_invalid") {
+		t.Errorf("empty walk-in = %q", texts)
+	}
+	send(2, "+ Smirnov Oleg oleg@example.com")
+	if texts := sender.texts(); len(texts) != 2 || texts[0] != T("en", "checkin_walk_in_done") || !strings.HasPrefix(texts[1], "Smirnov Oleg, oleg@example.com (walk-in)\n") ||
+		button(sender.messages[1]) != "checkin:5:0" {
+		t.Errorf("walk-in = %q", texts)
+	}
+	send(2, "+ @maria")
+	if texts := sender.texts(); len(texts) != 1 || visited(2) != 1 {
+		t.Errorf("walk-in of a registered user = %q, visited %d", texts, visited(2))
+	}
+	send(2, "smir")
+	if len(sender.messages) != 1 || button(sender.messages[0]) != "checkin:5:0" {
+		t.Errorf("walk-in without Telegram = %+v", sender.messages)
+	}
+
+	// Registrations without a Telegram ID are told apart by their row
+	press(2, "checkin:4:1")
+	if visited(4) != 1 || visited(5) != 1 || len(sender.answers) != 1 || sender.answers[0].Text != T("en", "checkin_counter", 3, 4) {
+		t.Errorf("imported registration: visited %d, answers %+v", visited(4), sender.answers)
+	}
+	press(2, "checkin:5:0")
+	if visited(5) != 0 || visited(4) != 1 {
+		t.Errorf("undo of the walk-in: visited %d, imported %d", visited(5), visited(4))
+	}
+	press(2, "checkin:99:1")
+	if len(sender.answers) != 1 || sender.answers[0].Text != T("en", "checkin_registration_gone") {
+		t.Errorf("unknown registration: answers %+v", sender.answers)
+	}
+	send(2, "+ Kuznetsov Ivan @ivan")
+	if visited(1) != 1 {
+		t.Error("walk-in with the username of a registered user is not checked in")
+	}
+	// The undone walk-in is not expected anymore
+	attendees, _ := checkinAttendees(ctx, db, 1)
+	if counter := checkinCounter("en", attendees); counter != T("en", "checkin_counter", 3, 3) {
+		t.Errorf("counter = %q", counter)
+	}
+
+	// A command ends the mode
+	send(2, "/help")
+	send(2, "ivan")
+	if len(sender.messages) == 0 || strings.HasPrefix(button(sender.messages[0]), checkinCallbackPrefix) {
+		t.Error("search after the check-in mode ended")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// cliActor is the actor of audit log entries written by the command-line interface.
+// Telegram usernames have at least five characters, so it can't be mistaken for a user.
+var cliActor = &tgbotapi.User{UserName: "cli"}
+
+// errCLIUsage marks errors in the arguments of a subcommand, they exit with code 2
+var errCLIUsage = errors.New("invalid arguments")
+
+// cliCommand is a subcommand of the meetupbot binary
+type cliCommand struct {
+	name string // name is the command and the subcommand, e.g. "events list"
+	args string // args describes the flags and arguments in the usage
+	help string // help is a one-line description
+	run  func(ctx context.Context, db Repository, args []string, out io.Writer) error
+}
+
+// cliCommands lists the subcommands, they work on the database without connecting to Telegram
+var cliCommands = []cliCommand{
+	{"events list", "", "List all events, newest first", cliEventsList},
+	{"events create", "--name NAME --date YYYY-MM-DD --capacity N", "Create an event and archive the current one", cliEventsCreate},
+	{"registrations export", "[--event ID] [--format csv|json]", "Write the registrations of an event, the current one by default", cliRegistrationsExport},
+	{"users remove", "[--event ID] USERNAME", "Remove a user from an event, the current one by default", cliUsersRemove},
+	{"apikeys create", "--name NAME [--scope events|full]", "Create an API key, it is shown only once", cliAPIKeysCreate},
+	{"apikeys list", "", "List the API keys", cliAPIKeysList},
+	{"apikeys revoke", "ID", "Revoke an API key", cliAPIKeysRevoke},
+	{"db backup", "FILE", "Copy the SQLite database to FILE", cliDBBackup},
+	{"db check", "", "Check the database and the registration counts", cliDBCheck},
+}
+
+// runCLI runs the subcommand in args and returns the exit code of the process
+func runCLI(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCLIUsage(stdout)
+		return 0
+	}
+	var cmd *cliCommand
+	if len(args) >= 2 {
+		for i := range cliCommands {
+			if cliCommands[i].name == args[0]+" "+args[1] {
+				cmd = &cliCommands[i]
+			}
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", strings.Join(args[:min(len(args), 2)], " "))
+		printCLIUsage(stderr)
+		return 2
+	}
+
+	// Only warnings and errors are logged, the output is for scripts
+	logger, err := NewLogger(stderr, "logfmt", "warn")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to load configuration:", err)
+		return 1
+	}
+	AppConfig = config
+
+	repo, db, err := OpenRepository(ctx, AppConfig.DatabaseURL)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to open database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := cmd.run(ctx, repo, args[2:], stdout); err != nil {
+		fmt.Fprintf(stderr, "meetupbot %s: %v\n", cmd.name, err)
+		if errors.Is(err, errCLIUsage) {
+			fmt.Fprintf(stderr, "usage: meetupbot %s %s\n", cmd.name, cmd.args)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// printCLIUsage writes the list of subcommands
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: meetupbot [command]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Without a command the bot starts. The commands work on the database in DATABASE_URL")
+	fmt.Fprintln(w, "without connecting to Telegram:")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	tw.Flush()
+}
+
+// newCLIFlags returns a flag set for a subcommand, parse errors are returned and not printed
+func newCLIFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseCLIFlags parses args and checks that exactly nargs positional arguments remain
+func parseCLIFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errCLIUsage, err)
+	}
+	if fs.NArg() != nargs {
+		return fmt.Errorf("%w: expected %d arguments, got %d", errCLIUsage, nargs, fs.NArg())
+	}
+	return nil
+}
+
+// cliEvent returns the event with id, or the current event if id is 0
+func cliEvent(ctx context.Context, db Repository, id int) (*Event, error) {
+	if id == 0 {
+		event, err := db.GetLatestEvent(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			return nil, errors.New("no active event, pass --event")
+		}
+		return event, nil
+	}
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].id == id {
+			return &events[i], nil
+		}
+	}
+	return nil, fmt.Errorf("event %d not found", id)
+}
+
+// cliEventsList writes a table of all events
+func cliEventsList(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	if err := parseCLIFlags(newCLIFlags("events list"), args, 0); err != nil {
+		return err
+	}
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tDATE\tCAPACITY\tREGISTERED\tSTATE")
+	for _, event := range events {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%s\n",
+			event.id, event.name, event.date.Format("2006-01-02"), event.capacity, event.registrationCount, event.state)
+	}
+	return tw.Flush()
+}
+
+// cliEventsCreate creates an event like /addevent does
+func cliEventsCreate(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("events create")
+	name := fs.String("name", "", "name of the event")
+	dateStr := fs.String("date", "", "date of the event, YYYY-MM-DD")
+	capacity := fs.Int("capacity", 0, "number of seats")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*name) == "" {
+		return fmt.Errorf("%w: --name is required", errCLIUsage)
+	}
+	date, err := time.Parse("2006-01-02", *dateStr)
+	if err != nil {
+		return fmt.Errorf("%w: --date must be YYYY-MM-DD", errCLIUsage)
+	}
+	if *capacity <= 0 {
+		return fmt.Errorf("%w: --capacity must be a positive number", errCLIUsage)
+	}
+
+	if err := db.MarkEventsAsPast(ctx); err != nil {
+		return err
+	}
+	if err := db.AddEvent(ctx, strings.TrimSpace(*name), date, *capacity); err != nil {
+		return err
+	}
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil || event == nil {
+		return fmt.Errorf("event created but not found: %v", err)
+	}
+	audit(ctx, db, AuditEventCreate, cliActor, 0, "", event.id, fmt.Sprintf("%s;%s;%d", event.name, *dateStr, *capacity))
+	fmt.Fprintf(out, "Created event %d: %s\n", event.id, event.name)
+	return nil
+}
+
+// cliRegistrationsExport writes the registrations of an event as CSV or JSON
+func cliRegistrationsExport(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("registrations export")
+	eventID := fs.Int("event", 0, "event ID, the current event by default")
+	format := fs.String("format", "csv", "csv or json")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("%w: unknown format %q", errCLIUsage, *format)
+	}
+	event, err := cliEvent(ctx, db, *eventID)
+	if err != nil {
+		return err
+	}
+	rows, err := eventRegistrations(ctx, db, event.id)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return writeRegistrationsJSON(out, rows)
+	}
+	return writeRegistrationsCSV(out, rows)
+}
+
+// cliUsersRemove removes a user from an event like /remove does.
+// Unlike /remove it can't notify the waitlist, the bot isn't connected.
+func cliUsersRemove(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("users remove")
+	eventID := fs.Int("event", 0, "event ID, the current event by default")
+	if err := parseCLIFlags(fs, args, 1); err != nil {
+		return err
+	}
+	username := strings.TrimPrefix(strings.TrimSpace(fs.Arg(0)), "@")
+	if username == "" {
+		return fmt.Errorf("%w: USERNAME is empty", errCLIUsage)
+	}
+	event, err := cliEvent(ctx, db, *eventID)
+	if err != nil {
+		return err
+	}
+
+	wasRegistered, err := db.RemoveUserByUsername(ctx, username, event.id)
+	if err != nil {
+		return err
+	}
+	details := "registered"
+	if !wasRegistered {
+		details = removedNotRegistered
+	}
+	audit(ctx, db, AuditAdminRemove, cliActor, 0, username, event.id, details)
+
+	if !wasRegistered {
+		fmt.Fprintf(out, "@%s is not registered for event %d\n", username, event.id)
+		return nil
+	}
+	if err := db.DecrementEventRegistrationCount(ctx, event.id); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Removed @%s from event %d\n", username, event.id)
+	return nil
+}
+
+// cliAPIKeysCreate creates an API key and writes it, only its hash is stored
+func cliAPIKeysCreate(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("apikeys create")
+	name := fs.String("name", "", "who uses the key")
+	scope := fs.String("scope", APIScopeEvents, "events or full")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*name) == "" {
+		return fmt.Errorf("%w: --name is required", errCLIUsage)
+	}
+	if !validAPIScope(*scope) {
+		return fmt.Errorf("%w: --scope must be %s or %s", errCLIUsage, APIScopeEvents, APIScopeFull)
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	id, err := db.AddAPIKey(ctx, APIKey{
+		Name:      strings.TrimSpace(*name),
+		Hash:      hashAPIKey(key),
+		Scope:     *scope,
+		CreatedBy: cliActor.UserName,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	audit(ctx, db, AuditAPIKeyCreate, cliActor, 0, "", 0, fmt.Sprintf("%d;%s;%s", id, strings.TrimSpace(*name), *scope))
+	fmt.Fprintf(out, "Created API key %d with scope %s, store it now, it can't be shown again:\n%s\n", id, *scope, key)
+	return nil
+}
+
+// cliAPIKeysList writes a table of the API keys
+func cliAPIKeysList(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	if err := parseCLIFlags(newCLIFlags("apikeys list"), args, 0); err != nil {
+		return err
+	}
+	keys, err := db.GetAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tCREATED\tREVOKED")
+	for _, key := range keys {
+		revoked := "-"
+		if !key.RevokedAt.IsZero() {
+			revoked = key.RevokedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Scope, key.CreatedAt.Format("2006-01-02 15:04"), revoked)
+	}
+	return tw.Flush()
+}
+
+// cliAPIKeysRevoke revokes an API key, requests with it fail right away
+func cliAPIKeysRevoke(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("apikeys revoke")
+	if err := parseCLIFlags(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: ID must be a number", errCLIUsage)
+	}
+	keys, err := db.GetAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		if !key.RevokedAt.IsZero() {
+			fmt.Fprintf(out, "API key %d is already revoked\n", id)
+			return nil
+		}
+		if err := db.RevokeAPIKey(ctx, id, time.Now()); err != nil {
+			return err
+		}
+		audit(ctx, db, AuditAPIKeyRevoke, cliActor, 0, "", 0, fmt.Sprintf("%d;%s", id, key.Name))
+		fmt.Fprintf(out, "Revoked API key %d (%s)\n", id, key.Name)
+		return nil
+	}
+	return fmt.Errorf("API key %d not found", id)
+}
+
+// cliDBBackup writes a consistent copy of the database to a new file
+func cliDBBackup(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	fs := newCLIFlags("db backup")
+	if err := parseCLIFlags(fs, args, 1); err != nil {
+		return err
+	}
+	backuper, ok := db.(Backuper)
+	if !ok {
+		return errors.New("backups are supported for SQLite only, use pg_dump for PostgreSQL")
+	}
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := backuper.Backup(ctx, path); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Backup written to %s\n", path)
+	return nil
+}
+
+// cliDBCheck checks that the database is reachable and consistent.
+// Every problem is written to out, any problem fails the command.
+func cliDBCheck(ctx context.Context, db Repository, args []string, out io.Writer) error {
+	if err := parseCLIFlags(newCLIFlags("db check"), args, 0); err != nil {
+		return err
+	}
+	if err := db.Ping(ctx); err != nil {
+		return err
+	}
+	problems := 0
+	if checker, ok := db.(IntegrityChecker); ok {
+		if err := checker.CheckIntegrity(ctx); err != nil {
+			fmt.Fprintln(out, "integrity:", err)
+			problems++
+		}
+	}
+
+	// The stored registration count of each event must match its registered rows
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return err
+	}
+	registrations, err := db.GetAllRegistrations(ctx)
+	if err != nil {
+		return err
+	}
+	registered := make(map[int]int)
+	for _, reg := range registrations {
+		if reg.Registred == 1 {
+			registered[reg.EventID]++
+		}
+	}
+	for _, event := range events {
+		if event.registrationCount != registered[event.id] {
+			fmt.Fprintf(out, "event %d: registration count is %d, %d users are registered\n",
+				event.id, event.registrationCount, registered[event.id])
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	fmt.Fprintf(out, "OK: %d events, %d registrations\n", len(events), len(registrations))
+	return nil
+}
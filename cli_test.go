@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cliRun runs a subcommand and returns its exit code and output
+func cliRun(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runCLI(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	databaseURL := "sqlite://" + filepath.Join(dir, "bot.db")
+	t.Setenv("DATABASE_URL", databaseURL)
+	t.Setenv("MANDATORY_FIELDS", "")
+
+	if code, _, stderr := cliRun(t, "events", "create", "--name", "Go Meetup", "--date", "2030-01-01", "--capacity", "10"); code != 0 {
+		t.Fatalf("events create = %d, %s", code, stderr)
+	}
+
+	// Add registrations the way the bot does
+	repo, db, err := OpenRepository(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	event := mustEvent(t, repo)
+	for _, id := range []int{1, 2} {
+		u := testUsers[id]
+		reg := UserRegistration{TelegramID: u.ID, Username: u.UserName, Name: u.FirstName, Email: u.UserName + "@example.com", EventID: event.id, Registred: 1}
+		if err := repo.RegisterUser(ctx, reg); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.UpdateEventRegistrationCount(ctx, event.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("EventsList", func(t *testing.T) {
+		code, stdout, _ := cliRun(t, "events", "list")
+		if code != 0 || !strings.Contains(stdout, "Go Meetup") || !strings.Contains(stdout, "2030-01-01") {
+			t.Errorf("events list = %d:\n%s", code, stdout)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		code, stdout, stderr := cliRun(t, "registrations", "export", "--format", "json")
+		if code != 0 {
+			t.Fatalf("export = %d, %s", code, stderr)
+		}
+		var rows []exportedRegistration
+		if err := json.Unmarshal([]byte(stdout), &rows); err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || !rows[0].Registered || rows[0].EventID != event.id {
+			t.Errorf("exported %+v", rows)
+		}
+
+		code, stdout, _ = cliRun(t, "registrations", "export")
+		if code != 0 || !strings.HasPrefix(stdout, "telegram_id,username,") || strings.Count(stdout, "\n") != 3 {
+			t.Errorf("CSV export = %d:\n%s", code, stdout)
+		}
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		if code, stdout, stderr := cliRun(t, "db", "check"); code != 0 {
+			t.Errorf("db check = %d, %s%s", code, stdout, stderr)
+		}
+		// A count out of sync with the registrations is reported
+		if err := repo.UpdateEventRegistrationCount(ctx, event.id); err != nil {
+			t.Fatal(err)
+		}
+		code, stdout, _ := cliRun(t, "db", "check")
+		if code != 1 || !strings.Contains(stdout, "registration count is 3") {
+			t.Errorf("db check with a wrong count = %d:\n%s", code, stdout)
+		}
+		if err := repo.DecrementEventRegistrationCount(ctx, event.id); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		code, stdout, _ := cliRun(t, "users", "remove", "@ivan")
+		if code != 0 || !strings.Contains(stdout, "Removed @ivan") {
+			t.Errorf("users remove = %d: %s", code, stdout)
+		}
+		if registered, _, _ := repo.IsUserRegistered(ctx, 1, event.id); registered {
+			t.Error("ivan is still registered")
+		}
+		if code, stdout, _ := cliRun(t, "db", "check"); code != 0 {
+			t.Errorf("db check after remove = %d: %s", code, stdout)
+		}
+		entries, err := repo.GetAuditLog(ctx, AuditFilter{Action: string(AuditAdminRemove)})
+		if err != nil || len(entries) != 1 || entries[0].ActorUsername != "cli" {
+			t.Errorf("audit entries = %+v, %v", entries, err)
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		path := filepath.Join(dir, "backup.db")
+		if code, _, stderr := cliRun(t, "db", "backup", path); code != 0 {
+			t.Fatalf("db backup = %d, %s", code, stderr)
+		}
+		backup, backupDB, err := OpenRepository(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backupDB.Close()
+		if event := mustEvent(t, backup); event.name != "Go Meetup" {
+			t.Errorf("backup event = %+v", event)
+		}
+		// An existing file is never overwritten
+		if code, _, _ := cliRun(t, "db", "backup", path); code != 1 {
+			t.Errorf("second db backup = %d, want 1", code)
+		}
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		code, stdout, stderr := cliRun(t, "apikeys", "create", "--name", "crm", "--scope", "full")
+		if code != 0 {
+			t.Fatalf("apikeys create = %d, %s", code, stderr)
+		}
+		key := strings.TrimSpace(stdout[strings.LastIndex(strings.TrimSpace(stdout), "\n")+1:])
+		stored, err := repo.GetAPIKeyByHash(ctx, hashAPIKey(key))
+		if err != nil || stored == nil || stored.Name != "crm" || stored.Scope != APIScopeFull {
+			t.Fatalf("stored key for %q = %+v, %v", key, stored, err)
+		}
+
+		if code, stdout, _ := cliRun(t, "apikeys", "list"); code != 0 || !strings.Contains(stdout, "crm") || strings.Contains(stdout, key) {
+			t.Errorf("apikeys list = %d:\n%s", code, stdout)
+		}
+		if code, _, _ := cliRun(t, "apikeys", "revoke", "1"); code != 0 {
+			t.Errorf("apikeys revoke = %d", code)
+		}
+		if stored, _ := repo.GetAPIKeyByHash(ctx, hashAPIKey(key)); stored.RevokedAt.IsZero() {
+			t.Error("key is not revoked")
+		}
+		if code, _, _ := cliRun(t, "apikeys", "create", "--name", "x", "--scope", "admin"); code != 2 {
+			t.Errorf("unknown scope = %d, want 2", code)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		if code, _, _ := cliRun(t, "events", "frobnicate"); code != 2 {
+			t.Errorf("unknown command = %d, want 2", code)
+		}
+		if code, _, stderr := cliRun(t, "events", "create", "--name", "x", "--date", "tomorrow", "--capacity", "5"); code != 2 || !strings.Contains(stderr, "usage:") {
+			t.Errorf("bad date = %d, %s", code, stderr)
+		}
+		if code, _, _ := cliRun(t, "registrations", "export", "--event", "99"); code != 1 {
+			t.Errorf("unknown event = %d, want 1", code)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Command declares a bot command: its handler, description, permission and middlewares
+type Command struct {
+	Name        string             // Name is the command without the leading slash.
+	Description string             // Description is the catalog key of the command description.
+	Permission  Permission         // Permission is required to run the command, empty if anyone can.
+	Middlewares []Middleware       // Middlewares run after the permission check, right before the handler.
+	Handler     CommandHandlerFunc // Handler processes the command.
+}
+
+// Callback declares the handler of the inline buttons whose callback data starts with a prefix
+type Callback struct {
+	Prefix      string              // Prefix starts the callback data of the buttons, like "export:".
+	Permission  Permission          // Permission is required to press the buttons, empty if anyone can.
+	Middlewares []Middleware        // Middlewares run after the permission check, right before the handler.
+	Handler     CallbackHandlerFunc // Handler processes the callback query.
+}
+
+// callbackRoute is a registered Callback wrapped with its permission and middlewares
+type callbackRoute struct {
+	prefix  string
+	handler HandlerFunc
+}
+
+// CommandRegistry routes updates through the global middlewares and commands to their handlers
+type CommandRegistry struct {
+	commands  map[string]Command
+	handlers  map[string]HandlerFunc
+	order     []string
+	callbacks []callbackRoute
+	message   HandlerFunc
+	callback  HandlerFunc
+}
+
+// NewCommandRegistry creates a registry whose middlewares wrap every message and callback query
+func NewCommandRegistry(middlewares ...Middleware) *CommandRegistry {
+	chain := Chain(middlewares)
+	r := &CommandRegistry{
+		commands: make(map[string]Command),
+		handlers: make(map[string]HandlerFunc),
+		message: chain.Then(func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+			return handleMessage(ctx, bot, db, req)
+		}),
+	}
+	r.callback = chain.Then(r.dispatchCallback)
+	return r
+}
+
+// routeChain returns the permission check and the middlewares of a command or a callback
+func routeChain(perm Permission, middlewares []Middleware) Chain {
+	var chain Chain
+	if perm != "" {
+		chain = append(chain, RequirePermission(perm))
+	}
+	return append(chain, middlewares...)
+}
+
+// Register adds commands to the registry, in the order they are listed in /help
+func (r *CommandRegistry) Register(commands ...Command) {
+	for _, cmd := range commands {
+		if _, exists := r.commands[cmd.Name]; !exists {
+			r.order = append(r.order, cmd.Name)
+		}
+		r.commands[cmd.Name] = cmd
+		r.handlers[cmd.Name] = routeChain(cmd.Permission, cmd.Middlewares).Command(cmd.Handler)
+	}
+}
+
+// RegisterCallbacks adds the handlers of inline buttons to the registry.
+// The permission is checked on every press, buttons stay in the chat after a role is revoked.
+func (r *CommandRegistry) RegisterCallbacks(callbacks ...Callback) {
+	for _, cb := range callbacks {
+		r.callbacks = append(r.callbacks, callbackRoute{
+			prefix:  cb.Prefix,
+			handler: routeChain(cb.Permission, cb.Middlewares).Callback(cb.Handler),
+		})
+	}
+}
+
+// dispatchCallback runs the handler registered for the prefix of the callback data.
+// The registration buttons have no prefix, they go to handleCallbackQuery.
+func (r *CommandRegistry) dispatchCallback(ctx context.Context, bot Sender, db Repository, req *Request) error {
+	for _, route := range r.callbacks {
+		if strings.HasPrefix(req.Callback.Data, route.prefix) {
+			return route.handler(ctx, bot, db, req)
+		}
+	}
+	return handleCallbackQuery(ctx, bot, db, req.Callback)
+}
+
+// updateTimeout bounds the time spent handling a single update, including its database calls
+const updateTimeout = 30 * time.Second
+
+// HandleUpdate processes an update from Telegram.
+// A panic is contained to the update that caused it, so the update loop keeps running.
+func (r *CommandRegistry) HandleUpdate(ctx context.Context, bot Sender, db Repository, update tgbotapi.Update) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("Panic in update", "update_id", update.UpdateID, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+		}
+	}()
+
+	var req *Request
+	handler := r.message
+	switch {
+	case update.CallbackQuery != nil:
+		Metrics.Updates.Inc("callback_query")
+		req, handler = newCallbackRequest(update.CallbackQuery), r.callback
+	case update.Message != nil && update.Message.IsCommand():
+		Metrics.Updates.Inc("command")
+		req = newMessageRequest(update.Message)
+	case update.Message != nil:
+		Metrics.Updates.Inc("message")
+		req = newMessageRequest(update.Message)
+	default:
+		Metrics.Updates.Inc("other")
+		return
+	}
+	req.ID = newCorrelationID()
+	req.UpdateID = update.UpdateID
+
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+	if err := handler(ctx, bot, db, req); err != nil {
+		// The ErrorReporter middleware handles errors, this is only reached without it
+		req.Logger().Error("Unhandled error", "error", err)
+	}
+}
+
+// Dispatch runs the handler of the command in the message of the request.
+// The global middlewares have already run in HandleUpdate.
+func (r *CommandRegistry) Dispatch(ctx context.Context, bot Sender, db Repository, req *Request) error {
+	name := req.Message.Command()
+	handler, ok := r.handlers[name]
+	if !ok {
+		Metrics.Commands.Inc("unknown")
+		return Reject("unknown_command")
+	}
+	Metrics.Commands.Inc(name)
+	return handler(ctx, bot, db, req)
+}
+
+// Has checks if a command is registered
+func (r *CommandRegistry) Has(name string) bool {
+	_, ok := r.commands[name]
+	return ok
+}
+
+// Available returns the commands the user is allowed to run, in registration order
+func (r *CommandRegistry) Available(ctx context.Context, db Repository, user *tgbotapi.User) []Command {
+	role := userRole(ctx, db, user)
+	var commands []Command
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		if cmd.Permission == "" || role.Can(cmd.Permission) {
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// PublishCommands sends the public commands to Telegram, so clients show them in the command menu.
+// Every catalog gets its own list, the default language is also used for all other clients.
+func (r *CommandRegistry) PublishCommands(bot *tgbotapi.BotAPI) {
+	type botCommand struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	publish := func(lang, languageCode string) {
+		var commands []botCommand
+		for _, name := range r.order {
+			cmd := r.commands[name]
+			if cmd.Permission == "" {
+				commands = append(commands, botCommand{Command: cmd.Name, Description: T(lang, cmd.Description)})
+			}
+		}
+		data, err := json.Marshal(commands)
+		if err != nil {
+			slog.Error("Failed to encode bot commands", "error", err)
+			return
+		}
+		params := url.Values{}
+		params.Set("commands", string(data))
+		if languageCode != "" {
+			params.Set("language_code", languageCode)
+		}
+		if _, err := bot.MakeRequest("setMyCommands", params); err != nil {
+			slog.Error("Failed to publish bot commands", "language", languageCode, "error", err)
+		}
+	}
+
+	publish(defaultLanguage(), "")
+	for _, lang := range I18n.Languages() {
+		publish(lang, lang)
+	}
+}
+
+// registerCommands declares every command of the bot
+func registerCommands(r *CommandRegistry) {
+	r.Register(
+		Command{Name: "start", Description: "command_start", Handler: handleStart},
+		Command{Name: "register", Description: "command_register", Handler: handleRegister},
+		Command{Name: "state", Description: "command_state", Handler: handleState},
+		Command{Name: "ticket", Description: "command_ticket", Handler: handleTicket},
+		Command{Name: "invite", Description: "command_invite", Handler: handleInvite},
+		Command{Name: "language", Description: "command_language", Handler: handleLanguage},
+		Command{Name: "help", Description: "command_help", Handler: handleHelp},
+
+		Command{Name: "addevent", Description: "command_addevent", Permission: PermEvents, Handler: handleAddEvent},
+		Command{Name: "qrcode", Description: "command_qrcode", Permission: PermCheckin, Handler: handleQRCode},
+		Command{Name: "checkin", Description: "command_checkin", Permission: PermCheckin,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleCheckin},
+		Command{Name: "export", Description: "command_export", Permission: PermExport,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleExport},
+		Command{Name: "import", Description: "command_import", Permission: PermEvents,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleImport},
+		Command{Name: "print", Description: "command_print", Permission: PermExport,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handlePrint},
+		Command{Name: "remove", Description: "command_remove", Permission: PermRegistrations, Handler: handleRemoveUser},
+		Command{Name: "ban", Description: "command_ban", Permission: PermRegistrations, Handler: handleBan},
+		Command{Name: "unban", Description: "command_unban", Permission: PermRegistrations, Handler: handleUnban},
+		Command{Name: "templates", Description: "command_templates", Permission: PermTemplates, Handler: handleTemplates},
+		Command{Name: "settemplate", Description: "command_settemplate", Permission: PermTemplates, Handler: handleSetTemplate},
+		Command{Name: "grant", Description: "command_grant", Permission: PermRoles, Handler: handleGrant},
+		Command{Name: "revoke", Description: "command_revoke", Permission: PermRoles, Handler: handleRevoke},
+		Command{Name: "roles", Description: "command_roles", Permission: PermRoles, Handler: handleRoles},
+		Command{Name: "log", Description: "command_log", Permission: PermAudit,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleLog},
+		Command{Name: "webhooks", Description: "command_webhooks", Permission: PermAudit,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleWebhooks},
+	)
+	// The buttons check the permission of the command that sent them
+	r.RegisterCallbacks(
+		Callback{Prefix: languageCallbackPrefix, Handler: handleLanguageCallback},
+		Callback{Prefix: exportCallbackPrefix, Permission: PermExport,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleExportCallback},
+		Callback{Prefix: importCallbackPrefix, Permission: PermEvents,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleImportCallback},
+		Callback{Prefix: checkinCallbackPrefix, Permission: PermCheckin,
+			Middlewares: []Middleware{PrivateChatOnly}, Handler: handleCheckinCallback},
+	)
+}
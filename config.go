@@ -3,8 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,18 +15,50 @@ type Config struct {
 	BotToken        string
 	AdminUsers      []string
 	MandatoryFields []string
+	DefaultLocale   string
+	RateLimit       int      // Maximum number of requests per user per minute, 0 disables the limit
+	ErrorChatID     int64    // Chat that receives handler errors, 0 only logs them
+	LogLevel        string   // Minimum level of logged records: debug, info, warn or error
+	LogFormat       string   // Format of log records: logfmt or json
+	HTTPAddr        string   // Address of the HTTP server for /metrics, /healthz and /readyz, empty disables it
+	DatabaseURL     string   // postgres:// URL for PostgreSQL, sqlite://path or a path for SQLite
+	TelegramAPIURL  string   // Base URL of the Bot API server, empty for api.telegram.org
+	AdminToken      string   // Token to sign in to the admin UI at /admin/ on HTTP_ADDR, empty disables the sign-in with a token
+	PublicURL       string   // Public base URL of HTTP_ADDR, enables the Telegram sign-in of the admin UI
+	WebhookURLs     []string // URLs that receive the registration lifecycle events, empty disables webhooks
+	WebhookSecret   string   // Key of the HMAC signature of webhook payloads
+	PDFFont         string   // TrueType font of the /print documents, empty looks for a system font
+	TicketSecret    string   // Key of the ticket signatures, empty derives one from the bot token
 }
 
 // LoadConfig loads configuration from .env file and environment variables
 func LoadConfig() (*Config, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.BotToken == "" {
+		return nil, fmt.Errorf("BOT_TOKEN is required")
+	}
+	return config, nil
+}
+
+// loadConfig loads the configuration without requiring the settings for Telegram,
+// which the command-line interface doesn't need
+func loadConfig() (*Config, error) {
 	config := &Config{
 		AdminUsers:      []string{},
 		MandatoryFields: []string{},
+		DefaultLocale:   "ru",
+		RateLimit:       20,
+		LogLevel:        "info",
+		LogFormat:       "logfmt",
+		DatabaseURL:     "sqlite://./bot.db",
 	}
 
 	// Try to load from .env file
 	if err := loadEnvFile(".env"); err == nil {
-		log.Println("Loaded .env file")
+		slog.Info("Loaded .env file")
 	}
 
 	// Get configuration from environment variables
@@ -38,11 +72,77 @@ func LoadConfig() (*Config, error) {
 		config.MandatoryFields = parseCommaSeparated(mandatoryFields)
 	}
 
-	// Validate configuration
-	if config.BotToken == "" {
-		return nil, fmt.Errorf("BOT_TOKEN is required")
+	if defaultLocale := os.Getenv("DEFAULT_LOCALE"); defaultLocale != "" {
+		config.DefaultLocale = strings.TrimSpace(defaultLocale)
+	}
+
+	if rateLimit := os.Getenv("RATE_LIMIT"); rateLimit != "" {
+		limit, err := strconv.Atoi(strings.TrimSpace(rateLimit))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT: %s", rateLimit)
+		}
+		config.RateLimit = limit
+	}
+
+	if errorChatID := os.Getenv("ERROR_CHAT_ID"); errorChatID != "" {
+		chatID, err := strconv.ParseInt(strings.TrimSpace(errorChatID), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ERROR_CHAT_ID: %s", errorChatID)
+		}
+		config.ErrorChatID = chatID
+	}
+
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		config.LogLevel = strings.ToLower(strings.TrimSpace(logLevel))
 	}
 
+	if logFormat := os.Getenv("LOG_FORMAT"); logFormat != "" {
+		config.LogFormat = strings.ToLower(strings.TrimSpace(logFormat))
+	}
+
+	if httpAddr := os.Getenv("HTTP_ADDR"); httpAddr != "" {
+		config.HTTPAddr = strings.TrimSpace(httpAddr)
+	}
+
+	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
+		config.DatabaseURL = strings.TrimSpace(databaseURL)
+	}
+
+	if telegramAPIURL := os.Getenv("TELEGRAM_API_URL"); telegramAPIURL != "" {
+		u, err := url.Parse(strings.TrimSpace(telegramAPIURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid TELEGRAM_API_URL: %s", telegramAPIURL)
+		}
+		config.TelegramAPIURL = u.String()
+	}
+
+	config.AdminToken = strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+
+	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
+		u, err := url.Parse(strings.TrimSpace(publicURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid PUBLIC_URL: %s", publicURL)
+		}
+		config.PublicURL = strings.TrimSuffix(u.String(), "/")
+	}
+
+	if webhookURLs := os.Getenv("WEBHOOK_URLS"); webhookURLs != "" {
+		for _, webhookURL := range parseCommaSeparated(webhookURLs) {
+			u, err := url.Parse(webhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid webhook URL in WEBHOOK_URLS: %s", webhookURL)
+			}
+			config.WebhookURLs = append(config.WebhookURLs, webhookURL)
+		}
+	}
+	config.WebhookSecret = strings.TrimSpace(os.Getenv("WEBHOOK_SECRET"))
+	if len(config.WebhookURLs) > 0 && config.WebhookSecret == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET is required with WEBHOOK_URLS")
+	}
+
+	config.PDFFont = strings.TrimSpace(os.Getenv("PDF_FONT"))
+	config.TicketSecret = strings.TrimSpace(os.Getenv("TICKET_SECRET"))
+
 	// Validate mandatory fields
 	validFields := map[string]bool{
 		"name":  true,
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenRepository opens the database selected by a DATABASE_URL and creates its tables.
+// postgres:// and postgresql:// URLs select PostgreSQL; sqlite://path or a plain path selects SQLite.
+func OpenRepository(ctx context.Context, databaseURL string) (Repository, *sql.DB, error) {
+	var repo Repository
+	var db *sql.DB
+	var err error
+
+	switch {
+	case strings.HasPrefix(databaseURL, "postgres://"), strings.HasPrefix(databaseURL, "postgresql://"):
+		db, err = sql.Open("postgres", databaseURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo = NewPostgresRepository(db)
+	default:
+		db, err = sql.Open("sqlite3", strings.TrimPrefix(databaseURL, "sqlite://"))
+		if err != nil {
+			return nil, nil, err
+		}
+		repo = NewSQLiteRepository(db)
+	}
+
+	if err := repo.CreateTables(ctx); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("create tables: %w", err)
+	}
+	return repo, db, nil
+}
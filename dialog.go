@@ -13,6 +13,9 @@ const (
 	NoDialog DialogState = iota
 	WaitingForName
 	WaitingForEmail
+	WaitingForImportFile // Admin sent /import, the CSV file is expected
+	ReviewingImport      // Admin reviews the dry run of an import, the file is in the user data
+	CheckinMode          // Volunteer sent /checkin, messages look up the attendees of the event
 )
 
 // UserDialogState stores the dialog state for a user
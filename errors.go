@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// BotError is returned by handlers when a request can't be completed.
+// The user sees the catalog message, the underlying error goes to the log and the error chat.
+type BotError struct {
+	Key  string        // Key is the catalog key of the message shown to the user.
+	Args []interface{} // Args are the format arguments of the message.
+	Err  error         // Err is the underlying failure, nil for expected errors such as invalid input.
+}
+
+// Error implements the error interface
+func (e *BotError) Error() string {
+	if e.Err != nil {
+		return e.Key + ": " + e.Err.Error()
+	}
+	return e.Key
+}
+
+// Unwrap returns the underlying failure
+func (e *BotError) Unwrap() error {
+	return e.Err
+}
+
+// Fail reports a failure: the user gets the message for key, admins get err
+func Fail(err error, key string, args ...interface{}) error {
+	if err == nil {
+		err = errors.New(key)
+	}
+	return &BotError{Key: key, Args: args, Err: err}
+}
+
+// Reject refuses a request for an expected reason, e.g. invalid input.
+// The user gets the message for key, nothing is reported.
+func Reject(key string, args ...interface{}) error {
+	return &BotError{Key: key, Args: args}
+}
+
+// ErrorReporter forwards failures to an admin chat
+type ErrorReporter struct {
+	bot     Sender
+	chatID  int64
+	secrets []string
+}
+
+// NewErrorReporter creates an ErrorReporter, a chatID of 0 disables reporting to Telegram.
+// The secrets, e.g. the bot token, are redacted from the reports.
+func NewErrorReporter(bot Sender, chatID int64, secrets ...string) *ErrorReporter {
+	return &ErrorReporter{bot: bot, chatID: chatID, secrets: secrets}
+}
+
+// Report logs the failure with the update ID, the user and the command,
+// and forwards it to the error chat if one is configured
+func (r *ErrorReporter) Report(req *Request, err error) {
+	req.Logger().Error("Request failed", "error", err)
+
+	who := "unknown"
+	if req.User != nil {
+		who = strconv.Itoa(req.User.ID)
+		if req.User.UserName != "" {
+			who += " (@" + req.User.UserName + ")"
+		}
+	}
+	if r == nil || r.chatID == 0 || r.bot == nil {
+		return
+	}
+	text := fmt.Sprintf("⚠️ Error in update %d (%s)\nUser: %s\nRequest: %s\nError: %v", req.UpdateID, req.ID, who, req.Name, err)
+	// Errors of the Telegram API client contain the request URL with the bot token
+	text = redactString(text, r.secrets)
+	if len([]rune(text)) > maxMessageLength {
+		text = string([]rune(text)[:maxMessageLength])
+	}
+	if _, sendErr := r.bot.Send(tgbotapi.NewMessage(r.chatID, text)); sendErr != nil {
+		req.Logger().Error("Failed to report error", "chat_id", r.chatID, "error", sendErr)
+	}
+}
+
+// Middleware returns the middleware that turns handler errors into replies.
+// Rejections are only shown to the user, failures are also reported.
+func (r *ErrorReporter) Middleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		err := next(ctx, bot, db, req)
+		if err == nil {
+			return nil
+		}
+
+		var botErr *BotError
+		if !errors.As(err, &botErr) {
+			botErr = &BotError{Key: "internal_error", Err: err}
+		}
+		if botErr.Err != nil {
+			r.Report(req, botErr.Err)
+		}
+		req.ReplyInChat(bot, T(userLanguage(ctx, db, req.User), botErr.Key, botErr.Args...))
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestErrorReporter(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	const errorChat = 100
+
+	Commands = NewCommandRegistry(NewErrorReporter(sender, errorChat, "123:secret").Middleware, RecoverMiddleware)
+	fail := func(err error) CommandHandlerFunc {
+		return func(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+			return err
+		}
+	}
+	Commands.Register(
+		Command{Name: "fail", Handler: fail(Fail(errors.New("database is locked, token 123:secret"), "error_export_fetch"))},
+		Command{Name: "reject", Handler: fail(Reject("export_invalid_args", "bad"))},
+		Command{Name: "plain", Handler: fail(errors.New("unexpected"))},
+		Command{Name: "panic", Handler: func(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+			panic("nil map")
+		}},
+	)
+	registerCommands(Commands)
+
+	// sent returns the texts sent to the user and to the error chat
+	sent := func(text string) (user, reports []string) {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: text}.update(7))
+		for _, msg := range sender.messages {
+			if msg.ChatID == errorChat {
+				reports = append(reports, msg.Text)
+			} else {
+				user = append(user, msg.Text)
+			}
+		}
+		return user, reports
+	}
+
+	// The user gets the catalog message only, the error goes to the error chat without secrets
+	user, reports := sent("/fail")
+	if len(user) != 1 || user[0] != T("en", "error_export_fetch") {
+		t.Errorf("failure shown to the user as %q", user)
+	}
+	if len(reports) != 1 || !strings.Contains(reports[0], "database is locked") || strings.Contains(reports[0], "123:secret") ||
+		!strings.Contains(reports[0], "update 7") || !strings.Contains(reports[0], "/fail") || !strings.Contains(reports[0], "1 (@ivan)") {
+		t.Errorf("report = %q", reports)
+	}
+
+	user, reports = sent("/reject")
+	if len(user) != 1 || user[0] != T("en", "export_invalid_args", "bad") || len(reports) != 0 {
+		t.Errorf("rejection: user %q, reports %q", user, reports)
+	}
+
+	user, reports = sent("/plain")
+	if len(user) != 1 || user[0] != T("en", "internal_error") || len(reports) != 1 || !strings.Contains(reports[0], "unexpected") {
+		t.Errorf("plain error: user %q, reports %q", user, reports)
+	}
+
+	// A panic is reported like a failure and the next update is handled
+	user, reports = sent("/panic")
+	if len(user) != 1 || user[0] != T("en", "internal_error") || len(reports) != 1 || !strings.Contains(reports[0], "panic: nil map") {
+		t.Errorf("panic: user %q, reports %q", user, reports)
+	}
+	if user, _ = sent("/help"); len(user) != 1 {
+		t.Errorf("/help after a panic = %q", user)
+	}
+}
+
+func TestHandleUpdateRecovers(t *testing.T) {
+	sender := setupHandlers(t)
+	// Without RecoverMiddleware the registry still contains the panic to its update
+	Commands = NewCommandRegistry()
+	Commands.Register(Command{Name: "panic", Handler: func(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+		panic("nil map")
+	}})
+	Commands.HandleUpdate(context.Background(), sender, NewMemoryRepository(), step{user: 1, text: "/panic"}.update(1))
+	if len(sender.messages) != 0 {
+		t.Errorf("messages = %+v", sender.messages)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventSummary is an event with its live counts, as shown by the admin UI and the API
+type eventSummary struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Date       time.Time `json:"date"`
+	Capacity   int       `json:"capacity"`
+	Registered int       `json:"registered"`
+	SeatsLeft  int       `json:"seats_left"`
+	CheckedIn  int       `json:"checked_in"`
+	Waitlist   int       `json:"waitlist"`
+	Active     bool      `json:"active"`
+}
+
+// eventSummaries returns all events with their counts, newest first
+func eventSummaries(ctx context.Context, db Repository) ([]eventSummary, error) {
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := db.GetEventCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]eventSummary, 0, len(events))
+	for _, event := range events {
+		result = append(result, eventSummary{
+			ID:         event.id,
+			Name:       event.name,
+			Date:       event.date,
+			Capacity:   event.capacity,
+			Registered: event.registrationCount,
+			SeatsLeft:  max(event.capacity-event.registrationCount, 0),
+			CheckedIn:  counts[event.id].CheckedIn,
+			Waitlist:   counts[event.id].Waitlist,
+			Active:     event.state == "active",
+		})
+	}
+	return result, nil
+}
+
+// exportedRegistration is a registration in the machine-readable exports of the
+// command-line interface and the admin UI, unlike /export it is not localized
+type exportedRegistration struct {
+	TelegramID       int    `json:"telegram_id"`
+	Username         string `json:"username"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	RegistrationDate string `json:"registration_date"`
+	EventID          int    `json:"event_id"`
+	Registered       bool   `json:"registered"`
+	Visited          bool   `json:"visited"`
+}
+
+// eventRegistrations returns the registrations of an event in the export format
+func eventRegistrations(ctx context.Context, db Repository, eventID int) ([]exportedRegistration, error) {
+	registrations, err := db.GetEventRegistrations(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	rows := []exportedRegistration{}
+	for _, reg := range registrations {
+		rows = append(rows, exportedRegistration{
+			TelegramID:       reg.TelegramID,
+			Username:         reg.Username,
+			Name:             reg.Name,
+			Email:            reg.Email,
+			RegistrationDate: reg.RegistrationDate.Format(time.RFC3339),
+			EventID:          reg.EventID,
+			Registered:       reg.Registred == 1,
+			Visited:          reg.Visited == 1,
+		})
+	}
+	return rows, nil
+}
+
+// writeRegistrationsJSON writes registrations as an indented JSON array
+func writeRegistrationsJSON(w io.Writer, rows []exportedRegistration) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// writeRegistrationsCSV writes registrations as CSV with a header row.
+// The texts typed by users are escaped like in /export, admins open the file in spreadsheets.
+func writeRegistrationsCSV(w io.Writer, rows []exportedRegistration) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"telegram_id", "username", "name", "email", "registration_date", "event_id", "registered", "visited"})
+	for _, row := range rows {
+		cw.Write([]string{
+			strconv.Itoa(row.TelegramID),
+			escapeFormula(row.Username),
+			escapeFormula(row.Name),
+			escapeFormula(row.Email),
+			row.RegistrationDate,
+			strconv.Itoa(row.EventID),
+			strconv.FormatBool(row.Registered),
+			strconv.FormatBool(row.Visited),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportCallbackPrefix starts the data of the /export keyboard buttons, the rest are /export arguments
+const exportCallbackPrefix = "export:"
+
+// exportFilters select the users in the /export file
+var exportFilters = []string{"registered", "visited", "noshow", "waitlist", "all"}
+
+// exportFormats are the file types of /export
+var exportFormats = []string{"csv", "xlsx", "json"}
+
+// exportRecentEvents is the number of past events offered on the /export keyboard
+const exportRecentEvents = 5
+
+// exportOptions selects what /export exports and how. Empty fields were not given.
+type exportOptions struct {
+	Event  string // Event is "current", "all", an event ID or a date range like 2024-01-01..2024-06-30.
+	Filter string // Filter is one of exportFilters.
+	Format string // Format is one of exportFormats.
+}
+
+// parseExportArgs parses /export arguments like "event=3 filter=visited format=xlsx"
+func parseExportArgs(args string) (exportOptions, error) {
+	var opts exportOptions
+	for _, arg := range strings.Fields(args) {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return opts, fmt.Errorf("invalid option %q", arg)
+		}
+		value = strings.ToLower(value)
+		switch strings.ToLower(key) {
+		case "event":
+			if _, _, err := parseExportEvent(value); err != nil {
+				return opts, err
+			}
+			opts.Event = value
+		case "filter":
+			if !slices.Contains(exportFilters, value) {
+				return opts, fmt.Errorf("unknown filter %q", value)
+			}
+			opts.Filter = value
+		case "format":
+			if !slices.Contains(exportFormats, value) {
+				return opts, fmt.Errorf("unknown format %q", value)
+			}
+			opts.Format = value
+		default:
+			return opts, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return opts, nil
+}
+
+// parseExportEvent checks an event selector, for a date range it returns the first and the last day
+func parseExportEvent(value string) (time.Time, time.Time, error) {
+	if value == "current" || value == "all" {
+		return time.Time{}, time.Time{}, nil
+	}
+	if id, err := strconv.Atoi(value); err == nil && id > 0 {
+		return time.Time{}, time.Time{}, nil
+	}
+	fromStr, toStr, ok := strings.Cut(value, "..")
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid event %q", value)
+	}
+	from, err := time.Parse("2006-01-02", fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", fromStr)
+	}
+	to, err := time.Parse("2006-01-02", toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", toStr)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range %q ends before it starts", value)
+	}
+	return from, to, nil
+}
+
+// withDefaults fills the options that were not given: the current event, everyone, CSV
+func (o exportOptions) withDefaults() exportOptions {
+	if o.Event == "" {
+		o.Event = "current"
+	}
+	if o.Filter == "" {
+		o.Filter = "all"
+	}
+	if o.Format == "" {
+		o.Format = "csv"
+	}
+	return o
+}
+
+// args returns the options as /export arguments, the keyboard buttons carry them in their data
+func (o exportOptions) args() string {
+	var args []string
+	if o.Event != "" {
+		args = append(args, "event="+o.Event)
+	}
+	if o.Filter != "" {
+		args = append(args, "filter="+o.Filter)
+	}
+	if o.Format != "" {
+		args = append(args, "format="+o.Format)
+	}
+	return strings.Join(args, " ")
+}
+
+// filename returns the name of the export file
+func (o exportOptions) filename(now time.Time) string {
+	event := strings.ReplaceAll(o.Event, "..", "_")
+	return "registrations_" + event + "_" + o.Filter + "_" + now.Format("20060102_150405") + "." + o.Format
+}
+
+// exportEvents returns the events an event selector matches, newest first
+func exportEvents(ctx context.Context, db Repository, selector string) ([]Event, error) {
+	if selector == "current" {
+		event, err := db.GetLatestEvent(ctx)
+		if err != nil || event == nil {
+			return nil, err
+		}
+		return []Event{*event}, nil
+	}
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if selector == "all" {
+		return events, nil
+	}
+	from, to, _ := parseExportEvent(selector)
+	id, _ := strconv.Atoi(selector)
+	var matched []Event
+	for _, event := range events {
+		day := time.Date(event.date.Year(), event.date.Month(), event.date.Day(), 0, 0, 0, 0, time.UTC)
+		if event.id == id || (id == 0 && !day.Before(from) && !day.After(to)) {
+			matched = append(matched, event)
+		}
+	}
+	return matched, nil
+}
+
+// exportRow is a line of the /export file. For the waitlist the date is when the user joined it.
+type exportRow struct {
+	TelegramID       int       `json:"telegram_id"`
+	Username         string    `json:"username"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	RegistrationDate time.Time `json:"registration_date"`
+	EventID          int       `json:"event_id"`
+	EventName        string    `json:"event_name"`
+	EventDate        time.Time `json:"event_date"`
+	Registered       bool      `json:"registered"`
+	Visited          bool      `json:"visited"`
+}
+
+// exportRows returns the rows of the events matching the filter
+func exportRows(ctx context.Context, db Repository, events []Event, filter string) ([]exportRow, error) {
+	rows := []exportRow{}
+	if filter == "waitlist" {
+		for _, event := range events {
+			waitlist, err := db.GetWaitlistForEvent(ctx, event.id)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range waitlist {
+				_, name, email, err := db.HasUserInfo(ctx, entry.TelegramID)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, exportRow{
+					TelegramID:       entry.TelegramID,
+					Username:         entry.Username,
+					Name:             name,
+					Email:            email,
+					RegistrationDate: entry.JoinedDate,
+					EventID:          event.id,
+					EventName:        event.name,
+					EventDate:        event.date,
+				})
+			}
+		}
+		return rows, nil
+	}
+
+	selected := make(map[int]bool, len(events))
+	for _, event := range events {
+		selected[event.id] = true
+	}
+	registrations, err := db.GetAllRegistrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, reg := range registrations {
+		if !selected[reg.EventID] {
+			continue
+		}
+		registered, visited := reg.Registred == 1, reg.Visited == 1
+		if (filter == "registered" && !registered) || (filter == "visited" && !visited) || (filter == "noshow" && (!registered || visited)) {
+			continue
+		}
+		rows = append(rows, exportRow{
+			TelegramID:       reg.TelegramID,
+			Username:         reg.Username,
+			Name:             reg.Name,
+			Email:            reg.Email,
+			RegistrationDate: reg.RegistrationDate,
+			EventID:          reg.EventID,
+			EventName:        reg.EventName,
+			EventDate:        reg.EventDate,
+			Registered:       registered,
+			Visited:          visited,
+		})
+	}
+	return rows, nil
+}
+
+// exportTable returns the rows with a localized header as the cells of a CSV or XLSX file
+func exportTable(lang string, rows []exportRow) [][]string {
+	yesNo := func(b bool) string {
+		if b {
+			return T(lang, "export_yes")
+		}
+		return T(lang, "export_no")
+	}
+	table := [][]string{{
+		T(lang, "export_column_telegram_id"),
+		T(lang, "export_column_username"),
+		T(lang, "export_column_name"),
+		T(lang, "export_column_email"),
+		T(lang, "export_column_registration_date"),
+		T(lang, "export_column_event"),
+		T(lang, "export_column_event_date"),
+		T(lang, "export_column_registered"),
+		T(lang, "export_column_visited"),
+	}}
+	for _, row := range rows {
+		table = append(table, []string{
+			strconv.Itoa(row.TelegramID),
+			escapeFormula(row.Username),
+			escapeFormula(row.Name),
+			escapeFormula(row.Email),
+			row.RegistrationDate.Format("02.01.2006 15:04"),
+			escapeFormula(row.EventName),
+			row.EventDate.Format("02.01.2006"),
+			yesNo(row.Registered),
+			yesNo(row.Visited),
+		})
+	}
+	return table
+}
+
+// escapeFormula keeps spreadsheets from running a cell typed by a user as a formula:
+// a value starting with =, +, -, @, a tab or a carriage return gets a leading '
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// writeExport writes the rows in the format of the options
+func writeExport(w io.Writer, lang, format string, rows []exportRow) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "xlsx":
+		return writeXLSX(w, T(lang, "export_sheet_name"), exportTable(lang, rows))
+	default:
+		// Write UTF-8 BOM for better Excel compatibility
+		io.WriteString(w, "\xEF\xBB\xBF")
+		cw := csv.NewWriter(w)
+		cw.WriteAll(exportTable(lang, rows))
+		return cw.Error()
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestParseExportArgs(t *testing.T) {
+	opts, err := parseExportArgs("event=2024-01-01..2024-06-30 filter=NoShow format=xlsx")
+	if err != nil || opts != (exportOptions{Event: "2024-01-01..2024-06-30", Filter: "noshow", Format: "xlsx"}) {
+		t.Errorf("parseExportArgs = %+v, %v", opts, err)
+	}
+	if opts, _ := parseExportArgs("event=3"); opts.withDefaults() != (exportOptions{Event: "3", Filter: "all", Format: "csv"}) {
+		t.Errorf("defaults = %+v", opts.withDefaults())
+	}
+	for _, args := range []string{"current", "event=last", "event=2024-06-30..2024-01-01", "filter=cancelled", "format=pdf", "since=2024-01-01"} {
+		if _, err := parseExportArgs(args); err == nil {
+			t.Errorf("parseExportArgs(%q) succeeded", args)
+		}
+	}
+}
+
+// exportDocument returns the name and the content of the document sent last
+func exportDocument(t *testing.T, sender *fakeSender) (string, []byte) {
+	t.Helper()
+	for i := len(sender.other) - 1; i >= 0; i-- {
+		if doc, ok := sender.other[i].(tgbotapi.DocumentConfig); ok {
+			file := doc.File.(tgbotapi.FileBytes)
+			return file.Name, file.Bytes
+		}
+	}
+	t.Fatalf("no document sent, messages: %q", sender.texts())
+	return "", nil
+}
+
+func TestExport(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Spring", time.Date(2024, 3, 1, 19, 0, 0, 0, time.UTC), 50))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: 1, Registred: 1, Visited: 1}))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 2, Username: "anna", Name: "Petrova Anna", EventID: 1, Registred: 1}))
+	mustNoError(t, db.MarkEventsAsPast(ctx))
+	mustNoError(t, db.AddEvent(ctx, "Summer", time.Now().AddDate(0, 0, 7), 1))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: 2, Registred: 1}))
+	mustNoError(t, db.AddToWaitlist(ctx, 2, 2, "anna", 2))
+
+	export := func(args string) []exportRow {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export " + args}.update(1))
+		_, body := exportDocument(t, sender)
+		var rows []exportRow
+		mustNoError(t, json.Unmarshal(body, &rows))
+		return rows
+	}
+
+	t.Run("Arguments", func(t *testing.T) {
+		if rows := export("format=json"); len(rows) != 1 || rows[0].EventName != "Summer" {
+			t.Errorf("current event = %+v", rows)
+		}
+		if rows := export("event=1 filter=noshow format=json"); len(rows) != 1 || rows[0].Username != "anna" {
+			t.Errorf("no-shows of event 1 = %+v", rows)
+		}
+		if rows := export("event=all filter=visited format=json"); len(rows) != 1 || rows[0].EventID != 1 {
+			t.Errorf("visited = %+v", rows)
+		}
+		if rows := export("event=2024-01-01..2024-03-01 format=json"); len(rows) != 2 {
+			t.Errorf("date range = %+v", rows)
+		}
+		if rows := export("filter=waitlist format=json"); len(rows) != 1 || rows[0].Username != "anna" || rows[0].Name != "" || rows[0].Registered {
+			t.Errorf("waitlist = %+v", rows)
+		}
+
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export event=2023-01-01..2023-12-31"}.update(1))
+		if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "export_empty") {
+			t.Errorf("export without rows = %q", texts)
+		}
+
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export format=pdf"}.update(1))
+		if texts := sender.texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "Invalid option") {
+			t.Errorf("invalid format = %q", texts)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export event=all"}.update(1))
+		name, body := exportDocument(t, sender)
+		records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xEF\xBB\xBF")))).ReadAll()
+		if !strings.HasPrefix(name, "registrations_all_all_") || !strings.HasSuffix(name, ".csv") || err != nil ||
+			len(records) != 4 || records[0][0] != T("en", "export_column_telegram_id") {
+			t.Errorf("%s = %q, %v", name, records, err)
+		}
+	})
+
+	t.Run("Keyboard", func(t *testing.T) {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export"}.update(1))
+		if len(sender.messages) != 1 || sender.messages[0].Text != T("en", "export_choose_event") {
+			t.Fatalf("messages = %+v", sender.messages)
+		}
+		keyboard := sender.messages[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+		if len(keyboard.InlineKeyboard) != 3 || keyboard.InlineKeyboard[1][0].Text != "Spring (01.03.2024)" {
+			t.Fatalf("event keyboard = %+v", keyboard.InlineKeyboard)
+		}
+
+		// Press the past event, the no-shows and XLSX
+		data := *keyboard.InlineKeyboard[1][0].CallbackData
+		for _, choice := range []int{2, 0} {
+			sender.reset()
+			Commands.HandleUpdate(ctx, sender, db, step{user: 3, data: data}.update(2))
+			edit, ok := sender.other[0].(tgbotapi.EditMessageTextConfig)
+			if !ok || edit.ReplyMarkup == nil {
+				t.Fatalf("after %q sent %+v", data, sender.other)
+			}
+			rows := edit.ReplyMarkup.InlineKeyboard
+			if len(rows) == 1 {
+				data = *rows[0][1].CallbackData
+			} else {
+				data = *rows[choice][0].CallbackData
+			}
+		}
+		if data != "export:event=1 filter=noshow format=xlsx" {
+			t.Fatalf("callback data = %q", data)
+		}
+
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, data: data}.update(3))
+		name, body := exportDocument(t, sender)
+		if !strings.HasSuffix(name, ".xlsx") || !strings.Contains(xlsxSheet(t, body), "<t xml:space=\"preserve\">anna</t>") {
+			t.Errorf("%s has no row of anna", name)
+		}
+
+		// A user without the export permission can't use the buttons
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, data: data}.update(4))
+		if len(sender.other) != 0 || len(sender.answers) != 1 || sender.answers[0].Text != T("en", "permission_denied") {
+			t.Errorf("export by a user without a role: answers %+v, sent %+v", sender.answers, sender.other)
+		}
+	})
+}
+
+// xlsxSheet returns the XML of the first worksheet of a workbook
+func xlsxSheet(t *testing.T, workbook []byte) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sheet, _ := io.ReadAll(f)
+	return string(sheet)
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	mustNoError(t, writeXLSX(&buf, "Sheet <1>", [][]string{{"Name", "Email"}, {"Ivanov & Sons", "a@b.c"}}))
+	sheet := xlsxSheet(t, buf.Bytes())
+	for _, want := range []string{`<c r="A1" t="inlineStr">`, `<c r="B2" t="inlineStr">`, "Ivanov &amp; Sons"} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet has no %s:\n%s", want, sheet)
+		}
+	}
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestExportFormulas(t *testing.T) {
+	setupHandlers(t)
+	table := exportTable("en", []exportRow{{
+		TelegramID: 1,
+		Username:   "ivan",
+		Name:       `=HYPERLINK("http://evil","x")`,
+		Email:      "@SUM(A1)",
+		EventName:  "-1+1",
+	}})
+	for i, want := range map[int]string{1: "ivan", 2: `'=HYPERLINK("http://evil","x")`, 3: "'@SUM(A1)", 5: "'-1+1"} {
+		if got := table[1][i]; got != want {
+			t.Errorf("cell %d = %q, want %q", i, got, want)
+		}
+	}
+	var buf bytes.Buffer
+	mustNoError(t, writeRegistrationsCSV(&buf, []exportedRegistration{{TelegramID: 1, Username: "ivan", Name: "=1+1", Email: "-2@example.com", EventID: 1}}))
+	if !strings.Contains(buf.String(), "\n1,ivan,'=1+1,'-2@example.com,") {
+		t.Errorf("CSV of the admin UI and the CLI = %q", buf.String())
+	}
+	for value, want := range map[string]string{"": "", "+7 999": "'+7 999", "\tx": "'\tx", "a=b": "a=b"} {
+		if got := escapeFormula(value); got != want {
+			t.Errorf("escapeFormula(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// fakeBotAPIWait is how long a test waits for the bot to make an expected call
+const fakeBotAPIWait = 10 * time.Second
+
+// fakeBotAPI is a stand-in for the Telegram Bot API server.
+// Tests push updates that the bot receives with getUpdates and wait for the calls the bot makes.
+// It supports getMe, getUpdates, sendMessage, sendPhoto, sendDocument, answerCallbackQuery,
+// editMessageText and setMyCommands, which the bot calls on start.
+type fakeBotAPI struct {
+	server *httptest.Server
+	token  string
+	self   tgbotapi.User
+
+	mu        sync.Mutex
+	updates   []tgbotapi.Update // updates not yet confirmed with an offset
+	updateID  int               // ID of the last pushed update
+	messageID int               // ID of the last sent message
+	calls     []fakeCall        // calls other than getMe and getUpdates, in order
+	consumed  []bool            // calls already returned by WaitForCall
+	changed   chan struct{}     // closed and replaced when updates or calls are added
+	closed    chan struct{}     // closed when the server shuts down
+}
+
+// fakeCall is a Bot API call made by the bot
+type fakeCall struct {
+	Method string
+	Params url.Values
+	Files  map[string]string // Files maps the form field of each uploaded file to its name
+}
+
+// ChatID returns the chat_id parameter of the call
+func (c fakeCall) ChatID() int64 {
+	id, _ := strconv.ParseInt(c.Params.Get("chat_id"), 10, 64)
+	return id
+}
+
+// newFakeBotAPI starts a fake Bot API server accepting token, it is closed when the test ends
+func newFakeBotAPI(t *testing.T, token string) *fakeBotAPI {
+	f := &fakeBotAPI{
+		token:   token,
+		self:    tgbotapi.User{ID: 1000, IsBot: true, FirstName: "Meetup", UserName: "meetup_test_bot"},
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// URL returns the base URL to use as TELEGRAM_API_URL
+func (f *fakeBotAPI) URL() string {
+	return f.server.URL
+}
+
+// Close stops the server, pending getUpdates calls return first
+func (f *fakeBotAPI) Close() {
+	f.mu.Lock()
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+	f.mu.Unlock()
+	f.server.Close()
+}
+
+// notify wakes up everyone waiting for a change, the caller must hold the lock
+func (f *fakeBotAPI) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// PushUpdate queues an update for the bot, the update ID is assigned by the server
+func (f *fakeBotAPI) PushUpdate(update tgbotapi.Update) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updateID++
+	update.UpdateID = f.updateID
+	f.updates = append(f.updates, update)
+	f.notify()
+}
+
+// PushMessage queues a private text message of user, commands get their entity like in Telegram
+func (f *fakeBotAPI) PushMessage(user *tgbotapi.User, text string) {
+	msg := &tgbotapi.Message{
+		MessageID: int(time.Now().UnixNano() % 1e9),
+		From:      user,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: int64(user.ID), Type: "private"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
+	}
+	f.PushUpdate(tgbotapi.Update{Message: msg})
+}
+
+// PushCallback queues a press of an inline button with data in the private chat of user
+func (f *fakeBotAPI) PushCallback(user *tgbotapi.User, data string) {
+	f.PushUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		From: user,
+		Data: data,
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: int64(user.ID), Type: "private"},
+		},
+	}})
+}
+
+// WaitForCall waits for the first call of method that match accepts and that no earlier
+// WaitForCall returned. A nil match accepts any call of the method.
+func (f *fakeBotAPI) WaitForCall(t *testing.T, method string, match func(fakeCall) bool) fakeCall {
+	t.Helper()
+	timeout := time.After(fakeBotAPIWait)
+	for {
+		f.mu.Lock()
+		for i, call := range f.calls {
+			if !f.consumed[i] && call.Method == method && (match == nil || match(call)) {
+				f.consumed[i] = true
+				f.mu.Unlock()
+				return call
+			}
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("bot did not call %s in %s, calls: %+v", method, fakeBotAPIWait, f.Calls(""))
+			return fakeCall{}
+		}
+	}
+}
+
+// WaitForMessage waits for a sendMessage call to chatID with text
+func (f *fakeBotAPI) WaitForMessage(t *testing.T, chatID int64, text string) fakeCall {
+	t.Helper()
+	return f.WaitForCall(t, "sendMessage", func(c fakeCall) bool {
+		return c.ChatID() == chatID && c.Params.Get("text") == text
+	})
+}
+
+// Calls returns the calls of method made so far, an empty method returns all calls
+func (f *fakeBotAPI) Calls(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ServeHTTP answers the Bot API calls at /bot<token>/<method>
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+f.token+"/")
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	call, err := parseFakeCall(method, r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	var result interface{}
+	switch method {
+	case "getMe":
+		writeAPIResult(w, f.self)
+		return
+	case "getUpdates":
+		writeAPIResult(w, f.getUpdates(r.Context(), call.Params))
+		return
+	case "sendMessage", "sendPhoto", "sendDocument", "editMessageText":
+		if call.Params.Get("chat_id") == "" {
+			writeAPIError(w, http.StatusBadRequest, "Bad Request: chat_id is empty")
+			return
+		}
+		result = f.newMessage(call)
+	case "answerCallbackQuery", "setMyCommands":
+		result = true
+	default:
+		writeAPIError(w, http.StatusNotFound, "Not Found: method not found")
+		return
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.consumed = append(f.consumed, false)
+	f.notify()
+	f.mu.Unlock()
+	writeAPIResult(w, result)
+}
+
+// parseFakeCall reads the parameters of a call sent as a form or as multipart with files
+func parseFakeCall(method string, r *http.Request) (fakeCall, error) {
+	call := fakeCall{Method: method, Files: make(map[string]string)}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return call, err
+		}
+		call.Params = url.Values(r.MultipartForm.Value)
+		for field, files := range r.MultipartForm.File {
+			call.Files[field] = files[0].Filename
+		}
+		return call, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return call, err
+	}
+	call.Params = r.Form
+	return call, nil
+}
+
+// getUpdates returns the updates from the offset on, waiting up to the timeout if there are none
+func (f *fakeBotAPI) getUpdates(ctx context.Context, params url.Values) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	timeout, _ := strconv.Atoi(params.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		f.mu.Lock()
+		// Updates before the offset are confirmed, like Telegram the server forgets them
+		kept := f.updates[:0]
+		for _, update := range f.updates {
+			if update.UpdateID >= offset {
+				kept = append(kept, update)
+			}
+		}
+		f.updates = kept
+		if len(f.updates) > 0 || timeout <= 0 {
+			updates := append([]tgbotapi.Update{}, f.updates...)
+			f.mu.Unlock()
+			return updates
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return []tgbotapi.Update{}
+		case <-ctx.Done():
+			return []tgbotapi.Update{}
+		case <-f.closed:
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+// newMessage returns the message the bot sent or edited with the call
+func (f *fakeBotAPI) newMessage(call fakeCall) tgbotapi.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, _ := strconv.Atoi(call.Params.Get("message_id"))
+	if id == 0 {
+		f.messageID++
+		id = f.messageID
+	}
+	return tgbotapi.Message{
+		MessageID: id,
+		From:      &f.self,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: call.ChatID(), Type: "private"},
+		Text:      call.Params.Get("text"),
+		Caption:   call.Params.Get("caption"),
+	}
+}
+
+// writeAPIResult writes a successful Bot API response
+func writeAPIResult(w http.ResponseWriter, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+// writeAPIError writes a failed Bot API response
+func writeAPIError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
@@ -1,213 +1,469 @@
 package main
 
 import (
-	"encoding/csv"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
-	"github.com/skip2/go-qrcode"
 )
 
-// handleCommand routes commands to corresponding handlers.
-func handleCommand(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	if msg.Command() == "start" && strings.ToLower(msg.CommandArguments()) == "imhere" {
-		handleImhere(bot, db, msg)
-		return
+// handleMessage routes a message: commands go to the command registry,
+// other messages continue the registration dialog or get the registration button.
+func handleMessage(ctx context.Context, bot Sender, db Repository, req *Request) error {
+	msg := req.Message
+	// Check if user is in a dialog
+	dialogState, eventID := DialogMgr.GetState(msg.From.ID)
+
+	if msg.IsCommand() {
+		switch dialogState {
+		case NoDialog:
+		case WaitingForImportFile, ReviewingImport, CheckinMode:
+			// An import or the check-in mode has no registration to remove, any command ends it
+			DialogMgr.ClearState(msg.From.ID)
+		default:
+			// If user is in a dialog and sends a command, cancel the dialog and remove incomplete registration
+			handleDialogCancel(ctx, bot, db, msg, eventID)
+		}
+		return Commands.Dispatch(ctx, bot, db, req)
 	}
-	switch msg.Command() {
-	case "start":
-		sendMessage(bot, msg.Chat.ID, "Добро пожаловать! \nИспользуйте /start для регистрации или дерегистрации на митап."+
-			"\nИспользуйте /state для получения статуса регистрации.")
-		handleNoDialog(bot, db, msg)
-	case "register":
-		handleRegister(bot, db, msg)
-	case "addevent":
-		AdminCheckMiddleware(handleAddEvent)(bot, db, msg)
-	case "qrcode":
-		AdminCheckMiddleware(handleQRCode)(bot, db, msg)
-	case "state":
-		handleState(bot, db, msg)
-	case "export":
-		AdminCheckMiddleware(handleExport)(bot, db, msg)
-	case "remove":
-		AdminCheckMiddleware(handleRemoveUser)(bot, db, msg)
-	default:
-		sendMessage(bot, msg.Chat.ID, "Неизвестная команда")
+	if dialogState != NoDialog {
+		// Handle dialog based on state
+		return handleDialog(ctx, bot, db, msg, dialogState, eventID)
 	}
+	// No dialog mode: show appropriate button based on registration status
+	return handleNoDialog(ctx, bot, db, msg)
 }
 
-// handleExport handles the /export command.
-// Creates a CSV file with all registrations and sends it to the user
-func handleExport(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	registrations, err := db.GetAllRegistrations()
-	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка получения данных о регистрациях: "+err.Error())
-		return
+// handleStart handles the /start command, including the deep links of the bot: the check-in
+// QR code, the tickets, the event invites and the referral links, see parseStartPayload.
+func handleStart(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	linkImportedRegistrations(ctx, db, msg.From)
+	payload := parseStartPayload(msg.CommandArguments())
+	switch payload.Kind {
+	case PayloadCheckin:
+		return handleImhere(ctx, bot, db, msg, payload.Code)
+	case PayloadTicket:
+		return handleTicketScan(ctx, bot, db, msg, payload.Code)
 	}
+	lang := userLanguage(ctx, db, msg.From)
+	event, _ := db.GetLatestEvent(ctx)
+	switch payload.Kind {
+	case PayloadInvite:
+		// An invite shared before the event ended still shows the current one
+		if event == nil || event.id != payload.EventID {
+			sendMessage(bot, msg.Chat.ID, T(lang, "invite_event_over"))
+		}
+	case PayloadReferral:
+		recordReferral(ctx, db, msg.From, payload.UserID, event)
+	}
+	sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, lang, "welcome", newTemplateData(event, msg.From)))
+	return handleNoDialog(ctx, bot, db, msg)
+}
 
-	if len(registrations) == 0 {
-		sendMessage(bot, msg.Chat.ID, "Регистрации отсутствуют")
+// recordReferral records in the audit log who brought a user with their link.
+// Only the first link a user opens counts, and a link opened by its own user doesn't.
+func recordReferral(ctx context.Context, db Repository, user *tgbotapi.User, referrerID int, event *Event) {
+	if referrerID == user.ID {
 		return
 	}
-
-	// Create CSV file
-	filename := "registrations_export_" + time.Now().Format("20060102_150405") + ".csv"
-	file, err := os.Create(filename)
+	entries, err := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditReferral), UserID: user.ID})
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка создания файла: "+err.Error())
+		slog.Error("Failed to check the referrals of a user", "user_id", user.ID, "error", err)
 		return
 	}
+	for _, entry := range entries {
+		if entry.ActorID == user.ID {
+			return
+		}
+	}
+	eventID := 0
+	if event != nil {
+		eventID = event.id
+	}
+	audit(ctx, db, AuditReferral, user, referrerID, "", eventID, "")
+}
 
-	// Write UTF-8 BOM for better Excel compatibility
-	file.WriteString("\xEF\xBB\xBF")
-
-	// Create CSV writer
-	writer := csv.NewWriter(file)
+// handleInvite handles the /invite command.
+// Sends the invite link of the current event and the user's own referral link.
+func handleInvite(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
+	}
+	invite, err := Links.Start(InvitePayload(event.id))
+	if err != nil {
+		return Fail(err, "error_invite_link")
+	}
+	referral, err := Links.Start(ReferralPayload(msg.From.ID))
+	if err != nil {
+		return Fail(err, "error_invite_link")
+	}
+	sendMessage(bot, msg.Chat.ID, T(lang, "invite_links", event.name, invite, referral))
+	return nil
+}
 
-	// Write header
-	header := []string{
-		"ID Telegram",
-		"Имя пользователя",
-		"Полное имя",
-		"Email",
-		"Дата регистрации",
-		"Событие",
-		"Дата события",
-		"Зарегистрирован",
-		"Посетил",
+// handleHelp handles the /help command.
+// Lists the commands the user is allowed to run.
+func handleHelp(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	text := T(lang, "help_header")
+	for _, cmd := range Commands.Available(ctx, db, msg.From) {
+		text += "\n/" + cmd.Name + " - " + T(lang, cmd.Description)
 	}
+	sendMessage(bot, msg.Chat.ID, text)
+	return nil
+}
 
-	if err := writer.Write(header); err != nil {
-		file.Close()
-		sendMessage(bot, msg.Chat.ID, "Ошибка записи заголовка CSV: "+err.Error())
-		return
+// handleExport handles the /export command.
+// With arguments it sends the file right away, the options not given take their defaults.
+// Without arguments it asks for the event, the users and the format with inline keyboards.
+func handleExport(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	args := strings.TrimSpace(msg.CommandArguments())
+	opts, err := parseExportArgs(args)
+	if err != nil {
+		return Reject("export_invalid_args", err.Error())
 	}
+	if args == "" {
+		text, keyboard, err := exportKeyboard(ctx, db, lang, opts)
+		if err != nil {
+			return Fail(err, "error_export_fetch")
+		}
+		message := tgbotapi.NewMessage(msg.Chat.ID, text)
+		message.ReplyMarkup = keyboard
+		bot.Send(message)
+		return nil
+	}
+	return sendExport(ctx, bot, db, msg.Chat.ID, lang, opts.withDefaults())
+}
 
-	// Write data
-	for _, reg := range registrations {
-		registeredStr := "Нет"
-		if reg.Registred == 1 {
-			registeredStr = "Да"
+// handleExportCallback handles a press on one of the /export keyboards.
+// It asks for the next option, or sends the file once all are chosen.
+func handleExportCallback(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+	lang := userLanguage(ctx, db, cq.From)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, ""))
+
+	opts, err := parseExportArgs(strings.TrimPrefix(cq.Data, exportCallbackPrefix))
+	if err != nil {
+		return Reject("export_invalid_args", err.Error())
+	}
+	if opts.Event == "" || opts.Filter == "" || opts.Format == "" {
+		text, keyboard, err := exportKeyboard(ctx, db, lang, opts)
+		if err != nil {
+			return Fail(err, "error_export_fetch")
 		}
+		edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+		edit.ReplyMarkup = &keyboard
+		bot.Send(edit)
+		return nil
+	}
+
+	bot.Send(tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, T(lang, "export_preparing")))
+	return sendExport(ctx, bot, db, cq.Message.Chat.ID, lang, opts)
+}
 
-		visitedStr := "Нет"
-		if reg.Visited == 1 {
-			visitedStr = "Да"
+// exportKeyboard returns the question and the buttons for the first option of /export not chosen yet
+func exportKeyboard(ctx context.Context, db Repository, lang string, opts exportOptions) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	button := func(text string, next exportOptions) []tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, exportCallbackPrefix+next.args()))
+	}
+
+	switch {
+	case opts.Event == "":
+		events, err := db.GetEvents(ctx)
+		if err != nil {
+			return "", tgbotapi.InlineKeyboardMarkup{}, err
 		}
+		next := opts
+		next.Event = "current"
+		rows := [][]tgbotapi.InlineKeyboardButton{button(T(lang, "export_event_current"), next)}
+		recent := 0
+		for _, event := range events {
+			if event.state == "active" || recent == exportRecentEvents {
+				continue
+			}
+			next.Event = strconv.Itoa(event.id)
+			rows = append(rows, button(event.name+" ("+event.date.Format("02.01.2006")+")", next))
+			recent++
+		}
+		next.Event = "all"
+		rows = append(rows, button(T(lang, "export_event_all"), next))
+		return T(lang, "export_choose_event"), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+
+	case opts.Filter == "":
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, filter := range exportFilters {
+			next := opts
+			next.Filter = filter
+			rows = append(rows, button(T(lang, "export_filter_"+filter), next))
+		}
+		return T(lang, "export_choose_filter"), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
 
-		row := []string{
-			strconv.Itoa(reg.TelegramID),
-			reg.Username,
-			reg.Name,
-			reg.Email,
-			reg.RegistrationDate.Format("02.01.2006 15:04"),
-			reg.EventName,
-			reg.EventDate.Format("02.01.2006"),
-			registeredStr,
-			visitedStr,
+	default:
+		var row []tgbotapi.InlineKeyboardButton
+		for _, format := range exportFormats {
+			next := opts
+			next.Format = format
+			row = append(row, button(strings.ToUpper(format), next)...)
 		}
+		return T(lang, "export_choose_format"), tgbotapi.NewInlineKeyboardMarkup(row), nil
+	}
+}
 
-		if err := writer.Write(row); err != nil {
-			file.Close()
-			sendMessage(bot, msg.Chat.ID, "Ошибка записи данных в CSV: "+err.Error())
-			return
+// sendExport builds the export file for the options in memory and sends it to the chat
+func sendExport(ctx context.Context, bot Sender, db Repository, chatID int64, lang string, opts exportOptions) error {
+	events, err := exportEvents(ctx, db, opts.Event)
+	if err != nil {
+		return Fail(err, "error_export_fetch")
+	}
+	if len(events) == 0 && opts.Event == "current" {
+		sendMessage(bot, chatID, T(lang, "no_active_event"))
+		return nil
+	}
+	rows, err := exportRows(ctx, db, events, opts.Filter)
+	if err != nil {
+		return Fail(err, "error_export_fetch")
+	}
+	if len(rows) == 0 {
+		sendMessage(bot, chatID, T(lang, "export_empty"))
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeExport(&buf, lang, opts.Format, rows); err != nil {
+		return Fail(err, "error_export_write")
+	}
+	doc := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{
+		Name:  opts.filename(time.Now()),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = N(lang, "export_caption", len(rows))
+	if _, err := bot.Send(doc); err != nil {
+		return Fail(err, "error_export_send")
+	}
+	return nil
+}
+
+// handleImport handles the /import command.
+// It starts an import of registrations from a CSV file into the event given as the argument,
+// or the current event. The file itself comes as the next message, see handleImportFile.
+func handleImport(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	var target *Event
+	if args := strings.TrimSpace(msg.CommandArguments()); args != "" {
+		eventID, err := strconv.Atoi(args)
+		if err != nil {
+			return Reject("import_usage")
 		}
+		events, err := db.GetEvents(ctx)
+		if err != nil {
+			return Fail(err, "error_event_fetch")
+		}
+		for i := range events {
+			if events[i].id == eventID {
+				target = &events[i]
+			}
+		}
+		if target == nil {
+			return Reject("import_event_not_found", eventID)
+		}
+	} else {
+		event, err := db.GetLatestEvent(ctx)
+		if err != nil {
+			return Fail(err, "error_event_fetch")
+		}
+		target = event
 	}
 
-	// Flush the writer to ensure all data is written to the file
-	writer.Flush()
+	targetID, targetLabel := 0, T(lang, "import_target_none")
+	if target != nil {
+		targetID, targetLabel = target.id, importEventLabel(target.name, target.date)
+	}
+	DialogMgr.SetState(msg.From.ID, WaitingForImportFile, targetID)
+	sendMessage(bot, msg.Chat.ID, T(lang, "import_send_file", targetLabel))
+	return nil
+}
 
-	// Close the file before sending
-	file.Close()
+// handleImportFile receives the CSV file of an /import and shows the dry run.
+// Another file replaces the one under review.
+func handleImportFile(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, eventID int) error {
+	lang := userLanguage(ctx, db, msg.From)
+	doc := msg.Document
+	if doc == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "import_waiting_file"))
+		return nil
+	}
+	if !isCSVDocument(doc) {
+		return Reject("import_not_csv")
+	}
+	if doc.FileSize > importMaxFileSize {
+		return Reject("import_file_too_large", importMaxFileSize>>10)
+	}
+	data, err := downloadFile(ctx, bot, doc.FileID, importMaxFileSize)
+	if errors.Is(err, errFileTooLarge) {
+		return Reject("import_file_too_large", importMaxFileSize>>10)
+	}
+	if err != nil {
+		return Fail(err, "error_import_download")
+	}
+	records, err := parseImportFile(data)
+	if err != nil {
+		return Reject("import_invalid_file", err.Error())
+	}
 
-	// Send the file to the user
-	fileBytes, err := os.ReadFile(filename)
+	DialogMgr.SetState(msg.From.ID, ReviewingImport, eventID)
+	DialogMgr.SetUserData(msg.From.ID, "import_file", doc.FileName)
+	DialogMgr.SetUserData(msg.From.ID, "import_csv", string(data))
+	DialogMgr.SetUserData(msg.From.ID, "import_mapping", detectImportMapping(records[0]).String())
+	session, err := loadImportSession(msg.From.ID)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка чтения файла: "+err.Error())
-		return
+		return Fail(err, "error_import_plan")
 	}
+	plan, target, err := session.plan(ctx, db)
+	if err != nil {
+		return Fail(err, "error_import_plan")
+	}
+	text, keyboard := importPreview(lang, session, target, plan)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	message.ReplyMarkup = keyboard
+	bot.Send(message)
+	return nil
+}
 
-	fileDoc := tgbotapi.FileBytes{
-		Name:  filename,
-		Bytes: fileBytes,
+// handleImportCallback handles the buttons of the import preview: changing the column of a field,
+// committing the valid rows and cancelling
+func handleImportCallback(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+	lang := userLanguage(ctx, db, cq.From)
+	session, err := loadImportSession(cq.From.ID)
+	if err != nil {
+		return Fail(err, "error_import_plan")
+	}
+	if session == nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, T(lang, "import_expired")))
+		return nil
 	}
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, ""))
 
-	doc := tgbotapi.NewDocumentUpload(msg.Chat.ID, fileDoc)
-	doc.Caption = fmt.Sprintf("Экспорт данных регистраций (%d записей)", len(registrations))
+	action := strings.TrimPrefix(cq.Data, importCallbackPrefix)
+	if action == "cancel" {
+		DialogMgr.ClearState(cq.From.ID)
+		bot.Send(tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, T(lang, "import_cancelled")))
+		return nil
+	}
+	if field, err := strconv.Atoi(strings.TrimPrefix(action, "map:")); err == nil && field >= 0 && field < len(importFields) {
+		session.mapping.next(field, len(session.records[0]))
+		DialogMgr.SetUserData(cq.From.ID, "import_mapping", session.mapping.String())
+	}
 
-	_, err = bot.Send(doc)
+	// The dry run is repeated on commit, the database may have changed since the preview
+	plan, target, err := session.plan(ctx, db)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка отправки файла: "+err.Error())
-		return
+		return Fail(err, "error_import_plan")
+	}
+	if action == "commit" && plan.registrations() > 0 {
+		if err := db.ImportRegistrations(ctx, plan.imports); err != nil {
+			return Fail(err, "error_import_save")
+		}
+		DialogMgr.ClearState(cq.From.ID)
+		audit(ctx, db, AuditImport, cq.From, 0, "", session.eventID,
+			fmt.Sprintf("%s;%d;%d;%d", session.fileName, plan.registrations(), plan.newEvents(), len(plan.errors)))
+		text := N(lang, "import_done", plan.registrations())
+		if n := plan.newEvents(); n > 0 {
+			text += "\n" + N(lang, "import_done_events", n)
+		}
+		if n := len(plan.errors); n > 0 {
+			text += "\n" + N(lang, "import_done_skipped", n)
+		}
+		if n := sendImportTickets(ctx, bot, db, plan, target); n > 0 {
+			text += "\n" + N(lang, "import_done_tickets", n)
+		}
+		bot.Send(tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text))
+		return nil
 	}
 
-	// Clean up the file after sending
-	os.Remove(filename)
+	text, keyboard := importPreview(lang, session, target, plan)
+	edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+	edit.ReplyMarkup = &keyboard
+	bot.Send(edit)
+	return nil
 }
 
 // sendMessage sends a text message to the given chat.
-func sendMessage(bot *tgbotapi.BotAPI, chatID int64, text string) {
+func sendMessage(bot Sender, chatID int64, text string) {
 	message := tgbotapi.NewMessage(chatID, text)
 	bot.Send(message)
 }
 
 // handleRegister sends the register button.
-func handleRegister(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	button := tgbotapi.NewInlineKeyboardButtonData("Зарегистрироваться", "register")
+func handleRegister(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	button := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_register"), "register")
 	row := tgbotapi.NewInlineKeyboardRow(button)
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-	message := tgbotapi.NewMessage(msg.Chat.ID, "Нажмите кнопку ниже, чтобы зарегистрироваться.")
+	message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "register_prompt"))
 	message.ReplyMarkup = keyboard
 	bot.Send(message)
+	return nil
 }
 
 // Provide event state
-func handleState(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	event, err := db.GetLatestEvent()
+func handleState(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка получения информации о событии")
-		return
+		return Fail(err, "error_event_fetch")
 	}
 	if event == nil {
-		sendMessage(bot, msg.Chat.ID, "Нет активного события")
-		return
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
 	}
 	remaining := event.capacity - event.registrationCount
-	sendMessage(bot, msg.Chat.ID, "Осталось мест: "+strconv.Itoa(remaining))
+	sendMessage(bot, msg.Chat.ID, N(lang, "seats_left", remaining))
 	// Am I registred?
-	registered, _, err := db.IsUserRegistered(msg.From.ID, event.id)
+	registered, _, err := db.IsUserRegistered(ctx, msg.From.ID, event.id)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка проверки регистрации")
-		return
+		return Fail(err, "error_registration_check")
 	}
 	if registered {
-		button := tgbotapi.NewInlineKeyboardButtonData("Передумал, удалите меня", "remove")
+		button := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_remove"), "remove")
 		row := tgbotapi.NewInlineKeyboardRow(button)
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-		message := tgbotapi.NewMessage(msg.Chat.ID, "Вы зарегистрированы")
+		message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "status_registered"))
 		message.ReplyMarkup = keyboard
 		bot.Send(message)
 	} else {
-		sendMessage(bot, msg.Chat.ID, "Вы не зарегистрированы")
+		sendMessage(bot, msg.Chat.ID, T(lang, "status_not_registered"))
 	}
+	return nil
 }
 
 // handleNoDialog handles all non-command messages.
-func handleNoDialog(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	event, err := db.GetLatestEvent()
+func handleNoDialog(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка получения информации о событии")
-		return
+		return Fail(err, "error_event_fetch")
+	}
+	// If no future event, show closed message
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "registration_closed"))
+		return nil
 	}
 
-	registered, _, err := db.IsUserRegistered(msg.From.ID, event.id)
+	registered, _, err := db.IsUserRegistered(ctx, msg.From.ID, event.id)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка проверки регистрации")
-		return
+		return Fail(err, "error_registration_check")
 	}
 
 	activeMeetupDate := event.date.Format("02.01.2006")
@@ -215,78 +471,82 @@ func handleNoDialog(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message)
 
 	// If registration is closed but user is registered, show deregistration button
 	if registrationClosed && registered {
-		button := tgbotapi.NewInlineKeyboardButtonData("Передумал, удалите меня", "remove")
+		button := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_remove"), "remove")
 		row := tgbotapi.NewInlineKeyboardRow(button)
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-		message := tgbotapi.NewMessage(msg.Chat.ID, "Регистрация закрыта. Вы зарегистрированы на митап "+activeMeetupDate)
+		message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "registration_closed_registered", activeMeetupDate))
 		message.ReplyMarkup = keyboard
 		bot.Send(message)
-		return
+		return nil
 	}
 
 	// If registration is closed and user is not registered, offer waitlist directly
 	if registrationClosed && !registered {
 		// Check if already in waitlist
-		inWaitlist, _ := db.IsUserInWaitlist(msg.From.ID, event.id)
+		inWaitlist, _ := db.IsUserInWaitlist(ctx, msg.From.ID, event.id)
 		if inWaitlist {
-			sendMessage(bot, msg.Chat.ID, "Мест нет. Вы в очереди ожидания - мы сообщим, когда появится место.")
-			return
+			sendMessage(bot, msg.Chat.ID, T(lang, "waitlist_waiting"))
+			return nil
 		}
-		yesButton := tgbotapi.NewInlineKeyboardButtonData("Да", "join_waitlist")
-		noButton := tgbotapi.NewInlineKeyboardButtonData("Нет", "decline_waitlist")
+		yesButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_yes"), "join_waitlist")
+		noButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_no"), "decline_waitlist")
 		row := tgbotapi.NewInlineKeyboardRow(yesButton, noButton)
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-		message := tgbotapi.NewMessage(msg.Chat.ID, "Сожалеем, мест больше нет. Хотите, чтобы мы сообщили, если место освободится?")
+		message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "waitlist_offer"))
 		message.ReplyMarkup = keyboard
 		bot.Send(message)
-		return
-	}
-
-	// If no future event, show closed message
-	if event == nil {
-		sendMessage(bot, msg.Chat.ID, "Регистрация закрыта")
-		return
+		return nil
 	}
 
 	// Registration is open, show appropriate button
 	var button tgbotapi.InlineKeyboardButton
 	if registered {
-		button = tgbotapi.NewInlineKeyboardButtonData("Передумал, удалите меня", "remove")
+		button = tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_remove"), "remove")
 	} else {
-		button = tgbotapi.NewInlineKeyboardButtonData("Зарегистрироваться", "register")
+		button = tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_register"), "register")
 	}
 	row := tgbotapi.NewInlineKeyboardRow(button)
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-	message := tgbotapi.NewMessage(msg.Chat.ID, "Идёте на митап "+activeMeetupDate+"?")
+	message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "meetup_question", activeMeetupDate))
 	message.ReplyMarkup = keyboard
 	bot.Send(message)
+	return nil
 }
 
-// handleImhere handles the "/start imhere" command.
+// handleImhere handles the "/start imhere_..." command of the check-in QR code.
+// The code must be current and for the active event, see checkCheckinCode.
 // If the user is registered, it updates visited = 1.
 // If not, it creates a new record with visited = 1 and registred = 0.
-func handleImhere(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	event, err := db.GetLatestEvent()
+func handleImhere(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, code string) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка получения информации о событии")
-		return
+		return Fail(err, "error_event_fetch")
 	}
 	if event == nil {
-		sendMessage(bot, msg.Chat.ID, "Нет активного события")
-		return
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
 	}
-	registered, _, err := db.IsUserRegistered(msg.From.ID, event.id)
+	if err := checkCheckinCode(code, event.id, time.Now()); err != nil {
+		switch {
+		case errors.Is(err, errCheckinCodeExpired):
+			return Reject("checkin_code_expired")
+		case errors.Is(err, errCheckinCodeWrongEvent):
+			return Reject("checkin_code_wrong_event")
+		}
+		return Reject("checkin_code_invalid")
+	}
+	registered, _, err := db.IsUserRegistered(ctx, msg.From.ID, event.id)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка проверки регистрации")
-		return
+		return Fail(err, "error_registration_check")
 	}
 	if registered {
-		err := db.UpdateVisitedStatus(msg.From.ID, event.id, 1)
+		err := db.UpdateVisitedStatus(ctx, msg.From.ID, event.id, 1)
 		if err != nil {
-			sendMessage(bot, msg.Chat.ID, "Ошибка обновления статуса посещения")
-			return
+			return Fail(err, "error_visit_update")
 		}
-		sendMessage(bot, msg.Chat.ID, "Статус посещения обновлён. Спасибо, что пришли!")
+		auditSelf(ctx, db, AuditCheckin, msg.From, event.id, "registered")
+		sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, lang, "visit_updated", newTemplateData(event, msg.From)))
 	} else {
 		// Add new user with visited = 1 and registred = 0
 		newUser := UserRegistration{
@@ -299,138 +559,406 @@ func handleImhere(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
 			Registred:        0,
 			Visited:          1,
 		}
-		err := db.RegisterUser(newUser)
+		err := db.RegisterUser(ctx, newUser)
 		if err != nil {
-			sendMessage(bot, msg.Chat.ID, "Ошибка добавления пользователя")
-			return
+			return Fail(err, "error_user_add")
 		}
-		sendMessage(bot, msg.Chat.ID, "Спасибо что отметились! Это важно для нас, мы всегда рады гостям! Чтобы помочь нам лучше планировать митапы, регистрируйтесь на следующие события заранее. Спасибо!")
+		auditSelf(ctx, db, AuditCheckin, msg.From, event.id, "walk-in")
+		sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, lang, "visit_walk_in", newTemplateData(event, msg.From)))
+	}
+	return nil
+}
+
+// handleTicket handles the /ticket command.
+// Sends the ticket of the user for the current event again.
+func handleTicket(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
+	}
+	registered, _, err := db.IsUserRegistered(ctx, msg.From.ID, event.id)
+	if err != nil {
+		return Fail(err, "error_registration_check")
 	}
+	if !registered {
+		sendMessage(bot, msg.Chat.ID, T(lang, "status_not_registered"))
+		return nil
+	}
+	sendTicket(bot, msg.Chat.ID, lang, event, msg.From.ID)
+	return nil
+}
+
+// handleTicketScan handles the "/start ticket_..." link of a ticket QR code.
+// A check-in volunteer who scans the ticket marks its attendee as visited. The ticket
+// is rejected if its signature is wrong, it is for another event or it was used already.
+func handleTicketScan(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, token string) error {
+	lang := userLanguage(ctx, db, msg.From)
+	// Attendees scanning their own ticket must not be able to check in from home
+	if !HasPermission(ctx, db, msg.From, PermCheckin) {
+		sendMessage(bot, msg.Chat.ID, T(lang, "ticket_show_volunteer"))
+		return nil
+	}
+	eventID, telegramID, err := parseTicketToken(token)
+	if err != nil {
+		slog.Warn("Rejected a forged ticket", "user_id", msg.From.ID, "ticket_hash", ticketFingerprint(token))
+		return Reject("ticket_invalid")
+	}
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil || event.id != eventID {
+		return Reject("ticket_wrong_event")
+	}
+	registered, reg, err := db.IsUserRegistered(ctx, telegramID, eventID)
+	if err != nil {
+		return Fail(err, "error_registration_check")
+	}
+	if !registered {
+		return Reject("ticket_not_registered")
+	}
+	name := reg.Name
+	if reg.Username != "" {
+		name += " (@" + reg.Username + ")"
+	}
+	if reg.Visited == 1 {
+		return Reject("ticket_used", name)
+	}
+
+	if err := db.UpdateVisitedStatus(ctx, telegramID, eventID, 1); err != nil {
+		return Fail(err, "error_visit_update")
+	}
+	audit(ctx, db, AuditCheckin, msg.From, telegramID, reg.Username, eventID, "ticket")
+	sendMessage(bot, msg.Chat.ID, T(lang, "ticket_checked_in", name))
+	attendee := &tgbotapi.User{ID: telegramID, UserName: reg.Username, FirstName: reg.Name}
+	sendMessage(bot, int64(telegramID), renderMessage(ctx, db, userLanguage(ctx, db, attendee), "visit_updated", newTemplateData(event, attendee)))
+	return nil
+}
+
+// handleCheckin handles the /checkin command.
+// Starts the check-in mode: the next messages of the volunteer look up attendees of the
+// current event, or register walk-ins. Any command ends it.
+func handleCheckin(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
+	}
+	attendees, err := checkinAttendees(ctx, db, event.id)
+	if err != nil {
+		return Fail(err, "error_checkin_fetch")
+	}
+	DialogMgr.SetState(msg.From.ID, CheckinMode, event.id)
+	sendMessage(bot, msg.Chat.ID, T(lang, "checkin_mode", event.name, checkinMinQuery))
+	// The counter is edited after every change the volunteer makes
+	counter, err := bot.Send(tgbotapi.NewMessage(msg.Chat.ID, checkinCounter(lang, attendees)))
+	if err == nil {
+		DialogMgr.SetUserData(msg.From.ID, "checkin_counter", strconv.Itoa(counter.MessageID))
+	}
+	return nil
+}
+
+// handleCheckinSearch handles a message in the check-in mode: a search shows every attendee
+// found with a button to mark them as arrived, "+ Surname Name" registers a walk-in
+func handleCheckinSearch(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, eventID int) error {
+	lang := userLanguage(ctx, db, msg.From)
+	// The mode outlives a revoked role
+	if !HasPermission(ctx, db, msg.From, PermCheckin) {
+		DialogMgr.ClearState(msg.From.ID)
+		return Reject("permission_denied")
+	}
+	query := strings.TrimSpace(msg.Text)
+	if strings.HasPrefix(query, "+") {
+		return handleCheckinWalkIn(ctx, bot, db, msg, eventID)
+	}
+	if len([]rune(strings.TrimPrefix(query, "@"))) < checkinMinQuery {
+		return Reject("checkin_query_short", checkinMinQuery)
+	}
+	attendees, err := checkinAttendees(ctx, db, eventID)
+	if err != nil {
+		return Fail(err, "error_checkin_fetch")
+	}
+	found := searchCheckinAttendees(attendees, query)
+	if len(found) == 0 {
+		sendMessage(bot, msg.Chat.ID, T(lang, "checkin_not_found", query))
+		return nil
+	}
+	for _, reg := range found[:min(len(found), checkinMaxResults)] {
+		text, keyboard := checkinResult(lang, reg)
+		message := tgbotapi.NewMessage(msg.Chat.ID, text)
+		if keyboard != nil {
+			message.ReplyMarkup = *keyboard
+		}
+		bot.Send(message)
+	}
+	if more := len(found) - checkinMaxResults; more > 0 {
+		sendMessage(bot, msg.Chat.ID, N(lang, "checkin_found_more", more))
+	}
+	return nil
+}
+
+// handleCheckinWalkIn registers someone who came without a registration. A walk-in with
+// a username the bot knows who has a registration already is marked as arrived instead.
+func handleCheckinWalkIn(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, eventID int) error {
+	lang := userLanguage(ctx, db, msg.From)
+	walkIn, ok := parseWalkIn(msg.Text)
+	if !ok {
+		return Reject("checkin_walk_in_invalid")
+	}
+	if walkIn.Username != "" {
+		id, err := db.FindTelegramIDByUsername(ctx, walkIn.Username)
+		if err != nil {
+			return Fail(err, "error_registration_check")
+		}
+		walkIn.TelegramID = id
+	}
+
+	var existing *UserRegistration
+	if walkIn.TelegramID != 0 {
+		_, reg, err := db.IsUserRegistered(ctx, walkIn.TelegramID, eventID)
+		if err != nil {
+			return Fail(err, "error_registration_check")
+		}
+		existing = reg
+	}
+	switch {
+	case existing != nil && existing.Visited == 1:
+	case existing != nil:
+		if err := db.UpdateVisitedStatusByID(ctx, existing.ID, 1); err != nil {
+			return Fail(err, "error_visit_update")
+		}
+		audit(ctx, db, AuditCheckin, msg.From, existing.TelegramID, existing.Username, eventID, "manual")
+		existing.Visited = 1
+	default:
+		// A row of its own: RegisterUser would update the other registrations without a Telegram ID
+		walkIn.EventID = eventID
+		walkIn.RegistrationDate = time.Now()
+		walkIn.Visited = 1
+		id, err := db.AddWalkIn(ctx, walkIn)
+		if err != nil {
+			return Fail(err, "error_user_add")
+		}
+		walkIn.ID = id
+		audit(ctx, db, AuditCheckin, msg.From, walkIn.TelegramID, walkIn.Username, eventID, "walk-in")
+		existing = &walkIn
+		sendMessage(bot, msg.Chat.ID, T(lang, "checkin_walk_in_done"))
+	}
+
+	text, keyboard := checkinResult(lang, *existing)
+	message := tgbotapi.NewMessage(msg.Chat.ID, text)
+	if keyboard != nil {
+		message.ReplyMarkup = *keyboard
+	}
+	bot.Send(message)
+	updateCheckinCounter(ctx, bot, db, msg.From, msg.Chat.ID, lang, eventID)
+	return nil
+}
+
+// handleCheckinCallback handles the "Mark as arrived" and "Undo" buttons of the check-in mode.
+// The message of the attendee is edited to show the new status and the other button.
+func handleCheckinCallback(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+	lang := userLanguage(ctx, db, cq.From)
+	registrationID, visited, ok := parseCheckinCallback(cq.Data)
+	if !ok {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, ""))
+		return nil
+	}
+	reg, err := db.GetRegistrationByID(ctx, registrationID)
+	if err != nil {
+		return Fail(err, "error_registration_check")
+	}
+	if reg == nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, T(lang, "checkin_registration_gone")))
+		return nil
+	}
+	// A second press, or another volunteer, may have changed it already
+	if reg.Visited != visited {
+		if err := db.UpdateVisitedStatusByID(ctx, reg.ID, visited); err != nil {
+			return Fail(err, "error_visit_update")
+		}
+		if visited == 1 {
+			audit(ctx, db, AuditCheckin, cq.From, reg.TelegramID, reg.Username, reg.EventID, "manual")
+		} else {
+			audit(ctx, db, AuditCheckinUndo, cq.From, reg.TelegramID, reg.Username, reg.EventID, "")
+		}
+		reg.Visited = visited
+	}
+
+	attendees, err := checkinAttendees(ctx, db, reg.EventID)
+	if err != nil {
+		return Fail(err, "error_checkin_fetch")
+	}
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, checkinCounter(lang, attendees)))
+	text, keyboard := checkinResult(lang, *reg)
+	edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+	edit.ReplyMarkup = keyboard
+	bot.Send(edit)
+	updateCheckinCounter(ctx, bot, db, cq.From, cq.Message.Chat.ID, lang, reg.EventID)
+	return nil
+}
+
+// updateCheckinCounter edits the counter sent by /checkin, if the volunteer is still in the check-in mode of the event
+func updateCheckinCounter(ctx context.Context, bot Sender, db Repository, user *tgbotapi.User, chatID int64, lang string, eventID int) {
+	state, modeEventID := DialogMgr.GetState(user.ID)
+	messageID, err := strconv.Atoi(DialogMgr.GetUserData(user.ID, "checkin_counter"))
+	if state != CheckinMode || modeEventID != eventID || err != nil {
+		return
+	}
+	attendees, err := checkinAttendees(ctx, db, eventID)
+	if err != nil {
+		slog.Error("Failed to count the arrived attendees", "event_id", eventID, "error", err)
+		return
+	}
+	bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, checkinCounter(lang, attendees)))
 }
 
 // handleDialogCancel cancels the current dialog and removes the incomplete registration
-func handleDialogCancel(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message, eventID int) {
+func handleDialogCancel(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, eventID int) {
 	// Clear dialog state
 	DialogMgr.ClearState(msg.From.ID)
 
 	// Remove the incomplete registration
-	if err := db.RemoveRegistration(msg.From.ID, eventID); err != nil {
+	if err := db.RemoveRegistration(ctx, msg.From.ID, eventID); err != nil {
 		// Log error but don't notify user - they're moving on to a command
+		slog.Error("Failed to remove incomplete registration", "user_id", msg.From.ID, "event_id", eventID, "error", err)
 		return
 	}
 
 	// Decrement registration count
-	db.DecrementEventRegistrationCount(eventID)
+	db.DecrementEventRegistrationCount(ctx, eventID)
+	auditSelf(ctx, db, AuditDialogCancel, msg.From, eventID, "/"+msg.Command())
 
-	sendMessage(bot, msg.Chat.ID, "Регистрация отменена. Вы не указали обязательные данные.")
+	sendMessage(bot, msg.Chat.ID, T(userLanguage(ctx, db, msg.From), "dialog_cancelled"))
 }
 
 // handleDialog processes user input during a dialog
-func handleDialog(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message, state DialogState, eventID int) {
+func handleDialog(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, state DialogState, eventID int) error {
+	lang := userLanguage(ctx, db, msg.From)
 	switch state {
 	case WaitingForName:
 		// Validate name format (Surname Name)
 		if !ValidateName(msg.Text) {
-			sendMessage(bot, msg.Chat.ID, "Пожалуйста, укажите Фамилию и Имя в формате: Фамилия Имя")
-			return
+			sendMessage(bot, msg.Chat.ID, T(lang, "ask_name_format"))
+			return nil
 		}
 
 		// Update user's name in the database
-		if err := db.UpdateUserName(msg.From.ID, msg.Text); err != nil {
-			sendMessage(bot, msg.Chat.ID, "Ошибка при сохранении имени. Пожалуйста, попробуйте еще раз.")
-			return
+		if err := db.UpdateUserName(ctx, msg.From.ID, msg.Text); err != nil {
+			return Fail(err, "error_name_save")
 		}
+		auditSelf(ctx, db, AuditProfileUpdate, msg.From, eventID, "name")
 
 		// Check if email is mandatory
 		if AppConfig.HasMandatoryField("email") {
 			// Move to next state - asking for email
 			DialogMgr.SetState(msg.From.ID, WaitingForEmail, eventID)
-			sendMessage(bot, msg.Chat.ID, "Укажите email:")
+			sendMessage(bot, msg.Chat.ID, T(lang, "ask_email"))
 		} else {
 			// Email is not mandatory, registration is complete
 			DialogMgr.ClearState(msg.From.ID)
-			sendMessage(bot, msg.Chat.ID, "Спасибо! Ваша регистрация завершена.")
-			
-			// Show remaining spots
-			event, err := db.GetLatestEvent()
-			if err == nil && event != nil {
-				remaining := event.capacity - event.registrationCount
-				sendMessage(bot, msg.Chat.ID, "Осталось мест: "+strconv.Itoa(remaining))
-			}
+			sendRegistrationComplete(ctx, bot, db, msg, lang, strings.TrimSpace(msg.Text))
 		}
 
 	case WaitingForEmail:
 		// Validate email format
 		if !ValidateEmail(msg.Text) {
-			sendMessage(bot, msg.Chat.ID, "Пожалуйста, укажите корректный email адрес.")
-			return
+			sendMessage(bot, msg.Chat.ID, T(lang, "ask_valid_email"))
+			return nil
 		}
 
 		// Update user's email in the database
-		if err := db.UpdateUserEmail(msg.From.ID, msg.Text); err != nil {
-			sendMessage(bot, msg.Chat.ID, "Ошибка при сохранении email. Пожалуйста, попробуйте еще раз.")
-			return
+		if err := db.UpdateUserEmail(ctx, msg.From.ID, msg.Text); err != nil {
+			return Fail(err, "error_email_save")
 		}
+		auditSelf(ctx, db, AuditProfileUpdate, msg.From, eventID, "email")
 
 		// Clear dialog state
 		DialogMgr.ClearState(msg.From.ID)
 
 		// Confirm registration is complete
-		sendMessage(bot, msg.Chat.ID, "Спасибо! Ваша регистрация завершена.")
-
-		// Show remaining spots
-		event, err := db.GetLatestEvent()
-		if err == nil && event != nil {
-			remaining := event.capacity - event.registrationCount
-			sendMessage(bot, msg.Chat.ID, "Осталось мест: "+strconv.Itoa(remaining))
+		name := ""
+		if _, reg, err := db.IsUserRegistered(ctx, msg.From.ID, eventID); err == nil && reg != nil {
+			name = reg.Name
 		}
+		sendRegistrationComplete(ctx, bot, db, msg, lang, name)
+
+	case WaitingForImportFile, ReviewingImport:
+		return handleImportFile(ctx, bot, db, msg, eventID)
+	case CheckinMode:
+		return handleCheckinSearch(ctx, bot, db, msg, eventID)
 	}
+	return nil
 }
 
-// handleCallbackQuery handles inline button callbacks.
-func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.CallbackQuery) {
-	event, err := db.GetLatestEvent()
+// sendRegistrationComplete confirms the finished dialog, shows the remaining spots and sends the ticket
+func sendRegistrationComplete(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message, lang string, name string) {
+	event, _ := db.GetLatestEvent(ctx)
+	data := newTemplateData(event, msg.From)
+	if name != "" {
+		data.UserName = name
+	}
+	sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, lang, "registration_complete", data))
+
+	// Show remaining spots
+	if event != nil {
+		remaining := event.capacity - event.registrationCount
+		sendMessage(bot, msg.Chat.ID, N(lang, "seats_left", remaining))
+		sendTicket(bot, msg.Chat.ID, lang, event, msg.From.ID)
+	}
+}
+
+// handleCallbackQuery handles the registration and waitlist buttons.
+// Buttons with a prefix, like the /export keyboards, are routed by RegisterCallbacks.
+func handleCallbackQuery(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+	lang := userLanguage(ctx, db, cq.From)
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, cq.Message.Chat.ID, "Ошибка получения информации о событии")
-		return
+		return Fail(err, "error_event_fetch")
 	}
 	if event == nil {
-		sendMessage(bot, cq.Message.Chat.ID, "Нет активного события")
-		return
+		sendMessage(bot, cq.Message.Chat.ID, T(lang, "no_active_event"))
+		return nil
 	}
 
 	// Check if registration is closed, but allow deregistration
 	registrationClosed := event.registrationCount >= event.capacity
 	if registrationClosed && cq.Data == "register" {
 		// Check if user is already in waitlist
-		inWaitlist, err := db.IsUserInWaitlist(cq.From.ID, event.id)
+		inWaitlist, err := db.IsUserInWaitlist(ctx, cq.From.ID, event.id)
 		if err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка проверки очереди ожидания")
-			return
+			return Fail(err, "error_waitlist_check")
 		}
 		if inWaitlist {
-			sendMessage(bot, cq.Message.Chat.ID, "Вы уже в очереди ожидания. Мы сообщим вам, когда появится свободное место.")
-			return
+			sendMessage(bot, cq.Message.Chat.ID, T(lang, "waitlist_already"))
+			return nil
 		}
 		// Show waitlist offer
-		yesButton := tgbotapi.NewInlineKeyboardButtonData("Да", "join_waitlist")
-		noButton := tgbotapi.NewInlineKeyboardButtonData("Нет", "decline_waitlist")
+		yesButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_yes"), "join_waitlist")
+		noButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_no"), "decline_waitlist")
 		row := tgbotapi.NewInlineKeyboardRow(yesButton, noButton)
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-		message := tgbotapi.NewMessage(cq.Message.Chat.ID, "Сожалеем, мест больше нет. Хотите забронировать место, если освободится?")
+		message := tgbotapi.NewMessage(cq.Message.Chat.ID, T(lang, "waitlist_offer_book"))
 		message.ReplyMarkup = keyboard
 		bot.Send(message)
 		callback := tgbotapi.NewCallback(cq.ID, "")
 		bot.AnswerCallbackQuery(callback)
-		return
+		return nil
 	}
 
 	if cq.Data == "register" {
 		// Check if user already has required info from previous registrations
-		hasInfo, name, email, err := db.HasUserInfo(cq.From.ID)
+		hasInfo, name, email, err := db.HasUserInfo(ctx, cq.From.ID)
 		if err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка проверки информации пользователя")
-			return
+			return Fail(err, "error_user_info_check")
 		}
 
 		// Check which mandatory fields are missing
@@ -443,10 +971,9 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 		}
 		needsDialog := len(missingFields) > 0
 
-		registered, existingReg, err := db.IsUserRegistered(cq.From.ID, event.id)
+		registered, existingReg, err := db.IsUserRegistered(ctx, cq.From.ID, event.id)
 		if err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка проверки регистрации")
-			return
+			return Fail(err, "error_registration_check")
 		}
 
 		if !registered {
@@ -467,17 +994,16 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 				Visited:          0,
 			}
 
-			if err := db.RegisterUser(reg); err != nil {
-				sendMessage(bot, cq.Message.Chat.ID, "Ошибка при регистрации")
-				return
+			if err := db.RegisterUser(ctx, reg); err != nil {
+				return Fail(err, "error_register")
 			}
 
-			if err := db.UpdateEventRegistrationCount(event.id); err != nil {
-				sendMessage(bot, cq.Message.Chat.ID, "Ошибка обновления количества регистраций")
-				return
+			if err := db.UpdateEventRegistrationCount(ctx, event.id); err != nil {
+				return Fail(err, "error_registration_count")
 			}
+			auditSelf(ctx, db, AuditRegister, cq.From, event.id, "")
 
-			callback := tgbotapi.NewCallback(cq.ID, "Регистрация успешна!")
+			callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_registered"))
 			bot.AnswerCallbackQuery(callback)
 
 			// If mandatory fields are missing, start dialog to collect them
@@ -485,26 +1011,27 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 				// Determine which dialog state to start with
 				if AppConfig.HasMandatoryField("name") && (name == "" || name == cq.From.FirstName+" "+cq.From.LastName) {
 					DialogMgr.SetState(cq.From.ID, WaitingForName, event.id)
-					sendMessage(bot, cq.Message.Chat.ID, "Укажите Фамилию и Имя:")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "ask_name"))
 				} else if AppConfig.HasMandatoryField("email") && email == "" {
 					DialogMgr.SetState(cq.From.ID, WaitingForEmail, event.id)
-					sendMessage(bot, cq.Message.Chat.ID, "Укажите email:")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "ask_email"))
 				}
-				return
+				return nil
 			} else {
 				// No mandatory fields or user has all required info
 				if len(AppConfig.MandatoryFields) == 0 {
-					sendMessage(bot, cq.Message.Chat.ID, "Вы успешно зарегистрированы!")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "registered_success"))
 				} else {
-					msg := "Вы зарегистрированы с вашими сохраненными данными:"
+					msg := T(lang, "registered_with_saved_data")
 					if AppConfig.HasMandatoryField("name") {
-						msg += "\nИмя: " + name
+						msg += T(lang, "saved_name_line", name)
 					}
 					if AppConfig.HasMandatoryField("email") {
-						msg += "\nEmail: " + email
+						msg += T(lang, "saved_email_line", email)
 					}
 					sendMessage(bot, cq.Message.Chat.ID, msg)
 				}
+				sendTicket(bot, cq.Message.Chat.ID, lang, event, cq.From.ID)
 			}
 		} else {
 			// Registration update: update the existing row.
@@ -525,12 +1052,12 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 				Visited:          existingReg.Visited, // Preserve visited status
 			}
 
-			if err := db.UpdateRegistration(reg); err != nil {
-				sendMessage(bot, cq.Message.Chat.ID, "Ошибка обновления регистрации")
-				return
+			if err := db.UpdateRegistration(ctx, reg); err != nil {
+				return Fail(err, "error_registration_update")
 			}
+			auditSelf(ctx, db, AuditRegistrationUpdate, cq.From, event.id, "")
 
-			callback := tgbotapi.NewCallback(cq.ID, "Регистрация обновлена!")
+			callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_registration_updated"))
 			bot.AnswerCallbackQuery(callback)
 
 			// If mandatory fields are missing, start dialog to collect them
@@ -538,90 +1065,89 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 				// Determine which dialog state to start with
 				if AppConfig.HasMandatoryField("name") && (name == "" || name == cq.From.FirstName+" "+cq.From.LastName) {
 					DialogMgr.SetState(cq.From.ID, WaitingForName, event.id)
-					sendMessage(bot, cq.Message.Chat.ID, "Укажите Фамилию и Имя:")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "ask_name"))
 				} else if AppConfig.HasMandatoryField("email") && email == "" {
 					DialogMgr.SetState(cq.From.ID, WaitingForEmail, event.id)
-					sendMessage(bot, cq.Message.Chat.ID, "Укажите email:")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "ask_email"))
 				}
 			} else {
 				// No mandatory fields or user has all required info
 				if len(AppConfig.MandatoryFields) == 0 {
-					sendMessage(bot, cq.Message.Chat.ID, "Регистрация успешно обновлена!")
+					sendMessage(bot, cq.Message.Chat.ID, T(lang, "registration_updated_success"))
 				} else {
-					msg := "Регистрация обновлена с вашими сохраненными данными:"
+					msg := T(lang, "registration_updated_with_saved_data")
 					if AppConfig.HasMandatoryField("name") {
-						msg += "\nИмя: " + name
+						msg += T(lang, "saved_name_line", name)
 					}
 					if AppConfig.HasMandatoryField("email") {
-						msg += "\nEmail: " + email
+						msg += T(lang, "saved_email_line", email)
 					}
 					sendMessage(bot, cq.Message.Chat.ID, msg)
 				}
+				sendTicket(bot, cq.Message.Chat.ID, lang, event, cq.From.ID)
 			}
 		}
 	} else if cq.Data == "remove" {
-		registered, _, err := db.IsUserRegistered(cq.From.ID, event.id)
+		registered, _, err := db.IsUserRegistered(ctx, cq.From.ID, event.id)
 		if err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка проверки регистрации")
-			return
+			return Fail(err, "error_registration_check")
 		}
 		if !registered {
 			remaining := event.capacity - event.registrationCount
-			sendMessage(bot, cq.Message.Chat.ID, "Вы не зарегистрированы. Осталось мест: "+strconv.Itoa(remaining))
-			return
+			sendMessage(bot, cq.Message.Chat.ID, N(lang, "not_registered_seats_left", remaining))
+			return nil
 		}
-		if err := db.RemoveRegistration(cq.From.ID, event.id); err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка при удалении регистрации")
-			return
+		if err := db.RemoveRegistration(ctx, cq.From.ID, event.id); err != nil {
+			return Fail(err, "error_registration_remove")
 		}
-		if err := db.DecrementEventRegistrationCount(event.id); err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка обновления количества регистраций")
-			return
+		if err := db.DecrementEventRegistrationCount(ctx, event.id); err != nil {
+			return Fail(err, "error_registration_count")
 		}
-		callback := tgbotapi.NewCallback(cq.ID, "Регистрация удалена!")
+		auditSelf(ctx, db, AuditCancel, cq.From, event.id, "")
+		callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_registration_removed"))
 		bot.AnswerCallbackQuery(callback)
 
 		// Notify waitlist users that a spot is available
-		notifyWaitlist(bot, db, event.id)
+		notifyWaitlist(ctx, bot, db, event.id)
 	} else if cq.Data == "join_waitlist" {
 		// Add user to waitlist
-		if err := db.AddToWaitlist(cq.From.ID, cq.Message.Chat.ID, cq.From.UserName, event.id); err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка добавления в очередь ожидания")
-			return
+		if err := db.AddToWaitlist(ctx, cq.From.ID, cq.Message.Chat.ID, cq.From.UserName, event.id); err != nil {
+			return Fail(err, "error_waitlist_add")
 		}
-		callback := tgbotapi.NewCallback(cq.ID, "Вы добавлены в очередь!")
+		auditSelf(ctx, db, AuditWaitlistJoin, cq.From, event.id, "")
+		callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_waitlist_added"))
 		bot.AnswerCallbackQuery(callback)
-		sendMessage(bot, cq.Message.Chat.ID, "Вы добавлены в очередь ожидания. Мы сообщим вам, когда появится свободное место.")
-		return
+		sendMessage(bot, cq.Message.Chat.ID, T(lang, "waitlist_added"))
+		return nil
 	} else if cq.Data == "decline_waitlist" {
 		callback := tgbotapi.NewCallback(cq.ID, "")
 		bot.AnswerCallbackQuery(callback)
-		sendMessage(bot, cq.Message.Chat.ID, "Хорошо. Если передумаете, вы всегда можете попробовать снова.")
-		return
+		sendMessage(bot, cq.Message.Chat.ID, T(lang, "waitlist_declined"))
+		return nil
 	} else if cq.Data == "waitlist_book" {
 		// User wants to book from waitlist notification
 		// First check if there's still a spot available
-		currentEvent, err := db.GetLatestEvent()
+		currentEvent, err := db.GetLatestEvent(ctx)
 		if err != nil || currentEvent == nil || currentEvent.id != event.id {
-			sendMessage(bot, cq.Message.Chat.ID, "К сожалению, событие уже завершено.")
-			db.RemoveFromWaitlist(cq.From.ID, event.id)
-			return
+			sendMessage(bot, cq.Message.Chat.ID, T(lang, "event_finished"))
+			db.RemoveFromWaitlist(ctx, cq.From.ID, event.id)
+			auditSelf(ctx, db, AuditWaitlistLeave, cq.From, event.id, "event finished")
+			return nil
 		}
 		if currentEvent.registrationCount >= currentEvent.capacity {
-			sendMessage(bot, cq.Message.Chat.ID, "К сожалению, место уже занято. Вы остаётесь в очереди ожидания.")
-			callback := tgbotapi.NewCallback(cq.ID, "Место уже занято")
+			sendMessage(bot, cq.Message.Chat.ID, T(lang, "spot_taken"))
+			callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_spot_taken"))
 			bot.AnswerCallbackQuery(callback)
-			return
+			return nil
 		}
 
 		// Remove from waitlist first
-		db.RemoveFromWaitlist(cq.From.ID, event.id)
+		db.RemoveFromWaitlist(ctx, cq.From.ID, event.id)
 
 		// Now proceed with normal registration flow
-		hasInfo, name, email, err := db.HasUserInfo(cq.From.ID)
+		hasInfo, name, email, err := db.HasUserInfo(ctx, cq.From.ID)
 		if err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка проверки информации пользователя")
-			return
+			return Fail(err, "error_user_info_check")
 		}
 
 		var missingFields []string
@@ -649,72 +1175,78 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db Repository, cq *tgbotapi.Callb
 			Visited:          0,
 		}
 
-		if err := db.RegisterUser(reg); err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка при регистрации")
-			return
+		if err := db.RegisterUser(ctx, reg); err != nil {
+			return Fail(err, "error_register")
 		}
 
-		if err := db.UpdateEventRegistrationCount(event.id); err != nil {
-			sendMessage(bot, cq.Message.Chat.ID, "Ошибка обновления количества регистраций")
-			return
+		if err := db.UpdateEventRegistrationCount(ctx, event.id); err != nil {
+			return Fail(err, "error_registration_count")
 		}
+		auditSelf(ctx, db, AuditWaitlistBook, cq.From, event.id, "")
 
-		callback := tgbotapi.NewCallback(cq.ID, "Регистрация успешна!")
+		callback := tgbotapi.NewCallback(cq.ID, T(lang, "callback_registered"))
 		bot.AnswerCallbackQuery(callback)
 
 		if needsDialog {
 			if AppConfig.HasMandatoryField("name") && (name == "" || name == cq.From.FirstName+" "+cq.From.LastName) {
 				DialogMgr.SetState(cq.From.ID, WaitingForName, event.id)
-				sendMessage(bot, cq.Message.Chat.ID, "Отлично! Место забронировано. Укажите Фамилию и Имя:")
+				sendMessage(bot, cq.Message.Chat.ID, T(lang, "booked_ask_name"))
 			} else if AppConfig.HasMandatoryField("email") && email == "" {
 				DialogMgr.SetState(cq.From.ID, WaitingForEmail, event.id)
-				sendMessage(bot, cq.Message.Chat.ID, "Отлично! Место забронировано. Укажите email:")
+				sendMessage(bot, cq.Message.Chat.ID, T(lang, "booked_ask_email"))
 			}
 		} else {
-			sendMessage(bot, cq.Message.Chat.ID, "Отлично! Вы успешно зарегистрированы!")
+			sendMessage(bot, cq.Message.Chat.ID, T(lang, "booked_success"))
+			sendTicket(bot, cq.Message.Chat.ID, lang, event, cq.From.ID)
 		}
-		return
+		return nil
 	} else if cq.Data == "waitlist_decline" {
 		// User declines the spot offer from waitlist
-		db.RemoveFromWaitlist(cq.From.ID, event.id)
+		db.RemoveFromWaitlist(ctx, cq.From.ID, event.id)
+		auditSelf(ctx, db, AuditWaitlistLeave, cq.From, event.id, "declined")
 		callback := tgbotapi.NewCallback(cq.ID, "")
 		bot.AnswerCallbackQuery(callback)
-		sendMessage(bot, cq.Message.Chat.ID, "Хорошо. Вы удалены из очереди ожидания.")
-		return
+		sendMessage(bot, cq.Message.Chat.ID, T(lang, "waitlist_left"))
+		return nil
 	}
 
-	updatedEvent, err := db.GetLatestEvent()
+	updatedEvent, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, cq.Message.Chat.ID, "Ошибка получения обновленной информации о событии")
-		return
+		return Fail(err, "error_event_refresh")
+	}
+	if updatedEvent == nil {
+		// The event was archived in the meantime, there are no seats to report
+		return nil
 	}
 	remaining := updatedEvent.capacity - updatedEvent.registrationCount
-	sendMessage(bot, cq.Message.Chat.ID, "Осталось мест: "+strconv.Itoa(remaining))
+	sendMessage(bot, cq.Message.Chat.ID, N(lang, "seats_left", remaining))
+	return nil
 }
 
 // notifyWaitlist sends notifications to all users in the waitlist for an event
-func notifyWaitlist(bot *tgbotapi.BotAPI, db Repository, eventID int) {
+func notifyWaitlist(ctx context.Context, bot Sender, db Repository, eventID int) {
 	// Check if event is still active (not past)
-	event, err := db.GetLatestEvent()
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil || event == nil || event.id != eventID {
 		// Event is no longer active, don't notify
 		return
 	}
 
 	// Get all users in waitlist
-	waitlist, err := db.GetWaitlistForEvent(eventID)
+	waitlist, err := db.GetWaitlistForEvent(ctx, eventID)
 	if err != nil || len(waitlist) == 0 {
 		return
 	}
 
-	// Send notification to all users in waitlist
-	bookButton := tgbotapi.NewInlineKeyboardButtonData("Забронировать", "waitlist_book")
-	declineButton := tgbotapi.NewInlineKeyboardButtonData("Нет", "waitlist_decline")
-	row := tgbotapi.NewInlineKeyboardRow(bookButton, declineButton)
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
-
+	// Send notification to all users in waitlist, each in their own language
 	for _, entry := range waitlist {
-		message := tgbotapi.NewMessage(entry.ChatID, "Есть свободное место! Хотите забронировать?")
+		lang := languageForUserID(ctx, db, entry.TelegramID)
+		bookButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_book"), "waitlist_book")
+		declineButton := tgbotapi.NewInlineKeyboardButtonData(T(lang, "button_no"), "waitlist_decline")
+		row := tgbotapi.NewInlineKeyboardRow(bookButton, declineButton)
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+
+		message := tgbotapi.NewMessage(entry.ChatID, T(lang, "waitlist_spot_available"))
 		message.ReplyMarkup = keyboard
 		bot.Send(message)
 	}
@@ -722,96 +1254,574 @@ func notifyWaitlist(bot *tgbotapi.BotAPI, db Repository, eventID int) {
 
 // handleAddEvent handles the /addevent command.
 // Before inserting the new event, all old active events are marked as "past".
-func handleAddEvent(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
+func handleAddEvent(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
 	args := msg.CommandArguments()
 	parts := strings.Split(args, ";")
 	if len(parts) < 3 {
-		sendMessage(bot, msg.Chat.ID, "Использование: /addevent НазваниеСобытия;YYYY-MM-DD;Вместимость")
-		return
+		return Reject("addevent_usage")
 	}
 	name := strings.TrimSpace(parts[0])
 	dateStr := strings.TrimSpace(parts[1])
 	capacityStr := strings.TrimSpace(parts[2])
 	capacity, err := strconv.Atoi(capacityStr)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Неверное число вместимости")
-		return
+		return Reject("error_capacity")
 	}
 	eventDate, err := time.Parse("2006-01-02", dateStr)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Неверный формат даты. Используйте YYYY-MM-DD")
-		return
+		return Reject("error_date_format")
 	}
 
 	// Update all active events to "past" (only for active events)
-	if err := db.MarkEventsAsPast(); err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка обновления состояния старых событий")
-		return
+	if err := db.MarkEventsAsPast(ctx); err != nil {
+		return Fail(err, "error_events_archive")
 	}
 
-	if err := db.AddEvent(name, eventDate, capacity); err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка добавления события")
-		return
+	if err := db.AddEvent(ctx, name, eventDate, capacity); err != nil {
+		return Fail(err, "error_event_add")
+	}
+	// The new event is the only active one now
+	eventID := 0
+	if event, err := db.GetLatestEvent(ctx); err == nil && event != nil {
+		eventID = event.id
 	}
-	sendMessage(bot, msg.Chat.ID, "Событие успешно добавлено!")
+	audit(ctx, db, AuditEventCreate, msg.From, 0, "", eventID, fmt.Sprintf("%s;%s;%d", name, dateStr, capacity))
+	sendMessage(bot, msg.Chat.ID, T(lang, "event_added"))
+	return nil
 }
 
 // handleQRCode handles the /qrcode command.
-// Generates a QR code with a static link to the bot with the "imhere" parameter.
-func handleQRCode(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-	qrData := "https://t.me/RndPHPbot?start=imhere"
-	qrFile := "qrcode_event.png"
-	if err := qrcode.WriteFile(qrData, qrcode.Medium, 256, qrFile); err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка генерации QR-кода")
-		return
+// Sends the check-in QR code of the current event. The code is signed and changes every
+// few minutes, the message is edited with the new code, see CheckinQRCodes.
+func handleQRCode(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
+	}
+	if err := QRCodes.Show(bot, msg.Chat.ID, lang, event); err != nil {
+		return Fail(err, "error_qrcode")
+	}
+	return nil
+}
+
+// handlePrint handles the /print command.
+// Sends the attendee list with check boxes and the name badges of the current event as PDF documents.
+func handlePrint(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		return Fail(err, "error_event_fetch")
+	}
+	if event == nil {
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
+	}
+	attendees, err := printAttendees(ctx, db, event.id)
+	if err != nil {
+		return Fail(err, "error_export_fetch")
+	}
+	if len(attendees) == 0 {
+		sendMessage(bot, msg.Chat.ID, T(lang, "print_empty"))
+		return nil
+	}
+
+	font, err := loadPDFFont(AppConfig.PDFFont)
+	if err != nil {
+		slog.Warn("Failed to load the PDF font, using Helvetica", "error", err)
+	}
+	var list, badges bytes.Buffer
+	if err := writeAttendeeList(&list, font, lang, event, attendees); err != nil {
+		return Fail(err, "error_print_write")
+	}
+	if err := writeBadges(&badges, font, event, attendees); err != nil {
+		return Fail(err, "error_print_write")
+	}
+
+	documents := []struct {
+		name    string
+		data    []byte
+		caption string
+	}{
+		{fmt.Sprintf("attendees_%d.pdf", event.id), list.Bytes(), N(lang, "print_list_caption", len(attendees), event.name, len(attendees))},
+		{fmt.Sprintf("badges_%d.pdf", event.id), badges.Bytes(), T(lang, "print_badges_caption", event.name)},
+	}
+	for _, document := range documents {
+		doc := tgbotapi.NewDocumentUpload(msg.Chat.ID, tgbotapi.FileBytes{Name: document.name, Bytes: document.data})
+		doc.Caption = document.caption
+		if _, err := bot.Send(doc); err != nil {
+			return Fail(err, "error_export_send")
+		}
 	}
-	photo := tgbotapi.NewPhotoUpload(msg.Chat.ID, qrFile)
-	photo.Caption = "QR-код для отметки о посещении"
-	bot.Send(photo)
-	os.Remove(qrFile)
+	return nil
 }
 
 // handleRemoveUser handles the /remove command.
 // Removes a user from the current event by username. Admin only.
-func handleRemoveUser(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
+func handleRemoveUser(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
 	username := strings.TrimSpace(msg.CommandArguments())
 	if username == "" {
-		sendMessage(bot, msg.Chat.ID, "Использование: /remove username")
-		return
+		return Reject("remove_usage")
 	}
 
 	// Remove @ if provided
 	username = strings.TrimPrefix(username, "@")
 
-	event, err := db.GetLatestEvent()
+	event, err := db.GetLatestEvent(ctx)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка получения информации о событии")
-		return
+		return Fail(err, "error_event_fetch")
 	}
 	if event == nil {
-		sendMessage(bot, msg.Chat.ID, "Нет активного события")
-		return
+		sendMessage(bot, msg.Chat.ID, T(lang, "no_active_event"))
+		return nil
 	}
 
-	wasRegistered, err := db.RemoveUserByUsername(username, event.id)
+	wasRegistered, err := db.RemoveUserByUsername(ctx, username, event.id)
 	if err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка удаления пользователя: "+err.Error())
-		return
+		return Fail(err, "error_user_remove")
 	}
+	// Rows of an unregistered user (walk-in, waitlist) are deleted too, so record the removal either way
+	details := "registered"
+	if !wasRegistered {
+		details = removedNotRegistered
+	}
+	audit(ctx, db, AuditAdminRemove, msg.From, 0, username, event.id, details)
 
 	if !wasRegistered {
-		sendMessage(bot, msg.Chat.ID, "Пользователь @"+username+" не найден в регистрациях")
-		return
+		sendMessage(bot, msg.Chat.ID, T(lang, "user_not_found", username))
+		return nil
 	}
 
 	// Decrement registration count
-	if err := db.DecrementEventRegistrationCount(event.id); err != nil {
-		sendMessage(bot, msg.Chat.ID, "Ошибка обновления количества регистраций")
-		return
+	if err := db.DecrementEventRegistrationCount(ctx, event.id); err != nil {
+		return Fail(err, "error_registration_count")
 	}
 
-	sendMessage(bot, msg.Chat.ID, "Пользователь @"+username+" удалён из регистраций")
+	sendMessage(bot, msg.Chat.ID, T(lang, "user_removed", username))
 
 	// Notify waitlist
-	notifyWaitlist(bot, db, event.id)
+	notifyWaitlist(ctx, bot, db, event.id)
+	return nil
+}
+
+// languageCallbackPrefix prefixes the callback data of the language buttons
+const languageCallbackPrefix = "lang:"
+
+// handleLanguage handles the /language command.
+// Without arguments it shows a button per supported language, with a language code it sets it directly.
+func handleLanguage(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	requested := normalizeLanguage(msg.CommandArguments())
+	if requested != "" {
+		return setUserLanguage(ctx, bot, db, msg.Chat.ID, msg.From.ID, lang, requested)
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, code := range I18n.Languages() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(T(code, "language_name"), languageCallbackPrefix+code))
+	}
+	message := tgbotapi.NewMessage(msg.Chat.ID, T(lang, "language_prompt"))
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	bot.Send(message)
+	return nil
+}
+
+// handleLanguageCallback handles a press on one of the /language buttons
+func handleLanguageCallback(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+	lang := userLanguage(ctx, db, cq.From)
+	requested := strings.TrimPrefix(cq.Data, languageCallbackPrefix)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, ""))
+	return setUserLanguage(ctx, bot, db, cq.Message.Chat.ID, cq.From.ID, lang, requested)
+}
+
+// setUserLanguage stores the language override and confirms it in the new language
+func setUserLanguage(ctx context.Context, bot Sender, db Repository, chatID int64, telegramID int, lang, requested string) error {
+	if !I18n.Supports(requested) {
+		sendMessage(bot, chatID, T(lang, "language_unsupported", requested, strings.Join(I18n.Languages(), ", ")))
+		return nil
+	}
+	requested = normalizeLanguage(requested)
+	if err := db.SetUserLanguage(ctx, telegramID, requested); err != nil {
+		return Fail(err, "error_language_save")
+	}
+	sendMessage(bot, chatID, T(requested, "language_set", T(requested, "language_name")))
+	return nil
+}
+
+// handleTemplates handles the /templates command.
+// Lists the editable messages, shows which ones are customized and how to change them.
+func handleTemplates(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	templates, err := db.GetMessageTemplates(ctx)
+	if err != nil {
+		return Fail(err, "error_template_load")
+	}
+	custom := make(map[string]bool)
+	for _, tpl := range templates {
+		custom[tpl.Key+"/"+tpl.Language] = true
+	}
+
+	text := T(lang, "templates_header")
+	for _, key := range editableTemplates {
+		var states []string
+		for _, code := range I18n.Languages() {
+			state := T(lang, "template_default")
+			if custom[key+"/"+code] {
+				state = T(lang, "template_custom")
+			}
+			states = append(states, code+": "+state)
+		}
+		text += "\n• " + key + " (" + strings.Join(states, ", ") + ")"
+	}
+	text += "\n\n" + T(lang, "templates_help")
+	sendMessage(bot, msg.Chat.ID, text)
+	return nil
+}
+
+// handleSetTemplate handles the /settemplate command.
+// The first line holds the key, an optional language and an optional "reset",
+// the following lines hold the template body. Without a body the current template is shown.
+func handleSetTemplate(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	header, body := msg.CommandArguments(), ""
+	if i := strings.Index(header, "\n"); i >= 0 {
+		header, body = header[:i], strings.TrimSpace(header[i+1:])
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return Reject("templates_help")
+	}
+
+	key := fields[0]
+	if !isEditableTemplate(key) {
+		return Reject("template_unknown_key", key, strings.Join(editableTemplates, ", "))
+	}
+	targetLang, reset := lang, false
+	for _, field := range fields[1:] {
+		switch {
+		case strings.ToLower(field) == "reset":
+			reset = true
+		case I18n.Supports(field):
+			targetLang = normalizeLanguage(field)
+		default:
+			sendMessage(bot, msg.Chat.ID, T(lang, "language_unsupported", field, strings.Join(I18n.Languages(), ", ")))
+			return nil
+		}
+	}
+
+	event, _ := db.GetLatestEvent(ctx)
+	data := newTemplateData(event, msg.From)
+
+	if reset {
+		if err := db.DeleteMessageTemplate(ctx, key, targetLang); err != nil {
+			return Fail(err, "error_template_save")
+		}
+		audit(ctx, db, AuditTemplateUpdate, msg.From, 0, "", 0, key+"/"+targetLang+" reset")
+		sendMessage(bot, msg.Chat.ID, T(lang, "template_reset", key, targetLang))
+		sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, targetLang, key, data))
+		return nil
+	}
+
+	if body == "" {
+		current := T(targetLang, key)
+		tpl, err := db.GetMessageTemplate(ctx, key, targetLang)
+		if err != nil {
+			return Fail(err, "error_template_load")
+		}
+		if tpl != nil {
+			current = tpl.Body
+		}
+		sendMessage(bot, msg.Chat.ID, T(lang, "template_current", key, targetLang)+"\n\n"+current)
+		sendMessage(bot, msg.Chat.ID, T(lang, "template_preview"))
+		sendMessage(bot, msg.Chat.ID, renderMessage(ctx, db, targetLang, key, data))
+		return nil
+	}
+
+	if err := validateMessageTemplate(key, body); err != nil {
+		return Reject("template_invalid", err.Error())
+	}
+	// Render with the live data as well, so the preview is exactly what users get
+	preview, err := executeMessageTemplate(key, body, data)
+	if err != nil {
+		return Reject("template_invalid", err.Error())
+	}
+
+	tpl := MessageTemplate{
+		Key:       key,
+		Language:  targetLang,
+		Body:      body,
+		UpdatedBy: msg.From.UserName,
+		UpdatedAt: time.Now(),
+	}
+	if err := db.SaveMessageTemplate(ctx, tpl); err != nil {
+		return Fail(err, "error_template_save")
+	}
+	audit(ctx, db, AuditTemplateUpdate, msg.From, 0, "", 0, key+"/"+targetLang)
+	sendMessage(bot, msg.Chat.ID, T(lang, "template_saved", key, targetLang))
+	sendMessage(bot, msg.Chat.ID, preview)
+	return nil
+}
+
+// resolveUserTarget turns a "@username" or numeric Telegram ID argument into a Telegram ID and username
+func resolveUserTarget(ctx context.Context, db Repository, arg string) (int, string, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return id, "", nil
+	}
+	username := strings.TrimPrefix(arg, "@")
+	telegramID, err := db.FindTelegramIDByUsername(ctx, username)
+	return telegramID, username, err
+}
+
+// handleGrant handles the /grant command.
+// Grants a role to a user by username or Telegram ID, replacing the previous role.
+func handleGrant(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		return Reject("grant_usage", roleNames())
+	}
+	role, ok := ParseRole(args[1])
+	if !ok {
+		return Reject("role_unknown", args[1], roleNames())
+	}
+
+	telegramID, username, err := resolveUserTarget(ctx, db, args[0])
+	if err != nil {
+		return Fail(err, "error_role_save")
+	}
+	if telegramID == 0 {
+		return Reject("user_unknown", args[0])
+	}
+
+	err = db.SetUserRole(ctx, UserRole{
+		TelegramID: telegramID,
+		Username:   username,
+		Role:       string(role),
+		GrantedBy:  msg.From.ID,
+		GrantedAt:  time.Now(),
+	})
+	if err != nil {
+		return Fail(err, "error_role_save")
+	}
+	audit(ctx, db, AuditRoleGrant, msg.From, telegramID, username, 0, string(role))
+	sendMessage(bot, msg.Chat.ID, T(lang, "role_granted", args[0], string(role)))
+	return nil
+}
+
+// handleRevoke handles the /revoke command.
+// Removes the role of a user by username or Telegram ID.
+func handleRevoke(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 1 {
+		return Reject("revoke_usage")
+	}
+
+	telegramID, username, err := resolveUserTarget(ctx, db, args[0])
+	if err != nil {
+		return Fail(err, "error_role_save")
+	}
+	if telegramID == 0 {
+		return Reject("user_unknown", args[0])
+	}
+	if telegramID == msg.From.ID {
+		return Reject("role_revoke_self")
+	}
+	if username == "" {
+		// Revoking by ID: take the username the role was granted with
+		if roles, err := db.GetUserRoles(ctx); err == nil {
+			for _, role := range roles {
+				if role.TelegramID == telegramID {
+					username = role.Username
+				}
+			}
+		}
+	}
+	if err := db.RemoveUserRole(ctx, telegramID); err != nil {
+		return Fail(err, "error_role_save")
+	}
+	audit(ctx, db, AuditRoleRevoke, msg.From, telegramID, username, 0, "")
+	sendMessage(bot, msg.Chat.ID, T(lang, "role_revoked", args[0]))
+	return nil
+}
+
+// handleRoles handles the /roles command.
+// Lists all users with a role.
+func handleRoles(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	roles, err := db.GetUserRoles(ctx)
+	if err != nil {
+		return Fail(err, "error_role_load")
+	}
+	if len(roles) == 0 {
+		sendMessage(bot, msg.Chat.ID, T(lang, "roles_empty"))
+		return nil
+	}
+
+	text := T(lang, "roles_header")
+	for _, role := range roles {
+		who := strconv.Itoa(role.TelegramID)
+		if role.Username != "" {
+			who = "@" + role.Username + " (" + who + ")"
+		}
+		text += "\n• " + who + ": " + role.Role
+	}
+	sendMessage(bot, msg.Chat.ID, text)
+	return nil
+}
+
+// roleNames returns the comma-separated list of role names
+func roleNames() string {
+	names := make([]string, 0, len(allRoles))
+	for _, role := range allRoles {
+		names = append(names, string(role))
+	}
+	return strings.Join(names, ", ")
+}
+
+// handleLog handles the /log command.
+// Shows the newest audit log entries matching the filters, or sends them as CSV with "csv".
+func handleLog(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	filter, asCSV, err := parseAuditFilter(msg.CommandArguments())
+	if err != nil {
+		return Reject("log_invalid_filter", err.Error())
+	}
+
+	entries, err := db.GetAuditLog(ctx, filter)
+	if err != nil {
+		return Fail(err, "error_log_load")
+	}
+	if len(entries) == 0 {
+		sendMessage(bot, msg.Chat.ID, T(lang, "log_empty"))
+		return nil
+	}
+
+	if asCSV {
+		var buf bytes.Buffer
+		if err := writeAuditCSV(&buf, entries); err != nil {
+			return Fail(err, "error_export_write")
+		}
+		doc := tgbotapi.NewDocumentUpload(msg.Chat.ID, tgbotapi.FileBytes{
+			Name:  "audit_log_" + time.Now().Format("20060102_150405") + ".csv",
+			Bytes: buf.Bytes(),
+		})
+		doc.Caption = N(lang, "log_caption", len(entries))
+		if _, err := bot.Send(doc); err != nil {
+			return Fail(err, "error_export_send")
+		}
+		return nil
+	}
+
+	// Entries come newest first, show them in chronological order
+	text := N(lang, "log_header", len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		line := "\n" + formatAuditEntry(entries[i])
+		if len([]rune(text+line)) > maxMessageLength {
+			sendMessage(bot, msg.Chat.ID, text)
+			text = ""
+		}
+		text += line
+	}
+	sendMessage(bot, msg.Chat.ID, text)
+	return nil
+}
+
+// handleWebhooks handles the /webhooks command.
+// Shows the newest webhook deliveries that failed, also those that are still retried.
+func handleWebhooks(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	failures, err := db.GetWebhookFailures(ctx, defaultAuditLimit)
+	if err != nil {
+		return Fail(err, "error_webhooks_load")
+	}
+	if len(failures) == 0 {
+		if len(AppConfig.WebhookURLs) == 0 {
+			sendMessage(bot, msg.Chat.ID, T(lang, "webhooks_disabled"))
+		} else {
+			sendMessage(bot, msg.Chat.ID, T(lang, "webhooks_empty"))
+		}
+		return nil
+	}
+
+	text := N(lang, "webhooks_header", len(failures))
+	for _, delivery := range failures {
+		line := "\n" + formatWebhookFailure(lang, delivery)
+		if len([]rune(text+line)) > maxMessageLength {
+			sendMessage(bot, msg.Chat.ID, text)
+			text = ""
+		}
+		text += line
+	}
+	sendMessage(bot, msg.Chat.ID, text)
+	return nil
+}
+
+// handleBan handles the /ban command.
+// Banned users are ignored by the bot, their existing registrations are kept.
+func handleBan(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	args := strings.SplitN(strings.TrimSpace(msg.CommandArguments()), " ", 2)
+	if args[0] == "" {
+		return Reject("ban_usage")
+	}
+	reason := ""
+	if len(args) == 2 {
+		reason = strings.TrimSpace(args[1])
+	}
+
+	telegramID, username, err := resolveUserTarget(ctx, db, args[0])
+	if err != nil {
+		return Fail(err, "error_ban_save")
+	}
+	if telegramID == 0 {
+		return Reject("user_unknown", args[0])
+	}
+	if telegramID == msg.From.ID {
+		return Reject("ban_self")
+	}
+
+	err = db.BanUser(ctx, BannedUser{
+		TelegramID: telegramID,
+		Username:   username,
+		Reason:     reason,
+		BannedBy:   msg.From.ID,
+		BannedAt:   time.Now(),
+	})
+	if err != nil {
+		return Fail(err, "error_ban_save")
+	}
+	audit(ctx, db, AuditUserBan, msg.From, telegramID, username, 0, reason)
+	sendMessage(bot, msg.Chat.ID, T(lang, "user_banned", args[0]))
+	return nil
+}
+
+// handleUnban handles the /unban command.
+func handleUnban(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+	lang := userLanguage(ctx, db, msg.From)
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		return Reject("unban_usage")
+	}
+
+	telegramID, username, err := resolveUserTarget(ctx, db, arg)
+	if err != nil {
+		return Fail(err, "error_ban_save")
+	}
+	if telegramID == 0 {
+		return Reject("user_unknown", arg)
+	}
+
+	if err := db.UnbanUser(ctx, telegramID); err != nil {
+		return Fail(err, "error_ban_save")
+	}
+	audit(ctx, db, AuditUserUnban, msg.From, telegramID, username, 0, "")
+	sendMessage(bot, msg.Chat.ID, T(lang, "user_unbanned", arg))
+	return nil
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// fakeSender records what the handlers send instead of calling Telegram
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []tgbotapi.MessageConfig
+	answers  []tgbotapi.CallbackConfig
+	other    []tgbotapi.Chattable
+	files    map[string]string // files maps the IDs of the files the handlers can download to their URLs
+	uploads  []fakeUpload
+}
+
+// fakeUpload is a call of UploadFile
+type fakeUpload struct {
+	endpoint string
+	params   map[string]string
+	file     tgbotapi.FileBytes
+}
+
+// Send records a message
+func (f *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		f.messages = append(f.messages, msg)
+	} else {
+		f.other = append(f.other, c)
+	}
+	return tgbotapi.Message{MessageID: len(f.messages) + len(f.other)}, nil
+}
+
+// AnswerCallbackQuery records a callback answer
+func (f *fakeSender) AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers = append(f.answers, config)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// GetFileDirectURL returns the URL of a file added with serveFile
+func (f *fakeSender) GetFileDirectURL(fileID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	link, ok := f.files[fileID]
+	if !ok {
+		return "", errors.New("Bad Request: invalid file_id")
+	}
+	return link, nil
+}
+
+// UploadFile records an upload
+func (f *fakeSender) UploadFile(endpoint string, params map[string]string, fieldname string, file interface{}) (tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload := fakeUpload{endpoint: endpoint, params: params}
+	upload.file, _ = file.(tgbotapi.FileBytes)
+	f.uploads = append(f.uploads, upload)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// serveFile makes content downloadable as the file with the ID until the test ends
+func (f *fakeSender) serveFile(t *testing.T, fileID string, content []byte) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string]string)
+	}
+	f.files[fileID] = server.URL + "/file/" + fileID
+}
+
+// texts returns the texts of the messages sent since the last reset
+func (f *fakeSender) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, msg := range f.messages {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// reset forgets everything recorded so far
+func (f *fakeSender) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages, f.answers, f.other, f.uploads = nil, nil, nil, nil
+}
+
+// testUsers are the users of the scenarios, the chat ID of each is the user ID
+var testUsers = map[int]*tgbotapi.User{
+	1: {ID: 1, UserName: "ivan", FirstName: "Ivan", LastName: "Ivanov", LanguageCode: "en"},
+	2: {ID: 2, UserName: "anna", FirstName: "Anna", LastName: "Petrova", LanguageCode: "en"},
+	3: {ID: 3, UserName: "boss", FirstName: "Boss", LanguageCode: "en"},
+}
+
+// step is a single update of a scenario and what the bot must answer
+type step struct {
+	user   int                               // user sending the update, see testUsers
+	text   string                            // text of a message
+	data   string                            // data of a pressed button, used if text is empty
+	want   []string                          // texts of the messages the bot sends, in order
+	answer string                            // text of the callback answer, checked if not empty
+	check  func(t *testing.T, db Repository) // optional check of the stored state
+}
+
+// update builds the Telegram update of the step
+func (s step) update(id int) tgbotapi.Update {
+	user := testUsers[s.user]
+	chat := &tgbotapi.Chat{ID: int64(user.ID), Type: "private"}
+	if s.text == "" {
+		return tgbotapi.Update{UpdateID: id, CallbackQuery: &tgbotapi.CallbackQuery{
+			ID: "cq", From: user, Data: s.data, Message: &tgbotapi.Message{Chat: chat},
+		}}
+	}
+	msg := &tgbotapi.Message{MessageID: id, From: user, Chat: chat, Text: s.text}
+	if strings.HasPrefix(s.text, "/") {
+		command := strings.SplitN(s.text, " ", 2)[0]
+		msg.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
+	}
+	return tgbotapi.Update{UpdateID: id, Message: msg}
+}
+
+// setupHandlers sets up the globals the handlers use and returns the fake sender
+func setupHandlers(t *testing.T) *fakeSender {
+	t.Helper()
+	locales, err := LoadLocales("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	I18n = locales
+	AppConfig = &Config{DefaultLocale: "en", AdminUsers: []string{"boss"}, MandatoryFields: []string{"name", "email"}}
+	DialogMgr = NewDialogManager()
+	QRCodes = NewCheckinQRCodes()
+	Links = NewLinkBuilder("testbot")
+
+	sender := &fakeSender{}
+	Commands = NewCommandRegistry(
+		NewErrorReporter(sender, 0).Middleware,
+		RecoverMiddleware,
+		BannedUserMiddleware,
+	)
+	registerCommands(Commands)
+	return sender
+}
+
+func TestScenarios(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	date := time.Now().AddDate(0, 0, 7).Truncate(time.Second)
+
+	tests := []struct {
+		name  string
+		setup func(db Repository)
+		steps []step
+	}{
+		{
+			name:  "register, dialog, remove, waitlist notify, book",
+			setup: func(db Repository) { db.AddEvent(ctx, "Meetup", date, 1) },
+			steps: []step{
+				{user: 1, data: "register", want: []string{T("en", "ask_name")}, answer: T("en", "callback_registered")},
+				{user: 1, text: "Ivan", want: []string{T("en", "ask_name_format")}},
+				{user: 1, text: "Ivanov Ivan", want: []string{T("en", "ask_email")}},
+				{user: 1, text: "not an email", want: []string{T("en", "ask_valid_email")}},
+				{user: 1, text: "ivan@example.com", want: []string{T("en", "registration_complete"), N("en", "seats_left", 0)},
+					check: func(t *testing.T, db Repository) {
+						_, reg, _ := db.IsUserRegistered(ctx, 1, 1)
+						if reg == nil || reg.Registred != 1 || reg.Name != "Ivanov Ivan" || reg.Email != "ivan@example.com" {
+							t.Errorf("registration = %+v", reg)
+						}
+					}},
+				{user: 2, data: "register", want: []string{T("en", "waitlist_offer_book")}},
+				{user: 2, data: "join_waitlist", want: []string{T("en", "waitlist_added")}, answer: T("en", "callback_waitlist_added")},
+				{user: 1, data: "remove", want: []string{T("en", "waitlist_spot_available"), N("en", "seats_left", 1)},
+					answer: T("en", "callback_registration_removed")},
+				{user: 2, data: "waitlist_book", want: []string{T("en", "booked_ask_name")}},
+				{user: 2, text: "Petrova Anna", want: []string{T("en", "ask_email")}},
+				{user: 2, text: "anna@example.com", want: []string{T("en", "registration_complete"), N("en", "seats_left", 0)},
+					check: func(t *testing.T, db Repository) {
+						if in, _ := db.IsUserInWaitlist(ctx, 2, 1); in {
+							t.Error("anna is still in the waitlist")
+						}
+						if registered, _, _ := db.IsUserRegistered(ctx, 1, 1); registered {
+							t.Error("ivan is still registered")
+						}
+						if event, _ := db.GetLatestEvent(ctx); event.registrationCount != 1 {
+							t.Errorf("registrationCount = %d, want 1", event.registrationCount)
+						}
+					}},
+			},
+		},
+		{
+			name: "command cancels the dialog",
+			setup: func(db Repository) {
+				db.AddEvent(ctx, "Meetup", date, 10)
+			},
+			steps: []step{
+				{user: 1, data: "register", want: []string{T("en", "ask_name")}},
+				{user: 1, text: "/state", want: []string{T("en", "dialog_cancelled"), N("en", "seats_left", 10), T("en", "status_not_registered")}},
+			},
+		},
+		{
+			name: "check-in of registered and walk-in users",
+			setup: func(db Repository) {
+				db.AddEvent(ctx, "Meetup", date, 10)
+				db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", RegistrationDate: date, EventID: 1, Registred: 1})
+			},
+			steps: []step{
+				{user: 1, text: "/start imhere", want: []string{T("en", "checkin_code_invalid")}},
+				{user: 1, text: "/start " + checkinCode(1, time.Now()), want: []string{T("en", "visit_updated")},
+					check: func(t *testing.T, db Repository) {
+						if registered, reg, _ := db.IsUserRegistered(ctx, 1, 1); !registered || reg.Visited != 1 {
+							t.Errorf("registered user = %v, %+v", registered, reg)
+						}
+					}},
+				{user: 2, text: "/start " + checkinCode(1, time.Now()), want: []string{T("en", "visit_walk_in")},
+					check: func(t *testing.T, db Repository) {
+						if registered, reg, _ := db.IsUserRegistered(ctx, 2, 1); registered || reg == nil || reg.Visited != 1 {
+							t.Errorf("walk-in = %v, %+v", registered, reg)
+						}
+						entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditCheckin)})
+						if len(entries) != 2 {
+							t.Errorf("audit log has %d check-ins, want 2", len(entries))
+						}
+					}},
+			},
+		},
+		{
+			name: "check-in without an event",
+			steps: []step{
+				{user: 1, text: "/start imhere", want: []string{T("en", "no_active_event")}},
+			},
+		},
+		{
+			name: "admin commands with and without permission",
+			setup: func(db Repository) {
+				db.AddEvent(ctx, "Meetup", date, 10)
+				db.RegisterUser(ctx, UserRegistration{TelegramID: 2, Username: "anna", RegistrationDate: date, EventID: 1, Registred: 1})
+				db.UpdateEventRegistrationCount(ctx, 1)
+			},
+			steps: []step{
+				{user: 1, text: "/addevent Next;2030-01-01;5", want: []string{T("en", "permission_denied")}},
+				{user: 1, text: "/remove anna", want: []string{T("en", "permission_denied")},
+					check: func(t *testing.T, db Repository) {
+						if registered, _, _ := db.IsUserRegistered(ctx, 2, 1); !registered {
+							t.Error("anna was removed without permission")
+						}
+					}},
+				{user: 3, text: "/remove @anna", want: []string{T("en", "user_removed", "anna")},
+					check: func(t *testing.T, db Repository) {
+						if registered, _, _ := db.IsUserRegistered(ctx, 2, 1); registered {
+							t.Error("anna is still registered")
+						}
+					}},
+				{user: 3, text: "/remove anna", want: []string{T("en", "user_not_found", "anna")}},
+				{user: 3, text: "/addevent Next", want: []string{T("en", "addevent_usage")}},
+				{user: 3, text: "/addevent Next;2030-01-01;5", want: []string{T("en", "event_added")},
+					check: func(t *testing.T, db Repository) {
+						if event, _ := db.GetLatestEvent(ctx); event == nil || event.name != "Next" || event.capacity != 5 {
+							t.Errorf("latest event = %+v", event)
+						}
+						if role, _ := db.GetUserRole(ctx, 3); role != string(RoleOwner) {
+							t.Errorf("role of ADMIN_USERS member = %q, want owner", role)
+						}
+					}},
+				{user: 3, text: "/grant @ivan volunteer", want: []string{T("en", "user_unknown", "@ivan")}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryRepository()
+			DialogMgr = NewDialogManager()
+			if tt.setup != nil {
+				tt.setup(db)
+			}
+			for i, s := range tt.steps {
+				sender.reset()
+				Commands.HandleUpdate(ctx, sender, db, s.update(i+1))
+
+				got := sender.texts()
+				if strings.Join(got, "\n---\n") != strings.Join(s.want, "\n---\n") {
+					t.Fatalf("step %d (%s%s): got %q, want %q", i+1, s.text, s.data, got, s.want)
+				}
+				if s.answer != "" && (len(sender.answers) == 0 || sender.answers[0].Text != s.answer) {
+					t.Errorf("step %d (%s%s): callback answers %+v, want %q", i+1, s.text, s.data, sender.answers, s.answer)
+				}
+				if s.check != nil {
+					s.check(t, db)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitlistNotificationGoesToWaitingChat(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now(), 1))
+	mustNoError(t, db.AddToWaitlist(ctx, 2, 200, "anna", 1))
+
+	notifyWaitlist(ctx, sender, db, 1)
+	if len(sender.messages) != 1 || sender.messages[0].ChatID != 200 {
+		t.Fatalf("messages = %+v, want one to chat 200", sender.messages)
+	}
+	keyboard, ok := sender.messages[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || len(keyboard.InlineKeyboard) != 1 || *keyboard.InlineKeyboard[0][0].CallbackData != "waitlist_book" {
+		t.Errorf("reply markup = %+v, want the book button", sender.messages[0].ReplyMarkup)
+	}
+}
+
+// TestFilesAreBuiltInMemory checks that /export and /qrcode leave no files with personal data behind
+func TestFilesAreBuiltInMemory(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now(), 10))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: 1, Registred: 1}))
+
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export"}.update(1))
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/export format=xlsx"}.update(2))
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/qrcode"}.update(3))
+
+	if len(sender.other) != 2 {
+		t.Fatalf("sent %+v, want the export and the QR code", sender.other)
+	}
+	photo, ok := sender.other[1].(tgbotapi.PhotoConfig)
+	if !ok || !bytes.HasPrefix(photo.File.(tgbotapi.FileBytes).Bytes, []byte("\x89PNG")) {
+		t.Errorf("/qrcode sent %+v, want a PNG", sender.other[1])
+	}
+	if files, _ := os.ReadDir("."); len(files) != 0 {
+		t.Errorf("files left in the working directory: %v", files)
+	}
+}
+
+// TestRoleBootstrap checks that ADMIN_USERS seeds the owners once, who can then be revoked like anyone else
+func TestRoleBootstrap(t *testing.T) {
+	sender := setupHandlers(t)
+	AppConfig.AdminUsers = []string{"@boss", "2", "@ivan", "@olga"}
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	send := func(user *tgbotapi.User, text string) []string {
+		t.Helper()
+		sender.reset()
+		update := step{user: 1, text: text}.update(1)
+		update.Message.From = user
+		update.Message.Chat.ID = int64(user.ID)
+		Commands.HandleUpdate(ctx, sender, db, update)
+		return sender.texts()
+	}
+	role := func(id int) string {
+		role, _ := db.GetUserRole(ctx, id)
+		return role
+	}
+
+	// Nobody is seeded on startup without a Telegram ID or a username the bot knows
+	if seeded, err := seedOwners(ctx, db, nil); err != nil || !seeded || role(2) != string(RoleOwner) || role(3) != "" {
+		t.Fatalf("seeded on startup = %v, %v, roles %q, %q", seeded, err, role(2), role(3))
+	}
+	// The roles table is not empty anymore, so the first command of another member seeds nothing
+	if texts := send(testUsers[3], "/roles"); len(texts) != 1 || texts[0] != T("en", "permission_denied") || role(3) != "" {
+		t.Errorf("ADMIN_USERS username after the startup = %q, role %q", texts, role(3))
+	}
+
+	// Every member is seeded at once, not only the first one to send a command
+	db = NewMemoryRepository()
+	AppConfig.AdminUsers = []string{"@boss", "2", "@ivan", "@olga"}
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", EventID: 1, Registred: 1}))
+	send(testUsers[3], "/roles")
+	if role(3) != string(RoleOwner) || role(2) != string(RoleOwner) || role(1) != string(RoleOwner) {
+		t.Fatalf("roles after the first command = %q, %q, %q, want all owners", role(3), role(2), role(1))
+	}
+	// A username unknown at the time is not granted later, it could have been taken over
+	olga := &tgbotapi.User{ID: 4, UserName: "olga", LanguageCode: "en"}
+	if texts := send(olga, "/roles"); len(texts) != 1 || texts[0] != T("en", "permission_denied") || role(4) != "" {
+		t.Errorf("ADMIN_USERS username after the seeding = %q, role %q", texts, role(4))
+	}
+	hijacker := &tgbotapi.User{ID: 99, UserName: "Boss", LanguageCode: "en"}
+	if texts := send(hijacker, "/roles"); len(texts) != 1 || texts[0] != T("en", "permission_denied") || role(99) != "" {
+		t.Errorf("new holder of the username = %q, role %q", texts, role(99))
+	}
+
+	send(testUsers[3], "/grant 2 owner")
+	if texts := send(testUsers[2], "/revoke 3"); len(texts) != 1 || texts[0] != T("en", "role_revoked", "3") || role(3) != "" {
+		t.Errorf("/revoke of the bootstrap owner = %q, role %q", texts, role(3))
+	}
+	if texts := send(testUsers[3], "/roles"); len(texts) != 1 || texts[0] != T("en", "permission_denied") {
+		t.Errorf("revoked bootstrap owner = %q, role %q", texts, role(3))
+	}
+}
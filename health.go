@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// version is the build version, set with -ldflags "-X main.version=v1.2.3".
+// Without it the VCS revision from the build info is used.
+var version = ""
+
+// pollStaleAfter is how long after the last successful getUpdates the bot is considered stuck.
+// Long polling returns at least every 60 seconds, even without updates.
+const pollStaleAfter = 3 * time.Minute
+
+// Health tracks what /healthz and /readyz report
+type Health struct {
+	started  time.Time
+	lastPoll atomic.Int64 // Unix nanoseconds of the last successful getUpdates
+}
+
+// NewHealth creates a Health for a process started now.
+// The start counts as the first poll, the bot has just talked to Telegram to authorize.
+func NewHealth() *Health {
+	h := &Health{started: time.Now()}
+	h.lastPoll.Store(h.started.UnixNano())
+	return h
+}
+
+// Transport records every successful getUpdates call
+func (h *Health) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err == nil && resp.StatusCode == http.StatusOK && strings.HasSuffix(r.URL.Path, "/getUpdates") {
+			h.lastPoll.Store(time.Now().UnixNano())
+		}
+		return resp, err
+	})
+}
+
+// LastPoll returns the time of the last successful getUpdates
+func (h *Health) LastPoll() time.Time {
+	return time.Unix(0, h.lastPoll.Load())
+}
+
+// healthResponse is the JSON body of /healthz and /readyz
+type healthResponse struct {
+	Status  string            `json:"status"`
+	Version string            `json:"version"`
+	Uptime  string            `json:"uptime"`
+	Checks  map[string]string `json:"checks,omitempty"`
+}
+
+// newHealthResponse fills in the fields every health response has
+func (h *Health) newHealthResponse(status string) healthResponse {
+	return healthResponse{
+		Status:  status,
+		Version: buildVersion(),
+		Uptime:  time.Since(h.started).Truncate(time.Second).String(),
+	}
+}
+
+// LivenessHandler serves /healthz: the process is up and serving HTTP
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, h.newHealthResponse("ok"))
+	})
+}
+
+// ReadinessHandler serves /readyz: the database answers and updates are still being received
+func (h *Health) ReadinessHandler(db Repository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := h.newHealthResponse("ok")
+		resp.Checks = make(map[string]string)
+		code := http.StatusOK
+
+		if err := db.Ping(r.Context()); err != nil {
+			resp.Checks["database"] = "unavailable: " + err.Error()
+			code = http.StatusServiceUnavailable
+		} else {
+			resp.Checks["database"] = "ok"
+		}
+
+		sincePoll := time.Since(h.LastPoll()).Truncate(time.Second)
+		if sincePoll > pollStaleAfter {
+			resp.Checks["telegram"] = "no successful getUpdates for " + sincePoll.String()
+			code = http.StatusServiceUnavailable
+		} else {
+			resp.Checks["telegram"] = "ok"
+		}
+
+		if code != http.StatusOK {
+			resp.Status = "unavailable"
+		}
+		writeHealth(w, code, resp)
+	})
+}
+
+// writeHealth writes a health response as JSON
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// buildVersion returns the version set at build time, or the VCS revision
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "dev"
+}
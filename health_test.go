@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unreachableRepository is a repository whose database doesn't answer
+type unreachableRepository struct {
+	Repository
+}
+
+func (unreachableRepository) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestReadiness(t *testing.T) {
+	ready := func(h *Health, db Repository) (int, healthResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ReadinessHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var resp healthResponse
+		mustNoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	h := NewHealth()
+	if code, resp := ready(h, NewMemoryRepository()); code != http.StatusOK || resp.Status != "ok" ||
+		resp.Checks["database"] != "ok" || resp.Checks["telegram"] != "ok" || resp.Version == "" {
+		t.Errorf("ready = %d, %+v", code, resp)
+	}
+
+	if code, resp := ready(h, unreachableRepository{NewMemoryRepository()}); code != http.StatusServiceUnavailable ||
+		resp.Status != "unavailable" || resp.Checks["database"] != "unavailable: connection refused" || resp.Checks["telegram"] != "ok" {
+		t.Errorf("without the database = %d, %+v", code, resp)
+	}
+
+	// Polling stopped: the bot is not ready even though the database answers
+	h.lastPoll.Store(time.Now().Add(-pollStaleAfter - time.Minute).UnixNano())
+	if code, resp := ready(h, NewMemoryRepository()); code != http.StatusServiceUnavailable || resp.Checks["database"] != "ok" ||
+		resp.Checks["telegram"] != "no successful getUpdates for 4m0s" {
+		t.Errorf("stale polling = %d, %+v", code, resp)
+	}
+
+	// Only a successful getUpdates counts as a poll
+	status := http.StatusBadGateway
+	client := &http.Client{Transport: h.Transport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: http.NoBody, Request: r}, nil
+	}))}
+	get := func(method string) {
+		t.Helper()
+		resp, err := client.Get("https://api.telegram.org/bot123:abc/" + method)
+		mustNoError(t, err)
+		resp.Body.Close()
+	}
+	get("getUpdates")
+	status = http.StatusOK
+	get("sendMessage")
+	if code, _ := ready(h, NewMemoryRepository()); code != http.StatusServiceUnavailable {
+		t.Errorf("ready after failed polls = %d", code)
+	}
+	get("getUpdates")
+	if code, _ := ready(h, NewMemoryRepository()); code != http.StatusOK {
+		t.Errorf("ready after a successful poll = %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("healthz = %d, %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// localeFiles holds the message catalogs, one JSON file per language
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Message is a single catalog entry.
+// Plain messages have only Text set, messages that depend on a number
+// keep one text per plural category (one, few, many, other).
+type Message struct {
+	Text  string
+	Forms map[string]string
+}
+
+// UnmarshalJSON accepts either a plain string or an object of plural forms
+func (m *Message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Text); err == nil {
+		return nil
+	}
+	m.Text = ""
+	return json.Unmarshal(data, &m.Forms)
+}
+
+// IsPlural reports whether the message has plural forms
+func (m Message) IsPlural() bool {
+	return len(m.Forms) > 0
+}
+
+// Catalog maps message keys to messages for a single language
+type Catalog map[string]Message
+
+// Localizer resolves message keys into texts for a given language
+type Localizer struct {
+	catalogs    map[string]Catalog
+	defaultLang string
+}
+
+// LoadLocales loads all embedded catalogs and sets the fallback language
+func LoadLocales(defaultLang string) (*Localizer, error) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Localizer{catalogs: make(map[string]Catalog)}
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var catalog Catalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("invalid locale catalog %s: %w", entry.Name(), err)
+		}
+		l.catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+
+	defaultLang = normalizeLanguage(defaultLang)
+	if _, ok := l.catalogs[defaultLang]; !ok {
+		return nil, fmt.Errorf("unsupported default locale: %s", defaultLang)
+	}
+	l.defaultLang = defaultLang
+
+	return l, nil
+}
+
+// Languages returns the sorted list of supported language codes
+func (l *Localizer) Languages() []string {
+	langs := make([]string, 0, len(l.catalogs))
+	for lang := range l.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supports checks if there is a catalog for the language
+func (l *Localizer) Supports(lang string) bool {
+	_, ok := l.catalogs[normalizeLanguage(lang)]
+	return ok
+}
+
+// DefaultLanguage returns the fallback language
+func (l *Localizer) DefaultLanguage() string {
+	return l.defaultLang
+}
+
+// lookup finds a message in the language catalog, falling back to the default one
+func (l *Localizer) lookup(lang, key string) (Message, string, bool) {
+	lang = normalizeLanguage(lang)
+	if catalog, ok := l.catalogs[lang]; ok {
+		if msg, ok := catalog[key]; ok {
+			return msg, lang, true
+		}
+	}
+	if msg, ok := l.catalogs[l.defaultLang][key]; ok {
+		return msg, l.defaultLang, true
+	}
+	return Message{}, lang, false
+}
+
+// T returns the text for the key, formatted with args
+func (l *Localizer) T(lang, key string, args ...interface{}) string {
+	msg, _, ok := l.lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := msg.Text
+	if msg.IsPlural() {
+		text = msg.Forms["other"]
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// N returns the plural form of the key matching n.
+// If no args are given, n itself is used as the only format argument.
+func (l *Localizer) N(lang, key string, n int, args ...interface{}) string {
+	msg, msgLang, ok := l.lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := msg.Text
+	if msg.IsPlural() {
+		text, ok = msg.Forms[pluralCategory(msgLang, n)]
+		if !ok {
+			text = msg.Forms["other"]
+		}
+	}
+	if len(args) == 0 {
+		args = []interface{}{n}
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// pluralCategory returns the CLDR plural category of n for the language
+func pluralCategory(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ru", "uk", "be":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// pluralCategories lists the plural categories a catalog must define for the language
+func pluralCategories(lang string) []string {
+	switch lang {
+	case "ru", "uk", "be":
+		return []string{"one", "few", "many"}
+	default:
+		return []string{"one", "other"}
+	}
+}
+
+// normalizeLanguage turns a Telegram language code like "en-US" into a catalog name
+func normalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// T translates a key using the global localizer
+func T(lang, key string, args ...interface{}) string {
+	if I18n == nil {
+		return key
+	}
+	return I18n.T(lang, key, args...)
+}
+
+// N translates a plural key using the global localizer
+func N(lang, key string, n int, args ...interface{}) string {
+	if I18n == nil {
+		return key
+	}
+	return I18n.N(lang, key, n, args...)
+}
+
+// userLanguage picks the language for a user: the /language override first,
+// then the Telegram client language, then the configured default
+func userLanguage(ctx context.Context, db Repository, user *tgbotapi.User) string {
+	if user == nil {
+		return defaultLanguage()
+	}
+	if lang, ok := storedLanguage(ctx, db, user.ID); ok {
+		return lang
+	}
+	if I18n != nil && user.LanguageCode != "" && I18n.Supports(user.LanguageCode) {
+		return normalizeLanguage(user.LanguageCode)
+	}
+	return defaultLanguage()
+}
+
+// languageForUserID picks the language for a user we only know by ID,
+// e.g. when sending waitlist notifications
+func languageForUserID(ctx context.Context, db Repository, telegramID int) string {
+	if lang, ok := storedLanguage(ctx, db, telegramID); ok {
+		return lang
+	}
+	return defaultLanguage()
+}
+
+// storedLanguage returns the language the user chose with /language, if any
+func storedLanguage(ctx context.Context, db Repository, telegramID int) (string, bool) {
+	if I18n == nil || telegramID == 0 {
+		return "", false
+	}
+	lang, err := db.GetUserLanguage(ctx, telegramID)
+	if err != nil || lang == "" || !I18n.Supports(lang) {
+		return "", false
+	}
+	return normalizeLanguage(lang), true
+}
+
+// defaultLanguage returns the configured fallback language
+func defaultLanguage() string {
+	if I18n == nil {
+		return ""
+	}
+	return I18n.DefaultLanguage()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCatalogsHaveAllKeys(t *testing.T) {
+	l, err := LoadLocales("ru")
+	if err != nil {
+		t.Fatalf("LoadLocales: %v", err)
+	}
+	if len(l.catalogs) < 2 {
+		t.Fatalf("expected at least two catalogs, got %v", l.Languages())
+	}
+
+	keys := map[string]bool{}
+	for _, catalog := range l.catalogs {
+		for key := range catalog {
+			keys[key] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, lang := range l.Languages() {
+		catalog := l.catalogs[lang]
+		for _, key := range sorted {
+			msg, ok := catalog[key]
+			if !ok {
+				t.Errorf("catalog %q is missing key %q", lang, key)
+				continue
+			}
+			if !msg.IsPlural() {
+				if msg.Text == "" {
+					t.Errorf("catalog %q has an empty text for key %q", lang, key)
+				}
+				continue
+			}
+			for _, form := range pluralCategories(lang) {
+				if msg.Forms[form] == "" {
+					t.Errorf("catalog %q is missing plural form %q of key %q", lang, form, key)
+				}
+			}
+		}
+	}
+}
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"ru", 1, "one"},
+		{"ru", 21, "one"},
+		{"ru", 11, "many"},
+		{"ru", 2, "few"},
+		{"ru", 24, "few"},
+		{"ru", 12, "many"},
+		{"ru", 5, "many"},
+		{"ru", 0, "many"},
+		{"en", 1, "one"},
+		{"en", 0, "other"},
+		{"en", 2, "other"},
+	}
+	for _, tt := range tests {
+		if got := pluralCategory(tt.lang, tt.n); got != tt.want {
+			t.Errorf("pluralCategory(%q, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizerFallback(t *testing.T) {
+	l, err := LoadLocales("ru")
+	if err != nil {
+		t.Fatalf("LoadLocales: %v", err)
+	}
+	if got, want := l.N("ru", "seats_left", 3), "Осталось 3 места"; got != want {
+		t.Errorf("N(ru) = %q, want %q", got, want)
+	}
+	if got, want := l.N("en-US", "seats_left", 1), "1 seat left"; got != want {
+		t.Errorf("N(en-US) = %q, want %q", got, want)
+	}
+	if got, want := l.T("de", "button_yes"), "Да"; got != want {
+		t.Errorf("T(de) = %q, want default locale text %q", got, want)
+	}
+	if _, err := LoadLocales("xx"); err == nil {
+		t.Error("LoadLocales accepted an unsupported default locale")
+	}
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// importCallbackPrefix starts the callback data of the /import preview buttons
+const importCallbackPrefix = "import:"
+
+const (
+	importMaxFileSize = 1 << 20 // importMaxFileSize limits the size of an imported CSV file
+	importMaxRows     = 5000    // importMaxRows limits the number of rows of an imported CSV file
+	importMaxErrors   = 15      // importMaxErrors is the number of row errors listed in the preview
+)
+
+// Fields a column of an imported file can be mapped to, in the order of importFields
+const (
+	importTelegramID = iota
+	importUsername
+	importName
+	importEmail
+	importRegistrationDate
+	importEvent
+	importEventDate
+	importRegistered
+	importVisited
+)
+
+// importFields are the fields of an imported row. They are the columns of /export,
+// so the export_column_* keys label them and exported files can be imported back.
+var importFields = []string{"telegram_id", "username", "name", "email", "registration_date", "event", "event_date", "registered", "visited"}
+
+// importHeaders maps lower-case column headers of common registration forms to the fields.
+// The localized headers of /export are recognized too, see detectImportMapping.
+var importHeaders = map[string]int{
+	"telegram_id":       importTelegramID,
+	"telegram id":       importTelegramID,
+	"tg id":             importTelegramID,
+	"user id":           importTelegramID,
+	"username":          importUsername,
+	"telegram":          importUsername,
+	"telegram username": importUsername,
+	"ник в telegram":    importUsername,
+	"ник в телеграм":    importUsername,
+	"телеграм":          importUsername,
+	"name":              importName,
+	"full name":         importName,
+	"фио":               importName,
+	"имя":               importName,
+	"имя и фамилия":     importName,
+	"фамилия и имя":     importName,
+	"email":             importEmail,
+	"e-mail":            importEmail,
+	"email address":     importEmail,
+	"почта":             importEmail,
+	"электронная почта": importEmail,
+	"адрес электронной почты": importEmail,
+	"registration_date":       importRegistrationDate,
+	"registration date":       importRegistrationDate,
+	"timestamp":               importRegistrationDate,
+	"отметка времени":         importRegistrationDate,
+	"дата регистрации":        importRegistrationDate,
+	"event":                   importEvent,
+	"event name":              importEvent,
+	"мероприятие":             importEvent,
+	"event_date":              importEventDate,
+	"event date":              importEventDate,
+	"дата мероприятия":        importEventDate,
+	"registered":              importRegistered,
+	"зарегистрирован":         importRegistered,
+	"visited":                 importVisited,
+	"checked in":              importVisited,
+	"attended":                importVisited,
+	"пришел":                  importVisited,
+	"пришёл":                  importVisited,
+}
+
+// importDateLayouts are the accepted date formats, the ones of /export and of Google Forms among them
+var importDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"1/2/2006 15:04:05",
+	"1/2/2006",
+}
+
+// importFlags are the accepted values of the registered and visited columns besides the localized yes and no
+var importFlags = map[string]bool{
+	"1": true, "yes": true, "true": true, "да": true, "+": true,
+	"0": false, "no": false, "false": false, "нет": false, "-": false,
+}
+
+// errImportEmpty is returned for a file without rows below the header
+var errImportEmpty = errors.New("no rows below the header")
+
+// importMapping holds the column of every field in importFields order, -1 for a field without a column
+type importMapping []int
+
+// detectImportMapping maps the columns to the fields by their headers
+func detectImportMapping(header []string) importMapping {
+	known := make(map[string]int, len(importHeaders))
+	for h, field := range importHeaders {
+		known[h] = field
+	}
+	if I18n != nil {
+		for _, lang := range I18n.Languages() {
+			for field, name := range importFields {
+				known[strings.ToLower(T(lang, "export_column_"+name))] = field
+			}
+		}
+	}
+
+	mapping := make(importMapping, len(importFields))
+	for i := range mapping {
+		mapping[i] = -1
+	}
+	for col, h := range header {
+		field, ok := known[strings.ToLower(strings.TrimSpace(h))]
+		if ok && mapping[field] < 0 {
+			mapping[field] = col
+		}
+	}
+	return mapping
+}
+
+// parseImportMapping restores a mapping saved with String for a file with the given number of columns
+func parseImportMapping(s string, columns int) (importMapping, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != len(importFields) {
+		return nil, fmt.Errorf("invalid mapping %q", s)
+	}
+	mapping := make(importMapping, len(parts))
+	for i, part := range parts {
+		col, err := strconv.Atoi(part)
+		if err != nil || col < -1 || col >= columns {
+			return nil, fmt.Errorf("invalid mapping %q", s)
+		}
+		mapping[i] = col
+	}
+	return mapping, nil
+}
+
+// String encodes the mapping for the dialog data
+func (m importMapping) String() string {
+	cols := make([]string, len(m))
+	for i, col := range m {
+		cols[i] = strconv.Itoa(col)
+	}
+	return strings.Join(cols, ",")
+}
+
+// next maps the field to the following column, after the last column the field is unmapped
+func (m importMapping) next(field, columns int) {
+	m[field]++
+	if m[field] >= columns {
+		m[field] = -1
+	}
+}
+
+// value returns the trimmed value of the field in a record, empty for an unmapped field or a short record
+func (m importMapping) value(record []string, field int) string {
+	if col := m[field]; col >= 0 && col < len(record) {
+		return strings.TrimSpace(record[col])
+	}
+	return ""
+}
+
+// parseImportFile reads the records of a CSV file. The delimiter is a comma, a semicolon or a tab,
+// whichever the header has most of, Excel uses a semicolon in many locales.
+func parseImportFile(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		return nil, errors.New("the file is not UTF-8 text")
+	}
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	comma := ','
+	for _, c := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(c))) > bytes.Count(header, []byte(string(comma))) {
+			comma = c
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	var records [][]string
+	for {
+		record, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(records) > importMaxRows {
+			return nil, fmt.Errorf("more than %d rows", importMaxRows)
+		}
+		records = append(records, record)
+	}
+	if len(records) < 2 {
+		return nil, errImportEmpty
+	}
+	return records, nil
+}
+
+// isCSVDocument checks if a document sent to the bot looks like a CSV file
+func isCSVDocument(doc *tgbotapi.Document) bool {
+	switch strings.ToLower(path.Ext(doc.FileName)) {
+	case ".csv", ".tsv", ".txt":
+		return true
+	}
+	return doc.MimeType == "text/csv" || doc.MimeType == "text/plain"
+}
+
+// parseImportDate parses a date in one of importDateLayouts, dates without a zone are local
+func parseImportDate(s string) (time.Time, bool) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseImportFlag parses the value of the registered or visited column, an empty value is def
+func parseImportFlag(s string, def int) (int, bool) {
+	if s == "" {
+		return def, true
+	}
+	s = strings.ToLower(s)
+	flag, ok := importFlags[s]
+	if !ok && I18n != nil {
+		for _, lang := range I18n.Languages() {
+			switch s {
+			case strings.ToLower(T(lang, "export_yes")):
+				flag, ok = true, true
+			case strings.ToLower(T(lang, "export_no")):
+				flag, ok = false, true
+			}
+		}
+	}
+	if flag {
+		return 1, ok
+	}
+	return 0, ok
+}
+
+// parseImportUsername extracts the username from "@name", "name" or a t.me link
+func parseImportUsername(s string) string {
+	for _, prefix := range []string{"https://t.me/", "http://t.me/", "t.me/", "@"} {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			s = s[len(prefix):]
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// countRegistered returns the number of registered rows, the ones that take a seat
+func countRegistered(regs []UserRegistration) int {
+	n := 0
+	for _, reg := range regs {
+		if reg.Registred == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// sendImportTickets sends the tickets of the registrations the import added to the current event.
+// Only rows with a Telegram ID can get one, the others get it on /ticket once /start links them.
+// Returns the number of tickets sent.
+func sendImportTickets(ctx context.Context, bot Sender, db Repository, plan *importPlan, target *Event) int {
+	if target == nil || target.state != "active" {
+		return 0
+	}
+	sent := 0
+	for _, imp := range plan.imports {
+		if imp.EventID != target.id {
+			continue
+		}
+		for _, reg := range imp.Registrations {
+			if reg.TelegramID != 0 && reg.Registred == 1 {
+				sendTicket(bot, int64(reg.TelegramID), languageForUserID(ctx, db, reg.TelegramID), target, reg.TelegramID)
+				sent++
+			}
+		}
+	}
+	return sent
+}
+
+// linkImportedRegistrations gives the user the imported registrations of their username.
+// Rows of users unknown at the time of the import have no Telegram ID, /start links the ones without an email.
+// The claim is audited, since it trusts whoever holds the username now.
+func linkImportedRegistrations(ctx context.Context, db Repository, user *tgbotapi.User) {
+	n, err := db.ClaimImportedRegistrations(ctx, user.ID, user.UserName)
+	if err != nil {
+		slog.Error("Failed to link imported registrations", "user_id", user.ID, "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Linked imported registrations", "user_id", user.ID, "username", user.UserName, "registrations", n)
+		auditSelf(ctx, db, AuditImportClaim, user, 0, strconv.Itoa(n))
+	}
+}
+
+// importRowError is a problem that keeps a row out of the import
+type importRowError struct {
+	row  int           // row is the line of the record in the file, the header is row 1
+	key  string        // key is the catalog key of the description
+	args []interface{} // args are the arguments of the description
+}
+
+// importGroup collects the valid rows of one event during planning
+type importGroup struct {
+	RegistrationImport
+	label string         // label names the event in errors
+	free  int            // free is the number of seats left, -1 for an event created by the import
+	seen  map[string]int // seen maps the identities of the added rows to their line
+}
+
+// importPlan is the dry run of an import: the rows that would be added and the ones skipped with errors
+type importPlan struct {
+	rows    int                  // rows is the number of non-empty rows in the file
+	imports []RegistrationImport // imports are the valid rows grouped by event
+	errors  []importRowError     // errors are the skipped rows in file order
+}
+
+// registrations returns the number of rows the import adds
+func (p *importPlan) registrations() int {
+	n := 0
+	for _, imp := range p.imports {
+		n += len(imp.Registrations)
+	}
+	return n
+}
+
+// newEvents returns the number of past events the import creates
+func (p *importPlan) newEvents() int {
+	n := 0
+	for _, imp := range p.imports {
+		if imp.EventID == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// planImport validates the records against the database without changing it.
+// Rows without event columns go to the target event. Rows of an event the bot doesn't know
+// create it as a past event, an unknown upcoming event is an error: it has to be created with /addevent.
+func planImport(ctx context.Context, db Repository, records [][]string, mapping importMapping, target *Event, now time.Time) (*importPlan, error) {
+	events, err := db.GetEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := db.GetAllRegistrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[int]map[string]bool)
+	for _, reg := range existing {
+		if taken[reg.EventID] == nil {
+			taken[reg.EventID] = make(map[string]bool)
+		}
+		for _, key := range importIdentities(reg.UserRegistration) {
+			taken[reg.EventID][key] = true
+		}
+	}
+
+	plan := &importPlan{}
+	groups := make(map[string]*importGroup)
+	var order []*importGroup
+	group := func(key string, ev *Event, name string, date time.Time) *importGroup {
+		if g, ok := groups[key]; ok {
+			return g
+		}
+		g := &importGroup{free: -1, seen: make(map[string]int)}
+		if ev != nil {
+			g.EventID, g.label, g.free = ev.id, importEventLabel(ev.name, ev.date), max(ev.capacity-ev.registrationCount, 0)
+		} else {
+			g.EventName, g.EventDate, g.label = name, date, importEventLabel(name, date)
+		}
+		groups[key] = g
+		order = append(order, g)
+		return g
+	}
+
+	for i, record := range records[1:] {
+		line := i + 2
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		plan.rows++
+		fail := func(key string, args ...interface{}) {
+			plan.errors = append(plan.errors, importRowError{row: line, key: key, args: args})
+		}
+		value := func(field int) string {
+			return mapping.value(record, field)
+		}
+
+		reg := UserRegistration{
+			Username:         parseImportUsername(value(importUsername)),
+			Name:             strings.Join(strings.Fields(value(importName)), " "),
+			Email:            value(importEmail),
+			RegistrationDate: now,
+		}
+		if v := value(importTelegramID); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				fail("import_error_telegram_id", v)
+				continue
+			}
+			reg.TelegramID = id
+		}
+		if reg.TelegramID == 0 && reg.Username == "" {
+			fail("import_error_no_user")
+			continue
+		}
+		if reg.TelegramID == 0 {
+			// Users who haven't talked to the bot yet are linked by their username on /start
+			if reg.TelegramID, err = db.FindTelegramIDByUsername(ctx, reg.Username); err != nil {
+				return nil, err
+			}
+		}
+		if reg.Email != "" && !ValidateEmail(reg.Email) {
+			fail("import_error_email", reg.Email)
+			continue
+		}
+		if v := value(importRegistrationDate); v != "" {
+			date, ok := parseImportDate(v)
+			if !ok {
+				fail("import_error_registration_date", v)
+				continue
+			}
+			reg.RegistrationDate = date
+		}
+		var ok bool
+		if reg.Registred, ok = parseImportFlag(value(importRegistered), 1); !ok {
+			fail("import_error_registered", value(importRegistered))
+			continue
+		}
+		if reg.Visited, ok = parseImportFlag(value(importVisited), 0); !ok {
+			fail("import_error_visited", value(importVisited))
+			continue
+		}
+
+		var g *importGroup
+		name, dateValue := strings.Join(strings.Fields(value(importEvent)), " "), value(importEventDate)
+		switch {
+		case name == "" && dateValue == "":
+			if target == nil {
+				fail("import_error_no_event")
+				continue
+			}
+			g = group("id:"+strconv.Itoa(target.id), target, "", time.Time{})
+		case dateValue == "":
+			fail("import_error_no_event_date", name)
+			continue
+		default:
+			date, ok := parseImportDate(dateValue)
+			if !ok {
+				fail("import_error_event_date", dateValue)
+				continue
+			}
+			day := date.Format("2006-01-02")
+			var ev *Event
+			for i := range events {
+				if events[i].date.Format("2006-01-02") == day && (name == "" || strings.EqualFold(events[i].name, name)) {
+					ev = &events[i]
+					break
+				}
+			}
+			switch {
+			case ev != nil:
+				g = group("id:"+strconv.Itoa(ev.id), ev, "", time.Time{})
+			case !date.Before(now):
+				fail("import_error_event_unknown", name, date.Format("02.01.2006"))
+				continue
+			case name == "":
+				fail("import_error_event_name", date.Format("02.01.2006"))
+				continue
+			default:
+				g = group("new:"+strings.ToLower(name)+"|"+day, nil, name, date)
+			}
+		}
+
+		identities := importIdentities(reg)
+		duplicate := false
+		for _, key := range identities {
+			if taken[g.EventID][key] {
+				fail("import_error_registered_already", g.label)
+				duplicate = true
+				break
+			}
+			if first, ok := g.seen[key]; ok {
+				fail("import_error_duplicate", first)
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		if reg.Registred == 1 && g.free >= 0 {
+			if g.free == 0 {
+				fail("import_error_capacity", g.label)
+				continue
+			}
+			g.free--
+		}
+		for _, key := range identities {
+			g.seen[key] = line
+		}
+		g.Registrations = append(g.Registrations, reg)
+	}
+
+	for _, g := range order {
+		if len(g.Registrations) > 0 {
+			plan.imports = append(plan.imports, g.RegistrationImport)
+		}
+	}
+	return plan, nil
+}
+
+// importEventLabel names an event in the preview
+func importEventLabel(name string, date time.Time) string {
+	return name + " (" + date.Format("02.01.2006") + ")"
+}
+
+// importIdentities returns the keys that identify the user of a registration for duplicate checks
+func importIdentities(reg UserRegistration) []string {
+	var keys []string
+	if reg.TelegramID != 0 {
+		keys = append(keys, "id:"+strconv.Itoa(reg.TelegramID))
+	}
+	if reg.Username != "" {
+		keys = append(keys, "username:"+strings.ToLower(reg.Username))
+	}
+	if reg.Email != "" {
+		keys = append(keys, "email:"+strings.ToLower(reg.Email))
+	}
+	return keys
+}
+
+// importSession is an /import between the upload and the commit, it lives in the dialog data of the admin
+type importSession struct {
+	fileName string
+	records  [][]string
+	mapping  importMapping
+	eventID  int // eventID is the target event, 0 if there is none
+}
+
+// loadImportSession restores the import of a user from the dialog data, nil if the user isn't reviewing one
+func loadImportSession(telegramID int) (*importSession, error) {
+	state, eventID := DialogMgr.GetState(telegramID)
+	if state != ReviewingImport {
+		return nil, nil
+	}
+	records, err := parseImportFile([]byte(DialogMgr.GetUserData(telegramID, "import_csv")))
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := parseImportMapping(DialogMgr.GetUserData(telegramID, "import_mapping"), len(records[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &importSession{
+		fileName: DialogMgr.GetUserData(telegramID, "import_file"),
+		records:  records,
+		mapping:  mapping,
+		eventID:  eventID,
+	}, nil
+}
+
+// plan runs the dry run of the session against the current state of the database.
+// Returns the target event too, nil if it doesn't exist anymore.
+func (s *importSession) plan(ctx context.Context, db Repository) (*importPlan, *Event, error) {
+	var target *Event
+	if s.eventID != 0 {
+		events, err := db.GetEvents(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range events {
+			if events[i].id == s.eventID {
+				target = &events[i]
+			}
+		}
+	}
+	plan, err := planImport(ctx, db, s.records, s.mapping, target, time.Now())
+	return plan, target, err
+}
+
+// importPreview returns the dry run report and the buttons to change the mapping, commit or cancel
+func importPreview(lang string, s *importSession, target *Event, plan *importPlan) (string, tgbotapi.InlineKeyboardMarkup) {
+	targetLabel := T(lang, "import_target_none")
+	if target != nil {
+		targetLabel = importEventLabel(target.name, target.date)
+	}
+	lines := []string{
+		T(lang, "import_preview", s.fileName, plan.rows),
+		T(lang, "import_target", targetLabel),
+		T(lang, "import_ready", plan.registrations()),
+	}
+	if n := plan.newEvents(); n > 0 {
+		lines = append(lines, N(lang, "import_new_events", n))
+	}
+	if len(plan.errors) > 0 {
+		lines = append(lines, "", N(lang, "import_errors_header", len(plan.errors)))
+		for i, e := range plan.errors {
+			if i == importMaxErrors {
+				lines = append(lines, T(lang, "import_more_errors", len(plan.errors)-importMaxErrors))
+				break
+			}
+			lines = append(lines, T(lang, "import_row_error", e.row, T(lang, e.key, e.args...)))
+		}
+	}
+	lines = append(lines, "", T(lang, "import_dry_run"))
+
+	header := s.records[0]
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for field, name := range importFields {
+		column := T(lang, "import_column_none")
+		if col := s.mapping[field]; col >= 0 {
+			column = header[col]
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(T(lang, "export_column_"+name)+": "+column, importCallbackPrefix+"map:"+strconv.Itoa(field))
+		if field%2 == 0 {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+	var actions []tgbotapi.InlineKeyboardButton
+	if n := plan.registrations(); n > 0 {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(T(lang, "import_button_commit", n), importCallbackPrefix+"commit"))
+	}
+	actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(T(lang, "import_button_cancel"), importCallbackPrefix+"cancel"))
+	rows = append(rows, actions)
+	return strings.Join(lines, "\n"), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestParseImportFile(t *testing.T) {
+	records, err := parseImportFile([]byte("\xEF\xBB\xBFName;Email\n\"Ivanov; Ivan\";ivan@example.com\n"))
+	if err != nil || !reflect.DeepEqual(records, [][]string{{"Name", "Email"}, {"Ivanov; Ivan", "ivan@example.com"}}) {
+		t.Errorf("semicolons = %q, %v", records, err)
+	}
+	if records, err := parseImportFile([]byte("Name\tEmail\nIvanov Ivan\n")); err != nil || len(records) != 2 || len(records[1]) != 1 {
+		t.Errorf("tabs with a short row = %q, %v", records, err)
+	}
+	if _, err := parseImportFile([]byte("Name,Email\n")); err != errImportEmpty {
+		t.Errorf("header only = %v", err)
+	}
+	if _, err := parseImportFile([]byte("Name\n\xC8\xE2\xE0\xED\n")); err == nil {
+		t.Error("windows-1251 file accepted")
+	}
+}
+
+func TestDetectImportMapping(t *testing.T) {
+	setupHandlers(t)
+	mapping := detectImportMapping([]string{"Отметка времени", "ФИО", " Электронная почта ", "Ник в Telegram", "Откуда узнали?", "Email"})
+	want := importMapping{-1, 3, 1, 2, 0, -1, -1, -1, -1}
+	if !reflect.DeepEqual(mapping, want) {
+		t.Errorf("Google Forms mapping = %v, want %v", mapping, want)
+	}
+
+	// A file of /export in any language is imported back as is
+	mapping = detectImportMapping(exportTable("ru", nil)[0])
+	if want := (importMapping{0, 1, 2, 3, 4, 5, 6, 7, 8}); !reflect.DeepEqual(mapping, want) {
+		t.Errorf("mapping of an export = %v, want %v", mapping, want)
+	}
+
+	restored, err := parseImportMapping(mapping.String(), 9)
+	if err != nil || !reflect.DeepEqual(restored, mapping) {
+		t.Errorf("parseImportMapping(%q) = %v, %v", mapping.String(), restored, err)
+	}
+	if _, err := parseImportMapping(mapping.String(), 5); err == nil {
+		t.Error("mapping to a missing column accepted")
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	setupHandlers(t)
+	ctx := context.Background()
+	now := time.Now()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Spring", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 50))
+	mustNoError(t, db.MarkEventsAsPast(ctx))
+	mustNoError(t, db.AddEvent(ctx, "Summer", now.AddDate(0, 0, 7), 3))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", EventID: 2, Registred: 1}))
+	mustNoError(t, db.UpdateEventRegistrationCount(ctx, 2))
+	target := mustEvent(t, db)
+
+	future := now.AddDate(0, 1, 0).Format("02.01.2006")
+	records := [][]string{
+		{"Telegram ID", "Username", "Full name", "Email", "Event", "Event date", "Visited"},
+		{"", "@anna", "Petrova  Anna", "anna@example.com", "", "", ""},
+		{"", "ivan", "Ivanov Ivan", "", "", "", ""},
+		{"", "oleg", "Olegov Oleg", "oleg@", "", "", ""},
+		{"", "ANNA", "", "", "", "", ""},
+		{"7", "", "Seven", "", "", "", ""},
+		{"8", "", "Eight", "", "", "", ""},
+		{"", "", "No One", "", "", "", ""},
+		{"x1", "", "", "", "", "", ""},
+		{"", "petr", "", "", "Spring", "01.03.2024", "yes"},
+		{"", "petr", "", "", "Autumn", "2023-10-05", ""},
+		{"", "petr", "", "", "Future", future, ""},
+		{"", "maria", "", "", "", "2023-10-05", ""},
+		{"", "petr", "", "", "autumn", "05.10.2023", ""},
+		{"", "olga", "", "", "", "", "maybe"},
+		{"", "", "", "", "", "", ""},
+	}
+	plan, err := planImport(ctx, db, records, detectImportMapping(records[0]), target, now)
+	mustNoError(t, err)
+
+	var errs []string
+	for _, e := range plan.errors {
+		errs = append(errs, T("en", "import_row_error", e.row, T("en", e.key, e.args...)))
+	}
+	want := []string{
+		"Row 3: already registered for Summer (" + target.date.Format("02.01.2006") + ")",
+		`Row 4: invalid email "oleg@"`,
+		"Row 5: duplicate of row 2",
+		"Row 7: over the capacity of Summer (" + target.date.Format("02.01.2006") + ")",
+		"Row 8: no Telegram ID or username",
+		`Row 9: invalid Telegram ID "x1"`,
+		`Row 12: upcoming event "Future" on ` + future + " is not in the bot, create it with /addevent first",
+		"Row 13: the event on 05.10.2023 is not in the bot, a name is needed to create it",
+		"Row 14: duplicate of row 11",
+		`Row 15: invalid visited value "maybe"`,
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(errs, "\n"), strings.Join(want, "\n"))
+	}
+	if plan.rows != 14 || plan.registrations() != 4 || plan.newEvents() != 1 || len(plan.imports) != 3 {
+		t.Fatalf("plan = %d rows, %+v", plan.rows, plan.imports)
+	}
+	if anna := plan.imports[0].Registrations[0]; plan.imports[0].EventID != target.id || anna.TelegramID != 0 || anna.Username != "anna" || anna.Name != "Petrova Anna" || anna.Registred != 1 {
+		t.Errorf("row of anna = %+v", plan.imports[0])
+	}
+	if spring := plan.imports[1]; spring.EventID != 1 || spring.Registrations[0].Visited != 1 {
+		t.Errorf("rows of Spring = %+v", spring)
+	}
+	if autumn := plan.imports[2]; autumn.EventID != 0 || autumn.EventName != "Autumn" || autumn.EventDate.Format("2006-01-02") != "2023-10-05" {
+		t.Errorf("new event = %+v", autumn)
+	}
+}
+
+// documentUpdate builds the update of a user sending a file
+func documentUpdate(id, user int, fileID, name string) tgbotapi.Update {
+	update := step{user: user, text: "-"}.update(id)
+	update.Message.Text = ""
+	update.Message.Document = &tgbotapi.Document{FileID: fileID, FileName: name}
+	return update
+}
+
+// lastEdit returns the last message edit sent
+func lastEdit(t *testing.T, sender *fakeSender) tgbotapi.EditMessageTextConfig {
+	t.Helper()
+	for i := len(sender.other) - 1; i >= 0; i-- {
+		if edit, ok := sender.other[i].(tgbotapi.EditMessageTextConfig); ok {
+			return edit
+		}
+	}
+	t.Fatalf("no message edited, sent %+v", sender.other)
+	return tgbotapi.EditMessageTextConfig{}
+}
+
+func TestSendImportTickets(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	event := mustEvent(t, db)
+	plan := &importPlan{imports: []RegistrationImport{
+		{EventID: event.id, Registrations: []UserRegistration{
+			{TelegramID: 2, Username: "anna", Registred: 1},
+			{Username: "oleg", Registred: 1},
+			{TelegramID: 4, Username: "olga", Registred: 0},
+		}},
+		{EventName: "Past", EventDate: time.Now().AddDate(0, -1, 0), Registrations: []UserRegistration{{TelegramID: 5, Registred: 1}}},
+	}}
+
+	// Only registered rows of the current event with a Telegram ID get a ticket
+	if n := sendImportTickets(ctx, sender, db, plan, event); n != 1 || len(sender.other) != 1 {
+		t.Fatalf("tickets = %d, sent %+v", n, sender.other)
+	}
+	if photo, ok := sender.other[0].(tgbotapi.PhotoConfig); !ok || photo.ChatID != 2 {
+		t.Errorf("ticket = %+v", sender.other[0])
+	}
+
+	mustNoError(t, db.MarkEventsAsPast(ctx))
+	events, _ := db.GetEvents(ctx)
+	if n := sendImportTickets(ctx, sender, db, plan, &events[0]); n != 0 {
+		t.Errorf("tickets for a past event = %d", n)
+	}
+	if n := sendImportTickets(ctx, sender, db, plan, nil); n != 0 {
+		t.Errorf("tickets without a target event = %d", n)
+	}
+}
+
+func TestImport(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	event := mustEvent(t, db)
+	sender.serveFile(t, "csv", []byte("Username;Full name;Email\n@anna;Petrova Anna;\nivan;Ivanov Ivan;ivan@example.com\n"))
+	sender.serveFile(t, "bad", []byte("Username\n"))
+
+	start := func(id int) {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/import"}.update(id))
+		Commands.HandleUpdate(ctx, sender, db, documentUpdate(id+1, 3, "csv", "registrations.csv"))
+		if len(sender.messages) != 2 || sender.messages[1].ReplyMarkup == nil {
+			t.Fatalf("messages = %q", sender.texts())
+		}
+	}
+	press := func(id, user int, data string) {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, data: data}.update(id))
+	}
+
+	t.Run("Upload", func(t *testing.T) {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/import"}.update(1))
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "hello"}.update(2))
+		Commands.HandleUpdate(ctx, sender, db, documentUpdate(3, 3, "bad", "photo.jpg"))
+		Commands.HandleUpdate(ctx, sender, db, documentUpdate(4, 3, "bad", "empty.csv"))
+		want := []string{
+			T("en", "import_send_file", "Meetup ("+event.date.Format("02.01.2006")+")"),
+			T("en", "import_waiting_file"),
+			T("en", "import_not_csv"),
+			T("en", "import_invalid_file", errImportEmpty.Error()),
+		}
+		if texts := sender.texts(); !reflect.DeepEqual(texts, want) {
+			t.Errorf("texts = %q, want %q", texts, want)
+		}
+
+		// A command ends the import without touching registrations
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/help"}.update(5))
+		if state, _ := DialogMgr.GetState(3); state != NoDialog || strings.Contains(strings.Join(sender.texts(), "\n"), T("en", "dialog_cancelled")) {
+			t.Errorf("after a command state = %v, texts = %q", state, sender.texts())
+		}
+	})
+
+	t.Run("Mapping", func(t *testing.T) {
+		start(10)
+		preview := sender.messages[1]
+		keyboard := preview.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+		if !strings.Contains(preview.Text, T("en", "import_ready", 2)) || keyboard.InlineKeyboard[1][1].Text != "Email: Email" ||
+			*keyboard.InlineKeyboard[1][1].CallbackData != "import:map:3" || keyboard.InlineKeyboard[5][0].Text != T("en", "import_button_commit", 2) {
+			t.Fatalf("preview = %q, %+v", preview.Text, keyboard.InlineKeyboard)
+		}
+
+		// Email is the last column, the next press leaves it unmapped and then takes the first column
+		press(12, 3, "import:map:3")
+		if edit := lastEdit(t, sender); edit.ReplyMarkup.InlineKeyboard[1][1].Text != "Email: —" {
+			t.Errorf("after the first press = %+v", edit.ReplyMarkup.InlineKeyboard[1])
+		}
+		press(13, 3, "import:map:3")
+		edit := lastEdit(t, sender)
+		if !strings.Contains(edit.Text, `Row 2: invalid email "@anna"`) || !strings.Contains(edit.Text, T("en", "import_ready", 0)) || len(edit.ReplyMarkup.InlineKeyboard[5]) != 1 {
+			t.Errorf("usernames as emails = %q, %+v", edit.Text, edit.ReplyMarkup.InlineKeyboard[5])
+		}
+		press(14, 3, "import:map:3")
+		press(15, 3, "import:map:3")
+		if edit := lastEdit(t, sender); !strings.Contains(edit.Text, T("en", "import_ready", 2)) {
+			t.Errorf("after a full cycle = %q", edit.Text)
+		}
+
+		press(16, 3, "import:cancel")
+		if edit := lastEdit(t, sender); edit.Text != T("en", "import_cancelled") || edit.ReplyMarkup != nil {
+			t.Errorf("cancel = %+v", edit)
+		}
+		press(17, 3, "import:commit")
+		if len(sender.answers) != 1 || sender.answers[0].Text != T("en", "import_expired") {
+			t.Errorf("commit after cancel answered %+v", sender.answers)
+		}
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		start(20)
+
+		// Only admins with the events permission can commit
+		press(22, 1, "import:commit")
+		if len(sender.other) != 0 || sender.answers[0].Text != T("en", "permission_denied") {
+			t.Errorf("commit by a user without a role: answers %+v, sent %+v", sender.answers, sender.other)
+		}
+
+		press(23, 3, "import:commit")
+		if edit := lastEdit(t, sender); edit.Text != N("en", "import_done", 2) {
+			t.Errorf("commit = %q", edit.Text)
+		}
+		if event := mustEvent(t, db); event.registrationCount != 2 {
+			t.Errorf("registrationCount = %d, want 2", event.registrationCount)
+		}
+		entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditImport)})
+		if len(entries) != 1 || entries[0].ActorID != 3 || entries[0].EventID != event.id || entries[0].Details != "registrations.csv;2;0;0" {
+			t.Errorf("audit log = %+v", entries)
+		}
+
+		// The same file again only has duplicates
+		start(24)
+		if !strings.Contains(sender.messages[1].Text, T("en", "import_ready", 0)) {
+			t.Errorf("second import = %q", sender.messages[1].Text)
+		}
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/help"}.update(26))
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		// anna had never talked to the bot, the imported registration waits for the /start
+		if registered, _, _ := db.IsUserRegistered(ctx, 2, event.id); registered {
+			t.Fatal("anna is registered before /start")
+		}
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 2, text: "/start"}.update(30))
+		registered, reg, _ := db.IsUserRegistered(ctx, 2, event.id)
+		if !registered || reg.Name != "Petrova Anna" {
+			t.Fatalf("anna after /start = %v, %+v", registered, reg)
+		}
+		if texts := sender.texts(); len(texts) != 2 || sender.messages[1].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup).InlineKeyboard[0][0].CallbackData == nil ||
+			*sender.messages[1].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup).InlineKeyboard[0][0].CallbackData != "remove" {
+			t.Errorf("/start of anna = %q, want the cancel button", texts)
+		}
+		if entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditImportClaim)}); len(entries) != 1 || entries[0].ActorID != 2 || entries[0].Details != "1" {
+			t.Errorf("audit log = %+v", entries)
+		}
+
+		// The row of ivan has an email, it's not linked to whoever has the username
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "/start"}.update(31))
+		if registered, _, _ := db.IsUserRegistered(ctx, 1, event.id); registered {
+			t.Error("row with an email linked on /start")
+		}
+	})
+}
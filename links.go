@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxStartParamLength is the longest /start parameter Telegram passes to the bot
+const maxStartParamLength = 64
+
+const (
+	invitePrefix   = "event_" // invitePrefix starts the /start parameter of the event invites
+	referralPrefix = "ref_"   // referralPrefix starts the /start parameter of the referral links
+)
+
+var (
+	errStartParamInvalid = errors.New("invalid /start parameter")
+	errNoBotUsername     = errors.New("the bot has no username")
+)
+
+// PayloadKind is the kind of a /start deep link
+type PayloadKind int
+
+const (
+	PayloadNone     PayloadKind = iota // Plain /start, or a parameter the bot doesn't know
+	PayloadCheckin                     // Check-in QR code at the entrance, "imhere_<event>_<period>_<signature>"
+	PayloadTicket                      // Ticket of an attendee, "ticket_<event>_<user>_<signature>"
+	PayloadInvite                      // Invite to an event, "event_<event>"
+	PayloadReferral                    // Link shared by a user, "ref_<user>"
+)
+
+// StartPayload is the parameter of a /start deep link
+type StartPayload struct {
+	Kind    PayloadKind
+	EventID int    // EventID is the event of an invite
+	UserID  int    // UserID is the user who shared a referral link
+	Code    string // Code is the signed code of a check-in or a ticket, checked by their handlers
+}
+
+// CheckinPayload returns the payload of a check-in code, see checkinCode
+func CheckinPayload(code string) StartPayload {
+	return StartPayload{Kind: PayloadCheckin, Code: code}
+}
+
+// TicketPayload returns the payload of a ticket, see ticketToken
+func TicketPayload(token string) StartPayload {
+	return StartPayload{Kind: PayloadTicket, Code: token}
+}
+
+// InvitePayload returns the payload of an invite to an event
+func InvitePayload(eventID int) StartPayload {
+	return StartPayload{Kind: PayloadInvite, EventID: eventID}
+}
+
+// ReferralPayload returns the payload of the link shared by a user
+func ReferralPayload(telegramID int) StartPayload {
+	return StartPayload{Kind: PayloadReferral, UserID: telegramID}
+}
+
+// param encodes the payload as a /start parameter
+func (p StartPayload) param() (string, error) {
+	var param string
+	switch p.Kind {
+	case PayloadCheckin, PayloadTicket:
+		param = p.Code
+	case PayloadInvite:
+		param = invitePrefix + strconv.Itoa(p.EventID)
+	case PayloadReferral:
+		param = referralPrefix + strconv.Itoa(p.UserID)
+	default:
+		return "", errStartParamInvalid
+	}
+	if !validStartParam(param) {
+		return "", fmt.Errorf("%w: %q", errStartParamInvalid, param)
+	}
+	return param, nil
+}
+
+// validStartParam reports whether Telegram passes a /start parameter as is:
+// 1 to 64 characters out of A-Z, a-z, 0-9, _ and -
+func validStartParam(param string) bool {
+	if param == "" || len(param) > maxStartParamLength {
+		return false
+	}
+	for _, r := range param {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseStartPayload parses the parameter of /start. The codes of check-ins and tickets are
+// only recognized by their prefix, their signatures are checked by the handlers.
+// The static "imhere" of the old check-in QR codes is a check-in code, rejected as invalid.
+func parseStartPayload(param string) StartPayload {
+	param = strings.TrimSpace(param)
+	if strings.EqualFold(param, "imhere") {
+		return CheckinPayload(param)
+	}
+	if !validStartParam(param) {
+		return StartPayload{}
+	}
+	switch {
+	case strings.HasPrefix(param, checkinPrefix):
+		return CheckinPayload(param)
+	case strings.HasPrefix(param, ticketPrefix):
+		return TicketPayload(param)
+	case strings.HasPrefix(param, invitePrefix):
+		if id, err := strconv.Atoi(strings.TrimPrefix(param, invitePrefix)); err == nil && id > 0 {
+			return InvitePayload(id)
+		}
+	case strings.HasPrefix(param, referralPrefix):
+		if id, err := strconv.Atoi(strings.TrimPrefix(param, referralPrefix)); err == nil && id > 0 {
+			return ReferralPayload(id)
+		}
+	}
+	return StartPayload{}
+}
+
+// LinkBuilder builds the t.me deep links of the bot
+type LinkBuilder struct {
+	botUsername string
+}
+
+// NewLinkBuilder creates the links of the bot with this username, as in bot.Self.UserName
+func NewLinkBuilder(botUsername string) *LinkBuilder {
+	return &LinkBuilder{botUsername: strings.TrimPrefix(botUsername, "@")}
+}
+
+// Start returns the link that opens the chat with the bot and sends /start with the payload
+func (l *LinkBuilder) Start(p StartPayload) (string, error) {
+	if l.botUsername == "" {
+		return "", errNoBotUsername
+	}
+	param, err := p.param()
+	if err != nil {
+		return "", err
+	}
+	return "https://t.me/" + l.botUsername + "?start=" + param, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartPayload(t *testing.T) {
+	AppConfig = &Config{BotToken: "123:abc"}
+	links := NewLinkBuilder("@meetup_bot")
+	for _, payload := range []StartPayload{
+		CheckinPayload(checkinCode(2147483647, time.Now())),
+		TicketPayload(ticketToken(2147483647, 9007199254)),
+		InvitePayload(7),
+		ReferralPayload(123456789),
+	} {
+		link, err := links.Start(payload)
+		if err != nil {
+			t.Errorf("Start(%+v) = %v", payload, err)
+			continue
+		}
+		param, ok := strings.CutPrefix(link, "https://t.me/meetup_bot?start=")
+		if !ok || !validStartParam(param) {
+			t.Errorf("Start(%+v) = %q", payload, link)
+		}
+		if parsed := parseStartPayload(param); parsed != payload {
+			t.Errorf("parseStartPayload(%q) = %+v, want %+v", param, parsed, payload)
+		}
+	}
+
+	for param, want := range map[string]StartPayload{
+		"":                                  {},
+		"imhere":                            CheckinPayload("imhere"),
+		"event_":                            {},
+		"event_x":                           {},
+		"ref_-5":                            {},
+		"hello":                             {},
+		"ref_1 2":                           {},
+		"ticket_" + strings.Repeat("a", 58): {},
+	} {
+		if got := parseStartPayload(param); got != want {
+			t.Errorf("parseStartPayload(%q) = %+v, want %+v", param, got, want)
+		}
+	}
+
+	if _, err := links.Start(TicketPayload("ticket_1_2_a/b")); !errors.Is(err, errStartParamInvalid) {
+		t.Errorf("parameter with a slash: %v", err)
+	}
+	if _, err := links.Start(CheckinPayload(strings.Repeat("a", 65))); !errors.Is(err, errStartParamInvalid) {
+		t.Errorf("parameter of 65 characters: %v", err)
+	}
+	if _, err := links.Start(StartPayload{}); !errors.Is(err, errStartParamInvalid) {
+		t.Errorf("empty payload: %v", err)
+	}
+	if _, err := NewLinkBuilder("").Start(InvitePayload(1)); !errors.Is(err, errNoBotUsername) {
+		t.Errorf("bot without a username: %v", err)
+	}
+}
+
+func TestInviteLinks(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "/invite"}.update(1))
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "no_active_event") {
+		t.Errorf("/invite without an event = %q", texts)
+	}
+
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	sender.reset()
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "/invite"}.update(2))
+	want := T("en", "invite_links", "Meetup", "https://t.me/testbot?start=event_1", "https://t.me/testbot?start=ref_1")
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != want {
+		t.Errorf("/invite = %q", texts)
+	}
+
+	start := func(user int, param string) []string {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: "/start " + param}.update(3))
+		return sender.texts()
+	}
+	referrals := func() []AuditEntry {
+		entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditReferral)})
+		return entries
+	}
+
+	// The invite of the current event is a plain /start
+	if texts := start(2, "event_1"); len(texts) == 0 || texts[0] == T("en", "invite_event_over") {
+		t.Errorf("invite = %q", texts)
+	}
+	if texts := start(2, "event_99"); len(texts) < 2 || texts[0] != T("en", "invite_event_over") {
+		t.Errorf("invite of another event = %q", texts)
+	}
+
+	// Only the first link of someone else is recorded
+	start(1, "ref_1")
+	start(2, "ref_1")
+	start(2, "ref_3")
+	entries := referrals()
+	if len(entries) != 1 || entries[0].ActorID != 2 || entries[0].TargetID != 1 || entries[0].EventID != 1 {
+		t.Errorf("referrals = %+v", entries)
+	}
+}
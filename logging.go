@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces sensitive values in the log
+const redacted = "[REDACTED]"
+
+// emailPattern finds email addresses anywhere in a logged string
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// sensitiveLogKeys are attributes whose values are never logged
+var sensitiveLogKeys = map[string]bool{
+	"name":       true,
+	"first_name": true,
+	"last_name":  true,
+	"email":      true,
+	"text":       true,
+}
+
+// NewLogger creates a leveled logger writing JSON or logfmt records to w.
+// Emails, names and the given secrets (e.g. the bot token) are redacted from every record.
+func NewLogger(w io.Writer, format, level string, secrets ...string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	var nonEmpty []string
+	for _, secret := range secrets {
+		if secret != "" {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redactAttr(nonEmpty)}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "logfmt", "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, use json or logfmt", format)
+}
+
+// redactAttr returns a ReplaceAttr function that hides personal data and secrets
+func redactAttr(secrets []string) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if sensitiveLogKeys[a.Key] {
+			return slog.String(a.Key, redacted)
+		}
+		switch a.Value.Kind() {
+		case slog.KindString:
+			return slog.String(a.Key, redactString(a.Value.String(), secrets))
+		case slog.KindAny:
+			// Errors and other values are logged as text, so redact their text
+			if err, ok := a.Value.Any().(error); ok {
+				return slog.String(a.Key, redactString(err.Error(), secrets))
+			}
+		}
+		return a
+	}
+}
+
+// redactString removes email addresses and secrets from s
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+// newCorrelationID returns a random ID that ties together all log records of one update
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
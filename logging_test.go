@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedaction(t *testing.T) {
+	const token = "123456:secret-token"
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "debug", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("Saved email ivan@example.com",
+		"name", "Ivanov Ivan",
+		"email", "ivan@example.com",
+		"error", errors.New("Post https://api.telegram.org/bot"+token+"/sendMessage: timeout"),
+		"user_id", 42,
+	)
+
+	out := buf.String()
+	for _, leak := range []string{"ivan@example.com", "Ivanov Ivan", token} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log output contains %q: %s", leak, out)
+		}
+	}
+	if !strings.Contains(out, `"user_id":42`) {
+		t.Errorf("log output lost the user ID: %s", out)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "logfmt", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("unexpected output for level warn: %s", buf.String())
+	}
+
+	if _, err := NewLogger(&buf, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := NewLogger(&buf, "json", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -1,35 +1,45 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // Global variables
 var (
-	AppConfig *Config        // Application configuration
-	DialogMgr *DialogManager // Dialog state manager
+	AppConfig *Config           // Application configuration
+	DialogMgr *DialogManager    // Dialog state manager
+	QRCodes   *CheckinQRCodes   // Check-in QR code messages kept up to date
+	Links     *LinkBuilder      // Deep links of the bot, set once it is authorized
+	I18n      *Localizer        // Message catalogs
+	Commands  *CommandRegistry  // Command handlers and middlewares
+	Metrics   = NewBotMetrics() // Counters and histograms exposed on /metrics
 )
 
-// IsAdmin checks if a username is in the list of admin users
-func IsAdmin(username string) bool {
-	if AppConfig == nil {
-		return false
-	}
-	for _, admin := range AppConfig.AdminUsers {
-		if admin == username {
-			return true
-		}
+func main() {
+	// Cancelled on SIGINT/SIGTERM, in-flight updates and database calls see the cancellation
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Subcommands work on the database without starting the bot, see cli.go
+	if len(os.Args) > 1 {
+		code := runCLI(ctx, os.Args[1:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
 	}
-	return false
-}
 
-func main() {
 	// Initialize dialog manager
 	DialogMgr = NewDialogManager()
+	QRCodes = NewCheckinQRCodes()
 
 	// Load configuration
 	config, err := LoadConfig()
@@ -38,28 +48,84 @@ func main() {
 	}
 	AppConfig = config
 
-	log.Printf("Admin users: %v", AppConfig.AdminUsers)
-	log.Printf("Mandatory fields: %v", AppConfig.MandatoryFields)
+	// Set up logging, the standard log package and the Telegram library log through it too
+	logger, err := NewLogger(os.Stderr, AppConfig.LogFormat, AppConfig.LogLevel, AppConfig.BotToken, AppConfig.AdminToken, AppConfig.WebhookSecret)
+	if err != nil {
+		log.Fatal("Failed to configure logging: ", err)
+	}
+	slog.SetDefault(logger)
+	tgbotapi.SetLogger(slog.NewLogLogger(logger.Handler(), slog.LevelWarn))
+
+	slog.Info("Configuration loaded",
+		"admin_users", AppConfig.AdminUsers,
+		"mandatory_fields", AppConfig.MandatoryFields,
+		"log_level", AppConfig.LogLevel,
+	)
 
-	bot, err := tgbotapi.NewBotAPI(AppConfig.BotToken)
+	// Load message catalogs
+	I18n, err = LoadLocales(AppConfig.DefaultLocale)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Failed to load locales: ", err)
 	}
-	bot.Debug = true
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	slog.Info("Locales loaded", "languages", I18n.Languages(), "default", I18n.DefaultLanguage())
 
-	db, err := sql.Open("sqlite3", "./bot.db")
+	// Count failed Bot API calls and track polling for /readyz
+	health := NewHealth()
+	var endpoint http.RoundTripper
+	if AppConfig.TelegramAPIURL != "" {
+		base, err := url.Parse(AppConfig.TelegramAPIURL)
+		if err != nil {
+			log.Fatal("Invalid TELEGRAM_API_URL: ", err)
+		}
+		endpoint = apiEndpointTransport(base, nil)
+		slog.Info("Using Bot API server", "url", AppConfig.TelegramAPIURL)
+	}
+	client := &http.Client{Transport: health.Transport(Metrics.Transport(endpoint))}
+	bot, err := tgbotapi.NewBotAPIWithClient(AppConfig.BotToken, client)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	slog.Info("Authorized", "account", bot.Self.UserName, "version", buildVersion())
+	Links = NewLinkBuilder(bot.Self.UserName)
+
+	// Register commands and the middlewares every update goes through
+	Commands = NewCommandRegistry(
+		NewErrorReporter(bot, AppConfig.ErrorChatID, AppConfig.BotToken).Middleware,
+		RecoverMiddleware,
+		LoggingMiddleware,
+		Metrics.Middleware,
+		BannedUserMiddleware,
+		NewRateLimiter(AppConfig.RateLimit, time.Minute).Middleware,
+	)
+	registerCommands(Commands)
+	Commands.PublishCommands(bot)
 
 	// Initialize repository
-	repo := NewSQLiteRepository(db)
+	repo, db, err := OpenRepository(ctx, AppConfig.DatabaseURL)
+	if err != nil {
+		log.Fatal("Failed to open database: ", err)
+	}
+	defer db.Close()
+	if _, err := seedOwners(ctx, repo, nil); err != nil {
+		log.Fatal("Failed to seed the owners from ADMIN_USERS: ", err)
+	}
 
-	if err := repo.CreateTables(); err != nil {
-		log.Fatal(err)
+	// The admin UI is enabled by either way to sign in: the token or Telegram
+	var admin *AdminUI
+	if AppConfig.AdminToken != "" || AppConfig.PublicURL != "" {
+		if AppConfig.HTTPAddr == "" {
+			slog.Warn("ADMIN_TOKEN or PUBLIC_URL is set without HTTP_ADDR, the admin UI is disabled")
+		}
+		admin = NewAdminUI(repo, bot, AppConfig.AdminToken, AppConfig.BotToken, bot.Self.UserName, AppConfig.PublicURL)
+	}
+	if AppConfig.HTTPAddr != "" {
+		go serveHTTP(AppConfig.HTTPAddr, repo, health, admin)
 	}
+	if len(AppConfig.WebhookURLs) > 0 {
+		slog.Info("Delivering webhooks", "urls", len(AppConfig.WebhookURLs))
+		go NewWebhookDispatcher(repo, AppConfig.WebhookSecret, nil).Run(ctx)
+	}
+	go QRCodes.Run(ctx, bot, repo)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
@@ -68,28 +134,14 @@ func main() {
 		log.Fatal(err)
 	}
 
-	for update := range updates {
-		if update.CallbackQuery != nil {
-			handleCallbackQuery(bot, repo, update.CallbackQuery)
-			continue
-		}
-		if update.Message != nil {
-			// Check if user is in a dialog
-			dialogState, eventID := DialogMgr.GetState(update.Message.From.ID)
-
-			if dialogState != NoDialog && !update.Message.IsCommand() {
-				// Handle dialog based on state
-				handleDialog(bot, repo, update.Message, dialogState, eventID)
-			} else if update.Message.IsCommand() {
-				// If user is in a dialog and sends a command, cancel the dialog and remove incomplete registration
-				if dialogState != NoDialog {
-					handleDialogCancel(bot, repo, update.Message, eventID)
-				}
-				handleCommand(bot, repo, update.Message)
-			} else {
-				// No dialog mode: show appropriate button based on registration status
-				handleNoDialog(bot, repo, update.Message)
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+			bot.StopReceivingUpdates()
+			return
+		case update := <-updates:
+			Commands.HandleUpdate(ctx, bot, repo, update)
 		}
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// TestEndToEnd builds the bot and runs it against the fake Bot API server
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the bot binary")
+	}
+	locales, err := LoadLocales("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	I18n = locales
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "meetupbot")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	fake := newFakeBotAPI(t, "123:e2e")
+	databaseURL := "sqlite://" + filepath.Join(dir, "bot.db")
+	var logs bytes.Buffer
+	cmd := exec.Command(binary)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"BOT_TOKEN=123:e2e",
+		"TELEGRAM_API_URL="+fake.URL(),
+		"DATABASE_URL="+databaseURL,
+		"ADMIN_USERS=boss",
+		"DEFAULT_LOCALE=en",
+		"MANDATORY_FIELDS=",
+		"HTTP_ADDR=",
+	)
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer func() {
+		if t.Failed() {
+			t.Logf("bot output:\n%s", logs.String())
+		}
+	}()
+
+	ivan := &tgbotapi.User{ID: 1, UserName: "ivan", FirstName: "Ivan", LastName: "Ivanov", LanguageCode: "en"}
+	boss := &tgbotapi.User{ID: 3, UserName: "boss", FirstName: "Boss", LanguageCode: "en"}
+
+	// The bot publishes its commands on start
+	fake.WaitForCall(t, "setMyCommands", nil)
+
+	fake.PushMessage(boss, "/addevent Meetup;2030-01-01;10")
+	fake.WaitForMessage(t, 3, T("en", "event_added"))
+
+	fake.PushCallback(ivan, "register")
+	answer := fake.WaitForCall(t, "answerCallbackQuery", nil)
+	if answer.Params.Get("text") != T("en", "callback_registered") {
+		t.Errorf("callback answer = %q", answer.Params.Get("text"))
+	}
+	fake.WaitForMessage(t, 1, T("en", "registered_success"))
+	fake.WaitForMessage(t, 1, N("en", "seats_left", 9))
+	ticket := fake.WaitForCall(t, "sendPhoto", nil)
+	if ticket.ChatID() != 1 || ticket.Files["photo"] != "ticket.png" {
+		t.Errorf("sendPhoto = %+v, want the ticket in chat 1", ticket)
+	}
+
+	fake.PushMessage(boss, "/qrcode")
+	photo := fake.WaitForCall(t, "sendPhoto", nil)
+	if photo.ChatID() != 3 || photo.Files["photo"] == "" {
+		t.Errorf("sendPhoto = %+v, want an uploaded photo in chat 3", photo)
+	}
+
+	// The code in the QR code is signed with a key derived from the bot token
+	AppConfig = &Config{BotToken: "123:e2e"}
+	fake.PushMessage(ivan, "/start "+checkinCode(1, time.Now()))
+	fake.WaitForMessage(t, 1, T("en", "visit_updated"))
+
+	// The bot shuts down on SIGTERM
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("bot exited with %v", err)
+		}
+	case <-time.After(fakeBotAPIWait):
+		cmd.Process.Kill()
+		t.Fatal("bot did not exit on SIGTERM")
+	}
+
+	repo, db, err := OpenRepository(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	event := mustEvent(t, repo)
+	if registered, reg, err := repo.IsUserRegistered(context.Background(), 1, event.id); err != nil || !registered || reg.Visited != 1 {
+		t.Errorf("stored registration = %v, %+v, %v", registered, reg, err)
+	}
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRepository implements the Repository interface in memory.
+// It behaves like the SQL repositories and is used by tests that don't need a database.
+type MemoryRepository struct {
+	mu        sync.Mutex
+	events    []Event
+	users     []UserRegistration
+	waitlist  []WaitlistEntry
+	languages map[int]string
+	templates map[[2]string]MessageTemplate
+	roles     map[int]UserRole
+	audit     []AuditEntry
+	bans      map[int]BannedUser
+	apiKeys   []APIKey
+	webhooks  []WebhookDelivery
+	webhookID int64
+	userID    int
+}
+
+// NewMemoryRepository creates an empty MemoryRepository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		languages: make(map[int]string),
+		templates: make(map[[2]string]MessageTemplate),
+		roles:     make(map[int]UserRole),
+		bans:      make(map[int]BannedUser),
+	}
+}
+
+// CreateTables does nothing, the repository is ready when created
+func (r *MemoryRepository) CreateTables(ctx context.Context) error {
+	return nil
+}
+
+// GetLatestEvent returns the latest active event
+func (r *MemoryRepository) GetLatestEvent(ctx context.Context) (*Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var latest *Event
+	for i := range r.events {
+		ev := r.events[i]
+		if ev.state != "active" {
+			continue
+		}
+		if latest == nil || ev.date.After(latest.date) {
+			latest = &ev
+		}
+	}
+	return latest, nil
+}
+
+// GetEvents returns all events, newest first
+func (r *MemoryRepository) GetEvents(ctx context.Context) ([]Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := append([]Event(nil), r.events...)
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].date.Equal(events[j].date) {
+			return events[i].date.After(events[j].date)
+		}
+		return events[i].id > events[j].id
+	})
+	return events, nil
+}
+
+// event returns the event with the given ID, the caller must hold the lock
+func (r *MemoryRepository) event(eventID int) *Event {
+	for i := range r.events {
+		if r.events[i].id == eventID {
+			return &r.events[i]
+		}
+	}
+	return nil
+}
+
+// user returns the registration row of a user for an event, the caller must hold the lock
+func (r *MemoryRepository) user(telegramID int, eventID int) *UserRegistration {
+	for i := range r.users {
+		if r.users[i].TelegramID == telegramID && r.users[i].EventID == eventID {
+			return &r.users[i]
+		}
+	}
+	return nil
+}
+
+// RegisterUser saves the user registration data or updates existing unregistered user
+func (r *MemoryRepository) RegisterUser(ctx context.Context, reg UserRegistration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(reg.TelegramID, reg.EventID); u != nil && u.Registred == 0 {
+		u.Username, u.Name, u.RegistrationDate, u.Email, u.Registred = reg.Username, reg.Name, reg.RegistrationDate, reg.Email, 1
+		return nil
+	}
+	r.addUser(reg)
+	return nil
+}
+
+// addUser appends a registration row with a new ID, the caller must hold the lock
+func (r *MemoryRepository) addUser(reg UserRegistration) {
+	r.userID++
+	reg.ID = r.userID
+	r.users = append(r.users, reg)
+}
+
+// UpdateUserEmail updates the user's email
+func (r *MemoryRepository) UpdateUserEmail(ctx context.Context, telegramID int, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].TelegramID == telegramID {
+			r.users[i].Email = email
+		}
+	}
+	return nil
+}
+
+// UpdateEventRegistrationCount increments the registration count for an event
+func (r *MemoryRepository) UpdateEventRegistrationCount(ctx context.Context, eventID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev := r.event(eventID); ev != nil {
+		ev.registrationCount++
+	}
+	return nil
+}
+
+// RemoveRegistration updates a user's registration status to unregistered
+func (r *MemoryRepository) RemoveRegistration(ctx context.Context, telegramID int, eventID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(telegramID, eventID); u != nil {
+		u.Registred = 0
+	}
+	return nil
+}
+
+// DecrementEventRegistrationCount decreases the registration count for an event
+func (r *MemoryRepository) DecrementEventRegistrationCount(ctx context.Context, eventID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev := r.event(eventID); ev != nil && ev.registrationCount > 0 {
+		ev.registrationCount--
+	}
+	return nil
+}
+
+// IsUserRegistered checks if a user is registered for an event
+func (r *MemoryRepository) IsUserRegistered(ctx context.Context, telegramID int, eventID int) (bool, *UserRegistration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.user(telegramID, eventID)
+	if u == nil {
+		return false, nil, nil
+	}
+	reg := *u
+	return reg.Registred == 1, &reg, nil
+}
+
+// UpdateVisitedStatus updates a user's visited status for an event
+func (r *MemoryRepository) UpdateVisitedStatus(ctx context.Context, telegramID int, eventID int, visited int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(telegramID, eventID); u != nil {
+		u.Visited = visited
+	}
+	return nil
+}
+
+// userByID returns the registration row with the ID, the caller must hold the lock
+func (r *MemoryRepository) userByID(id int) *UserRegistration {
+	for i := range r.users {
+		if r.users[i].ID == id {
+			return &r.users[i]
+		}
+	}
+	return nil
+}
+
+// GetRegistrationByID returns the registration row with the ID, or nil if there is none
+func (r *MemoryRepository) GetRegistrationByID(ctx context.Context, id int) (*UserRegistration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.userByID(id)
+	if u == nil {
+		return nil, nil
+	}
+	reg := *u
+	return &reg, nil
+}
+
+// CancelRegistrationByID marks the registration row with the ID as not registered.
+// Unlike RemoveUserByUsername of /remove, the row is kept, so an imported row without a Telegram ID
+// keeps its name and check-in in the history of the event.
+func (r *MemoryRepository) CancelRegistrationByID(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.userByID(id); u != nil {
+		u.Registred = 0
+	}
+	return nil
+}
+
+// UpdateVisitedStatusByID updates the visited status of the registration row with the ID
+func (r *MemoryRepository) UpdateVisitedStatusByID(ctx context.Context, id int, visited int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.userByID(id); u != nil {
+		u.Visited = visited
+	}
+	return nil
+}
+
+// AddWalkIn adds a row for someone who came without a registration and returns its ID
+func (r *MemoryRepository) AddWalkIn(ctx context.Context, reg UserRegistration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reg.Registred = 0
+	r.addUser(reg)
+	return r.userID, nil
+}
+
+// UpdateRegistration updates a user's registration for an event
+func (r *MemoryRepository) UpdateRegistration(ctx context.Context, reg UserRegistration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(reg.TelegramID, reg.EventID); u != nil {
+		u.Username, u.Name, u.RegistrationDate, u.Email, u.Registred = reg.Username, reg.Name, reg.RegistrationDate, reg.Email, reg.Registred
+	}
+	return nil
+}
+
+// MarkEventsAsPast updates all active events to past status
+func (r *MemoryRepository) MarkEventsAsPast(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.events {
+		r.events[i].state = "past"
+	}
+	return nil
+}
+
+// AddEvent adds a new active event
+func (r *MemoryRepository) AddEvent(ctx context.Context, name string, date time.Time, capacity int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, Event{id: len(r.events) + 1, name: name, date: date, capacity: capacity, state: "active"})
+	return nil
+}
+
+// UpdateEvent changes the name, date and capacity of an event
+func (r *MemoryRepository) UpdateEvent(ctx context.Context, eventID int, name string, date time.Time, capacity int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev := r.event(eventID); ev != nil {
+		ev.name, ev.date, ev.capacity = name, date, capacity
+	}
+	return nil
+}
+
+// GetAllRegistrations retrieves all user registrations with event details
+func (r *MemoryRepository) GetAllRegistrations(ctx context.Context) ([]UserRegistrationWithEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var registrations []UserRegistrationWithEvent
+	for _, u := range r.users {
+		ev := r.event(u.EventID)
+		if ev == nil {
+			continue
+		}
+		registrations = append(registrations, UserRegistrationWithEvent{UserRegistration: u, EventName: ev.name, EventDate: ev.date})
+	}
+	sort.SliceStable(registrations, func(i, j int) bool {
+		if !registrations[i].EventDate.Equal(registrations[j].EventDate) {
+			return registrations[i].EventDate.After(registrations[j].EventDate)
+		}
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations, nil
+}
+
+// GetEventRegistrations retrieves the registrations of an event, cancelled ones included, by name
+func (r *MemoryRepository) GetEventRegistrations(ctx context.Context, eventID int) ([]UserRegistration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var registrations []UserRegistration
+	for _, u := range r.users {
+		if u.EventID == eventID {
+			registrations = append(registrations, u)
+		}
+	}
+	sort.SliceStable(registrations, func(i, j int) bool { return registrations[i].Name < registrations[j].Name })
+	return registrations, nil
+}
+
+// GetEventCounts returns the check-ins and the waitlist length of every event that has any
+func (r *MemoryRepository) GetEventCounts(ctx context.Context) (map[int]EventCounts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[int]EventCounts)
+	for _, u := range r.users {
+		if u.Visited == 1 {
+			c := counts[u.EventID]
+			c.CheckedIn++
+			counts[u.EventID] = c
+		}
+	}
+	for _, e := range r.waitlist {
+		c := counts[e.EventID]
+		c.Waitlist++
+		counts[e.EventID] = c
+	}
+	return counts, nil
+}
+
+// HasUserInfo checks if a user has previously registered with name and email
+func (r *MemoryRepository) HasUserInfo(ctx context.Context, telegramID int) (bool, string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.TelegramID == telegramID && u.Name != "" && u.Email != "" {
+			return true, u.Name, u.Email, nil
+		}
+	}
+	return false, "", "", nil
+}
+
+// UpdateUserName updates the user's name
+func (r *MemoryRepository) UpdateUserName(ctx context.Context, telegramID int, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].TelegramID == telegramID {
+			r.users[i].Name = name
+		}
+	}
+	return nil
+}
+
+// AddToWaitlist adds a user to the waitlist for an event, rejoining moves the user to the end
+func (r *MemoryRepository) AddToWaitlist(ctx context.Context, telegramID int, chatID int64, username string, eventID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeFromWaitlist(func(e WaitlistEntry) bool { return e.TelegramID == telegramID && e.EventID == eventID })
+	r.waitlist = append(r.waitlist, WaitlistEntry{
+		TelegramID: telegramID, ChatID: chatID, Username: username, EventID: eventID,
+		JoinedDate: time.Now().Truncate(time.Second),
+	})
+	return nil
+}
+
+// removeFromWaitlist deletes the matching waitlist entries, the caller must hold the lock
+func (r *MemoryRepository) removeFromWaitlist(match func(WaitlistEntry) bool) {
+	kept := r.waitlist[:0]
+	for _, e := range r.waitlist {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+	r.waitlist = kept
+}
+
+// RemoveFromWaitlist removes a user from the waitlist for an event
+func (r *MemoryRepository) RemoveFromWaitlist(ctx context.Context, telegramID int, eventID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeFromWaitlist(func(e WaitlistEntry) bool { return e.TelegramID == telegramID && e.EventID == eventID })
+	return nil
+}
+
+// GetWaitlistForEvent returns all users in the waitlist for an event
+func (r *MemoryRepository) GetWaitlistForEvent(ctx context.Context, eventID int) ([]WaitlistEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []WaitlistEntry
+	for _, e := range r.waitlist {
+		if e.EventID == eventID {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// IsUserInWaitlist checks if a user is in the waitlist for an event
+func (r *MemoryRepository) IsUserInWaitlist(ctx context.Context, telegramID int, eventID int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.waitlist {
+		if e.TelegramID == telegramID && e.EventID == eventID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemoveUserByUsername removes a user from the registrations and the waitlist of an event by username
+// and returns if was registered. The rows of other events are kept, they hold the attendance history.
+func (r *MemoryRepository) RemoveUserByUsername(ctx context.Context, username string, eventID int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wasRegistered := false
+	for _, u := range r.users {
+		if u.Username == username && u.EventID == eventID {
+			wasRegistered = u.Registred == 1
+			break
+		}
+	}
+
+	kept := r.users[:0]
+	for _, u := range r.users {
+		if u.Username != username || u.EventID != eventID {
+			kept = append(kept, u)
+		}
+	}
+	r.users = kept
+	r.removeFromWaitlist(func(e WaitlistEntry) bool { return e.Username == username && e.EventID == eventID })
+	return wasRegistered, nil
+}
+
+// GetUserLanguage returns the language chosen by the user, or an empty string if none was chosen
+func (r *MemoryRepository) GetUserLanguage(ctx context.Context, telegramID int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.languages[telegramID], nil
+}
+
+// SetUserLanguage stores the language chosen by the user
+func (r *MemoryRepository) SetUserLanguage(ctx context.Context, telegramID int, language string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.languages[telegramID] = language
+	return nil
+}
+
+// GetMessageTemplate returns the template overriding a catalog message, or nil if there is none
+func (r *MemoryRepository) GetMessageTemplate(ctx context.Context, key string, language string) (*MessageTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tpl, ok := r.templates[[2]string{key, language}]
+	if !ok {
+		return nil, nil
+	}
+	return &tpl, nil
+}
+
+// GetMessageTemplates returns all saved message templates
+func (r *MemoryRepository) GetMessageTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var templates []MessageTemplate
+	for _, tpl := range r.templates {
+		templates = append(templates, tpl)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Key != templates[j].Key {
+			return templates[i].Key < templates[j].Key
+		}
+		return templates[i].Language < templates[j].Language
+	})
+	return templates, nil
+}
+
+// SaveMessageTemplate creates or replaces a message template
+func (r *MemoryRepository) SaveMessageTemplate(ctx context.Context, tpl MessageTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.templates[[2]string{tpl.Key, tpl.Language}] = tpl
+	return nil
+}
+
+// DeleteMessageTemplate removes a message template so the catalog text is used again
+func (r *MemoryRepository) DeleteMessageTemplate(ctx context.Context, key string, language string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.templates, [2]string{key, language})
+	return nil
+}
+
+// GetUserRole returns the role of a user, or an empty string if the user has none
+func (r *MemoryRepository) GetUserRole(ctx context.Context, telegramID int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.roles[telegramID].Role, nil
+}
+
+// GetUserRoles returns all granted roles
+func (r *MemoryRepository) GetUserRoles(ctx context.Context) ([]UserRole, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var roles []UserRole
+	for _, role := range r.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Role != roles[j].Role {
+			return roles[i].Role < roles[j].Role
+		}
+		return roles[i].Username < roles[j].Username
+	})
+	return roles, nil
+}
+
+// SetUserRole grants a role to a user, replacing the previous one
+func (r *MemoryRepository) SetUserRole(ctx context.Context, role UserRole) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roles[role.TelegramID] = role
+	return nil
+}
+
+// RemoveUserRole revokes the role of a user
+func (r *MemoryRepository) RemoveUserRole(ctx context.Context, telegramID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.roles, telegramID)
+	return nil
+}
+
+// FindTelegramIDByUsername looks up the Telegram ID of a user who has interacted with the bot.
+// Returns 0 if the username is unknown.
+func (r *MemoryRepository) FindTelegramIDByUsername(ctx context.Context, username string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.TelegramID != 0 && strings.EqualFold(u.Username, username) {
+			return u.TelegramID, nil
+		}
+	}
+	for _, e := range r.waitlist {
+		if strings.EqualFold(e.Username, username) {
+			return e.TelegramID, nil
+		}
+	}
+	for _, role := range r.roles {
+		if strings.EqualFold(role.Username, username) {
+			return role.TelegramID, nil
+		}
+	}
+	return 0, nil
+}
+
+// AddAuditEntry appends an entry to the audit log
+func (r *MemoryRepository) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.ID = int64(len(r.audit) + 1)
+	r.audit = append(r.audit, entry)
+	return nil
+}
+
+// GetAuditLog returns the newest audit log entries matching the filter, newest first
+func (r *MemoryRepository) GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []AuditEntry
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		entry := r.audit[i]
+		switch {
+		case filter.Action != "" && entry.Action != filter.Action,
+			filter.UserID != 0 && entry.ActorID != filter.UserID && entry.TargetID != filter.UserID,
+			filter.Username != "" && !strings.EqualFold(entry.ActorUsername, filter.Username) && !strings.EqualFold(entry.TargetUsername, filter.Username),
+			filter.EventID != 0 && entry.EventID != filter.EventID,
+			!filter.Since.IsZero() && entry.CreatedAt.Before(filter.Since):
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// IsUserBanned checks if a user is banned
+func (r *MemoryRepository) IsUserBanned(ctx context.Context, telegramID int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, banned := r.bans[telegramID]
+	return banned, nil
+}
+
+// BanUser bans a user, replacing a previous ban
+func (r *MemoryRepository) BanUser(ctx context.Context, ban BannedUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bans[ban.TelegramID] = ban
+	return nil
+}
+
+// UnbanUser lifts the ban of a user
+func (r *MemoryRepository) UnbanUser(ctx context.Context, telegramID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.bans, telegramID)
+	return nil
+}
+
+// Ping reports only a cancelled context, the repository is always reachable
+func (r *MemoryRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// AddAPIKey stores a new API key and returns its ID
+func (r *MemoryRepository) AddAPIKey(ctx context.Context, key APIKey) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.Hash == key.Hash {
+			return 0, fmt.Errorf("API key with hash %s already exists", key.Hash)
+		}
+	}
+	key.ID = int64(len(r.apiKeys) + 1)
+	key.RevokedAt = time.Time{}
+	r.apiKeys = append(r.apiKeys, key)
+	return key.ID, nil
+}
+
+// GetAPIKeyByHash returns the API key with the hash, revoked or not, or nil if there is none
+func (r *MemoryRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.Hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetAPIKeys returns all API keys, oldest first
+func (r *MemoryRepository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]APIKey(nil), r.apiKeys...), nil
+}
+
+// RevokeAPIKey revokes an API key, a key that is already revoked keeps its revocation time
+func (r *MemoryRepository) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.apiKeys {
+		if r.apiKeys[i].ID == id && r.apiKeys[i].RevokedAt.IsZero() {
+			r.apiKeys[i].RevokedAt = at
+		}
+	}
+	return nil
+}
+
+// AddWebhookDelivery queues a webhook delivery and returns its ID
+func (r *MemoryRepository) AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.webhookID++
+	delivery.ID = r.webhookID
+	r.webhooks = append(r.webhooks, delivery)
+	return delivery.ID, nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries due at now, oldest first
+func (r *MemoryRepository) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deliveries []WebhookDelivery
+	for _, d := range r.webhooks {
+		if d.Status == webhookPending && !d.NextAttemptAt.After(now) && len(deliveries) < limit {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery saves the outcome of a failed attempt
+func (r *MemoryRepository) UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.webhooks {
+		if r.webhooks[i].ID == delivery.ID {
+			d := &r.webhooks[i]
+			d.Status, d.Attempts, d.NextAttemptAt = delivery.Status, delivery.Attempts, delivery.NextAttemptAt
+			d.LastError, d.UpdatedAt = delivery.LastError, delivery.UpdatedAt
+		}
+	}
+	return nil
+}
+
+// DeleteWebhookDelivery removes a delivery from the queue
+func (r *MemoryRepository) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.webhooks {
+		if r.webhooks[i].ID == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetWebhookFailures returns up to limit deliveries with a failed attempt, pending or failed, most recently attempted first
+func (r *MemoryRepository) GetWebhookFailures(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var failures []WebhookDelivery
+	for _, d := range r.webhooks {
+		if d.Attempts > 0 {
+			failures = append(failures, d)
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		if !failures[i].UpdatedAt.Equal(failures[j].UpdatedAt) {
+			return failures[i].UpdatedAt.After(failures[j].UpdatedAt)
+		}
+		return failures[i].ID > failures[j].ID
+	})
+	if len(failures) > limit {
+		failures = failures[:limit]
+	}
+	return failures, nil
+}
+
+// ImportRegistrations adds imported registrations, events with a zero EventID are created as past events
+func (r *MemoryRepository) ImportRegistrations(ctx context.Context, imports []RegistrationImport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, imp := range imports {
+		registered := countRegistered(imp.Registrations)
+		eventID := imp.EventID
+		if eventID == 0 {
+			eventID = len(r.events) + 1
+			r.events = append(r.events, Event{id: eventID, name: imp.EventName, date: imp.EventDate, capacity: registered, state: "past"})
+		}
+		for _, reg := range imp.Registrations {
+			reg.EventID = eventID
+			r.addUser(reg)
+		}
+		if ev := r.event(eventID); ev != nil {
+			ev.registrationCount += registered
+		}
+	}
+	return nil
+}
+
+// ClaimImportedRegistrations links the imported registrations of a username without a Telegram ID and an email to the user
+func (r *MemoryRepository) ClaimImportedRegistrations(ctx context.Context, telegramID int, username string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if username == "" {
+		return 0, nil
+	}
+	claimed := 0
+	for i := range r.users {
+		u := &r.users[i]
+		if u.TelegramID == 0 && u.Email == "" && strings.EqualFold(u.Username, username) && r.user(telegramID, u.EventID) == nil {
+			u.TelegramID = telegramID
+			claimed++
+		}
+	}
+	return claimed, nil
+}
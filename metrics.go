@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the handler latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// BotMetrics holds the counters and histograms exposed on /metrics
+type BotMetrics struct {
+	Updates            *CounterVec   // Updates received, by type
+	Commands           *CounterVec   // Commands dispatched, by name
+	Registrations      *CounterVec   // Registrations, by source: button, waitlist or admin
+	Cancellations      *CounterVec   // Registrations removed, by reason
+	WaitlistJoins      *CounterVec   // Users who joined a waitlist
+	WaitlistPromotions *CounterVec   // Users registered from the waitlist, by themselves or by an admin
+	Checkins           *CounterVec   // Check-ins, by type: registered or walk-in
+	TelegramErrors     *CounterVec   // Failed Telegram Bot API calls, by method
+	WebhookDeliveries  *CounterVec   // Webhook delivery attempts, by result: delivered, retry or failed
+	HandlerLatency     *HistogramVec // Time to handle a request, by command
+}
+
+// NewBotMetrics creates all metrics of the bot
+func NewBotMetrics() *BotMetrics {
+	return &BotMetrics{
+		Updates:            NewCounterVec("meetupbot_updates_total", "Updates received from Telegram.", "type"),
+		Commands:           NewCounterVec("meetupbot_commands_total", "Commands dispatched to a handler.", "command"),
+		Registrations:      NewCounterVec("meetupbot_registrations_total", "Registrations for an event.", "source"),
+		Cancellations:      NewCounterVec("meetupbot_cancellations_total", "Registrations removed from an event.", "reason"),
+		WaitlistJoins:      NewCounterVec("meetupbot_waitlist_joins_total", "Users who joined a waitlist."),
+		WaitlistPromotions: NewCounterVec("meetupbot_waitlist_promotions_total", "Users registered from the waitlist."),
+		Checkins:           NewCounterVec("meetupbot_checkins_total", "Check-ins at the door.", "type"),
+		TelegramErrors:     NewCounterVec("meetupbot_telegram_api_errors_total", "Failed Telegram Bot API calls.", "method"),
+		WebhookDeliveries:  NewCounterVec("meetupbot_webhook_deliveries_total", "Webhook delivery attempts.", "result"),
+		HandlerLatency:     NewHistogramVec("meetupbot_handler_duration_seconds", "Time spent handling a request.", latencyBuckets, "command"),
+	}
+}
+
+// observeAction counts a state change recorded in the audit log
+func (m *BotMetrics) observeAction(action AuditAction, details string) {
+	switch action {
+	case AuditRegister:
+		m.Registrations.Inc("button")
+	case AuditWaitlistBook:
+		m.Registrations.Inc("waitlist")
+		m.WaitlistPromotions.Inc()
+	case AuditWaitlistPromote:
+		m.Registrations.Inc("admin")
+		m.WaitlistPromotions.Inc()
+	case AuditCancel:
+		m.Cancellations.Inc("user")
+	case AuditDialogCancel:
+		m.Cancellations.Inc("dialog")
+	case AuditAdminRemove:
+		m.Cancellations.Inc("admin")
+	case AuditWaitlistJoin:
+		m.WaitlistJoins.Inc()
+	case AuditCheckin:
+		m.Checkins.Inc(details)
+	}
+}
+
+// Middleware returns the middleware that records the handler latency
+func (m *BotMetrics) Middleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		start := time.Now()
+		err := next(ctx, bot, db, req)
+		m.HandlerLatency.Observe(time.Since(start).Seconds(), metricsCommand(req))
+		return err
+	}
+}
+
+// metricsCommand returns the command label of a request.
+// Callback data and unknown commands are collapsed, so the number of series stays bounded.
+func metricsCommand(req *Request) string {
+	switch {
+	case req.Callback != nil:
+		data := req.Callback.Data
+		if i := strings.Index(data, ":"); i >= 0 {
+			data = data[:i]
+		}
+		return "callback:" + data
+	case req.Message != nil && req.Message.IsCommand():
+		if Commands == nil || !Commands.Has(req.Message.Command()) {
+			return "unknown"
+		}
+	}
+	return req.Name
+}
+
+// Transport counts failed Telegram Bot API calls.
+// The Bot API answers errors with a 4xx or 5xx status, so the status is enough to tell.
+func (m *BotMetrics) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err != nil || resp.StatusCode >= 400 {
+			// The path is /bot<token>/<method>
+			m.TelegramErrors.Inc(path.Base(r.URL.Path))
+		}
+		return resp, err
+	})
+}
+
+// roundTripperFunc turns a function into an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus text format.
+// The event gauges are read from the repository on every scrape.
+func (m *BotMetrics) Handler(db Repository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Updates.write(w)
+		m.Commands.write(w)
+		m.Registrations.write(w)
+		m.Cancellations.write(w)
+		m.WaitlistJoins.write(w)
+		m.WaitlistPromotions.write(w)
+		m.Checkins.write(w)
+		m.TelegramErrors.write(w)
+		m.WebhookDeliveries.write(w)
+		m.HandlerLatency.write(w)
+		writeEventGauges(r.Context(), w, db)
+	})
+}
+
+// writeEventGauges writes the capacity and registrations of the active event
+func writeEventGauges(ctx context.Context, w io.Writer, db Repository) {
+	event, err := db.GetLatestEvent(ctx)
+	if err != nil {
+		slog.Error("Failed to get event for metrics", "error", err)
+		return
+	}
+	fmt.Fprintln(w, "# HELP meetupbot_event_capacity Capacity of the active event.")
+	fmt.Fprintln(w, "# TYPE meetupbot_event_capacity gauge")
+	if event != nil {
+		fmt.Fprintf(w, "meetupbot_event_capacity%s %d\n", formatLabels([]string{"event_id", "event"}, []string{strconv.Itoa(event.id), event.name}), event.capacity)
+	}
+	fmt.Fprintln(w, "# HELP meetupbot_event_registrations Registrations for the active event.")
+	fmt.Fprintln(w, "# TYPE meetupbot_event_registrations gauge")
+	if event != nil {
+		fmt.Fprintf(w, "meetupbot_event_registrations%s %d\n", formatLabels([]string{"event_id", "event"}, []string{strconv.Itoa(event.id), event.name}), event.registrationCount)
+	}
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+// counterSeries is the value of a counter for one combination of label values
+type counterSeries struct {
+	values []string
+	count  float64
+}
+
+// NewCounterVec creates a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+}
+
+// Inc increments the counter for the label values, given in the order of the label names
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.count++
+}
+
+// write writes the counter in the Prometheus text format
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values), formatFloat(s.count))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// histogramSeries holds the observations for one combination of label values
+type histogramSeries struct {
+	values []string
+	counts []uint64 // counts[i] is the number of observations <= buckets[i]
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram with the given bucket upper bounds and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// Observe records a value for the label values, given in the order of the label names
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// write writes the histogram in the Prometheus text format
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			values := append(append([]string{}, s.values...), formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.values...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.values), s.count)
+	}
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label names and values as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a series map in a stable order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecFormat(t *testing.T) {
+	c := NewCounterVec("test_total", "Test counter.", "type")
+	c.Inc("a")
+	c.Inc("a")
+	c.Inc(`b"\`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{type=\"a\"} 2\n" +
+		"test_total{type=\"b\\\"\\\\\"} 1\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramVecFormat(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "command")
+	h.Observe(0.05, "/start")
+	h.Observe(0.5, "/start")
+	h.Observe(5, "/start")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, line := range []string{
+		`test_seconds_bucket{command="/start",le="0.1"} 1`,
+		`test_seconds_bucket{command="/start",le="1"} 2`,
+		`test_seconds_bucket{command="/start",le="+Inf"} 3`,
+		`test_seconds_sum{command="/start"} 5.55`,
+		`test_seconds_count{command="/start"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := NewBotMetrics()
+	m.Updates.Inc("command")
+	m.WebhookDeliveries.Inc("delivered")
+	m.WebhookDeliveries.Inc("retry")
+
+	rec := httptest.NewRecorder()
+	m.Handler(NewMemoryRepository()).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`meetupbot_updates_total{type="command"} 1`,
+		"# TYPE meetupbot_webhook_deliveries_total counter",
+		`meetupbot_webhook_deliveries_total{result="delivered"} 1`,
+		`meetupbot_webhook_deliveries_total{result="retry"} 1`,
+		"# TYPE meetupbot_event_capacity gauge",
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, rec.Body.String())
+		}
+	}
+}
@@ -1,23 +1,286 @@
 package main
 
-import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
 
 // Middleware functions types
-type CommandHandlerFunc func(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message)
+type CommandHandlerFunc func(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error
+type CallbackHandlerFunc func(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error
+
+// Sender is the part of the Telegram Bot API the handlers use.
+// *tgbotapi.BotAPI implements it, tests use a fake that records what was sent.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	GetFileDirectURL(fileID string) (string, error)
+	UploadFile(endpoint string, params map[string]string, fieldname string, file interface{}) (tgbotapi.APIResponse, error)
+}
+
+// HandlerFunc processes a Request, it is what middlewares wrap.
+// Errors travel up the chain to the ErrorReporter middleware.
+type HandlerFunc func(ctx context.Context, bot Sender, db Repository, req *Request) error
+
+// Middleware wraps a handler with a cross-cutting concern.
+// The same middleware works for messages, commands and callback queries.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Request is what middlewares see of a message or a callback query
+type Request struct {
+	ID       string                  // ID is the correlation ID of all log records of the update
+	UpdateID int                     // UpdateID is the ID of the Telegram update
+	Name     string                  // Name is "/command", "text" or the callback data, used in logs
+	User     *tgbotapi.User          // User is the sender
+	ChatID   int64                   // ChatID is the chat to reply to
+	ChatType string                  // ChatType is "private", "group", "supergroup" or "channel"
+	Message  *tgbotapi.Message       // Message is set for messages and commands
+	Callback *tgbotapi.CallbackQuery // Callback is set for callback queries
+
+	log *slog.Logger
+}
+
+// newMessageRequest builds a Request for a message or a command
+func newMessageRequest(msg *tgbotapi.Message) *Request {
+	req := &Request{Name: "text", User: msg.From, Message: msg}
+	if msg.IsCommand() {
+		req.Name = "/" + msg.Command()
+	}
+	if msg.Chat != nil {
+		req.ChatID = msg.Chat.ID
+		req.ChatType = msg.Chat.Type
+	}
+	return req
+}
+
+// newCallbackRequest builds a Request for a callback query
+func newCallbackRequest(cq *tgbotapi.CallbackQuery) *Request {
+	req := &Request{Name: "callback:" + cq.Data, User: cq.From, Callback: cq}
+	if cq.Message != nil && cq.Message.Chat != nil {
+		req.ChatID = cq.Message.Chat.ID
+		req.ChatType = cq.Message.Chat.Type
+	}
+	return req
+}
+
+// Logger returns a logger with the correlation ID, update ID, user and command of the request
+func (req *Request) Logger() *slog.Logger {
+	if req.log == nil {
+		req.log = slog.With(
+			"correlation_id", req.ID,
+			"update_id", req.UpdateID,
+			"user_id", userID(req.User),
+			"command", req.Name,
+		)
+	}
+	return req.log
+}
 
-// Helper function to avoid circular imports
-func sendAdminDeniedMessage(bot *tgbotapi.BotAPI, chatID int64) {
-	message := tgbotapi.NewMessage(chatID, "У вас нет прав для выполнения этой команды. Только администраторы могут выполнять это действие.")
-	bot.Send(message)
+// Reply tells the user why the request was not processed:
+// a popup for callback queries, a message otherwise
+func (req *Request) Reply(bot Sender, text string) {
+	if req.Callback != nil {
+		callback := tgbotapi.NewCallback(req.Callback.ID, text)
+		callback.ShowAlert = text != ""
+		bot.AnswerCallbackQuery(callback)
+		return
+	}
+	if req.ChatID != 0 && text != "" {
+		sendMessage(bot, req.ChatID, text)
+	}
 }
 
-// AdminCheckMiddleware wraps a command handler with admin verification
-func AdminCheckMiddleware(handler CommandHandlerFunc) CommandHandlerFunc {
-	return func(bot *tgbotapi.BotAPI, db Repository, msg *tgbotapi.Message) {
-		if !IsAdmin(msg.From.UserName) {
-			sendAdminDeniedMessage(bot, msg.Chat.ID)
-			return
+// ReplyInChat sends text to the chat of the request.
+// For callback queries it also stops the spinner on the pressed button.
+func (req *Request) ReplyInChat(bot Sender, text string) {
+	if req.Callback != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(req.Callback.ID, ""))
+	}
+	if req.ChatID != 0 && text != "" {
+		sendMessage(bot, req.ChatID, text)
+	}
+}
+
+// Chain is an ordered list of middlewares, the first one is the outermost
+type Chain []Middleware
+
+// Then wraps the handler with all middlewares of the chain
+func (c Chain) Then(handler HandlerFunc) HandlerFunc {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+	return handler
+}
+
+// Command wraps a command or message handler with the chain.
+// The handler gets the message of the request the chain runs for.
+func (c Chain) Command(handler CommandHandlerFunc) HandlerFunc {
+	return c.Then(func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		return handler(ctx, bot, db, req.Message)
+	})
+}
+
+// Callback wraps a callback query handler with the chain.
+// The handler gets the callback query of the request the chain runs for.
+func (c Chain) Callback(handler CallbackHandlerFunc) HandlerFunc {
+	return c.Then(func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		return handler(ctx, bot, db, req.Callback)
+	})
+}
+
+// RecoverMiddleware turns a panic in a handler into an error, so the user gets
+// a friendly message and the failure is reported like any other
+func RecoverMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				req.Logger().Error("Panic in handler", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				err = Fail(fmt.Errorf("panic: %v", r), "internal_error")
+			}
+		}()
+		return next(ctx, bot, db, req)
+	}
+}
+
+// LoggingMiddleware logs every request with the time it took to handle
+func LoggingMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		start := time.Now()
+		err := next(ctx, bot, db, req)
+		req.Logger().Info("Handled request", "duration", time.Since(start))
+		return err
+	}
+}
+
+// PrivateChatOnly rejects requests coming from groups and channels
+func PrivateChatOnly(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		// Callbacks from inline messages have no chat, there is nothing to check
+		if req.ChatType != "" && req.ChatType != "private" {
+			req.Reply(bot, T(userLanguage(ctx, db, req.User), "private_chat_only"))
+			return nil
 		}
-		handler(bot, db, msg)
+		return next(ctx, bot, db, req)
+	}
+}
+
+// BannedUserMiddleware silently drops requests from banned users
+func BannedUserMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		if req.User != nil {
+			banned, err := db.IsUserBanned(ctx, req.User.ID)
+			if err != nil {
+				req.Logger().Error("Failed to check ban", "error", err)
+			}
+			if banned {
+				req.Logger().Info("Dropped request from banned user")
+				// Stop the spinner on the button, but don't give the user anything else
+				if req.Callback != nil {
+					req.Reply(bot, "")
+				}
+				return nil
+			}
+		}
+		return next(ctx, bot, db, req)
+	}
+}
+
+// RequirePermission rejects requests from users whose role doesn't grant perm
+func RequirePermission(perm Permission) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+			if !HasPermission(ctx, db, req.User, perm) {
+				req.Reply(bot, T(userLanguage(ctx, db, req.User), "permission_denied"))
+				return nil
+			}
+			return next(ctx, bot, db, req)
+		}
+	}
+}
+
+// RateLimiter allows each user at most limit requests per window
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	users  map[int]*rateWindow
+	mu     sync.Mutex
+}
+
+// rateWindow counts the requests of a user in the current window
+type rateWindow struct {
+	start  time.Time
+	count  int
+	warned bool
+}
+
+// NewRateLimiter creates a RateLimiter, a limit of 0 disables it
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		users:  make(map[int]*rateWindow),
+	}
+}
+
+// allow registers a request and reports whether it is within the limit,
+// and whether the user should be told about the limit
+func (rl *RateLimiter) allow(telegramID int, now time.Time) (bool, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	w, ok := rl.users[telegramID]
+	if !ok || now.Sub(w.start) >= rl.window {
+		// Drop expired windows now and then so the map doesn't grow forever
+		if len(rl.users) > 10000 {
+			for id, uw := range rl.users {
+				if now.Sub(uw.start) >= rl.window {
+					delete(rl.users, id)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		rl.users[telegramID] = w
+	}
+	w.count++
+	if w.count <= rl.limit {
+		return true, false
+	}
+	warn := !w.warned
+	w.warned = true
+	return false, warn
+}
+
+// Middleware returns the rate limiting middleware.
+// The user is warned once per window, further requests are dropped silently.
+func (rl *RateLimiter) Middleware(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+		if rl.limit <= 0 || req.User == nil {
+			return next(ctx, bot, db, req)
+		}
+		allowed, warn := rl.allow(req.User.ID, time.Now())
+		if !allowed {
+			req.Logger().Warn("Rate limited", "limit", rl.limit, "window", rl.window)
+			if warn {
+				req.Reply(bot, T(userLanguage(ctx, db, req.User), "rate_limited"))
+			} else if req.Callback != nil {
+				req.Reply(bot, "")
+			}
+			return nil
+		}
+		return next(ctx, bot, db, req)
+	}
+}
+
+// userID returns the Telegram ID of the user, 0 if unknown
+func userID(user *tgbotapi.User) int {
+	if user == nil {
+		return 0
 	}
+	return user.ID
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+
+	var calls []string
+	var ids []string
+	record := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, bot Sender, db Repository, req *Request) error {
+				calls = append(calls, name)
+				ids = append(ids, req.ID)
+				return next(ctx, bot, db, req)
+			}
+		}
+	}
+	Commands = NewCommandRegistry(record("first"), record("second"))
+	Commands.Register(Command{Name: "probe", Permission: PermExport, Middlewares: []Middleware{record("command")},
+		Handler: func(ctx context.Context, bot Sender, db Repository, msg *tgbotapi.Message) error {
+			calls = append(calls, "handler")
+			return nil
+		}})
+	Commands.RegisterCallbacks(Callback{Prefix: "probe:", Permission: PermExport, Middlewares: []Middleware{record("callback")},
+		Handler: func(ctx context.Context, bot Sender, db Repository, cq *tgbotapi.CallbackQuery) error {
+			calls = append(calls, "handler "+cq.Data)
+			return nil
+		}})
+
+	for _, test := range []struct {
+		name string
+		step step
+		want []string
+	}{
+		{"command", step{user: 3, text: "/probe"}, []string{"first", "second", "command", "handler"}},
+		{"callback", step{user: 3, data: "probe:1"}, []string{"first", "second", "callback", "handler probe:1"}},
+		{"command without the permission", step{user: 1, text: "/probe"}, []string{"first", "second"}},
+		{"callback without the permission", step{user: 1, data: "probe:1"}, []string{"first", "second"}},
+	} {
+		calls, ids = nil, nil
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, test.step.update(1))
+		if !slices.Equal(calls, test.want) {
+			t.Errorf("%s: calls = %q, want %q", test.name, calls, test.want)
+		}
+		// Every middleware sees the request of the update, with its correlation ID
+		if len(ids) == 0 || ids[0] == "" || slices.ContainsFunc(ids, func(id string) bool { return id != ids[0] }) {
+			t.Errorf("%s: correlation IDs = %q", test.name, ids)
+		}
+	}
+	if len(sender.answers) != 1 || sender.answers[0].Text != T("en", "permission_denied") || !sender.answers[0].ShowAlert {
+		t.Errorf("answers without the permission = %+v", sender.answers)
+	}
+}
+
+func TestCallbackPermissions(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+
+	// Buttons stay in the chat after the role is revoked, the press is checked again
+	for _, data := range []string{"export:event=all", "import:cancel", "checkin:1:1"} {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, data: data}.update(1))
+		if len(sender.answers) != 1 || sender.answers[0].Text != T("en", "permission_denied") || len(sender.messages)+len(sender.other) != 0 {
+			t.Errorf("%s: answers %+v, messages %+v", data, sender.answers, sender.messages)
+		}
+	}
+
+	// Language buttons need no permission
+	sender.reset()
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, data: languageCallbackPrefix + "en"}.update(2))
+	if len(sender.answers) == 0 || sender.answers[0].Text == T("en", "permission_denied") {
+		t.Errorf("language button: answers %+v", sender.answers)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(2, time.Minute)
+	now := time.Now()
+	for i, want := range []struct{ allowed, warn bool }{{true, false}, {true, false}, {false, true}, {false, false}} {
+		if allowed, warn := rl.allow(1, now); allowed != want.allowed || warn != want.warn {
+			t.Errorf("request %d = %v, %v; want %v, %v", i+1, allowed, warn, want.allowed, want.warn)
+		}
+	}
+	if allowed, _ := rl.allow(2, now); !allowed {
+		t.Error("another user is limited")
+	}
+	if allowed, warn := rl.allow(1, now.Add(time.Minute)); !allowed || warn {
+		t.Error("the limit is not reset after the window")
+	}
+
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	Commands = NewCommandRegistry(NewRateLimiter(1, time.Minute).Middleware)
+	registerCommands(Commands)
+	for i, s := range []step{{user: 1, text: "/help"}, {user: 1, text: "/help"}, {user: 1, text: "/help"}, {user: 1, data: "register"}} {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, s.update(i))
+		switch texts := sender.texts(); i {
+		case 0:
+			if len(texts) != 1 || texts[0] == T("en", "rate_limited") {
+				t.Errorf("first request = %q", texts)
+			}
+		case 1:
+			if len(texts) != 1 || texts[0] != T("en", "rate_limited") {
+				t.Errorf("request over the limit = %q, want a warning", texts)
+			}
+		case 2:
+			if len(texts) != 0 {
+				t.Errorf("second request over the limit = %q, want it dropped silently", texts)
+			}
+		case 3:
+			// The spinner of a dropped button press is stopped
+			if len(texts) != 0 || len(sender.answers) != 1 || sender.answers[0].Text != "" {
+				t.Errorf("button over the limit: messages %q, answers %+v", texts, sender.answers)
+			}
+		}
+	}
+}
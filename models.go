@@ -4,6 +4,7 @@ import "time"
 
 // UserRegistration represents a user registration record.
 type UserRegistration struct {
+	ID               int       // ID is the row of the registration, it tells apart registrations imported without a Telegram ID.
 	TelegramID       int       // TelegramID is the unique identifier for the user on Telegram.
 	Username         string    // Username is the user's Telegram username.
 	Name             string    // Name is the user's full name.
@@ -21,6 +22,7 @@ type Event struct {
 	date              time.Time // date is the date and time when the event is scheduled.
 	capacity          int       // capacity is the maximum number of participants allowed.
 	registrationCount int       // registrationCount is the number of participants registered for the event.
+	state             string    // state is "active" for the current event, "past" for archived ones.
 }
 
 // UserRegistrationWithEvent extends UserRegistration with event information
@@ -30,6 +32,20 @@ type UserRegistrationWithEvent struct {
 	EventDate        time.Time // Date of the event
 }
 
+// EventCounts are the counts of an event that are not kept in the events table
+type EventCounts struct {
+	CheckedIn int // CheckedIn is the number of users checked in, walk-ins included
+	Waitlist  int // Waitlist is the number of users in the waitlist
+}
+
+// RegistrationImport is a set of registrations imported from a file into one event.
+type RegistrationImport struct {
+	EventID       int                // EventID is the event the registrations are added to, 0 to create a past event.
+	EventName     string             // EventName is the name of the event created when EventID is 0.
+	EventDate     time.Time          // EventDate is the date of the event created when EventID is 0.
+	Registrations []UserRegistration // Registrations are the imported rows, their EventID is ignored.
+}
+
 // WaitlistEntry represents a user in the waitlist for an event.
 type WaitlistEntry struct {
 	TelegramID int       // TelegramID is the unique identifier for the user on Telegram.
@@ -38,3 +54,79 @@ type WaitlistEntry struct {
 	EventID    int       // EventID is the identifier of the event.
 	JoinedDate time.Time // JoinedDate is when the user joined the waitlist.
 }
+
+// MessageTemplate represents an organizer-defined override of a catalog message.
+type MessageTemplate struct {
+	Key       string    // Key is the catalog key the template overrides.
+	Language  string    // Language is the catalog language the template overrides.
+	Body      string    // Body is the text/template source of the message.
+	UpdatedBy string    // UpdatedBy is the username of the admin who saved the template.
+	UpdatedAt time.Time // UpdatedAt is when the template was last saved.
+}
+
+// UserRole represents a role granted to a Telegram user.
+type UserRole struct {
+	TelegramID int       // TelegramID is the unique identifier for the user on Telegram.
+	Username   string    // Username is the user's Telegram username at the time of the grant.
+	Role       string    // Role is the granted role: owner, organizer or volunteer.
+	GrantedBy  int       // GrantedBy is the Telegram ID of the user who granted the role, 0 for ADMIN_USERS.
+	GrantedAt  time.Time // GrantedAt is when the role was granted.
+}
+
+// AuditEntry represents a single state-changing action in the audit log.
+type AuditEntry struct {
+	ID             int64     // ID is the sequential number of the entry.
+	CreatedAt      time.Time // CreatedAt is when the action happened.
+	Action         string    // Action is the kind of action, see AuditAction.
+	ActorID        int       // ActorID is the Telegram ID of the user who performed the action.
+	ActorUsername  string    // ActorUsername is the Telegram username of the actor.
+	TargetID       int       // TargetID is the Telegram ID of the affected user, 0 if unknown.
+	TargetUsername string    // TargetUsername is the Telegram username of the affected user.
+	EventID        int       // EventID is the identifier of the affected event, 0 if none.
+	Details        string    // Details is free-form additional information.
+}
+
+// AuditFilter selects audit log entries. Zero values match everything.
+type AuditFilter struct {
+	Action   string    // Action matches entries with this action.
+	UserID   int       // UserID matches entries where the user is the actor or the target.
+	Username string    // Username matches entries where the user is the actor or the target.
+	EventID  int       // EventID matches entries for this event.
+	Since    time.Time // Since matches entries created at or after this time.
+	Limit    int       // Limit is the maximum number of newest entries returned, 0 for all.
+}
+
+// APIKey represents a key of the HTTP API. Only the hash of the key is stored.
+type APIKey struct {
+	ID        int64     // ID is the sequential number of the key.
+	Name      string    // Name describes who uses the key, e.g. "website".
+	Hash      string    // Hash is the hex SHA-256 of the key.
+	Scope     string    // Scope is "events" for event data or "full" for personal data too.
+	CreatedBy string    // CreatedBy is the username of who created the key.
+	CreatedAt time.Time // CreatedAt is when the key was created.
+	RevokedAt time.Time // RevokedAt is when the key was revoked, zero for active keys.
+}
+
+// WebhookDelivery is a webhook payload queued for a POST to a URL.
+// Deliveries are removed once the receiver accepts them.
+type WebhookDelivery struct {
+	ID            int64     // ID is the sequential number of the delivery.
+	URL           string    // URL is where the payload is posted.
+	Type          string    // Type is the lifecycle event, e.g. "registration.created".
+	Payload       string    // Payload is the JSON body.
+	Status        string    // Status is "pending" while the delivery is retried, "failed" after the last attempt.
+	Attempts      int       // Attempts is the number of failed attempts so far.
+	NextAttemptAt time.Time // NextAttemptAt is when a pending delivery is attempted next.
+	LastError     string    // LastError describes why the last attempt failed.
+	CreatedAt     time.Time // CreatedAt is when the delivery was queued.
+	UpdatedAt     time.Time // UpdatedAt is when the delivery was last attempted.
+}
+
+// BannedUser represents a user the bot ignores.
+type BannedUser struct {
+	TelegramID int       // TelegramID is the unique identifier for the user on Telegram.
+	Username   string    // Username is the user's Telegram username at the time of the ban.
+	Reason     string    // Reason is an optional note of the admin.
+	BannedBy   int       // BannedBy is the Telegram ID of the admin who banned the user.
+	BannedAt   time.Time // BannedAt is when the user was banned.
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Size of an A4 page in points, and the number of points in a millimetre
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMM         = 72 / 25.4
+)
+
+// pdfFontPaths are the places of common TrueType fonts with Cyrillic letters,
+// tried in order when PDF_FONT is not set
+var pdfFontPaths = []string{
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+	"/usr/share/fonts/truetype/liberation/LiberationSans-Regular.ttf",
+	"/usr/share/fonts/liberation/LiberationSans-Regular.ttf",
+	"/System/Library/Fonts/Supplemental/Arial.ttf",
+	"/Library/Fonts/Arial.ttf",
+	`C:\Windows\Fonts\arial.ttf`,
+}
+
+// loadPDFFont reads the font for the PDF printouts from path, or from the first
+// of pdfFontPaths that exists when path is empty. It returns nil without an error
+// when there is no font, the printouts are set in Helvetica then.
+func loadPDFFont(path string) (*ttfFont, error) {
+	if path == "" {
+		for _, candidate := range pdfFontPaths {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	font, err := parseTTF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return font, nil
+}
+
+// ttfFont is a TrueType font with the metrics needed to set text and embed it into a PDF
+type ttfFont struct {
+	data       []byte
+	name       string
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	advances   []int // advance widths of the glyphs in font units
+	glyphs     map[rune]uint16
+}
+
+var errTTFInvalid = errors.New("not a TrueType font")
+
+// parseTTF reads the tables of a TrueType font. Fonts with CFF outlines and font
+// collections are not supported.
+func parseTTF(data []byte) (*ttfFont, error) {
+	if len(data) < 12 {
+		return nil, errTTFInvalid
+	}
+	if version := string(data[:4]); version != "\x00\x01\x00\x00" && version != "true" {
+		return nil, errTTFInvalid
+	}
+	tables := make(map[string][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errTTFInvalid
+		}
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errTTFInvalid
+		}
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+	head, hhea, maxp, hmtx := tables["head"], tables["hhea"], tables["maxp"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 || tables["glyf"] == nil {
+		return nil, errTTFInvalid
+	}
+
+	int16At := func(b []byte, off int) int { return int(int16(binary.BigEndian.Uint16(b[off:]))) }
+	font := &ttfFont{
+		data:       data,
+		name:       ttfPostScriptName(tables["name"]),
+		unitsPerEm: int(binary.BigEndian.Uint16(head[18:])),
+		ascent:     int16At(hhea, 4),
+		descent:    int16At(hhea, 6),
+		bbox:       [4]int{int16At(head, 36), int16At(head, 38), int16At(head, 40), int16At(head, 42)},
+	}
+	if font.unitsPerEm == 0 {
+		return nil, errTTFInvalid
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < 4*numMetrics {
+		return nil, errTTFInvalid
+	}
+	font.advances = make([]int, numGlyphs)
+	for i := range font.advances {
+		font.advances[i] = int(binary.BigEndian.Uint16(hmtx[4*min(i, numMetrics-1):]))
+	}
+
+	glyphs, err := ttfCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	for r, glyph := range glyphs {
+		if int(glyph) >= numGlyphs {
+			delete(glyphs, r)
+		}
+	}
+	font.glyphs = glyphs
+	return font, nil
+}
+
+// ttfCmap reads the Unicode BMP mapping (format 4) of the cmap table
+func ttfCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errTTFInvalid
+	}
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables && 4+8*i+8 <= len(cmap); i++ {
+		record := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(record), binary.BigEndian.Uint16(record[2:])
+		if platform != 0 && (platform != 3 || encoding != 1) {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(record[4:]))
+		if offset+14 > len(cmap) || binary.BigEndian.Uint16(cmap[offset:]) != 4 {
+			continue
+		}
+		sub := cmap[offset:]
+		segments := int(binary.BigEndian.Uint16(sub[6:])) / 2
+		if 16+8*segments > len(sub) {
+			return nil, errTTFInvalid
+		}
+		ends, starts, deltas, ranges := 14, 16+2*segments, 16+4*segments, 16+6*segments
+		glyphs := make(map[rune]uint16)
+		for s := 0; s < segments; s++ {
+			end := int(binary.BigEndian.Uint16(sub[ends+2*s:]))
+			start := int(binary.BigEndian.Uint16(sub[starts+2*s:]))
+			delta := binary.BigEndian.Uint16(sub[deltas+2*s:])
+			rangeOffset := int(binary.BigEndian.Uint16(sub[ranges+2*s:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				glyph := uint16(c) + delta
+				if rangeOffset != 0 {
+					addr := ranges + 2*s + rangeOffset + 2*(c-start)
+					if addr+2 > len(sub) {
+						return nil, errTTFInvalid
+					}
+					if glyph = binary.BigEndian.Uint16(sub[addr:]); glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = glyph
+				}
+			}
+		}
+		return glyphs, nil
+	}
+	return nil, fmt.Errorf("%w: no Unicode cmap", errTTFInvalid)
+}
+
+// ttfPostScriptName returns the PostScript name from the name table, the PDF refers to the font by it
+func ttfPostScriptName(table []byte) string {
+	if len(table) >= 6 {
+		count, storage := int(binary.BigEndian.Uint16(table[2:])), int(binary.BigEndian.Uint16(table[4:]))
+		for i := 0; i < count && 6+12*i+12 <= len(table); i++ {
+			record := table[6+12*i:]
+			platform, nameID := binary.BigEndian.Uint16(record), binary.BigEndian.Uint16(record[6:])
+			length, offset := int(binary.BigEndian.Uint16(record[8:])), int(binary.BigEndian.Uint16(record[10:]))
+			if nameID != 6 || storage+offset+length > len(table) {
+				continue
+			}
+			value := table[storage+offset : storage+offset+length]
+			var name string
+			switch platform {
+			case 1:
+				name = string(value)
+			case 0, 3:
+				units := make([]uint16, len(value)/2)
+				for j := range units {
+					units[j] = binary.BigEndian.Uint16(value[2*j:])
+				}
+				name = string(utf16.Decode(units))
+			}
+			// Names are made of printable ASCII without the PDF delimiters
+			name = strings.Map(func(r rune) rune {
+				if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+					return -1
+				}
+				return r
+			}, name)
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return "EmbeddedFont"
+}
+
+// helveticaWidths are the widths of the ASCII characters from the space to the tilde in Helvetica
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfTransliteration replaces the Russian and Ukrainian letters and the common
+// typographic characters for the text set in Helvetica
+var pdfTransliteration = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+	'«': "\"", '»': "\"", '“': "\"", '”': "\"", '„': "\"", '‘': "'", '’': "'",
+	'–': "-", '—': "-", '·': "-", '…': "...", '№': "No", '\u00A0': " ",
+}
+
+// pdfLatin converts text to the ASCII Helvetica can show: Cyrillic is transliterated,
+// the other characters are replaced with a question mark
+func pdfLatin(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case pdfTransliteration[r] != "":
+			b.WriteString(pdfTransliteration[r])
+		case pdfTransliteration[unicode.ToLower(r)] != "":
+			latin := pdfTransliteration[unicode.ToLower(r)]
+			b.WriteString(strings.ToUpper(latin[:1]) + latin[1:])
+		case r == 'ъ' || r == 'ь' || r == 'Ъ' || r == 'Ь':
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfDocument builds a PDF of A4 pages in memory. All text is set in one font:
+// the embedded TrueType font when there is one, or Helvetica.
+type pdfDocument struct {
+	font  *ttfFont
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	used  map[uint16]rune // glyphs set with the embedded font and their characters
+}
+
+// newPDFDocument returns an empty document set in font, nil stands for Helvetica
+func newPDFDocument(font *ttfFont) *pdfDocument {
+	return &pdfDocument{font: font, used: make(map[uint16]rune)}
+}
+
+// addPage starts a new page, the drawing methods draw on it
+func (d *pdfDocument) addPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+}
+
+// selectPage makes the drawing methods draw on a page added before
+func (d *pdfDocument) selectPage(i int) {
+	d.page = d.pages[i]
+}
+
+// textWidth returns the width of s set in size points
+func (d *pdfDocument) textWidth(s string, size float64) float64 {
+	units := 0
+	if d.font == nil {
+		for _, c := range []byte(pdfLatin(s)) {
+			units += helveticaWidths[c-' ']
+		}
+		return float64(units) * size / 1000
+	}
+	for _, r := range s {
+		units += d.font.advances[d.font.glyphs[r]]
+	}
+	return float64(units) * size / float64(d.font.unitsPerEm)
+}
+
+// fitText returns the largest size up to size at which s is not wider than width
+func (d *pdfDocument) fitText(s string, size, width float64) float64 {
+	for size > 6 && d.textWidth(s, size) > width {
+		size -= 0.5
+	}
+	return size
+}
+
+// text draws s with the baseline starting at x, y
+func (d *pdfDocument) text(x, y, size float64, s string) {
+	fmt.Fprintf(d.page, "BT /F1 %.2f Tf %.2f %.2f Td ", size, x, y)
+	if d.font == nil {
+		d.page.WriteByte('(')
+		for _, c := range []byte(pdfLatin(s)) {
+			if c == '(' || c == ')' || c == '\\' {
+				d.page.WriteByte('\\')
+			}
+			d.page.WriteByte(c)
+		}
+		d.page.WriteString(") Tj ET\n")
+		return
+	}
+	d.page.WriteByte('<')
+	for _, r := range s {
+		glyph := d.font.glyphs[r]
+		if _, ok := d.used[glyph]; !ok {
+			d.used[glyph] = r
+		}
+		fmt.Fprintf(d.page, "%04X", glyph)
+	}
+	d.page.WriteString("> Tj ET\n")
+}
+
+// gray sets the shade of the next lines, fills and text, 0 is black and 1 is white
+func (d *pdfDocument) gray(level float64) {
+	fmt.Fprintf(d.page, "%.2f G %.2f g\n", level, level)
+}
+
+// rect strokes a rectangle with the lower left corner at x, y
+func (d *pdfDocument) rect(x, y, w, h, lineWidth float64) {
+	fmt.Fprintf(d.page, "%.2f w %.2f %.2f %.2f %.2f re S\n", lineWidth, x, y, w, h)
+}
+
+// line strokes a line from x1, y1 to x2, y2
+func (d *pdfDocument) line(x1, y1, x2, y2, lineWidth float64) {
+	fmt.Fprintf(d.page, "%.2f w %.2f %.2f m %.2f %.2f l S\n", lineWidth, x1, y1, x2, y2)
+}
+
+// bitmap fills the set modules of a square bitmap, such as a QR code, into the
+// square with the lower left corner at x, y. Runs of modules in a row are filled
+// as one rectangle, so scanners don't see gaps between them.
+func (d *pdfDocument) bitmap(x, y, size float64, modules [][]bool) {
+	if len(modules) == 0 {
+		return
+	}
+	module := size / float64(len(modules))
+	for i, row := range modules {
+		top := y + size - float64(i+1)*module
+		for j := 0; j < len(row); j++ {
+			if !row[j] {
+				continue
+			}
+			start := j
+			for j+1 < len(row) && row[j+1] {
+				j++
+			}
+			fmt.Fprintf(d.page, "%.3f %.3f %.3f %.3f re\n", x+float64(start)*module, top, float64(j-start+1)*module, module)
+		}
+	}
+	d.page.WriteString("f\n")
+}
+
+// write writes the document as PDF 1.4
+func (d *pdfDocument) write(w io.Writer) error {
+	var objects [][]byte
+	add := func(object []byte) int {
+		objects = append(objects, object)
+		return len(objects)
+	}
+	add(nil) // the catalog, 1 0 R
+	add(nil) // the page tree, 2 0 R
+
+	font, err := d.fontObjects(add)
+	if err != nil {
+		return err
+	}
+	kids := make([]string, 0, len(d.pages))
+	for _, page := range d.pages {
+		contents := add(pdfStream("", page.Bytes()))
+		kids = append(kids, fmt.Sprintf("%d 0 R", add(fmt.Appendf(nil,
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, font, contents))))
+	}
+	objects[0] = []byte("<< /Type /Catalog /Pages 2 0 R >>")
+	objects[1] = fmt.Appendf(nil, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(object)
+		buf.WriteString("\nendobj\n")
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// fontObjects adds the objects of the font and returns the number of the font object.
+// The embedded font is a CID font with the glyph IDs as character codes, its
+// ToUnicode map lets viewers copy and search the text.
+func (d *pdfDocument) fontObjects(add func([]byte) int) (int, error) {
+	if d.font == nil {
+		return add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")), nil
+	}
+	f := d.font
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(f.data); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	file := add(pdfStream(fmt.Sprintf("/Length1 %d /Filter /FlateDecode", len(f.data)), compressed.Bytes()))
+
+	scale := func(v int) int { return v * 1000 / f.unitsPerEm }
+	descriptor := add(fmt.Appendf(nil,
+		"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, scale(f.bbox[0]), scale(f.bbox[1]), scale(f.bbox[2]), scale(f.bbox[3]), scale(f.ascent), scale(f.descent), scale(f.ascent), file))
+
+	glyphs := make([]uint16, 0, len(d.used))
+	for glyph := range d.used {
+		glyphs = append(glyphs, glyph)
+	}
+	slices.Sort(glyphs)
+	var widths, toUnicode strings.Builder
+	for i, glyph := range glyphs {
+		fmt.Fprintf(&widths, " %d [%d]", glyph, scale(f.advances[glyph]))
+		if i%100 == 0 {
+			if i > 0 {
+				toUnicode.WriteString("endbfchar\n")
+			}
+			fmt.Fprintf(&toUnicode, "%d beginbfchar\n", min(100, len(glyphs)-i))
+		}
+		fmt.Fprintf(&toUnicode, "<%04X> <", glyph)
+		for _, unit := range utf16.Encode([]rune{d.used[glyph]}) {
+			fmt.Fprintf(&toUnicode, "%04X", unit)
+		}
+		toUnicode.WriteString(">\n")
+	}
+	if len(glyphs) > 0 {
+		toUnicode.WriteString("endbfchar\n")
+	}
+	cmap := add(pdfStream("", []byte("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n"+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n"+
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n"+toUnicode.String()+
+		"endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")))
+
+	cidFont := add(fmt.Appendf(nil,
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW %d /W [%s ] /CIDToGIDMap /Identity >>",
+		f.name, descriptor, scale(f.advances[0]), widths.String()))
+	return add(fmt.Appendf(nil,
+		"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		f.name, cidFont, cmap)), nil
+}
+
+// pdfStream returns a stream object with the extra entries in its dictionary
+func pdfStream(entries string, data []byte) []byte {
+	if entries != "" {
+		entries = " " + entries
+	}
+	stream := fmt.Appendf(nil, "<< /Length %d%s >>\nstream\n", len(data), entries)
+	stream = append(stream, data...)
+	return append(stream, "\nendstream"...)
+}
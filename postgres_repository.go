@@ -0,0 +1,952 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations create and evolve the PostgreSQL schema.
+// Migrations are applied in order and recorded in schema_migrations, so only append to this list.
+var postgresMigrations = []string{
+	// 1: initial schema, equivalent to the SQLite tables
+	`CREATE TABLE users (
+		id SERIAL PRIMARY KEY,
+		telegram_id BIGINT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		registration_date TIMESTAMPTZ,
+		email TEXT NOT NULL DEFAULT '',
+		event_id INTEGER NOT NULL,
+		registred INTEGER NOT NULL DEFAULT 0,
+		visited INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX users_telegram_id_event_id ON users (telegram_id, event_id);
+
+	CREATE TABLE events (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		date TIMESTAMPTZ NOT NULL,
+		capacity INTEGER NOT NULL,
+		registration_count INTEGER NOT NULL DEFAULT 0,
+		state TEXT NOT NULL DEFAULT 'active'
+	);
+
+	CREATE TABLE waitlist (
+		id SERIAL PRIMARY KEY,
+		telegram_id BIGINT NOT NULL,
+		chat_id BIGINT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		event_id INTEGER NOT NULL,
+		joined_date TIMESTAMPTZ NOT NULL,
+		UNIQUE (telegram_id, event_id)
+	);
+
+	CREATE TABLE user_settings (
+		telegram_id BIGINT PRIMARY KEY,
+		language TEXT
+	);
+
+	CREATE TABLE message_templates (
+		key TEXT,
+		language TEXT,
+		body TEXT NOT NULL,
+		updated_by TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (key, language)
+	);
+
+	CREATE TABLE roles (
+		telegram_id BIGINT PRIMARY KEY,
+		username TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		granted_by BIGINT NOT NULL DEFAULT 0,
+		granted_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL,
+		action TEXT NOT NULL,
+		actor_id BIGINT NOT NULL DEFAULT 0,
+		actor_username TEXT NOT NULL DEFAULT '',
+		target_id BIGINT NOT NULL DEFAULT 0,
+		target_username TEXT NOT NULL DEFAULT '',
+		event_id INTEGER NOT NULL DEFAULT 0,
+		details TEXT NOT NULL DEFAULT ''
+	);
+
+	-- The audit log is append-only: reject any attempt to change or delete entries
+	CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+	CREATE TABLE banned_users (
+		telegram_id BIGINT PRIMARY KEY,
+		username TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		banned_by BIGINT NOT NULL DEFAULT 0,
+		banned_at TIMESTAMPTZ NOT NULL
+	);`,
+	// 2: API keys
+	`CREATE TABLE api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scope TEXT NOT NULL,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		revoked_at TIMESTAMPTZ
+	);`,
+	// 3: Webhook delivery queue
+	`CREATE TABLE webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+}
+
+// PostgresRepository implements the Repository interface on PostgreSQL
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRepository creates a new PostgresRepository
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// CreateTables applies the migrations that have not been applied yet
+func (r *PostgresRepository) CreateTables(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := r.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(postgresMigrations); i++ {
+		if err := r.migrate(ctx, i+1, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// migrate applies a single migration in a transaction
+func (r *PostgresRepository) migrate(ctx context.Context, version int, migration string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize concurrent starts, the lock is released with the transaction
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(4242)"); err != nil {
+		return err
+	}
+	var applied bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLatestEvent returns the latest active event
+func (r *PostgresRepository) GetLatestEvent(ctx context.Context) (*Event, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, name, date, capacity, registration_count, state FROM events WHERE state = 'active' ORDER BY date DESC LIMIT 1")
+	var ev Event
+	err := row.Scan(&ev.id, &ev.name, &ev.date, &ev.capacity, &ev.registrationCount, &ev.state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// GetEvents returns all events, newest first
+func (r *PostgresRepository) GetEvents(ctx context.Context) ([]Event, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, date, capacity, registration_count, state FROM events ORDER BY date DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		if err := rows.Scan(&ev.id, &ev.name, &ev.date, &ev.capacity, &ev.registrationCount, &ev.state); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// RegisterUser saves the user registration data or updates existing unregistered user
+func (r *PostgresRepository) RegisterUser(ctx context.Context, reg UserRegistration) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET username = $1, name = $2, registration_date = $3, email = $4, registred = 1 WHERE telegram_id = $5 AND event_id = $6 AND registred = 0",
+		reg.Username, reg.Name, reg.RegistrationDate, reg.Email, reg.TelegramID, reg.EventID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// User doesn't exist, insert new record
+	_, err = r.db.ExecContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate, reg.Email, reg.EventID, reg.Registred, reg.Visited)
+	return err
+}
+
+// UpdateUserEmail updates the user's email
+func (r *PostgresRepository) UpdateUserEmail(ctx context.Context, telegramID int, email string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET email = $1 WHERE telegram_id = $2", email, telegramID)
+	return err
+}
+
+// UpdateEventRegistrationCount increments the registration count for an event
+func (r *PostgresRepository) UpdateEventRegistrationCount(ctx context.Context, eventID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE events SET registration_count = registration_count + 1 WHERE id = $1", eventID)
+	return err
+}
+
+// RemoveRegistration updates a user's registration status to unregistered
+func (r *PostgresRepository) RemoveRegistration(ctx context.Context, telegramID int, eventID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET registred = 0 WHERE telegram_id = $1 AND event_id = $2", telegramID, eventID)
+	return err
+}
+
+// DecrementEventRegistrationCount decreases the registration count for an event
+func (r *PostgresRepository) DecrementEventRegistrationCount(ctx context.Context, eventID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE events SET registration_count = registration_count - 1 WHERE id = $1 AND registration_count > 0", eventID)
+	return err
+}
+
+// IsUserRegistered checks if a user is registered for an event
+func (r *PostgresRepository) IsUserRegistered(ctx context.Context, telegramID int, eventID int) (bool, *UserRegistration, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited FROM users WHERE telegram_id = $1 AND event_id = $2 ORDER BY id LIMIT 1", telegramID, eventID)
+	var reg UserRegistration
+	var date sql.NullTime
+	err := row.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &date, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	reg.RegistrationDate = date.Time
+	return reg.Registred == 1, &reg, nil
+}
+
+// UpdateVisitedStatus updates a user's visited status for an event
+func (r *PostgresRepository) UpdateVisitedStatus(ctx context.Context, telegramID int, eventID int, visited int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET visited = $1 WHERE telegram_id = $2 AND event_id = $3", visited, telegramID, eventID)
+	return err
+}
+
+// GetRegistrationByID returns the registration row with the ID, or nil if there is none
+func (r *PostgresRepository) GetRegistrationByID(ctx context.Context, id int) (*UserRegistration, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited FROM users WHERE id = $1", id)
+	var reg UserRegistration
+	var date sql.NullTime
+	err := row.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &date, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	reg.RegistrationDate = date.Time
+	return &reg, nil
+}
+
+// CancelRegistrationByID marks the registration row with the ID as not registered.
+// Unlike RemoveUserByUsername of /remove, the row is kept, so an imported row without a Telegram ID
+// keeps its name and check-in in the history of the event.
+func (r *PostgresRepository) CancelRegistrationByID(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET registred = 0 WHERE id = $1", id)
+	return err
+}
+
+// UpdateVisitedStatusByID updates the visited status of the registration row with the ID
+func (r *PostgresRepository) UpdateVisitedStatusByID(ctx context.Context, id int, visited int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET visited = $1 WHERE id = $2", visited, id)
+	return err
+}
+
+// AddWalkIn adds a row for someone who came without a registration and returns its ID.
+// The row is not a registration, so the registration count of the event stays the same.
+func (r *PostgresRepository) AddWalkIn(ctx context.Context, reg UserRegistration) (int, error) {
+	var id int
+	err := r.db.QueryRowContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES ($1, $2, $3, $4, $5, $6, 0, $7) RETURNING id",
+		reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate, reg.Email, reg.EventID, reg.Visited).Scan(&id)
+	return id, err
+}
+
+// UpdateRegistration updates a user's registration for an event
+func (r *PostgresRepository) UpdateRegistration(ctx context.Context, reg UserRegistration) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET username = $1, name = $2, registration_date = $3, email = $4, registred = $5 WHERE telegram_id = $6 AND event_id = $7",
+		reg.Username, reg.Name, reg.RegistrationDate, reg.Email, reg.Registred, reg.TelegramID, reg.EventID)
+	return err
+}
+
+// MarkEventsAsPast updates all active events to past status
+func (r *PostgresRepository) MarkEventsAsPast(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE events SET state = 'past' WHERE state = 'active'")
+	return err
+}
+
+// AddEvent adds a new active event
+func (r *PostgresRepository) AddEvent(ctx context.Context, name string, date time.Time, capacity int) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO events (name, date, capacity, state) VALUES ($1, $2, $3, 'active')", name, date, capacity)
+	return err
+}
+
+// UpdateEvent changes the name, date and capacity of an event
+func (r *PostgresRepository) UpdateEvent(ctx context.Context, eventID int, name string, date time.Time, capacity int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE events SET name = $1, date = $2, capacity = $3 WHERE id = $4", name, date, capacity, eventID)
+	return err
+}
+
+// Ping checks that the database is reachable
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// CheckIntegrity checks that the schema is at the latest migration.
+// Data integrity itself is guarded by PostgreSQL.
+func (r *PostgresRepository) CheckIntegrity(ctx context.Context) error {
+	var current int
+	if err := r.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	if current != len(postgresMigrations) {
+		return fmt.Errorf("schema is at migration %d, want %d", current, len(postgresMigrations))
+	}
+	return nil
+}
+
+// HasUserInfo checks if a user has previously registered with name and email
+func (r *PostgresRepository) HasUserInfo(ctx context.Context, telegramID int) (bool, string, string, error) {
+	query := `
+		SELECT name, email FROM users
+		WHERE telegram_id = $1
+		AND name != ''
+		AND email != ''
+		LIMIT 1
+	`
+	var name, email string
+	err := r.db.QueryRowContext(ctx, query, telegramID).Scan(&name, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, "", "", nil
+		}
+		return false, "", "", err
+	}
+	return true, name, email, nil
+}
+
+// UpdateUserName updates the user's name
+func (r *PostgresRepository) UpdateUserName(ctx context.Context, telegramID int, name string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET name = $1 WHERE telegram_id = $2", name, telegramID)
+	return err
+}
+
+// GetAllRegistrations retrieves all user registrations with event details
+func (r *PostgresRepository) GetAllRegistrations(ctx context.Context) ([]UserRegistrationWithEvent, error) {
+	query := `
+		SELECT u.id, u.telegram_id, u.username, u.name, u.registration_date, u.email, u.event_id, u.registred, u.visited,
+		       e.name, e.date
+		FROM users u
+		JOIN events e ON u.event_id = e.id
+		ORDER BY e.date DESC, u.name ASC
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registrations []UserRegistrationWithEvent
+	for rows.Next() {
+		var reg UserRegistrationWithEvent
+		var regDate sql.NullTime
+		err := rows.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &regDate, &reg.Email, &reg.EventID,
+			&reg.Registred, &reg.Visited, &reg.EventName, &reg.EventDate)
+		if err != nil {
+			return nil, err
+		}
+		reg.RegistrationDate = regDate.Time
+		registrations = append(registrations, reg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+// GetEventRegistrations retrieves the registrations of an event, cancelled ones included, by name
+func (r *PostgresRepository) GetEventRegistrations(ctx context.Context, eventID int) ([]UserRegistration, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited
+		FROM users
+		WHERE event_id = $1
+		ORDER BY name ASC, id ASC
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registrations []UserRegistration
+	for rows.Next() {
+		var reg UserRegistration
+		var regDate sql.NullTime
+		err := rows.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &regDate, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+		if err != nil {
+			return nil, err
+		}
+		reg.RegistrationDate = regDate.Time
+		registrations = append(registrations, reg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+// GetEventCounts returns the check-ins and the waitlist length of every event that has any
+func (r *PostgresRepository) GetEventCounts(ctx context.Context) (map[int]EventCounts, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT event_id, SUM(checked_in), SUM(waitlist) FROM (
+			SELECT event_id, COUNT(*) AS checked_in, 0 AS waitlist FROM users WHERE visited = 1 GROUP BY event_id
+			UNION ALL
+			SELECT event_id, 0, COUNT(*) FROM waitlist GROUP BY event_id
+		) counts GROUP BY event_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]EventCounts)
+	for rows.Next() {
+		var eventID int
+		var c EventCounts
+		if err := rows.Scan(&eventID, &c.CheckedIn, &c.Waitlist); err != nil {
+			return nil, err
+		}
+		counts[eventID] = c
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// AddToWaitlist adds a user to the waitlist for an event
+func (r *PostgresRepository) AddToWaitlist(ctx context.Context, telegramID int, chatID int64, username string, eventID int) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO waitlist (telegram_id, chat_id, username, event_id, joined_date) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (telegram_id, event_id) DO UPDATE SET chat_id = excluded.chat_id, username = excluded.username, joined_date = excluded.joined_date`,
+		telegramID, chatID, username, eventID, time.Now())
+	return err
+}
+
+// RemoveFromWaitlist removes a user from the waitlist for an event
+func (r *PostgresRepository) RemoveFromWaitlist(ctx context.Context, telegramID int, eventID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM waitlist WHERE telegram_id = $1 AND event_id = $2", telegramID, eventID)
+	return err
+}
+
+// GetWaitlistForEvent returns all users in the waitlist for an event
+func (r *PostgresRepository) GetWaitlistForEvent(ctx context.Context, eventID int) ([]WaitlistEntry, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT telegram_id, chat_id, username, event_id, joined_date FROM waitlist WHERE event_id = $1 ORDER BY joined_date ASC, id ASC", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		var entry WaitlistEntry
+		if err := rows.Scan(&entry.TelegramID, &entry.ChatID, &entry.Username, &entry.EventID, &entry.JoinedDate); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// IsUserInWaitlist checks if a user is in the waitlist for an event
+func (r *PostgresRepository) IsUserInWaitlist(ctx context.Context, telegramID int, eventID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM waitlist WHERE telegram_id = $1 AND event_id = $2)", telegramID, eventID).Scan(&exists)
+	return exists, err
+}
+
+// RemoveUserByUsername removes a user from the registrations and the waitlist of an event by username
+// and returns if was registered. The rows of other events are kept, they hold the attendance history.
+func (r *PostgresRepository) RemoveUserByUsername(ctx context.Context, username string, eventID int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var registred int
+	err = tx.QueryRowContext(ctx, "SELECT registred FROM users WHERE username = $1 AND event_id = $2 ORDER BY id LIMIT 1", username, eventID).Scan(&registred)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	wasRegistered := err == nil && registred == 1
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE username = $1 AND event_id = $2", username, eventID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM waitlist WHERE username = $1 AND event_id = $2", username, eventID); err != nil {
+		return false, err
+	}
+	return wasRegistered, tx.Commit()
+}
+
+// GetUserLanguage returns the language chosen by the user, or an empty string if none was chosen
+func (r *PostgresRepository) GetUserLanguage(ctx context.Context, telegramID int) (string, error) {
+	var language sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT language FROM user_settings WHERE telegram_id = $1", telegramID).Scan(&language)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return language.String, nil
+}
+
+// SetUserLanguage stores the language chosen by the user
+func (r *PostgresRepository) SetUserLanguage(ctx context.Context, telegramID int, language string) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO user_settings (telegram_id, language) VALUES ($1, $2) ON CONFLICT (telegram_id) DO UPDATE SET language = excluded.language",
+		telegramID, language)
+	return err
+}
+
+// GetMessageTemplate returns the template overriding a catalog message, or nil if there is none
+func (r *PostgresRepository) GetMessageTemplate(ctx context.Context, key string, language string) (*MessageTemplate, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT key, language, body, updated_by, updated_at FROM message_templates WHERE key = $1 AND language = $2", key, language)
+	var tpl MessageTemplate
+	err := row.Scan(&tpl.Key, &tpl.Language, &tpl.Body, &tpl.UpdatedBy, &tpl.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// GetMessageTemplates returns all saved message templates
+func (r *PostgresRepository) GetMessageTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT key, language, body, updated_by, updated_at FROM message_templates ORDER BY key, language")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []MessageTemplate
+	for rows.Next() {
+		var tpl MessageTemplate
+		if err := rows.Scan(&tpl.Key, &tpl.Language, &tpl.Body, &tpl.UpdatedBy, &tpl.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, tpl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// SaveMessageTemplate creates or replaces a message template
+func (r *PostgresRepository) SaveMessageTemplate(ctx context.Context, tpl MessageTemplate) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO message_templates (key, language, body, updated_by, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key, language) DO UPDATE SET body = excluded.body, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		tpl.Key, tpl.Language, tpl.Body, tpl.UpdatedBy, tpl.UpdatedAt)
+	return err
+}
+
+// DeleteMessageTemplate removes a message template so the catalog text is used again
+func (r *PostgresRepository) DeleteMessageTemplate(ctx context.Context, key string, language string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM message_templates WHERE key = $1 AND language = $2", key, language)
+	return err
+}
+
+// GetUserRole returns the role of a user, or an empty string if the user has none
+func (r *PostgresRepository) GetUserRole(ctx context.Context, telegramID int) (string, error) {
+	var role string
+	err := r.db.QueryRowContext(ctx, "SELECT role FROM roles WHERE telegram_id = $1", telegramID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// GetUserRoles returns all granted roles
+func (r *PostgresRepository) GetUserRoles(ctx context.Context) ([]UserRole, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT telegram_id, username, role, granted_by, granted_at FROM roles ORDER BY role, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []UserRole
+	for rows.Next() {
+		var role UserRole
+		if err := rows.Scan(&role.TelegramID, &role.Username, &role.Role, &role.GrantedBy, &role.GrantedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// SetUserRole grants a role to a user, replacing the previous one
+func (r *PostgresRepository) SetUserRole(ctx context.Context, role UserRole) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO roles (telegram_id, username, role, granted_by, granted_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (telegram_id) DO UPDATE SET username = excluded.username, role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+		role.TelegramID, role.Username, role.Role, role.GrantedBy, role.GrantedAt)
+	return err
+}
+
+// RemoveUserRole revokes the role of a user
+func (r *PostgresRepository) RemoveUserRole(ctx context.Context, telegramID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM roles WHERE telegram_id = $1", telegramID)
+	return err
+}
+
+// FindTelegramIDByUsername looks up the Telegram ID of a user who has interacted with the bot.
+// Returns 0 if the username is unknown.
+func (r *PostgresRepository) FindTelegramIDByUsername(ctx context.Context, username string) (int, error) {
+	query := `
+		SELECT telegram_id FROM (
+			SELECT telegram_id, username FROM users
+			UNION ALL SELECT telegram_id, username FROM waitlist
+			UNION ALL SELECT telegram_id, username FROM roles
+		) known
+		WHERE LOWER(username) = LOWER($1) AND telegram_id <> 0
+		LIMIT 1
+	`
+	var telegramID int
+	err := r.db.QueryRowContext(ctx, query, username).Scan(&telegramID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return telegramID, nil
+}
+
+// AddAuditEntry appends an entry to the audit log
+func (r *PostgresRepository) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO audit_log (created_at, action, actor_id, actor_username, target_id, target_username, event_id, details) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		entry.CreatedAt.UTC(), entry.Action, entry.ActorID, entry.ActorUsername, entry.TargetID, entry.TargetUsername, entry.EventID, entry.Details)
+	return err
+}
+
+// GetAuditLog returns the newest audit log entries matching the filter, newest first
+func (r *PostgresRepository) GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = "+arg(filter.Action))
+	}
+	if filter.UserID != 0 {
+		p := arg(filter.UserID)
+		conditions = append(conditions, "(actor_id = "+p+" OR target_id = "+p+")")
+	}
+	if filter.Username != "" {
+		p := arg(filter.Username)
+		conditions = append(conditions, "(LOWER(actor_username) = LOWER("+p+") OR LOWER(target_username) = LOWER("+p+"))")
+	}
+	if filter.EventID != 0 {
+		conditions = append(conditions, "event_id = "+arg(filter.EventID))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(filter.Since.UTC()))
+	}
+
+	query := "SELECT id, created_at, action, actor_id, actor_username, target_id, target_username, event_id, details FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.Action, &entry.ActorID, &entry.ActorUsername,
+			&entry.TargetID, &entry.TargetUsername, &entry.EventID, &entry.Details)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// IsUserBanned checks if a user is banned
+func (r *PostgresRepository) IsUserBanned(ctx context.Context, telegramID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM banned_users WHERE telegram_id = $1)", telegramID).Scan(&exists)
+	return exists, err
+}
+
+// BanUser bans a user, replacing a previous ban
+func (r *PostgresRepository) BanUser(ctx context.Context, ban BannedUser) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO banned_users (telegram_id, username, reason, banned_by, banned_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (telegram_id) DO UPDATE SET username = excluded.username, reason = excluded.reason, banned_by = excluded.banned_by, banned_at = excluded.banned_at`,
+		ban.TelegramID, ban.Username, ban.Reason, ban.BannedBy, ban.BannedAt)
+	return err
+}
+
+// UnbanUser lifts the ban of a user
+func (r *PostgresRepository) UnbanUser(ctx context.Context, telegramID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM banned_users WHERE telegram_id = $1", telegramID)
+	return err
+}
+
+// AddAPIKey stores a new API key and returns its ID
+func (r *PostgresRepository) AddAPIKey(ctx context.Context, key APIKey) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, "INSERT INTO api_keys (name, key_hash, scope, created_by, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		key.Name, key.Hash, key.Scope, key.CreatedBy, key.CreatedAt).Scan(&id)
+	return id, err
+}
+
+// GetAPIKeyByHash returns the API key with the hash, revoked or not, or nil if there is none
+func (r *PostgresRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, name, key_hash, scope, created_by, created_at, revoked_at FROM api_keys WHERE key_hash = $1", hash)
+	key, err := scanPostgresAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKeys returns all API keys, oldest first
+func (r *PostgresRepository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, key_hash, scope, created_by, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanPostgresAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// scanPostgresAPIKey reads an api_keys row, revoked_at is NULL for active keys
+func scanPostgresAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Hash, &key.Scope, &key.CreatedBy, &key.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.RevokedAt = revokedAt.Time
+	return &key, nil
+}
+
+// RevokeAPIKey revokes an API key, a key that is already revoked keeps its revocation time
+func (r *PostgresRepository) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at, id)
+	return err
+}
+
+// AddWebhookDelivery queues a webhook delivery and returns its ID
+func (r *PostgresRepository) AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, "INSERT INTO webhook_deliveries (url, type, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
+		delivery.URL, delivery.Type, delivery.Payload, delivery.Status, delivery.Attempts, delivery.NextAttemptAt,
+		delivery.LastError, delivery.CreatedAt, delivery.UpdatedAt).Scan(&id)
+	return id, err
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries due at now, oldest first
+func (r *PostgresRepository) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= $1 ORDER BY id LIMIT $2", now, limit)
+}
+
+// UpdateWebhookDelivery saves the outcome of a failed attempt
+func (r *PostgresRepository) UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, updated_at = $5 WHERE id = $6",
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastError, delivery.UpdatedAt, delivery.ID)
+	return err
+}
+
+// DeleteWebhookDelivery removes a delivery from the queue
+func (r *PostgresRepository) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE id = $1", id)
+	return err
+}
+
+// GetWebhookFailures returns up to limit deliveries with a failed attempt, pending or failed, most recently attempted first
+func (r *PostgresRepository) GetWebhookFailures(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE attempts > 0 ORDER BY updated_at DESC, id DESC LIMIT $1", limit)
+}
+
+// queryWebhookDeliveries runs a query for webhook_deliveries rows
+func (r *PostgresRepository) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.URL, &d.Type, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ImportRegistrations adds imported registrations in a single transaction, nothing is added if any row fails.
+// Events with a zero EventID are created as past events with a capacity of their registered rows.
+func (r *PostgresRepository) ImportRegistrations(ctx context.Context, imports []RegistrationImport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, imp := range imports {
+		registered := countRegistered(imp.Registrations)
+		eventID := imp.EventID
+		if eventID == 0 {
+			err := tx.QueryRowContext(ctx, "INSERT INTO events (name, date, capacity, state) VALUES ($1, $2, $3, 'past') RETURNING id",
+				imp.EventName, imp.EventDate, registered).Scan(&eventID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, reg := range imp.Registrations {
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+				reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate, reg.Email, eventID, reg.Registred, reg.Visited)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE events SET registration_count = registration_count + $1 WHERE id = $2", registered, eventID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimImportedRegistrations links the imported registrations of a username without a Telegram ID to the user.
+// Rows with an email are skipped: a username can change hands, and the new owner must not get the email of the old one.
+// Events the user already has a registration for are skipped too. Returns the number of linked registrations.
+func (r *PostgresRepository) ClaimImportedRegistrations(ctx context.Context, telegramID int, username string) (int, error) {
+	if username == "" {
+		return 0, nil
+	}
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET telegram_id = $1
+		WHERE telegram_id = 0 AND LOWER(username) = LOWER($2) AND email = ''
+			AND event_id NOT IN (SELECT event_id FROM users WHERE telegram_id = $1)
+	`, telegramID, username)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Layout of the badges: a grid of 90×55 mm cards centred on the A4 page
+const (
+	badgeColumns = 2
+	badgeRows    = 5
+	badgeWidth   = 90 * pdfMM
+	badgeHeight  = 55 * pdfMM
+	badgePadding = 5 * pdfMM
+	badgeQRSize  = 35 * pdfMM
+)
+
+// Layout of the attendee list
+const (
+	listMargin    = 40.0
+	listRowHeight = 22.0
+	listFontSize  = 11.0
+)
+
+// printAttendee is a registered attendee on the printouts of an event
+type printAttendee struct {
+	TelegramID int
+	Username   string
+	Name       string
+}
+
+// label returns the name of the attendee, or the username when they didn't give one
+func (a printAttendee) label() string {
+	switch {
+	case a.Name != "":
+		return a.Name
+	case a.Username != "":
+		return "@" + a.Username
+	}
+	return "ID " + strconv.Itoa(a.TelegramID)
+}
+
+// contactURL returns the link encoded in the QR code of the badge, it opens a chat with the attendee
+func (a printAttendee) contactURL() string {
+	if a.Username != "" {
+		return "https://t.me/" + a.Username
+	}
+	return "tg://user?id=" + strconv.Itoa(a.TelegramID)
+}
+
+// printAttendees returns the registered attendees of an event sorted by name.
+// Names are entered as "Surname Name", so this sorts them by surname.
+func printAttendees(ctx context.Context, db Repository, eventID int) ([]printAttendee, error) {
+	registrations, err := db.GetAllRegistrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var attendees []printAttendee
+	for _, reg := range registrations {
+		if reg.EventID == eventID && reg.Registred == 1 {
+			attendees = append(attendees, printAttendee{TelegramID: reg.TelegramID, Username: reg.Username, Name: reg.Name})
+		}
+	}
+	slices.SortFunc(attendees, func(a, b printAttendee) int {
+		key := func(a printAttendee) string { return strings.ToLower(strings.TrimPrefix(a.label(), "@")) }
+		return cmp.Or(cmp.Compare(key(a), key(b)), cmp.Compare(a.TelegramID, b.TelegramID))
+	})
+	return attendees, nil
+}
+
+// writeAttendeeList writes the check-in list of an event as a PDF: a numbered table
+// of the attendees with an empty box to tick for everyone who came
+func writeAttendeeList(w io.Writer, font *ttfFont, lang string, event *Event, attendees []printAttendee) error {
+	doc := newPDFDocument(font)
+	top := pdfPageHeight - listMargin
+	width := pdfPageWidth - 2*listMargin
+	usernameX := listMargin + width*0.65
+
+	doc.addPage()
+	title := event.name
+	doc.text(listMargin, top-16, doc.fitText(title, 16, width), title)
+	doc.text(listMargin, top-34, 10, event.date.Format("02.01.2006 15:04")+" · "+N(lang, "print_registered", len(attendees)))
+
+	y := top - 60
+	header := func() {
+		doc.gray(0.4)
+		doc.text(listMargin+50, y, 9, T(lang, "export_column_name"))
+		doc.text(usernameX, y, 9, T(lang, "export_column_username"))
+		doc.line(listMargin, y-6, listMargin+width, y-6, 0.5)
+		doc.gray(0)
+		y -= listRowHeight
+	}
+	header()
+	for i, attendee := range attendees {
+		if y < listMargin+listRowHeight {
+			doc.addPage()
+			y = top - 10
+			header()
+		}
+		doc.rect(listMargin, y-2, 11, 11, 0.8)
+		number := strconv.Itoa(i+1) + "."
+		doc.text(listMargin+42-doc.textWidth(number, listFontSize), y, listFontSize, number)
+		name := attendee.label()
+		doc.text(listMargin+50, y, doc.fitText(name, listFontSize, usernameX-listMargin-60), name)
+		if attendee.Username != "" && attendee.Name != "" {
+			doc.text(usernameX, y, doc.fitText("@"+attendee.Username, listFontSize, listMargin+width-usernameX), "@"+attendee.Username)
+		}
+		doc.gray(0.85)
+		doc.line(listMargin, y-7, listMargin+width, y-7, 0.5)
+		doc.gray(0)
+		y -= listRowHeight
+	}
+
+	for i := range doc.pages {
+		doc.selectPage(i)
+		footer := fmt.Sprintf("%d / %d", i+1, len(doc.pages))
+		doc.text(pdfPageWidth-listMargin-doc.textWidth(footer, 9), listMargin/2, 9, footer)
+	}
+	return doc.write(w)
+}
+
+// writeBadges writes the name badges of the attendees as a PDF with ten badges on
+// every A4 page. A badge has the name, the surname below it in a smaller size and
+// a QR code that opens a chat with the attendee, the grey frame is the line to cut.
+func writeBadges(w io.Writer, font *ttfFont, event *Event, attendees []printAttendee) error {
+	doc := newPDFDocument(font)
+	marginX := (pdfPageWidth - badgeColumns*badgeWidth) / 2
+	marginY := (pdfPageHeight - badgeRows*badgeHeight) / 2
+	textWidth := badgeWidth - badgeQRSize - 3*badgePadding
+
+	for i, attendee := range attendees {
+		slot := i % (badgeColumns * badgeRows)
+		if slot == 0 {
+			doc.addPage()
+		}
+		x := marginX + float64(slot%badgeColumns)*badgeWidth
+		y := pdfPageHeight - marginY - float64(slot/badgeColumns+1)*badgeHeight
+
+		doc.gray(0.75)
+		doc.rect(x, y, badgeWidth, badgeHeight, 0.5)
+		doc.gray(0.4)
+		doc.text(x+badgePadding, y+badgeHeight-badgePadding-8, doc.fitText(event.name, 8, textWidth), event.name)
+		doc.gray(0)
+
+		// "Surname Name" is shown as the name in large type and the surname below it
+		name, surname := attendee.label(), ""
+		if fields := strings.Fields(attendee.Name); len(fields) > 1 {
+			name, surname = strings.Join(fields[1:], " "), fields[0]
+		}
+		baseline := y + badgeHeight/2 + 2
+		doc.text(x+badgePadding, baseline, doc.fitText(name, 20, textWidth), name)
+		if surname != "" {
+			doc.text(x+badgePadding, baseline-20, doc.fitText(surname, 14, textWidth), surname)
+		}
+		if attendee.Username != "" && attendee.Name != "" {
+			doc.gray(0.4)
+			doc.text(x+badgePadding, y+badgePadding, doc.fitText("@"+attendee.Username, 9, textWidth), "@"+attendee.Username)
+			doc.gray(0)
+		}
+
+		qr, err := qrcode.New(attendee.contactURL(), qrcode.Medium)
+		if err != nil {
+			return err
+		}
+		qr.DisableBorder = true
+		doc.bitmap(x+badgeWidth-badgePadding-badgeQRSize, y+(badgeHeight-badgeQRSize)/2, badgeQRSize, qr.Bitmap())
+	}
+	return doc.write(w)
+}
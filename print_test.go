@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// checkPDF checks that the cross-reference table of a PDF points at its objects
+func checkPDF(t *testing.T, data []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %.40q", data)
+	}
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d points at %.20q", xref, data[xref:])
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(data[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("object %d is at %.20q", i+1, data[offset:])
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	// Without a font the documents are set in Helvetica, whatever fonts the system has
+	AppConfig.PDFFont = filepath.Join(t.TempDir(), "missing.ttf")
+	db := NewMemoryRepository()
+
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/print"}.update(1))
+	if texts := sender.texts(); len(texts) != 1 || texts[0] != T("en", "no_active_event") {
+		t.Errorf("/print without an event = %q", texts)
+	}
+
+	mustNoError(t, db.AddEvent(ctx, "Go «Meetup»", time.Now().AddDate(0, 0, 7), 30))
+	for _, reg := range []UserRegistration{
+		{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: 1, Registred: 1},
+		{TelegramID: 2, Username: "anna", Name: "Абрамова Анна", EventID: 1, Registred: 1},
+		{TelegramID: 4, Username: "zed", EventID: 1, Registred: 1},
+		{TelegramID: 5, Username: "carl", Name: "Cancelled Carl", EventID: 1, Registred: 0},
+	} {
+		mustNoError(t, db.RegisterUser(ctx, reg))
+	}
+
+	sender.reset()
+	Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/print"}.update(2))
+	var documents []tgbotapi.FileBytes
+	for _, sent := range sender.other {
+		if doc, ok := sent.(tgbotapi.DocumentConfig); ok {
+			documents = append(documents, doc.File.(tgbotapi.FileBytes))
+		}
+	}
+	if len(documents) != 2 || documents[0].Name != "attendees_1.pdf" || documents[1].Name != "badges_1.pdf" {
+		t.Fatalf("/print sent %+v, messages %q", sender.other, sender.texts())
+	}
+
+	list := string(documents[0].Bytes)
+	checkPDF(t, documents[0].Bytes)
+	last := -1
+	for _, want := range []string{`(Go "Meetup") Tj`, "3 attendees) Tj", "(Ivanov Ivan) Tj", "(@zed) Tj", "(Abramova Anna) Tj", "(@anna) Tj", "(1 / 1) Tj"} {
+		i := strings.Index(list, want)
+		if i < 0 || i < last {
+			t.Errorf("attendee list has no %s after the previous line", want)
+		}
+		last = i
+	}
+	if strings.Contains(list, "Carl") {
+		t.Error("attendee list has a cancelled registration")
+	}
+
+	badges := string(documents[1].Bytes)
+	checkPDF(t, documents[1].Bytes)
+	for _, want := range []string{"(Ivan) Tj", "(Ivanov) Tj", "(Anna) Tj", "(Abramova) Tj", "(@zed) Tj", " re\nf\n"} {
+		if !strings.Contains(badges, want) {
+			t.Errorf("badges have no %q", want)
+		}
+	}
+}
+
+func TestPDFLatin(t *testing.T) {
+	for in, want := range map[string]string{
+		"Щукин Ёжик":        "Shchukin Ezhik",
+		"Подъячев Юрий":     "Podyachev Yuriy",
+		"Chloé (a\\b) — №1": "Chlo? (a\\b) - No1",
+	} {
+		if got := pdfLatin(in); got != want {
+			t.Errorf("pdfLatin(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// testTTF builds a TrueType font with the letters A, B and Я and without outlines
+func testTTF() []byte {
+	be := binary.BigEndian
+	head := make([]byte, 54)
+	be.PutUint16(head[18:], 1000)
+	for i, v := range []int16{-100, -200, 900, 800} {
+		be.PutUint16(head[36+2*i:], uint16(v))
+	}
+	hhea := make([]byte, 36)
+	be.PutUint16(hhea[4:], 800)
+	be.PutUint16(hhea[6:], uint16(0xFFFF-199)) // -200
+	be.PutUint16(hhea[34:], 2)
+	maxp := make([]byte, 6)
+	be.PutUint16(maxp[4:], 4)
+	hmtx := []byte{0x01, 0xF4, 0, 0, 0x02, 0x58, 0, 0} // 500 and 600 for the rest
+
+	// Segments A-B with a delta, Я through the glyph array, and the final one
+	segments := []struct{ start, end, delta, rangeOffset uint16 }{
+		{'A', 'B', 0x10000 + 1 - 'A', 0},
+		{'Я', 'Я', 0, 4},
+		{0xFFFF, 0xFFFF, 1, 0},
+	}
+	sub := make([]byte, 16+8*len(segments)+2)
+	be.PutUint16(sub, 4)
+	be.PutUint16(sub[2:], uint16(len(sub)))
+	be.PutUint16(sub[6:], uint16(2*len(segments)))
+	for i, s := range segments {
+		be.PutUint16(sub[14+2*i:], s.end)
+		be.PutUint16(sub[16+2*len(segments)+2*i:], s.start)
+		be.PutUint16(sub[16+4*len(segments)+2*i:], s.delta)
+		be.PutUint16(sub[16+6*len(segments)+2*i:], s.rangeOffset)
+	}
+	be.PutUint16(sub[16+8*len(segments):], 3)
+	cmap := append([]byte{0, 0, 0, 1, 0, 3, 0, 1, 0, 0, 0, 12}, sub...)
+
+	name := []byte{0, 0, 0, 1, 0, 18, 0, 1, 0, 0, 0, 0, 0, 6, 0, 8, 0, 0}
+	name = append(name, "Test(1)"...)
+	name = append(name, 0)
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"glyf", []byte{0, 0, 0, 0}}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}, {"maxp", maxp}, {"name", name}}
+	font := []byte{0, 1, 0, 0, 0, byte(len(tables)), 0, 0, 0, 0, 0, 0}
+	offset := len(font) + 16*len(tables)
+	var data []byte
+	for _, table := range tables {
+		record := make([]byte, 16)
+		copy(record, table.tag)
+		be.PutUint32(record[8:], uint32(offset+len(data)))
+		be.PutUint32(record[12:], uint32(len(table.data)))
+		font = append(font, record...)
+		data = append(data, table.data...)
+	}
+	return append(font, data...)
+}
+
+func TestParseTTF(t *testing.T) {
+	font, err := parseTTF(testTTF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if font.name != "Test1" || font.glyphs['A'] != 1 || font.glyphs['B'] != 2 || font.glyphs['Я'] != 3 || font.glyphs['C'] != 0 {
+		t.Errorf("font = %s, glyphs %v", font.name, font.glyphs)
+	}
+	if _, err := parseTTF([]byte("OTTO\x00\x01\x00\x00\x00\x00\x00\x00")); err == nil {
+		t.Error("parseTTF accepted a CFF font")
+	}
+
+	doc := newPDFDocument(font)
+	doc.addPage()
+	if width := doc.textWidth("AЯ", 10); width != 12 {
+		t.Errorf("width = %v, want 12", width)
+	}
+	doc.text(10, 10, 10, "AЯ")
+	var buf bytes.Buffer
+	mustNoError(t, doc.write(&buf))
+	checkPDF(t, buf.Bytes())
+	for _, want := range []string{"<00010003> Tj", "/FontFile2", "/BaseFont /Test1", "/W [ 1 [600] 3 [600] ]", "<0003> <042F>"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PDF has no %q", want)
+		}
+	}
+}
@@ -1,37 +1,92 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 )
 
 // Repository defines the interface for database operations
 type Repository interface {
-	CreateTables() error
-	GetLatestEvent() (*Event, error)
-	RegisterUser(reg UserRegistration) error
-	UpdateUserEmail(telegramID int, email string) error
-	UpdateEventRegistrationCount(eventID int) error
-	RemoveRegistration(telegramID int, eventID int) error
-	DecrementEventRegistrationCount(eventID int) error
-	IsUserRegistered(telegramID int, eventID int) (bool, *UserRegistration, error)
-	UpdateVisitedStatus(telegramID int, eventID int, visited int) error
-	UpdateRegistration(reg UserRegistration) error
-	MarkEventsAsPast() error
-	AddEvent(name string, date time.Time, capacity int) error
-	GetAllRegistrations() ([]UserRegistrationWithEvent, error)
-	HasUserInfo(telegramID int) (bool, string, string, error)
-	UpdateUserName(telegramID int, name string) error
+	CreateTables(ctx context.Context) error
+	GetLatestEvent(ctx context.Context) (*Event, error)
+	GetEvents(ctx context.Context) ([]Event, error)
+	RegisterUser(ctx context.Context, reg UserRegistration) error
+	UpdateUserEmail(ctx context.Context, telegramID int, email string) error
+	UpdateEventRegistrationCount(ctx context.Context, eventID int) error
+	RemoveRegistration(ctx context.Context, telegramID int, eventID int) error
+	DecrementEventRegistrationCount(ctx context.Context, eventID int) error
+	IsUserRegistered(ctx context.Context, telegramID int, eventID int) (bool, *UserRegistration, error)
+	UpdateVisitedStatus(ctx context.Context, telegramID int, eventID int, visited int) error
+	GetRegistrationByID(ctx context.Context, id int) (*UserRegistration, error)
+	CancelRegistrationByID(ctx context.Context, id int) error
+	UpdateVisitedStatusByID(ctx context.Context, id int, visited int) error
+	AddWalkIn(ctx context.Context, reg UserRegistration) (int, error)
+	UpdateRegistration(ctx context.Context, reg UserRegistration) error
+	MarkEventsAsPast(ctx context.Context) error
+	AddEvent(ctx context.Context, name string, date time.Time, capacity int) error
+	UpdateEvent(ctx context.Context, eventID int, name string, date time.Time, capacity int) error
+	GetAllRegistrations(ctx context.Context) ([]UserRegistrationWithEvent, error)
+	GetEventRegistrations(ctx context.Context, eventID int) ([]UserRegistration, error)
+	GetEventCounts(ctx context.Context) (map[int]EventCounts, error)
+	HasUserInfo(ctx context.Context, telegramID int) (bool, string, string, error)
+	UpdateUserName(ctx context.Context, telegramID int, name string) error
 	// Waitlist methods
-	AddToWaitlist(telegramID int, chatID int64, username string, eventID int) error
-	RemoveFromWaitlist(telegramID int, eventID int) error
-	GetWaitlistForEvent(eventID int) ([]WaitlistEntry, error)
-	IsUserInWaitlist(telegramID int, eventID int) (bool, error)
+	AddToWaitlist(ctx context.Context, telegramID int, chatID int64, username string, eventID int) error
+	RemoveFromWaitlist(ctx context.Context, telegramID int, eventID int) error
+	GetWaitlistForEvent(ctx context.Context, eventID int) ([]WaitlistEntry, error)
+	IsUserInWaitlist(ctx context.Context, telegramID int, eventID int) (bool, error)
 	// Admin methods
-	RemoveUserByUsername(username string, eventID int) (bool, error)
-	// Add method for SQL statement preparation
-	Prepare(query string) (*sql.Stmt, error)
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	RemoveUserByUsername(ctx context.Context, username string, eventID int) (bool, error)
+	// User settings methods
+	GetUserLanguage(ctx context.Context, telegramID int) (string, error)
+	SetUserLanguage(ctx context.Context, telegramID int, language string) error
+	// Message template methods
+	GetMessageTemplate(ctx context.Context, key string, language string) (*MessageTemplate, error)
+	GetMessageTemplates(ctx context.Context) ([]MessageTemplate, error)
+	SaveMessageTemplate(ctx context.Context, tpl MessageTemplate) error
+	DeleteMessageTemplate(ctx context.Context, key string, language string) error
+	// Role methods
+	GetUserRole(ctx context.Context, telegramID int) (string, error)
+	GetUserRoles(ctx context.Context) ([]UserRole, error)
+	SetUserRole(ctx context.Context, role UserRole) error
+	RemoveUserRole(ctx context.Context, telegramID int) error
+	FindTelegramIDByUsername(ctx context.Context, username string) (int, error)
+	// Audit log methods
+	AddAuditEntry(ctx context.Context, entry AuditEntry) error
+	GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+	// Ban methods
+	IsUserBanned(ctx context.Context, telegramID int) (bool, error)
+	BanUser(ctx context.Context, ban BannedUser) error
+	UnbanUser(ctx context.Context, telegramID int) error
+	// API key methods
+	AddAPIKey(ctx context.Context, key APIKey) (int64, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64, at time.Time) error
+	// Webhook delivery queue methods
+	AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) (int64, error)
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
+	DeleteWebhookDelivery(ctx context.Context, id int64) error
+	GetWebhookFailures(ctx context.Context, limit int) ([]WebhookDelivery, error)
+	// Import methods
+	ImportRegistrations(ctx context.Context, imports []RegistrationImport) error
+	ClaimImportedRegistrations(ctx context.Context, telegramID int, username string) (int, error)
+	// Health methods
+	Ping(ctx context.Context) error
+}
+
+// Backuper is implemented by repositories that can copy their database into a file
+type Backuper interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// IntegrityChecker is implemented by repositories that can check their storage for corruption
+type IntegrityChecker interface {
+	CheckIntegrity(ctx context.Context) error
 }
 
 // SQLiteRepository implements the Repository interface
@@ -45,7 +100,7 @@ func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
 }
 
 // CreateTables creates the necessary tables for users and events
-func (r *SQLiteRepository) CreateTables() error {
+func (r *SQLiteRepository) CreateTables(ctx context.Context) error {
 	userTable := `CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		telegram_id INTEGER,
@@ -77,24 +132,124 @@ func (r *SQLiteRepository) CreateTables() error {
 		UNIQUE(telegram_id, event_id)
 	);`
 
-	if _, err := r.db.Exec(userTable); err != nil {
+	userSettingsTable := `CREATE TABLE IF NOT EXISTS user_settings (
+		telegram_id INTEGER PRIMARY KEY,
+		language TEXT
+	);`
+
+	messageTemplatesTable := `CREATE TABLE IF NOT EXISTS message_templates (
+		key TEXT,
+		language TEXT,
+		body TEXT,
+		updated_by TEXT,
+		updated_at DATETIME,
+		PRIMARY KEY(key, language)
+	);`
+
+	rolesTable := `CREATE TABLE IF NOT EXISTS roles (
+		telegram_id INTEGER PRIMARY KEY,
+		username TEXT,
+		role TEXT,
+		granted_by INTEGER,
+		granted_at DATETIME
+	);`
+
+	auditLogTable := `CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME,
+		action TEXT,
+		actor_id INTEGER,
+		actor_username TEXT,
+		target_id INTEGER,
+		target_username TEXT,
+		event_id INTEGER,
+		details TEXT
+	);`
+
+	bannedUsersTable := `CREATE TABLE IF NOT EXISTS banned_users (
+		telegram_id INTEGER PRIMARY KEY,
+		username TEXT,
+		reason TEXT,
+		banned_by INTEGER,
+		banned_at DATETIME
+	);`
+
+	apiKeysTable := `CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		key_hash TEXT UNIQUE,
+		scope TEXT,
+		created_by TEXT,
+		created_at DATETIME,
+		revoked_at DATETIME DEFAULT ''
+	);`
+
+	webhookDeliveriesTable := `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT,
+		type TEXT,
+		payload TEXT,
+		status TEXT,
+		attempts INTEGER DEFAULT 0,
+		next_attempt_at DATETIME,
+		last_error TEXT DEFAULT '',
+		created_at DATETIME,
+		updated_at DATETIME
+	);`
+
+	// The audit log is append-only: reject any attempt to change or delete entries
+	auditLogTriggers := `
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;`
+
+	if _, err := r.db.ExecContext(ctx, userTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, eventTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, waitlistTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, userSettingsTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, messageTemplatesTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, rolesTable); err != nil {
 		return err
 	}
-	if _, err := r.db.Exec(eventTable); err != nil {
+	if _, err := r.db.ExecContext(ctx, auditLogTable); err != nil {
 		return err
 	}
-	if _, err := r.db.Exec(waitlistTable); err != nil {
+	if _, err := r.db.ExecContext(ctx, auditLogTriggers); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, bannedUsersTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, apiKeysTable); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, webhookDeliveriesTable); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetLatestEvent returns the latest active event
-func (r *SQLiteRepository) GetLatestEvent() (*Event, error) {
-	row := r.db.QueryRow("SELECT id, name, date, capacity, registration_count FROM events WHERE state = 'active' ORDER BY date DESC LIMIT 1")
+func (r *SQLiteRepository) GetLatestEvent(ctx context.Context) (*Event, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, name, date, capacity, registration_count, state FROM events WHERE state = 'active' ORDER BY date DESC LIMIT 1")
 	var ev Event
 	var dateStr string
-	err := row.Scan(&ev.id, &ev.name, &dateStr, &ev.capacity, &ev.registrationCount)
+	err := row.Scan(&ev.id, &ev.name, &dateStr, &ev.capacity, &ev.registrationCount, &ev.state)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -105,11 +260,37 @@ func (r *SQLiteRepository) GetLatestEvent() (*Event, error) {
 	return &ev, nil
 }
 
+// GetEvents returns all events, newest first
+func (r *SQLiteRepository) GetEvents(ctx context.Context) ([]Event, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, date, capacity, registration_count, state FROM events ORDER BY date DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		var dateStr string
+		if err := rows.Scan(&ev.id, &ev.name, &dateStr, &ev.capacity, &ev.registrationCount, &ev.state); err != nil {
+			return nil, err
+		}
+		ev.date, _ = time.Parse(time.RFC3339, dateStr)
+		events = append(events, ev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
 // RegisterUser saves the user registration data or updates existing unregistered user
-func (r *SQLiteRepository) RegisterUser(reg UserRegistration) error {
+func (r *SQLiteRepository) RegisterUser(ctx context.Context, reg UserRegistration) error {
 	// Check if user exists but is unregistered
 	var count int
-	err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE telegram_id = ? AND event_id = ? AND registred = 0",
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE telegram_id = ? AND event_id = ? AND registred = 0",
 		reg.TelegramID, reg.EventID).Scan(&count)
 	if err != nil {
 		return err
@@ -117,75 +298,75 @@ func (r *SQLiteRepository) RegisterUser(reg UserRegistration) error {
 
 	if count > 0 {
 		// User exists but is unregistered, update their registration status
-		stmt, err := r.db.Prepare("UPDATE users SET username = ?, name = ?, registration_date = ?, email = ?, registred = 1 WHERE telegram_id = ? AND event_id = ?")
+		stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET username = ?, name = ?, registration_date = ?, email = ?, registred = 1 WHERE telegram_id = ? AND event_id = ?")
 		if err != nil {
 			return err
 		}
 		defer stmt.Close()
-		_, err = stmt.Exec(reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.TelegramID, reg.EventID)
+		_, err = stmt.ExecContext(ctx, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.TelegramID, reg.EventID)
 		return err
 	}
 
 	// User doesn't exist, insert new record
-	stmt, err := r.db.Prepare("INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := r.db.PrepareContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.EventID, reg.Registred, reg.Visited)
+	_, err = stmt.ExecContext(ctx, reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.EventID, reg.Registred, reg.Visited)
 	return err
 }
 
 // UpdateUserEmail updates the user's email
-func (r *SQLiteRepository) UpdateUserEmail(telegramID int, email string) error {
-	stmt, err := r.db.Prepare("UPDATE users SET email = ? WHERE telegram_id = ?")
+func (r *SQLiteRepository) UpdateUserEmail(ctx context.Context, telegramID int, email string) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET email = ? WHERE telegram_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(email, telegramID)
+	_, err = stmt.ExecContext(ctx, email, telegramID)
 	return err
 }
 
 // UpdateEventRegistrationCount increments the registration count for an event
-func (r *SQLiteRepository) UpdateEventRegistrationCount(eventID int) error {
-	stmt, err := r.db.Prepare("UPDATE events SET registration_count = registration_count + 1 WHERE id = ?")
+func (r *SQLiteRepository) UpdateEventRegistrationCount(ctx context.Context, eventID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE events SET registration_count = registration_count + 1 WHERE id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(eventID)
+	_, err = stmt.ExecContext(ctx, eventID)
 	return err
 }
 
 // RemoveRegistration updates a user's registration status to unregistered
-func (r *SQLiteRepository) RemoveRegistration(telegramID int, eventID int) error {
-	stmt, err := r.db.Prepare("UPDATE users SET registred = 0 WHERE telegram_id = ? AND event_id = ?")
+func (r *SQLiteRepository) RemoveRegistration(ctx context.Context, telegramID int, eventID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET registred = 0 WHERE telegram_id = ? AND event_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(telegramID, eventID)
+	_, err = stmt.ExecContext(ctx, telegramID, eventID)
 	return err
 }
 
 // DecrementEventRegistrationCount decreases the registration count for an event
-func (r *SQLiteRepository) DecrementEventRegistrationCount(eventID int) error {
-	stmt, err := r.db.Prepare("UPDATE events SET registration_count = registration_count - 1 WHERE id = ? AND registration_count > 0")
+func (r *SQLiteRepository) DecrementEventRegistrationCount(ctx context.Context, eventID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE events SET registration_count = registration_count - 1 WHERE id = ? AND registration_count > 0")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(eventID)
+	_, err = stmt.ExecContext(ctx, eventID)
 	return err
 }
 
 // IsUserRegistered checks if a user is registered for an event
-func (r *SQLiteRepository) IsUserRegistered(telegramID int, eventID int) (bool, *UserRegistration, error) {
-	row := r.db.QueryRow("SELECT telegram_id, username, name, registration_date, email, event_id, registred, visited FROM users WHERE telegram_id = ? AND event_id = ?", telegramID, eventID)
+func (r *SQLiteRepository) IsUserRegistered(ctx context.Context, telegramID int, eventID int) (bool, *UserRegistration, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited FROM users WHERE telegram_id = ? AND event_id = ?", telegramID, eventID)
 	var reg UserRegistration
 	var dateStr string
-	err := row.Scan(&reg.TelegramID, &reg.Username, &reg.Name, &dateStr, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+	err := row.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &dateStr, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil, nil
@@ -197,61 +378,143 @@ func (r *SQLiteRepository) IsUserRegistered(telegramID int, eventID int) (bool,
 }
 
 // UpdateVisitedStatus updates a user's visited status for an event
-func (r *SQLiteRepository) UpdateVisitedStatus(telegramID int, eventID int, visited int) error {
-	stmt, err := r.db.Prepare("UPDATE users SET visited = ? WHERE telegram_id = ? AND event_id = ?")
+func (r *SQLiteRepository) UpdateVisitedStatus(ctx context.Context, telegramID int, eventID int, visited int) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET visited = ? WHERE telegram_id = ? AND event_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(visited, telegramID, eventID)
+	_, err = stmt.ExecContext(ctx, visited, telegramID, eventID)
+	return err
+}
+
+// GetRegistrationByID returns the registration row with the ID, or nil if there is none
+func (r *SQLiteRepository) GetRegistrationByID(ctx context.Context, id int) (*UserRegistration, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited FROM users WHERE id = ?", id)
+	var reg UserRegistration
+	var dateStr string
+	err := row.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &dateStr, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	reg.RegistrationDate, _ = time.Parse(time.RFC3339, dateStr)
+	return &reg, nil
+}
+
+// CancelRegistrationByID marks the registration row with the ID as not registered.
+// Unlike RemoveUserByUsername of /remove, the row is kept, so an imported row without a Telegram ID
+// keeps its name and check-in in the history of the event.
+func (r *SQLiteRepository) CancelRegistrationByID(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET registred = 0 WHERE id = ?", id)
+	return err
+}
+
+// UpdateVisitedStatusByID updates the visited status of the registration row with the ID
+func (r *SQLiteRepository) UpdateVisitedStatusByID(ctx context.Context, id int, visited int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET visited = ? WHERE id = ?", visited, id)
 	return err
 }
 
+// AddWalkIn adds a row for someone who came without a registration and returns its ID.
+// The row is not a registration, so the registration count of the event stays the same.
+func (r *SQLiteRepository) AddWalkIn(ctx context.Context, reg UserRegistration) (int, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES (?, ?, ?, ?, ?, ?, 0, ?)",
+		reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.EventID, reg.Visited)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
 // UpdateRegistration updates a user's registration for an event
-func (r *SQLiteRepository) UpdateRegistration(reg UserRegistration) error {
-	stmt, err := r.db.Prepare("UPDATE users SET username = ?, name = ?, registration_date = ?, email = ?, registred = ? WHERE telegram_id = ? AND event_id = ?")
+func (r *SQLiteRepository) UpdateRegistration(ctx context.Context, reg UserRegistration) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET username = ?, name = ?, registration_date = ?, email = ?, registred = ? WHERE telegram_id = ? AND event_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.Registred, reg.TelegramID, reg.EventID)
+	_, err = stmt.ExecContext(ctx, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, reg.Registred, reg.TelegramID, reg.EventID)
 	return err
 }
 
 // MarkEventsAsPast updates all active events to past status
-func (r *SQLiteRepository) MarkEventsAsPast() error {
-	stmt, err := r.db.Prepare("UPDATE events SET state = 'past' WHERE state = 'active'")
+func (r *SQLiteRepository) MarkEventsAsPast(ctx context.Context) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE events SET state = 'past' WHERE state = 'active'")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec()
+	_, err = stmt.ExecContext(ctx)
 	return err
 }
 
 // AddEvent adds a new active event
-func (r *SQLiteRepository) AddEvent(name string, date time.Time, capacity int) error {
-	stmt, err := r.db.Prepare("INSERT INTO events (name, date, capacity, state) VALUES (?, ?, ?, 'active')")
+func (r *SQLiteRepository) AddEvent(ctx context.Context, name string, date time.Time, capacity int) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT INTO events (name, date, capacity, state) VALUES (?, ?, ?, 'active')")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(name, date.Format(time.RFC3339), capacity)
+	_, err = stmt.ExecContext(ctx, name, date.Format(time.RFC3339), capacity)
 	return err
 }
 
-// Prepare forwards the prepare statement to the underlying database
-func (r *SQLiteRepository) Prepare(query string) (*sql.Stmt, error) {
-	return r.db.Prepare(query)
+// UpdateEvent changes the name, date and capacity of an event
+func (r *SQLiteRepository) UpdateEvent(ctx context.Context, eventID int, name string, date time.Time, capacity int) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE events SET name = ?, date = ?, capacity = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, name, date.Format(time.RFC3339), capacity, eventID)
+	return err
 }
 
-// Exec forwards the exec statement to the underlying database
-func (r *SQLiteRepository) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return r.db.Exec(query, args...)
+// Ping checks that the database is reachable
+func (r *SQLiteRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// Backup writes a consistent copy of the database to a new file, also while the bot is running
+func (r *SQLiteRepository) Backup(ctx context.Context, path string) error {
+	_, err := r.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// CheckIntegrity runs the SQLite integrity check and returns the problems it finds
+func (r *SQLiteRepository) CheckIntegrity(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 // HasUserInfo checks if a user has previously registered with name and email
-func (r *SQLiteRepository) HasUserInfo(telegramID int) (bool, string, string, error) {
+func (r *SQLiteRepository) HasUserInfo(ctx context.Context, telegramID int) (bool, string, string, error) {
 	query := `
 		SELECT name, email FROM users 
 		WHERE telegram_id = ? 
@@ -260,7 +523,7 @@ func (r *SQLiteRepository) HasUserInfo(telegramID int) (bool, string, string, er
 		LIMIT 1
 	`
 	
-	row := r.db.QueryRow(query, telegramID)
+	row := r.db.QueryRowContext(ctx, query, telegramID)
 	
 	var name, email string
 	err := row.Scan(&name, &email)
@@ -276,27 +539,27 @@ func (r *SQLiteRepository) HasUserInfo(telegramID int) (bool, string, string, er
 }
 
 // UpdateUserName updates the user's name
-func (r *SQLiteRepository) UpdateUserName(telegramID int, name string) error {
-	stmt, err := r.db.Prepare("UPDATE users SET name = ? WHERE telegram_id = ?")
+func (r *SQLiteRepository) UpdateUserName(ctx context.Context, telegramID int, name string) error {
+	stmt, err := r.db.PrepareContext(ctx, "UPDATE users SET name = ? WHERE telegram_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(name, telegramID)
+	_, err = stmt.ExecContext(ctx, name, telegramID)
 	return err
 }
 
 // GetAllRegistrations retrieves all user registrations with event details
-func (r *SQLiteRepository) GetAllRegistrations() ([]UserRegistrationWithEvent, error) {
+func (r *SQLiteRepository) GetAllRegistrations(ctx context.Context) ([]UserRegistrationWithEvent, error) {
 	query := `
-        SELECT u.telegram_id, u.username, u.name, u.registration_date, u.email, u.event_id, u.registred, u.visited,
+        SELECT u.id, u.telegram_id, u.username, u.name, u.registration_date, u.email, u.event_id, u.registred, u.visited,
                e.name, e.date
         FROM users u
         JOIN events e ON u.event_id = e.id
         ORDER BY e.date DESC, u.name ASC
     `
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -310,6 +573,7 @@ func (r *SQLiteRepository) GetAllRegistrations() ([]UserRegistrationWithEvent, e
 		var eventDateStr, eventName sql.NullString
 
 		err := rows.Scan(
+			&reg.ID,
 			&reg.TelegramID,
 			&reg.Username,
 			&reg.Name,
@@ -349,31 +613,94 @@ func (r *SQLiteRepository) GetAllRegistrations() ([]UserRegistrationWithEvent, e
 	return registrations, nil
 }
 
+// GetEventRegistrations retrieves the registrations of an event, cancelled ones included, by name
+func (r *SQLiteRepository) GetEventRegistrations(ctx context.Context, eventID int) ([]UserRegistration, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, telegram_id, username, name, registration_date, email, event_id, registred, visited
+        FROM users
+        WHERE event_id = ?
+        ORDER BY name ASC, id ASC
+    `, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registrations []UserRegistration
+	for rows.Next() {
+		var reg UserRegistration
+		var regDateStr string
+		err := rows.Scan(&reg.ID, &reg.TelegramID, &reg.Username, &reg.Name, &regDateStr, &reg.Email, &reg.EventID, &reg.Registred, &reg.Visited)
+		if err != nil {
+			return nil, err
+		}
+		reg.RegistrationDate, _ = time.Parse(time.RFC3339, regDateStr)
+		registrations = append(registrations, reg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+// GetEventCounts returns the check-ins and the waitlist length of every event that has any
+func (r *SQLiteRepository) GetEventCounts(ctx context.Context) (map[int]EventCounts, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT event_id, SUM(checked_in), SUM(waitlist) FROM (
+            SELECT event_id, COUNT(*) AS checked_in, 0 AS waitlist FROM users WHERE visited = 1 GROUP BY event_id
+            UNION ALL
+            SELECT event_id, 0, COUNT(*) FROM waitlist GROUP BY event_id
+        ) GROUP BY event_id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]EventCounts)
+	for rows.Next() {
+		var eventID int
+		var c EventCounts
+		if err := rows.Scan(&eventID, &c.CheckedIn, &c.Waitlist); err != nil {
+			return nil, err
+		}
+		counts[eventID] = c
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
 // AddToWaitlist adds a user to the waitlist for an event
-func (r *SQLiteRepository) AddToWaitlist(telegramID int, chatID int64, username string, eventID int) error {
-	stmt, err := r.db.Prepare("INSERT OR REPLACE INTO waitlist (telegram_id, chat_id, username, event_id, joined_date) VALUES (?, ?, ?, ?, ?)")
+func (r *SQLiteRepository) AddToWaitlist(ctx context.Context, telegramID int, chatID int64, username string, eventID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT OR REPLACE INTO waitlist (telegram_id, chat_id, username, event_id, joined_date) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(telegramID, chatID, username, eventID, time.Now().Format(time.RFC3339))
+	_, err = stmt.ExecContext(ctx, telegramID, chatID, username, eventID, time.Now().Format(time.RFC3339))
 	return err
 }
 
 // RemoveFromWaitlist removes a user from the waitlist for an event
-func (r *SQLiteRepository) RemoveFromWaitlist(telegramID int, eventID int) error {
-	stmt, err := r.db.Prepare("DELETE FROM waitlist WHERE telegram_id = ? AND event_id = ?")
+func (r *SQLiteRepository) RemoveFromWaitlist(ctx context.Context, telegramID int, eventID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "DELETE FROM waitlist WHERE telegram_id = ? AND event_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(telegramID, eventID)
+	_, err = stmt.ExecContext(ctx, telegramID, eventID)
 	return err
 }
 
 // GetWaitlistForEvent returns all users in the waitlist for an event
-func (r *SQLiteRepository) GetWaitlistForEvent(eventID int) ([]WaitlistEntry, error) {
-	rows, err := r.db.Query("SELECT telegram_id, chat_id, username, event_id, joined_date FROM waitlist WHERE event_id = ? ORDER BY joined_date ASC", eventID)
+func (r *SQLiteRepository) GetWaitlistForEvent(ctx context.Context, eventID int) ([]WaitlistEntry, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT telegram_id, chat_id, username, event_id, joined_date FROM waitlist WHERE event_id = ? ORDER BY joined_date ASC", eventID)
 	if err != nil {
 		return nil, err
 	}
@@ -399,36 +726,496 @@ func (r *SQLiteRepository) GetWaitlistForEvent(eventID int) ([]WaitlistEntry, er
 }
 
 // IsUserInWaitlist checks if a user is in the waitlist for an event
-func (r *SQLiteRepository) IsUserInWaitlist(telegramID int, eventID int) (bool, error) {
+func (r *SQLiteRepository) IsUserInWaitlist(ctx context.Context, telegramID int, eventID int) (bool, error) {
 	var count int
-	err := r.db.QueryRow("SELECT COUNT(*) FROM waitlist WHERE telegram_id = ? AND event_id = ?", telegramID, eventID).Scan(&count)
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM waitlist WHERE telegram_id = ? AND event_id = ?", telegramID, eventID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
 
-// RemoveUserByUsername removes a user from all tables by username and returns if was registered
-func (r *SQLiteRepository) RemoveUserByUsername(username string, eventID int) (bool, error) {
+// RemoveUserByUsername removes a user from the registrations and the waitlist of an event by username
+// and returns if was registered. The rows of other events are kept, they hold the attendance history.
+func (r *SQLiteRepository) RemoveUserByUsername(ctx context.Context, username string, eventID int) (bool, error) {
 	// First check if user exists and is registered
 	var registred int
-	err := r.db.QueryRow("SELECT registred FROM users WHERE username = ? AND event_id = ?", username, eventID).Scan(&registred)
+	err := r.db.QueryRowContext(ctx, "SELECT registred FROM users WHERE username = ? AND event_id = ?", username, eventID).Scan(&registred)
 	if err != nil && err != sql.ErrNoRows {
 		return false, err
 	}
 	wasRegistered := err == nil && registred == 1
 
 	// Delete from users table
-	_, err = r.db.Exec("DELETE FROM users WHERE username = ?", username)
+	_, err = r.db.ExecContext(ctx, "DELETE FROM users WHERE username = ? AND event_id = ?", username, eventID)
 	if err != nil {
 		return false, err
 	}
 
 	// Delete from waitlist table
-	_, err = r.db.Exec("DELETE FROM waitlist WHERE username = ?", username)
+	_, err = r.db.ExecContext(ctx, "DELETE FROM waitlist WHERE username = ? AND event_id = ?", username, eventID)
 	if err != nil {
 		return false, err
 	}
 
 	return wasRegistered, nil
 }
+
+// GetUserLanguage returns the language chosen by the user, or an empty string if none was chosen
+func (r *SQLiteRepository) GetUserLanguage(ctx context.Context, telegramID int) (string, error) {
+	var language sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT language FROM user_settings WHERE telegram_id = ?", telegramID).Scan(&language)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return language.String, nil
+}
+
+// SetUserLanguage stores the language chosen by the user
+func (r *SQLiteRepository) SetUserLanguage(ctx context.Context, telegramID int, language string) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT INTO user_settings (telegram_id, language) VALUES (?, ?) ON CONFLICT(telegram_id) DO UPDATE SET language = excluded.language")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, telegramID, language)
+	return err
+}
+
+// GetMessageTemplate returns the template overriding a catalog message, or nil if there is none
+func (r *SQLiteRepository) GetMessageTemplate(ctx context.Context, key string, language string) (*MessageTemplate, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT key, language, body, updated_by, updated_at FROM message_templates WHERE key = ? AND language = ?", key, language)
+	var tpl MessageTemplate
+	var dateStr string
+	err := row.Scan(&tpl.Key, &tpl.Language, &tpl.Body, &tpl.UpdatedBy, &dateStr)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tpl.UpdatedAt, _ = time.Parse(time.RFC3339, dateStr)
+	return &tpl, nil
+}
+
+// GetMessageTemplates returns all saved message templates
+func (r *SQLiteRepository) GetMessageTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT key, language, body, updated_by, updated_at FROM message_templates ORDER BY key, language")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []MessageTemplate
+	for rows.Next() {
+		var tpl MessageTemplate
+		var dateStr string
+		if err := rows.Scan(&tpl.Key, &tpl.Language, &tpl.Body, &tpl.UpdatedBy, &dateStr); err != nil {
+			return nil, err
+		}
+		tpl.UpdatedAt, _ = time.Parse(time.RFC3339, dateStr)
+		templates = append(templates, tpl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// SaveMessageTemplate creates or replaces a message template
+func (r *SQLiteRepository) SaveMessageTemplate(ctx context.Context, tpl MessageTemplate) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT OR REPLACE INTO message_templates (key, language, body, updated_by, updated_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, tpl.Key, tpl.Language, tpl.Body, tpl.UpdatedBy, tpl.UpdatedAt.Format(time.RFC3339))
+	return err
+}
+
+// DeleteMessageTemplate removes a message template so the catalog text is used again
+func (r *SQLiteRepository) DeleteMessageTemplate(ctx context.Context, key string, language string) error {
+	stmt, err := r.db.PrepareContext(ctx, "DELETE FROM message_templates WHERE key = ? AND language = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, key, language)
+	return err
+}
+
+// GetUserRole returns the role of a user, or an empty string if the user has none
+func (r *SQLiteRepository) GetUserRole(ctx context.Context, telegramID int) (string, error) {
+	var role string
+	err := r.db.QueryRowContext(ctx, "SELECT role FROM roles WHERE telegram_id = ?", telegramID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// GetUserRoles returns all granted roles
+func (r *SQLiteRepository) GetUserRoles(ctx context.Context) ([]UserRole, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT telegram_id, username, role, granted_by, granted_at FROM roles ORDER BY role, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []UserRole
+	for rows.Next() {
+		var role UserRole
+		var dateStr string
+		if err := rows.Scan(&role.TelegramID, &role.Username, &role.Role, &role.GrantedBy, &dateStr); err != nil {
+			return nil, err
+		}
+		role.GrantedAt, _ = time.Parse(time.RFC3339, dateStr)
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// SetUserRole grants a role to a user, replacing the previous one
+func (r *SQLiteRepository) SetUserRole(ctx context.Context, role UserRole) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT OR REPLACE INTO roles (telegram_id, username, role, granted_by, granted_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, role.TelegramID, role.Username, role.Role, role.GrantedBy, role.GrantedAt.Format(time.RFC3339))
+	return err
+}
+
+// RemoveUserRole revokes the role of a user
+func (r *SQLiteRepository) RemoveUserRole(ctx context.Context, telegramID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "DELETE FROM roles WHERE telegram_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, telegramID)
+	return err
+}
+
+// FindTelegramIDByUsername looks up the Telegram ID of a user who has interacted with the bot.
+// Returns 0 if the username is unknown.
+func (r *SQLiteRepository) FindTelegramIDByUsername(ctx context.Context, username string) (int, error) {
+	query := `
+		SELECT telegram_id FROM (
+			SELECT telegram_id, username FROM users
+			UNION ALL SELECT telegram_id, username FROM waitlist
+			UNION ALL SELECT telegram_id, username FROM roles
+		)
+		WHERE username = ? COLLATE NOCASE AND telegram_id <> 0
+		LIMIT 1
+	`
+	var telegramID int
+	err := r.db.QueryRowContext(ctx, query, username).Scan(&telegramID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return telegramID, nil
+}
+
+// AddAuditEntry appends an entry to the audit log
+func (r *SQLiteRepository) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT INTO audit_log (created_at, action, actor_id, actor_username, target_id, target_username, event_id, details) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, entry.CreatedAt.UTC().Format(time.RFC3339), entry.Action, entry.ActorID, entry.ActorUsername,
+		entry.TargetID, entry.TargetUsername, entry.EventID, entry.Details)
+	return err
+}
+
+// GetAuditLog returns the newest audit log entries matching the filter, newest first
+func (r *SQLiteRepository) GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.UserID != 0 {
+		conditions = append(conditions, "(actor_id = ? OR target_id = ?)")
+		args = append(args, filter.UserID, filter.UserID)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "(actor_username = ? COLLATE NOCASE OR target_username = ? COLLATE NOCASE)")
+		args = append(args, filter.Username, filter.Username)
+	}
+	if filter.EventID != 0 {
+		conditions = append(conditions, "event_id = ?")
+		args = append(args, filter.EventID)
+	}
+	if !filter.Since.IsZero() {
+		// datetime() normalizes entries written with a local offset before times were stored in UTC
+		conditions = append(conditions, "datetime(created_at) >= datetime(?)")
+		args = append(args, filter.Since.UTC().Format(time.RFC3339))
+	}
+
+	query := "SELECT id, created_at, action, actor_id, actor_username, target_id, target_username, event_id, details FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var dateStr string
+		err := rows.Scan(&entry.ID, &dateStr, &entry.Action, &entry.ActorID, &entry.ActorUsername,
+			&entry.TargetID, &entry.TargetUsername, &entry.EventID, &entry.Details)
+		if err != nil {
+			return nil, err
+		}
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, dateStr)
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// IsUserBanned checks if a user is banned
+func (r *SQLiteRepository) IsUserBanned(ctx context.Context, telegramID int) (bool, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM banned_users WHERE telegram_id = ?", telegramID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// BanUser bans a user, replacing a previous ban
+func (r *SQLiteRepository) BanUser(ctx context.Context, ban BannedUser) error {
+	stmt, err := r.db.PrepareContext(ctx, "INSERT OR REPLACE INTO banned_users (telegram_id, username, reason, banned_by, banned_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, ban.TelegramID, ban.Username, ban.Reason, ban.BannedBy, ban.BannedAt.Format(time.RFC3339))
+	return err
+}
+
+// UnbanUser lifts the ban of a user
+func (r *SQLiteRepository) UnbanUser(ctx context.Context, telegramID int) error {
+	stmt, err := r.db.PrepareContext(ctx, "DELETE FROM banned_users WHERE telegram_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, telegramID)
+	return err
+}
+
+// AddAPIKey stores a new API key and returns its ID
+func (r *SQLiteRepository) AddAPIKey(ctx context.Context, key APIKey) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "INSERT INTO api_keys (name, key_hash, scope, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		key.Name, key.Hash, key.Scope, key.CreatedBy, key.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetAPIKeyByHash returns the API key with the hash, revoked or not, or nil if there is none
+func (r *SQLiteRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, name, key_hash, scope, created_by, created_at, revoked_at FROM api_keys WHERE key_hash = ?", hash)
+	key, err := scanSQLiteAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKeys returns all API keys, oldest first
+func (r *SQLiteRepository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, key_hash, scope, created_by, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanSQLiteAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// scanSQLiteAPIKey reads an api_keys row, dates are stored as RFC 3339 text
+func scanSQLiteAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var createdStr, revokedStr string
+	if err := row.Scan(&key.ID, &key.Name, &key.Hash, &key.Scope, &key.CreatedBy, &createdStr, &revokedStr); err != nil {
+		return nil, err
+	}
+	key.CreatedAt, _ = time.Parse(time.RFC3339, createdStr)
+	key.RevokedAt, _ = time.Parse(time.RFC3339, revokedStr)
+	return &key, nil
+}
+
+// RevokeAPIKey revokes an API key, a key that is already revoked keeps its revocation time
+func (r *SQLiteRepository) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at = ''", at.Format(time.RFC3339), id)
+	return err
+}
+
+// webhookDeliveryColumns are the columns scanSQLiteWebhookDelivery and scanPostgresWebhookDelivery read
+const webhookDeliveryColumns = "id, url, type, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at"
+
+// AddWebhookDelivery queues a webhook delivery and returns its ID
+func (r *SQLiteRepository) AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (url, type, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.URL, delivery.Type, delivery.Payload, delivery.Status, delivery.Attempts,
+		delivery.NextAttemptAt.UTC().Format(time.RFC3339), delivery.LastError,
+		delivery.CreatedAt.UTC().Format(time.RFC3339), delivery.UpdatedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries due at now, oldest first.
+// Times are stored in UTC, so the RFC 3339 strings compare in chronological order.
+func (r *SQLiteRepository) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= ? ORDER BY id LIMIT ?",
+		now.UTC().Format(time.RFC3339), limit)
+}
+
+// UpdateWebhookDelivery saves the outcome of a failed attempt
+func (r *SQLiteRepository) UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC().Format(time.RFC3339), delivery.LastError,
+		delivery.UpdatedAt.UTC().Format(time.RFC3339), delivery.ID)
+	return err
+}
+
+// DeleteWebhookDelivery removes a delivery from the queue
+func (r *SQLiteRepository) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE id = ?", id)
+	return err
+}
+
+// GetWebhookFailures returns up to limit deliveries with a failed attempt, pending or failed, most recently attempted first
+func (r *SQLiteRepository) GetWebhookFailures(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	return r.queryWebhookDeliveries(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE attempts > 0 ORDER BY updated_at DESC, id DESC LIMIT ?", limit)
+}
+
+// queryWebhookDeliveries runs a query for webhook_deliveries rows
+func (r *SQLiteRepository) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextStr, createdStr, updatedStr string
+		if err := rows.Scan(&d.ID, &d.URL, &d.Type, &d.Payload, &d.Status, &d.Attempts, &nextStr, &d.LastError, &createdStr, &updatedStr); err != nil {
+			return nil, err
+		}
+		d.NextAttemptAt, _ = time.Parse(time.RFC3339, nextStr)
+		d.CreatedAt, _ = time.Parse(time.RFC3339, createdStr)
+		d.UpdatedAt, _ = time.Parse(time.RFC3339, updatedStr)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ImportRegistrations adds imported registrations in a single transaction, nothing is added if any row fails.
+// Events with a zero EventID are created as past events with a capacity of their registered rows.
+func (r *SQLiteRepository) ImportRegistrations(ctx context.Context, imports []RegistrationImport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, imp := range imports {
+		registered := countRegistered(imp.Registrations)
+		eventID := imp.EventID
+		if eventID == 0 {
+			result, err := tx.ExecContext(ctx, "INSERT INTO events (name, date, capacity, state) VALUES (?, ?, ?, 'past')",
+				imp.EventName, imp.EventDate.Format(time.RFC3339), registered)
+			if err != nil {
+				return err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			eventID = int(id)
+		}
+		for _, reg := range imp.Registrations {
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (telegram_id, username, name, registration_date, email, event_id, registred, visited) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+				reg.TelegramID, reg.Username, reg.Name, reg.RegistrationDate.Format(time.RFC3339), reg.Email, eventID, reg.Registred, reg.Visited)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE events SET registration_count = registration_count + ? WHERE id = ?", registered, eventID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimImportedRegistrations links the imported registrations of a username without a Telegram ID to the user.
+// Rows with an email are skipped: a username can change hands, and the new owner must not get the email of the old one.
+// Events the user already has a registration for are skipped too. Returns the number of linked registrations.
+func (r *SQLiteRepository) ClaimImportedRegistrations(ctx context.Context, telegramID int, username string) (int, error) {
+	if username == "" {
+		return 0, nil
+	}
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET telegram_id = ?
+		WHERE telegram_id = 0 AND username = ? COLLATE NOCASE AND COALESCE(email, '') = ''
+			AND event_id NOT IN (SELECT event_id FROM users WHERE telegram_id = ?)
+	`, telegramID, username, telegramID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
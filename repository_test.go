@@ -0,0 +1,641 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSQLiteRepository runs the conformance suite against a fresh SQLite file
+func TestSQLiteRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		repo, db, err := OpenRepository(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "bot.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return repo
+	})
+}
+
+// TestSQLiteAuditLogAppendOnly checks that the triggers reject changes to audit log entries made past the repository
+func TestSQLiteAuditLogAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	repo, db, err := OpenRepository(ctx, "sqlite://"+filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mustNoError(t, repo.AddAuditEntry(ctx, AuditEntry{CreatedAt: time.Now(), Action: "register", ActorID: 1, TargetID: 1, EventID: 1}))
+
+	for _, query := range []string{
+		"UPDATE audit_log SET action = 'cancel'",
+		"DELETE FROM audit_log",
+	} {
+		if _, err := db.ExecContext(ctx, query); err == nil || !strings.Contains(err.Error(), "append-only") {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+	if entries, _ := repo.GetAuditLog(ctx, AuditFilter{}); len(entries) != 1 || entries[0].Action != "register" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+// TestMemoryRepository runs the conformance suite against the in-memory implementation
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+// TestPostgresRepository runs the conformance suite against PostgreSQL.
+// Set TEST_DATABASE_URL to a postgres:// URL to enable it, every test gets its own schema.
+func TestPostgresRepository(t *testing.T) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	testRepository(t, func(t *testing.T) Repository {
+		admin, err := sql.Open("postgres", databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer admin.Close()
+
+		b := make([]byte, 6)
+		rand.Read(b)
+		schema := "test_" + hex.EncodeToString(b)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if db, err := sql.Open("postgres", databaseURL); err == nil {
+				db.Exec("DROP SCHEMA " + schema + " CASCADE")
+				db.Close()
+			}
+		})
+
+		u, err := url.Parse(databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+
+		repo, db, err := OpenRepository(context.Background(), u.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return repo
+	})
+}
+
+// testRepository is the conformance suite every Repository implementation must pass
+func testRepository(t *testing.T, newRepo func(t *testing.T) Repository) {
+	ctx := context.Background()
+	// Timestamps are compared at second precision, which is what every implementation keeps
+	now := time.Now().Truncate(time.Second)
+
+	t.Run("Events", func(t *testing.T) {
+		repo := newRepo(t)
+		event, err := repo.GetLatestEvent(ctx)
+		if err != nil || event != nil {
+			t.Fatalf("GetLatestEvent on empty database = %v, %v; want nil, nil", event, err)
+		}
+
+		mustNoError(t, repo.AddEvent(ctx, "First", now.AddDate(0, 0, 7), 10))
+		mustNoError(t, repo.MarkEventsAsPast(ctx))
+		mustNoError(t, repo.AddEvent(ctx, "Second", now.AddDate(0, 0, 14), 2))
+
+		event = mustEvent(t, repo)
+		if event.name != "Second" || event.capacity != 2 || event.registrationCount != 0 || !event.date.Equal(now.AddDate(0, 0, 14)) {
+			t.Fatalf("GetLatestEvent = %+v, want the second event", event)
+		}
+
+		mustNoError(t, repo.UpdateEventRegistrationCount(ctx, event.id))
+		mustNoError(t, repo.UpdateEventRegistrationCount(ctx, event.id))
+		mustNoError(t, repo.DecrementEventRegistrationCount(ctx, event.id))
+		if event = mustEvent(t, repo); event.registrationCount != 1 {
+			t.Errorf("registrationCount = %d, want 1", event.registrationCount)
+		}
+		mustNoError(t, repo.DecrementEventRegistrationCount(ctx, event.id))
+		mustNoError(t, repo.DecrementEventRegistrationCount(ctx, event.id))
+		if event = mustEvent(t, repo); event.registrationCount != 0 {
+			t.Errorf("registrationCount = %d, want 0, the count never goes negative", event.registrationCount)
+		}
+
+		mustNoError(t, repo.UpdateEvent(ctx, event.id, "Second (moved)", now.AddDate(0, 0, 21), 5))
+		if event = mustEvent(t, repo); event.name != "Second (moved)" || event.capacity != 5 || !event.date.Equal(now.AddDate(0, 0, 21)) {
+			t.Errorf("event after UpdateEvent = %+v", event)
+		}
+
+		mustNoError(t, repo.MarkEventsAsPast(ctx))
+		if event, err := repo.GetLatestEvent(ctx); err != nil || event != nil {
+			t.Errorf("GetLatestEvent after MarkEventsAsPast = %v, %v; want nil, nil", event, err)
+		}
+
+		events, err := repo.GetEvents(ctx)
+		mustNoError(t, err)
+		if len(events) != 2 || events[0].name != "Second (moved)" || events[0].state != "past" || events[1].name != "First" || events[1].registrationCount != 0 {
+			t.Errorf("GetEvents = %+v, want both past events newest first", events)
+		}
+	})
+
+	t.Run("Registrations", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "Meetup", now, 10))
+		event := mustEvent(t, repo)
+
+		registered, reg, err := repo.IsUserRegistered(ctx, 1, event.id)
+		if err != nil || registered || reg != nil {
+			t.Fatalf("IsUserRegistered for unknown user = %v, %v, %v", registered, reg, err)
+		}
+
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{
+			TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", RegistrationDate: now,
+			EventID: event.id, Registred: 1,
+		}))
+		registered, reg, err = repo.IsUserRegistered(ctx, 1, event.id)
+		mustNoError(t, err)
+		if !registered || reg.Username != "ivan" || reg.Name != "Ivanov Ivan" || !reg.RegistrationDate.Equal(now) {
+			t.Fatalf("IsUserRegistered = %v, %+v", registered, reg)
+		}
+
+		if hasInfo, _, _, err := repo.HasUserInfo(ctx, 1); err != nil || hasInfo {
+			t.Errorf("HasUserInfo without email = %v, %v; want false", hasInfo, err)
+		}
+		mustNoError(t, repo.UpdateUserEmail(ctx, 1, "ivan@example.com"))
+		mustNoError(t, repo.UpdateUserName(ctx, 1, "Petrov Ivan"))
+		hasInfo, name, email, err := repo.HasUserInfo(ctx, 1)
+		mustNoError(t, err)
+		if !hasInfo || name != "Petrov Ivan" || email != "ivan@example.com" {
+			t.Errorf("HasUserInfo = %v, %q, %q", hasInfo, name, email)
+		}
+
+		mustNoError(t, repo.UpdateVisitedStatus(ctx, 1, event.id, 1))
+		mustNoError(t, repo.RemoveRegistration(ctx, 1, event.id))
+		registered, reg, err = repo.IsUserRegistered(ctx, 1, event.id)
+		mustNoError(t, err)
+		if registered || reg == nil || reg.Visited != 1 {
+			t.Fatalf("IsUserRegistered after removal = %v, %+v; want the unregistered row", registered, reg)
+		}
+
+		// Registering again reuses the unregistered row
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{
+			TelegramID: 1, Username: "ivan", Name: "Petrov Ivan", Email: "ivan@example.com",
+			RegistrationDate: now, EventID: event.id, Registred: 1,
+		}))
+		if registered, _, _ := repo.IsUserRegistered(ctx, 1, event.id); !registered {
+			t.Error("user is not registered after registering again")
+		}
+
+		mustNoError(t, repo.UpdateRegistration(ctx, UserRegistration{
+			TelegramID: 1, Username: "ivan_new", Name: "Petrov Ivan", Email: "new@example.com",
+			RegistrationDate: now.Add(time.Hour), EventID: event.id, Registred: 1,
+		}))
+		_, reg, _ = repo.IsUserRegistered(ctx, 1, event.id)
+		if reg.Username != "ivan_new" || reg.Email != "new@example.com" || !reg.RegistrationDate.Equal(now.Add(time.Hour)) {
+			t.Errorf("UpdateRegistration stored %+v", reg)
+		}
+
+		// A walk-in is recorded as visited but not registered
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{
+			TelegramID: 2, Username: "anna", Name: "Anna", RegistrationDate: now,
+			EventID: event.id, Registred: 0, Visited: 1,
+		}))
+		registered, reg, _ = repo.IsUserRegistered(ctx, 2, event.id)
+		if registered || reg == nil || reg.Visited != 1 {
+			t.Errorf("walk-in = %v, %+v", registered, reg)
+		}
+
+		all, err := repo.GetAllRegistrations(ctx)
+		mustNoError(t, err)
+		if len(all) != 2 || all[0].EventName != "Meetup" || !all[0].EventDate.Equal(now) {
+			t.Errorf("GetAllRegistrations = %+v", all)
+		}
+	})
+
+	t.Run("Waitlist", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "Meetup", now, 1))
+		event := mustEvent(t, repo)
+
+		mustNoError(t, repo.AddToWaitlist(ctx, 1, 100, "ivan", event.id))
+		mustNoError(t, repo.AddToWaitlist(ctx, 2, 200, "anna", event.id))
+		// Joining twice keeps a single entry
+		mustNoError(t, repo.AddToWaitlist(ctx, 2, 201, "anna", event.id))
+
+		entries, err := repo.GetWaitlistForEvent(ctx, event.id)
+		mustNoError(t, err)
+		if len(entries) != 2 {
+			t.Fatalf("GetWaitlistForEvent = %+v, want 2 entries", entries)
+		}
+		for _, entry := range entries {
+			if entry.TelegramID == 2 && entry.ChatID != 201 {
+				t.Errorf("entry of user 2 has chat %d, want the latest chat 201", entry.ChatID)
+			}
+		}
+
+		if in, err := repo.IsUserInWaitlist(ctx, 1, event.id); err != nil || !in {
+			t.Errorf("IsUserInWaitlist(1) = %v, %v", in, err)
+		}
+		mustNoError(t, repo.RemoveFromWaitlist(ctx, 1, event.id))
+		if in, err := repo.IsUserInWaitlist(ctx, 1, event.id); err != nil || in {
+			t.Errorf("IsUserInWaitlist(1) after removal = %v, %v", in, err)
+		}
+	})
+
+	t.Run("EventRegistrations", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "First", now, 10))
+		mustNoError(t, repo.MarkEventsAsPast(ctx))
+		mustNoError(t, repo.AddEvent(ctx, "Second", now.AddDate(0, 0, 7), 10))
+		for _, reg := range []UserRegistration{
+			{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", RegistrationDate: now, EventID: 1, Registred: 1, Visited: 1},
+			{TelegramID: 2, Username: "anna", Name: "Petrova Anna", RegistrationDate: now, EventID: 1, Registred: 1},
+			{TelegramID: 2, Username: "anna", Name: "Petrova Anna", RegistrationDate: now, EventID: 2, Registred: 1, Visited: 1},
+			{TelegramID: 3, Username: "boss", Name: "Boss", RegistrationDate: now, EventID: 2, Registred: 0},
+		} {
+			mustNoError(t, repo.RegisterUser(ctx, reg))
+		}
+		mustNoError(t, repo.AddToWaitlist(ctx, 4, 400, "olga", 2))
+
+		registrations, err := repo.GetEventRegistrations(ctx, 2)
+		mustNoError(t, err)
+		if len(registrations) != 2 || registrations[0].Username != "boss" || registrations[1].Username != "anna" ||
+			registrations[1].Visited != 1 || !registrations[1].RegistrationDate.Equal(now) {
+			t.Errorf("GetEventRegistrations(2) = %+v, want boss and anna by name", registrations)
+		}
+		if registrations, err := repo.GetEventRegistrations(ctx, 3); err != nil || len(registrations) != 0 {
+			t.Errorf("GetEventRegistrations of an unknown event = %+v, %v", registrations, err)
+		}
+
+		counts, err := repo.GetEventCounts(ctx)
+		mustNoError(t, err)
+		if len(counts) != 2 || counts[1] != (EventCounts{CheckedIn: 1}) || counts[2] != (EventCounts{CheckedIn: 1, Waitlist: 1}) {
+			t.Errorf("GetEventCounts = %+v", counts)
+		}
+
+		// A walk-in is a row of its own, also when a row without a Telegram ID looks the same
+		walkIn := UserRegistration{Name: "Smirnov Oleg", RegistrationDate: now, EventID: 2, Visited: 1}
+		first, err := repo.AddWalkIn(ctx, walkIn)
+		mustNoError(t, err)
+		second, err := repo.AddWalkIn(ctx, walkIn)
+		mustNoError(t, err)
+		reg, err := repo.GetRegistrationByID(ctx, second)
+		mustNoError(t, err)
+		if first == 0 || second == first || reg == nil || reg.Name != "Smirnov Oleg" || reg.Visited != 1 || reg.Registred != 0 {
+			t.Errorf("AddWalkIn = %d, %d, row %+v", first, second, reg)
+		}
+		if event := mustEvent(t, repo); event.registrationCount != 0 {
+			t.Errorf("registrationCount after walk-ins = %d, want 0", event.registrationCount)
+		}
+	})
+
+	t.Run("RemoveUserByUsername", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "Past", now.AddDate(0, 0, -7), 10))
+		past := mustEvent(t, repo)
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", RegistrationDate: now, EventID: past.id, Registred: 1, Visited: 1}))
+		mustNoError(t, repo.AddToWaitlist(ctx, 2, 200, "anna", past.id))
+		mustNoError(t, repo.MarkEventsAsPast(ctx))
+		mustNoError(t, repo.AddEvent(ctx, "Meetup", now, 10))
+		event := mustEvent(t, repo)
+
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", RegistrationDate: now, EventID: event.id, Registred: 1}))
+		mustNoError(t, repo.AddToWaitlist(ctx, 2, 200, "anna", event.id))
+
+		if was, err := repo.RemoveUserByUsername(ctx, "ivan", event.id); err != nil || !was {
+			t.Errorf("RemoveUserByUsername(ivan) = %v, %v; want true", was, err)
+		}
+		if _, reg, _ := repo.IsUserRegistered(ctx, 1, event.id); reg != nil {
+			t.Errorf("ivan still has a registration: %+v", reg)
+		}
+		if was, err := repo.RemoveUserByUsername(ctx, "anna", event.id); err != nil || was {
+			t.Errorf("RemoveUserByUsername(anna) = %v, %v; want false", was, err)
+		}
+		if in, _ := repo.IsUserInWaitlist(ctx, 2, event.id); in {
+			t.Error("anna is still in the waitlist")
+		}
+
+		// The other event keeps its attendance history
+		if registered, reg, _ := repo.IsUserRegistered(ctx, 1, past.id); !registered || reg.Visited != 1 {
+			t.Errorf("registration for the past event = %v, %+v", registered, reg)
+		}
+		if in, _ := repo.IsUserInWaitlist(ctx, 2, past.id); !in {
+			t.Error("anna was removed from the waitlist of the past event")
+		}
+	})
+
+	t.Run("UserLanguage", func(t *testing.T) {
+		repo := newRepo(t)
+		if lang, err := repo.GetUserLanguage(ctx, 1); err != nil || lang != "" {
+			t.Errorf("GetUserLanguage for unknown user = %q, %v", lang, err)
+		}
+		mustNoError(t, repo.SetUserLanguage(ctx, 1, "en"))
+		mustNoError(t, repo.SetUserLanguage(ctx, 1, "ru"))
+		if lang, err := repo.GetUserLanguage(ctx, 1); err != nil || lang != "ru" {
+			t.Errorf("GetUserLanguage = %q, %v; want ru", lang, err)
+		}
+	})
+
+	t.Run("MessageTemplates", func(t *testing.T) {
+		repo := newRepo(t)
+		if tpl, err := repo.GetMessageTemplate(ctx, "welcome", "en"); err != nil || tpl != nil {
+			t.Errorf("GetMessageTemplate for missing template = %v, %v", tpl, err)
+		}
+		mustNoError(t, repo.SaveMessageTemplate(ctx, MessageTemplate{Key: "welcome", Language: "en", Body: "Hi", UpdatedBy: "admin", UpdatedAt: now}))
+		mustNoError(t, repo.SaveMessageTemplate(ctx, MessageTemplate{Key: "welcome", Language: "en", Body: "Hello", UpdatedBy: "admin", UpdatedAt: now}))
+		mustNoError(t, repo.SaveMessageTemplate(ctx, MessageTemplate{Key: "welcome", Language: "ru", Body: "Привет", UpdatedBy: "admin", UpdatedAt: now}))
+
+		tpl, err := repo.GetMessageTemplate(ctx, "welcome", "en")
+		mustNoError(t, err)
+		if tpl == nil || tpl.Body != "Hello" || tpl.UpdatedBy != "admin" || !tpl.UpdatedAt.Equal(now) {
+			t.Errorf("GetMessageTemplate = %+v", tpl)
+		}
+		templates, err := repo.GetMessageTemplates(ctx)
+		mustNoError(t, err)
+		if len(templates) != 2 || templates[0].Language != "en" || templates[1].Language != "ru" {
+			t.Errorf("GetMessageTemplates = %+v", templates)
+		}
+
+		mustNoError(t, repo.DeleteMessageTemplate(ctx, "welcome", "en"))
+		if tpl, _ := repo.GetMessageTemplate(ctx, "welcome", "en"); tpl != nil {
+			t.Errorf("template still exists after delete: %+v", tpl)
+		}
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		repo := newRepo(t)
+		if role, err := repo.GetUserRole(ctx, 1); err != nil || role != "" {
+			t.Errorf("GetUserRole for unknown user = %q, %v", role, err)
+		}
+		mustNoError(t, repo.SetUserRole(ctx, UserRole{TelegramID: 1, Username: "Ivan", Role: "volunteer", GrantedBy: 9, GrantedAt: now}))
+		mustNoError(t, repo.SetUserRole(ctx, UserRole{TelegramID: 1, Username: "Ivan", Role: "organizer", GrantedBy: 9, GrantedAt: now}))
+		mustNoError(t, repo.SetUserRole(ctx, UserRole{TelegramID: 2, Username: "anna", Role: "owner", GrantedAt: now}))
+
+		if role, err := repo.GetUserRole(ctx, 1); err != nil || role != "organizer" {
+			t.Errorf("GetUserRole = %q, %v; want organizer", role, err)
+		}
+		roles, err := repo.GetUserRoles(ctx)
+		mustNoError(t, err)
+		if len(roles) != 2 || roles[0].Role != "organizer" || roles[0].GrantedBy != 9 || !roles[0].GrantedAt.Equal(now) {
+			t.Errorf("GetUserRoles = %+v", roles)
+		}
+
+		// Usernames are matched case-insensitively
+		if id, err := repo.FindTelegramIDByUsername(ctx, "ivan"); err != nil || id != 1 {
+			t.Errorf("FindTelegramIDByUsername(ivan) = %d, %v; want 1", id, err)
+		}
+		if id, err := repo.FindTelegramIDByUsername(ctx, "nobody"); err != nil || id != 0 {
+			t.Errorf("FindTelegramIDByUsername(nobody) = %d, %v; want 0", id, err)
+		}
+
+		mustNoError(t, repo.RemoveUserRole(ctx, 1))
+		if role, _ := repo.GetUserRole(ctx, 1); role != "" {
+			t.Errorf("role still granted after removal: %q", role)
+		}
+	})
+
+	t.Run("AuditLog", func(t *testing.T) {
+		repo := newRepo(t)
+		// Times in different zones are compared as instants
+		west, east := time.FixedZone("UTC-12", -12*3600), time.FixedZone("UTC+14", 14*3600)
+		entries := []AuditEntry{
+			{CreatedAt: now.Add(-48 * time.Hour), Action: "register", ActorID: 1, ActorUsername: "ivan", TargetID: 1, TargetUsername: "ivan", EventID: 1},
+			{CreatedAt: now.In(west), Action: "cancel", ActorID: 1, ActorUsername: "ivan", TargetID: 1, TargetUsername: "ivan", EventID: 1},
+			{CreatedAt: now, Action: "admin_remove", ActorID: 2, ActorUsername: "Anna", TargetUsername: "petr", EventID: 2, Details: "registered"},
+		}
+		for _, entry := range entries {
+			mustNoError(t, repo.AddAuditEntry(ctx, entry))
+		}
+
+		all, err := repo.GetAuditLog(ctx, AuditFilter{})
+		mustNoError(t, err)
+		if len(all) != 3 || all[0].Action != "admin_remove" || all[0].Details != "registered" || !all[0].CreatedAt.Equal(now) {
+			t.Fatalf("GetAuditLog = %+v, want all entries newest first", all)
+		}
+
+		for _, tc := range []struct {
+			filter AuditFilter
+			want   int
+		}{
+			{AuditFilter{Action: "cancel"}, 1},
+			{AuditFilter{UserID: 1}, 2},
+			{AuditFilter{Username: "anna"}, 1},
+			{AuditFilter{Username: "PETR"}, 1},
+			{AuditFilter{EventID: 1}, 2},
+			{AuditFilter{Since: now.Add(-time.Hour)}, 2},
+			{AuditFilter{Since: now.Add(-time.Hour).In(east)}, 2},
+			{AuditFilter{Since: now.Add(time.Hour).In(west)}, 0},
+			{AuditFilter{Limit: 1}, 1},
+			{AuditFilter{UserID: 1, Action: "register"}, 1},
+		} {
+			got, err := repo.GetAuditLog(ctx, tc.filter)
+			mustNoError(t, err)
+			if len(got) != tc.want {
+				t.Errorf("GetAuditLog(%+v) returned %d entries, want %d", tc.filter, len(got), tc.want)
+			}
+		}
+	})
+
+	t.Run("Bans", func(t *testing.T) {
+		repo := newRepo(t)
+		if banned, err := repo.IsUserBanned(ctx, 1); err != nil || banned {
+			t.Errorf("IsUserBanned for unknown user = %v, %v", banned, err)
+		}
+		mustNoError(t, repo.BanUser(ctx, BannedUser{TelegramID: 1, Username: "spam", Reason: "spam", BannedBy: 9, BannedAt: now}))
+		mustNoError(t, repo.BanUser(ctx, BannedUser{TelegramID: 1, Username: "spam", Reason: "again", BannedBy: 9, BannedAt: now}))
+		if banned, err := repo.IsUserBanned(ctx, 1); err != nil || !banned {
+			t.Errorf("IsUserBanned = %v, %v; want true", banned, err)
+		}
+		mustNoError(t, repo.UnbanUser(ctx, 1))
+		if banned, _ := repo.IsUserBanned(ctx, 1); banned {
+			t.Error("user is still banned after unban")
+		}
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		repo := newRepo(t)
+		if key, err := repo.GetAPIKeyByHash(ctx, "unknown"); err != nil || key != nil {
+			t.Errorf("GetAPIKeyByHash for unknown hash = %v, %v", key, err)
+		}
+		id, err := repo.AddAPIKey(ctx, APIKey{Name: "website", Hash: "h1", Scope: "events", CreatedBy: "cli", CreatedAt: now})
+		mustNoError(t, err)
+		second, err := repo.AddAPIKey(ctx, APIKey{Name: "crm", Hash: "h2", Scope: "full", CreatedBy: "cli", CreatedAt: now})
+		mustNoError(t, err)
+		if _, err := repo.AddAPIKey(ctx, APIKey{Name: "copy", Hash: "h1", Scope: "full", CreatedAt: now}); err == nil {
+			t.Error("AddAPIKey with a duplicate hash succeeded")
+		}
+
+		key, err := repo.GetAPIKeyByHash(ctx, "h1")
+		mustNoError(t, err)
+		if key == nil || key.ID != id || key.Name != "website" || key.Scope != "events" || !key.CreatedAt.Equal(now) || !key.RevokedAt.IsZero() {
+			t.Fatalf("GetAPIKeyByHash = %+v", key)
+		}
+
+		mustNoError(t, repo.RevokeAPIKey(ctx, second, now))
+		mustNoError(t, repo.RevokeAPIKey(ctx, second, now.Add(time.Hour)))
+		keys, err := repo.GetAPIKeys(ctx)
+		mustNoError(t, err)
+		if len(keys) != 2 || keys[0].ID != id || !keys[0].RevokedAt.IsZero() || !keys[1].RevokedAt.Equal(now) {
+			t.Errorf("GetAPIKeys = %+v, want the second key revoked once", keys)
+		}
+	})
+
+	t.Run("WebhookDeliveries", func(t *testing.T) {
+		repo := newRepo(t)
+		first, err := repo.AddWebhookDelivery(ctx, WebhookDelivery{URL: "https://example.com/a", Type: "checkin", Payload: `{"a":1}`, Status: webhookPending, NextAttemptAt: now, CreatedAt: now, UpdatedAt: now})
+		mustNoError(t, err)
+		second, err := repo.AddWebhookDelivery(ctx, WebhookDelivery{URL: "https://example.com/b", Type: "checkin", Payload: `{"b":1}`, Status: webhookPending, NextAttemptAt: now.Add(time.Minute), CreatedAt: now, UpdatedAt: now})
+		mustNoError(t, err)
+
+		due, err := repo.GetDueWebhookDeliveries(ctx, now, 10)
+		mustNoError(t, err)
+		if len(due) != 1 || due[0].ID != first || due[0].URL != "https://example.com/a" || due[0].Payload != `{"a":1}` || !due[0].NextAttemptAt.Equal(now) {
+			t.Fatalf("GetDueWebhookDeliveries = %+v", due)
+		}
+		if due, _ := repo.GetDueWebhookDeliveries(ctx, now.Add(time.Hour), 1); len(due) != 1 || due[0].ID != first {
+			t.Errorf("GetDueWebhookDeliveries with limit 1 = %+v, want the oldest", due)
+		}
+
+		failed := due[0]
+		failed.Attempts, failed.Status, failed.LastError, failed.UpdatedAt = 3, webhookFailed, "timeout", now.Add(time.Second)
+		mustNoError(t, repo.UpdateWebhookDelivery(ctx, failed))
+		if due, _ := repo.GetDueWebhookDeliveries(ctx, now.Add(time.Hour), 10); len(due) != 1 || due[0].ID != second {
+			t.Errorf("GetDueWebhookDeliveries after the failure = %+v, want only the pending one", due)
+		}
+		failures, err := repo.GetWebhookFailures(ctx, 10)
+		mustNoError(t, err)
+		if len(failures) != 1 || failures[0].Attempts != 3 || failures[0].LastError != "timeout" || !failures[0].UpdatedAt.Equal(now.Add(time.Second)) {
+			t.Errorf("GetWebhookFailures = %+v", failures)
+		}
+
+		mustNoError(t, repo.DeleteWebhookDelivery(ctx, second))
+		if due, _ := repo.GetDueWebhookDeliveries(ctx, now.Add(time.Hour), 10); len(due) != 0 {
+			t.Errorf("GetDueWebhookDeliveries after delete = %+v", due)
+		}
+	})
+
+	t.Run("ImportRegistrations", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "Current", now.AddDate(0, 0, 7), 10))
+		event := mustEvent(t, repo)
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", EventID: event.id, Registred: 1, RegistrationDate: now}))
+
+		mustNoError(t, repo.ImportRegistrations(ctx, []RegistrationImport{
+			{EventID: event.id, Registrations: []UserRegistration{
+				{TelegramID: 2, Username: "anna", Name: "Petrova Anna", Email: "anna@example.com", Registred: 1, RegistrationDate: now},
+				{Username: "Oleg", Name: "Olegov Oleg", Registred: 1, RegistrationDate: now},
+			}},
+			{EventName: "Spring", EventDate: now.AddDate(0, -6, 0), Registrations: []UserRegistration{
+				{Username: "oleg", Registred: 1, Visited: 1, RegistrationDate: now},
+				{TelegramID: 1, Username: "ivan", Registred: 1, RegistrationDate: now},
+				{Username: "walkin", Visited: 1, RegistrationDate: now},
+				{Username: "petr", Name: "Petrov Petr", Email: "petr@example.com", Visited: 1, RegistrationDate: now},
+			}},
+		}))
+		events, err := repo.GetEvents(ctx)
+		mustNoError(t, err)
+		if len(events) != 2 || events[0].registrationCount != 2 || events[1].name != "Spring" || events[1].state != "past" ||
+			events[1].capacity != 2 || events[1].registrationCount != 2 || !events[1].date.Equal(now.AddDate(0, -6, 0)) {
+			t.Fatalf("events after the import = %+v", events)
+		}
+		if registered, reg, _ := repo.IsUserRegistered(ctx, 2, event.id); !registered || reg.Email != "anna@example.com" {
+			t.Errorf("imported registration of anna = %v, %+v", registered, reg)
+		}
+		if id, _ := repo.FindTelegramIDByUsername(ctx, "oleg"); id != 0 {
+			t.Errorf("FindTelegramIDByUsername of a user without a Telegram ID = %d, want 0", id)
+		}
+
+		// The user registered for the current event on their own, only the past one is linked
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{TelegramID: 4, Username: "oleg", EventID: event.id, Registred: 1, RegistrationDate: now}))
+		claimed, err := repo.ClaimImportedRegistrations(ctx, 4, "OLEG")
+		mustNoError(t, err)
+		if claimed != 1 {
+			t.Errorf("ClaimImportedRegistrations = %d, want 1", claimed)
+		}
+		if registered, reg, _ := repo.IsUserRegistered(ctx, 4, events[1].id); !registered || reg.Visited != 1 {
+			t.Errorf("linked registration = %v, %+v", registered, reg)
+		}
+		if claimed, _ := repo.ClaimImportedRegistrations(ctx, 4, "oleg"); claimed != 0 {
+			t.Errorf("second ClaimImportedRegistrations = %d, want 0", claimed)
+		}
+		// The email of a row is not handed to whoever holds the username now
+		if claimed, _ := repo.ClaimImportedRegistrations(ctx, 5, "petr"); claimed != 0 {
+			t.Errorf("ClaimImportedRegistrations of a row with an email = %d, want 0", claimed)
+		}
+		if claimed, _ := repo.ClaimImportedRegistrations(ctx, 5, ""); claimed != 0 {
+			t.Errorf("ClaimImportedRegistrations without a username = %d, want 0", claimed)
+		}
+	})
+
+	t.Run("RegistrationsByID", func(t *testing.T) {
+		repo := newRepo(t)
+		mustNoError(t, repo.AddEvent(ctx, "Meetup", now, 10))
+		event := mustEvent(t, repo)
+		mustNoError(t, repo.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: event.id, Registred: 1, RegistrationDate: now}))
+		// Two registrations without a Telegram ID share the key (telegram_id, event_id)
+		mustNoError(t, repo.ImportRegistrations(ctx, []RegistrationImport{{EventID: event.id, Registrations: []UserRegistration{
+			{Name: "Olegov Oleg", Registred: 1, RegistrationDate: now},
+			{Name: "Petrov Petr", Registred: 1, RegistrationDate: now},
+		}}}))
+
+		registrations, err := repo.GetEventRegistrations(ctx, event.id)
+		mustNoError(t, err)
+		if len(registrations) != 3 || registrations[0].ID == 0 || registrations[1].ID == registrations[2].ID {
+			t.Fatalf("GetEventRegistrations = %+v, want 3 rows with their IDs", registrations)
+		}
+		_, ivan, _ := repo.IsUserRegistered(ctx, 1, event.id)
+		if ivan == nil || ivan.ID != registrations[0].ID {
+			t.Errorf("IsUserRegistered(1) = %+v, want the row %d", ivan, registrations[0].ID)
+		}
+		oleg, petr := registrations[1], registrations[2]
+
+		mustNoError(t, repo.UpdateVisitedStatusByID(ctx, oleg.ID, 1))
+		mustNoError(t, repo.CancelRegistrationByID(ctx, petr.ID))
+		if reg, err := repo.GetRegistrationByID(ctx, oleg.ID); err != nil || reg == nil || reg.Name != "Olegov Oleg" || reg.Visited != 1 || reg.Registred != 1 {
+			t.Errorf("GetRegistrationByID(oleg) = %+v, %v", reg, err)
+		}
+		if reg, err := repo.GetRegistrationByID(ctx, petr.ID); err != nil || reg == nil || reg.Visited != 0 || reg.Registred != 0 {
+			t.Errorf("GetRegistrationByID(petr) = %+v, %v", reg, err)
+		}
+		if reg, err := repo.GetRegistrationByID(ctx, 1000); err != nil || reg != nil {
+			t.Errorf("GetRegistrationByID of an unknown row = %+v, %v; want nil, nil", reg, err)
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		mustNoError(t, newRepo(t).Ping(ctx))
+	})
+}
+
+// mustEvent returns the active event and fails the test if there is none
+func mustEvent(t *testing.T, repo Repository) *Event {
+	t.Helper()
+	event, err := repo.GetLatestEvent(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil {
+		t.Fatal("no active event")
+	}
+	return event
+}
+
+// mustNoError fails the test on an error
+func mustNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Role is a named set of permissions granted to a Telegram user
+type Role string
+
+const (
+	RoleOwner     Role = "owner"     // Owner can do everything, including managing roles
+	RoleOrganizer Role = "organizer" // Organizer manages events, registrations and exports
+	RoleVolunteer Role = "volunteer" // Volunteer helps with check-in at the door
+)
+
+// Permission is an action a command requires
+type Permission string
+
+const (
+	PermEvents        Permission = "events"        // Create and archive events, import registrations
+	PermCheckin       Permission = "checkin"       // Check-in QR codes and marking attendance
+	PermExport        Permission = "export"        // Export registrations with personal data
+	PermRegistrations Permission = "registrations" // Remove users from registrations
+	PermTemplates     Permission = "templates"     // Edit message templates
+	PermRoles         Permission = "roles"         // Grant and revoke roles
+	PermAudit         Permission = "audit"         // View and export the audit log
+)
+
+// rolePermissions maps every role to the permissions it grants
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:     {PermEvents, PermCheckin, PermExport, PermRegistrations, PermTemplates, PermRoles, PermAudit},
+	RoleOrganizer: {PermEvents, PermCheckin, PermExport, PermRegistrations, PermTemplates, PermAudit},
+	RoleVolunteer: {PermCheckin},
+}
+
+// allRoles lists the roles in order of decreasing privileges
+var allRoles = []Role{RoleOwner, RoleOrganizer, RoleVolunteer}
+
+// ParseRole converts a role name into a Role
+func ParseRole(name string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	_, ok := rolePermissions[role]
+	return role, ok
+}
+
+// Can checks if the role grants the permission
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// userRole returns the role of the user, or an empty role if the user has none.
+// While nobody has a role, the first command of a user listed in ADMIN_USERS seeds the owners,
+// see seedOwners. Roles are stored by Telegram ID, so they survive a username change and
+// /revoke removes them like any other; a username freed later doesn't grant anything.
+func userRole(ctx context.Context, db Repository, user *tgbotapi.User) Role {
+	if user == nil {
+		return ""
+	}
+	role, err := db.GetUserRole(ctx, user.ID)
+	if err != nil {
+		slog.Error("Failed to get role", "user_id", user.ID, "error", err)
+		return ""
+	}
+	if role != "" {
+		return Role(role)
+	}
+	if !isBootstrapAdmin(user) {
+		return ""
+	}
+	if seeded, err := seedOwners(ctx, db, user); err != nil || !seeded {
+		return ""
+	}
+	role, err = db.GetUserRole(ctx, user.ID)
+	if err != nil {
+		slog.Error("Failed to get role", "user_id", user.ID, "error", err)
+		return ""
+	}
+	return Role(role)
+}
+
+// seedOwners grants the owner role to every ADMIN_USERS entry, once: only while nobody has a role.
+// It runs on startup and on the first command of an admin. Telegram IDs are granted as they are,
+// usernames only if the bot knows their Telegram ID, or if they are the user sending the command,
+// which may be nil. The usernames left over are logged, they need /grant or their ID instead.
+// Returns whether the owners were seeded.
+func seedOwners(ctx context.Context, db Repository, user *tgbotapi.User) (bool, error) {
+	if AppConfig == nil || len(AppConfig.AdminUsers) == 0 {
+		return false, nil
+	}
+	roles, err := db.GetUserRoles(ctx)
+	if err != nil {
+		slog.Error("Failed to get roles", "error", err)
+		return false, err
+	}
+	if len(roles) > 0 {
+		return false, nil
+	}
+
+	owners := make(map[int]string)
+	var unknown []string
+	for _, admin := range AppConfig.AdminUsers {
+		if id, err := strconv.Atoi(admin); err == nil {
+			if user != nil && id == user.ID {
+				owners[id] = user.UserName
+			} else if _, ok := owners[id]; !ok {
+				owners[id] = ""
+			}
+			continue
+		}
+		username := strings.TrimPrefix(admin, "@")
+		if user != nil && strings.EqualFold(username, user.UserName) {
+			owners[user.ID] = user.UserName
+			continue
+		}
+		id, err := db.FindTelegramIDByUsername(ctx, username)
+		if err != nil {
+			slog.Error("Failed to find an ADMIN_USERS member", "username", username, "error", err)
+			return false, err
+		}
+		if id == 0 {
+			unknown = append(unknown, username)
+			continue
+		}
+		owners[id] = username
+	}
+	if len(owners) == 0 {
+		return false, nil
+	}
+
+	for id, username := range owners {
+		err := db.SetUserRole(ctx, UserRole{TelegramID: id, Username: username, Role: string(RoleOwner), GrantedAt: time.Now()})
+		if err != nil {
+			slog.Error("Failed to seed owner", "user_id", id, "username", username, "error", err)
+			return false, err
+		}
+		slog.Info("Granted owner role from ADMIN_USERS", "user_id", id, "username", username)
+	}
+	if len(unknown) > 0 {
+		slog.Warn("ADMIN_USERS members the bot doesn't know yet got no role, grant it with /grant or list their Telegram IDs", "usernames", unknown)
+	}
+	return true, nil
+}
+
+// HasPermission checks if the user's role grants the permission
+func HasPermission(ctx context.Context, db Repository, user *tgbotapi.User, perm Permission) bool {
+	return userRole(ctx, db, user).Can(perm)
+}
+
+// isBootstrapAdmin checks if the user is in the ADMIN_USERS list, by username or Telegram ID
+func isBootstrapAdmin(user *tgbotapi.User) bool {
+	if AppConfig == nil {
+		return false
+	}
+	for _, admin := range AppConfig.AdminUsers {
+		if id, err := strconv.Atoi(admin); err == nil {
+			if id == user.ID {
+				return true
+			}
+		} else if user.UserName != "" && strings.EqualFold(strings.TrimPrefix(admin, "@"), user.UserName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRolePermissions(t *testing.T) {
+	for _, test := range []struct {
+		role Role
+		perm Permission
+		want bool
+	}{
+		{RoleOwner, PermRoles, true},
+		{RoleOrganizer, PermRoles, false},
+		{RoleOrganizer, PermExport, true},
+		{RoleOrganizer, PermAudit, true},
+		{RoleVolunteer, PermCheckin, true},
+		{RoleVolunteer, PermExport, false},
+		{RoleVolunteer, PermRegistrations, false},
+		{"", PermCheckin, false},
+	} {
+		if got := test.role.Can(test.perm); got != test.want {
+			t.Errorf("%q.Can(%q) = %v, want %v", test.role, test.perm, got, test.want)
+		}
+	}
+	if role, ok := ParseRole(" Volunteer "); !ok || role != RoleVolunteer {
+		t.Errorf("ParseRole = %q, %v", role, ok)
+	}
+	if _, ok := ParseRole("admin"); ok {
+		t.Error("unknown role parsed")
+	}
+}
+
+func TestGrantRevoke(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	mustNoError(t, db.RegisterUser(ctx, UserRegistration{TelegramID: 1, Username: "ivan", Name: "Ivanov Ivan", EventID: 1, Registred: 1}))
+	send := func(user int, text string) []string {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: text}.update(1))
+		return sender.texts()
+	}
+	denied := func(user int, text string) bool {
+		t.Helper()
+		texts := send(user, text)
+		return len(texts) == 1 && texts[0] == T("en", "permission_denied")
+	}
+	role := func(id int) string {
+		role, _ := db.GetUserRole(ctx, id)
+		return role
+	}
+
+	for text, want := range map[string]string{
+		"/grant 2":                 T("en", "grant_usage", roleNames()),
+		"/grant 2 admin":           T("en", "role_unknown", "admin", roleNames()),
+		"/grant @nobody org":       T("en", "role_unknown", "org", roleNames()),
+		"/grant @nobody volunteer": T("en", "user_unknown", "@nobody"),
+		"/revoke":                  T("en", "revoke_usage"),
+		"/revoke 3":                T("en", "role_revoke_self"),
+	} {
+		if texts := send(3, text); len(texts) != 1 || texts[0] != want {
+			t.Errorf("%s = %q, want %q", text, texts, want)
+		}
+	}
+
+	// A volunteer checks in, but can't export or hand out roles
+	if texts := send(3, "/grant 2 volunteer"); len(texts) != 1 || texts[0] != T("en", "role_granted", "2", "volunteer") || role(2) != string(RoleVolunteer) {
+		t.Fatalf("/grant = %q, role %q", texts, role(2))
+	}
+	if denied(2, "/checkin") || !denied(2, "/export") || !denied(2, "/grant 2 owner") || role(2) != string(RoleVolunteer) {
+		t.Error("permissions of a volunteer")
+	}
+
+	// A new role replaces the previous one, an organizer still can't manage roles
+	send(3, "/grant 2 organizer")
+	if role(2) != string(RoleOrganizer) || denied(2, "/log") || !denied(2, "/grant 1 organizer") || role(1) != "" {
+		t.Errorf("permissions of an organizer, role %q", role(2))
+	}
+
+	// Users are found by the username of their registration
+	if texts := send(3, "/grant @ivan volunteer"); len(texts) != 1 || texts[0] != T("en", "role_granted", "@ivan", "volunteer") || role(1) != string(RoleVolunteer) {
+		t.Errorf("/grant @ivan = %q, role %q", texts, role(1))
+	}
+
+	// The revoked role is checked on the next command
+	if texts := send(3, "/revoke 2"); len(texts) != 1 || texts[0] != T("en", "role_revoked", "2") || role(2) != "" {
+		t.Errorf("/revoke = %q, role %q", texts, role(2))
+	}
+	if !denied(2, "/log") {
+		t.Error("revoked organizer opened the audit log")
+	}
+
+	entries, _ := db.GetAuditLog(ctx, AuditFilter{UserID: 2})
+	if len(entries) != 3 || entries[0].Action != string(AuditRoleRevoke) || entries[1].Details != string(RoleOrganizer) ||
+		entries[2].Action != string(AuditRoleGrant) || entries[2].ActorID != 3 {
+		t.Errorf("audit log = %+v", entries)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// serveHTTP serves the monitoring endpoints, the API and the admin UI, if any, on addr until the process exits
+func serveHTTP(addr string, db Repository, health *Health, admin *AdminUI) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Metrics.Handler(db))
+	mux.Handle("GET /healthz", health.LivenessHandler())
+	mux.Handle("GET /readyz", health.ReadinessHandler(db))
+	mux.Handle("/api/", NewAPI(db).Handler())
+	if admin != nil {
+		mux.Handle("/admin/", admin.Handler())
+	}
+
+	slog.Info("HTTP server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("HTTP server stopped", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// maxMessageLength is the Telegram limit for a text message
+const maxMessageLength = 4096
+
+// editableTemplates lists the catalog keys organizers may override with /settemplate
+var editableTemplates = []string{
+	"welcome",
+	"registration_complete",
+	"visit_updated",
+	"visit_walk_in",
+}
+
+// TemplateData holds the placeholders available in message templates
+type TemplateData struct {
+	EventName string // EventName is the name of the active event.
+	EventDate string // EventDate is the date of the active event, formatted as DD.MM.YYYY.
+	Capacity  int    // Capacity is the maximum number of participants.
+	SeatsLeft int    // SeatsLeft is the number of remaining seats.
+	UserName  string // UserName is the user's full name.
+	Username  string // Username is the user's Telegram username.
+}
+
+// newTemplateData fills template placeholders from the event and the user, both may be nil
+func newTemplateData(event *Event, user *tgbotapi.User) TemplateData {
+	var data TemplateData
+	if event != nil {
+		data.EventName = event.name
+		data.EventDate = event.date.Format("02.01.2006")
+		data.Capacity = event.capacity
+		data.SeatsLeft = event.capacity - event.registrationCount
+	}
+	if user != nil {
+		data.UserName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		data.Username = user.UserName
+	}
+	return data
+}
+
+// sampleTemplateData is used to validate templates when there is no active event
+func sampleTemplateData() TemplateData {
+	return TemplateData{
+		EventName: "Meetup",
+		EventDate: time.Now().Format("02.01.2006"),
+		Capacity:  100,
+		SeatsLeft: 42,
+		UserName:  "Ivanov Ivan",
+		Username:  "ivanov",
+	}
+}
+
+// isEditableTemplate checks if the key can be overridden by organizers
+func isEditableTemplate(key string) bool {
+	for _, k := range editableTemplates {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// errTemplateTooLong stops the execution of a template that produces too much output
+var errTemplateTooLong = errors.New("rendered message is too long")
+
+// limitedBuffer is a buffer that refuses to grow past limit bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+// Write appends p to the buffer unless the limit is exceeded
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errTemplateTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+// executeMessageTemplate parses and executes a template body.
+// Unknown placeholders are reported as errors instead of being rendered empty,
+// and the output is capped so a template like {{range 1000000000}} can't exhaust memory.
+func executeMessageTemplate(key, body string, data TemplateData) (string, error) {
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", err
+	}
+	if err := checkTemplateNodes(tmpl.Tree.Root); err != nil {
+		return "", err
+	}
+	// A rune is at most 4 bytes in UTF-8
+	buf := &limitedBuffer{limit: maxMessageLength * 4}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// checkTemplateNodes rejects actions that make no sense for the flat TemplateData
+// but could keep the bot busy forever, such as {{range 1000000000}}{{end}}
+func checkTemplateNodes(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNodes(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		if err := checkTemplateNodes(n.List); err != nil {
+			return err
+		}
+		return checkTemplateNodes(n.ElseList)
+	case *parse.WithNode:
+		if err := checkTemplateNodes(n.List); err != nil {
+			return err
+		}
+		return checkTemplateNodes(n.ElseList)
+	case *parse.RangeNode:
+		return fmt.Errorf("{{range}} is not allowed in message templates")
+	case *parse.TemplateNode:
+		return fmt.Errorf("{{template}} is not allowed in message templates")
+	}
+	return nil
+}
+
+// validateMessageTemplate checks that a template can be saved: it must parse,
+// render with sample data and fit into a single Telegram message
+func validateMessageTemplate(key, body string) error {
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("template is empty")
+	}
+	text, err := executeMessageTemplate(key, body, sampleTemplateData())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("template renders to an empty message")
+	}
+	if len([]rune(text)) > maxMessageLength {
+		return fmt.Errorf("rendered message is longer than %d characters", maxMessageLength)
+	}
+	return nil
+}
+
+// renderMessage returns the organizer's template for the key if there is one,
+// otherwise the catalog text. A template that fails to render never breaks the
+// handler, the catalog text is used instead.
+func renderMessage(ctx context.Context, db Repository, lang, key string, data TemplateData) string {
+	tpl, err := db.GetMessageTemplate(ctx, key, lang)
+	if err != nil {
+		slog.Error("Failed to load template", "template", key, "language", lang, "error", err)
+		return T(lang, key)
+	}
+	if tpl == nil {
+		return T(lang, key)
+	}
+	text, err := executeMessageTemplate(key, tpl.Body, data)
+	if err != nil || strings.TrimSpace(text) == "" {
+		slog.Warn("Failed to render template", "template", key, "language", lang, "error", err)
+		return T(lang, key)
+	}
+	return text
+}
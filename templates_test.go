@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateMessageTemplate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		body string
+		want string // want is a part of the error, empty if the template is valid
+	}{
+		{"placeholders", "Hi {{.UserName}}, {{.SeatsLeft}} of {{.Capacity}} seats left for {{.EventName}} on {{.EventDate}}", ""},
+		{"condition", "{{if .Username}}@{{.Username}}{{else}}Hi{{end}}", ""},
+		{"empty", " \n ", "template is empty"},
+		{"renders empty", "{{if false}}Hi{{end}}", "renders to an empty message"},
+		{"syntax", "Hi {{.UserName", "unclosed action"},
+		{"unknown placeholder", "Hi {{.Email}}", "Email"},
+		{"range", "{{range 1000000000}}x{{end}}", "{{range}} is not allowed"},
+		{"range in a condition", "{{if true}}{{range 10}}x{{end}}{{end}}", "{{range}} is not allowed"},
+		{"template", `{{define "a"}}{{template "a"}}{{end}}{{template "a"}}`, "{{template}} is not allowed"},
+		{"longer than a message", strings.Repeat("я", maxMessageLength+1), "longer than 4096 characters"},
+	} {
+		err := validateMessageTemplate("welcome", test.body)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: validateMessageTemplate = %v, want %q", test.name, err, test.want)
+		}
+	}
+
+	// The output is capped while rendering, not after
+	body := strings.Repeat("{{.UserName}}", maxMessageLength*4/len(sampleTemplateData().UserName)+1)
+	if _, err := executeMessageTemplate("welcome", body, sampleTemplateData()); !errors.Is(err, errTemplateTooLong) {
+		t.Errorf("output over the limit: %v", err)
+	}
+}
+
+func TestSetTemplate(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	send := func(user int, text string) []string {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: text}.update(1))
+		return sender.texts()
+	}
+
+	if texts := send(1, "/settemplate welcome\nHi"); len(texts) != 1 || texts[0] != T("en", "permission_denied") {
+		t.Errorf("/settemplate of a user = %q", texts)
+	}
+
+	// The preview is rendered with the live data, users get the template instead of the catalog text
+	if texts := send(3, "/settemplate welcome\nHi {{.UserName}}, welcome to {{.EventName}}"); len(texts) != 2 ||
+		texts[0] != T("en", "template_saved", "welcome", "en") || texts[1] != "Hi Boss, welcome to Meetup" {
+		t.Errorf("/settemplate = %q", texts)
+	}
+	if texts := send(1, "/start"); len(texts) == 0 || texts[0] != "Hi Ivan Ivanov, welcome to Meetup" {
+		t.Errorf("/start with a template = %q", texts)
+	}
+	if entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditTemplateUpdate)}); len(entries) != 1 || entries[0].Details != "welcome/en" {
+		t.Errorf("audit log = %+v", entries)
+	}
+
+	// An invalid template is not saved
+	if texts := send(3, "/settemplate welcome\n{{range 1000000000}}spam{{end}}"); len(texts) != 1 ||
+		!strings.HasPrefix(texts[0], strings.TrimSuffix(T("en", "template_invalid", ""), " ")) {
+		t.Errorf("invalid template = %q", texts)
+	}
+	if texts := send(3, "/settemplate goodbye\nBye"); len(texts) != 1 || texts[0] != T("en", "template_unknown_key", "goodbye", strings.Join(editableTemplates, ", ")) {
+		t.Errorf("unknown template = %q", texts)
+	}
+	if tpl, _ := db.GetMessageTemplate(ctx, "welcome", "en"); tpl == nil || !strings.HasPrefix(tpl.Body, "Hi ") {
+		t.Errorf("template after the invalid one = %+v", tpl)
+	}
+
+	// A stored template that no longer renders falls back to the catalog text
+	mustNoError(t, db.SaveMessageTemplate(ctx, MessageTemplate{Key: "welcome", Language: "en", Body: "Hi {{.Email}}", UpdatedAt: time.Now()}))
+	if texts := send(1, "/start"); len(texts) == 0 || texts[0] != T("en", "welcome") {
+		t.Errorf("/start with a broken template = %q", texts)
+	}
+
+	if texts := send(3, "/settemplate welcome reset"); len(texts) != 2 || texts[0] != T("en", "template_reset", "welcome", "en") || texts[1] != T("en", "welcome") {
+		t.Errorf("reset = %q", texts)
+	}
+	if tpl, _ := db.GetMessageTemplate(ctx, "welcome", "en"); tpl != nil {
+		t.Errorf("template after the reset = %+v", tpl)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/skip2/go-qrcode"
+)
+
+// ticketPrefix starts the /start parameter of the ticket links
+const ticketPrefix = "ticket_"
+
+// ticketMACSize is the number of bytes of the HMAC kept in a ticket, 16 characters in base64
+const ticketMACSize = 12
+
+var errTicketInvalid = errors.New("invalid ticket")
+
+// ticketKey returns the key of the ticket signatures: TICKET_SECRET, or a key
+// derived from the bot token when it is not set
+func ticketKey() []byte {
+	if AppConfig.TicketSecret != "" {
+		return []byte(AppConfig.TicketSecret)
+	}
+	mac := hmac.New(sha256.New, []byte(AppConfig.BotToken))
+	mac.Write([]byte("meetupbot tickets"))
+	return mac.Sum(nil)
+}
+
+// ticketMAC signs the event and the attendee of a ticket
+func ticketMAC(eventID, telegramID int) []byte {
+	mac := hmac.New(sha256.New, ticketKey())
+	fmt.Fprintf(mac, "%d:%d", eventID, telegramID)
+	return mac.Sum(nil)[:ticketMACSize]
+}
+
+// ticketToken returns the ticket of an attendee for an event, like "ticket_7_123456_<signature>".
+// It only has characters allowed in a /start parameter and is well within its 64 characters.
+func ticketToken(eventID, telegramID int) string {
+	return fmt.Sprintf("%s%d_%d_%s", ticketPrefix, eventID, telegramID,
+		base64.RawURLEncoding.EncodeToString(ticketMAC(eventID, telegramID)))
+}
+
+// parseTicketToken checks the signature of a ticket and returns its event and attendee
+func parseTicketToken(token string) (eventID, telegramID int, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(token, ticketPrefix), "_", 3)
+	if !strings.HasPrefix(token, ticketPrefix) || len(parts) != 3 {
+		return 0, 0, errTicketInvalid
+	}
+	eventID, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errTicketInvalid
+	}
+	telegramID, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errTicketInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, ticketMAC(eventID, telegramID)) {
+		return 0, 0, errTicketInvalid
+	}
+	return eventID, telegramID, nil
+}
+
+// ticketFingerprint identifies a token in the logs without revealing it:
+// the first 8 bytes of its SHA-256 in hex
+func ticketFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sendTicket sends the ticket of an attendee as a QR code. A failure is only
+// logged, the attendee can get the ticket again with /ticket.
+func sendTicket(bot Sender, chatID int64, lang string, event *Event, telegramID int) {
+	link, err := Links.Start(TicketPayload(ticketToken(event.id, telegramID)))
+	if err != nil {
+		slog.Error("Failed to build the link of a ticket", "user_id", telegramID, "event_id", event.id, "error", err)
+		return
+	}
+	png, err := qrcode.Encode(link, qrcode.Medium, 512)
+	if err != nil {
+		slog.Error("Failed to generate a ticket", "user_id", telegramID, "event_id", event.id, "error", err)
+		return
+	}
+	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{Name: "ticket.png", Bytes: png})
+	photo.Caption = T(lang, "ticket_caption", event.name, event.date.Format("02.01.2006"))
+	if _, err := bot.Send(photo); err != nil {
+		slog.Error("Failed to send a ticket", "user_id", telegramID, "event_id", event.id, "error", err)
+	}
+}
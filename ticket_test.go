@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestTicketToken(t *testing.T) {
+	AppConfig = &Config{BotToken: "123:abc"}
+	token := ticketToken(7, 123456789)
+	if len(token) > 64 || strings.Trim(token, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") != "" {
+		t.Errorf("token %q is not a valid /start parameter", token)
+	}
+	if eventID, telegramID, err := parseTicketToken(token); err != nil || eventID != 7 || telegramID != 123456789 {
+		t.Errorf("parseTicketToken(%q) = %d, %d, %v", token, eventID, telegramID, err)
+	}
+
+	forged := strings.Replace(token, "_123456789_", "_123456780_", 1)
+	for _, bad := range []string{forged, token[:len(token)-1], "ticket_7_123456789", "imhere", ""} {
+		if _, _, err := parseTicketToken(bad); err == nil {
+			t.Errorf("parseTicketToken(%q) accepted a forged ticket", bad)
+		}
+	}
+
+	// Rejected tokens are logged by their fingerprint only
+	if fp := ticketFingerprint(forged); len(fp) != 16 || strings.Contains(forged, fp) || fp == ticketFingerprint(token) {
+		t.Errorf("ticketFingerprint(%q) = %q", forged, fp)
+	}
+
+	// Another bot token or a TICKET_SECRET gives other signatures
+	AppConfig.BotToken = "123:xyz"
+	if _, _, err := parseTicketToken(token); err == nil {
+		t.Error("ticket signed with another key accepted")
+	}
+	AppConfig.TicketSecret = "secret"
+	if ticketToken(7, 123456789) == token {
+		t.Error("TICKET_SECRET is not used")
+	}
+}
+
+func TestTicketCheckin(t *testing.T) {
+	sender := setupHandlers(t)
+	AppConfig.MandatoryFields = nil
+	ctx := context.Background()
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	mustNoError(t, db.SetUserRole(ctx, UserRole{TelegramID: 2, Username: "anna", Role: string(RoleVolunteer)}))
+
+	// The ticket comes with the registration and again with /ticket
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, data: "register"}.update(1))
+	Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "/ticket"}.update(2))
+	var tickets []tgbotapi.PhotoConfig
+	for _, sent := range sender.other {
+		if photo, ok := sent.(tgbotapi.PhotoConfig); ok {
+			tickets = append(tickets, photo)
+		}
+	}
+	if len(tickets) != 2 || tickets[0].ChatID != 1 || !strings.Contains(tickets[1].Caption, "Meetup") {
+		t.Fatalf("tickets = %+v", tickets)
+	}
+
+	token := ticketToken(1, 1)
+	scan := func(user int, token string) []string {
+		t.Helper()
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: user, text: "/start " + token}.update(3))
+		return sender.texts()
+	}
+	visited := func() int {
+		_, reg, _ := db.IsUserRegistered(ctx, 1, 1)
+		return reg.Visited
+	}
+
+	if texts := scan(1, token); len(texts) != 1 || texts[0] != T("en", "ticket_show_volunteer") || visited() != 0 {
+		t.Errorf("attendee scanning their own ticket = %q, visited %d", texts, visited())
+	}
+	if texts := scan(2, token[:len(token)-2]+"AA"); len(texts) != 1 || texts[0] != T("en", "ticket_invalid") || visited() != 0 {
+		t.Errorf("forged ticket = %q, visited %d", texts, visited())
+	}
+	if texts := scan(2, ticketToken(2, 1)); len(texts) != 1 || texts[0] != T("en", "ticket_wrong_event") {
+		t.Errorf("ticket of another event = %q", texts)
+	}
+	if texts := scan(2, ticketToken(1, 3)); len(texts) != 1 || texts[0] != T("en", "ticket_not_registered") {
+		t.Errorf("ticket without a registration = %q", texts)
+	}
+
+	texts := scan(2, token)
+	if len(texts) != 2 || texts[0] != T("en", "ticket_checked_in", "Ivan Ivanov (@ivan)") || sender.messages[1].ChatID != 1 || visited() != 1 {
+		t.Errorf("check-in = %q, visited %d", texts, visited())
+	}
+	entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditCheckin)})
+	if len(entries) != 1 || entries[0].ActorID != 2 || entries[0].TargetID != 1 || entries[0].Details != "ticket" {
+		t.Errorf("audit log = %+v", entries)
+	}
+
+	if texts := scan(2, token); len(texts) != 1 || texts[0] != T("en", "ticket_used", "Ivan Ivanov (@ivan)") {
+		t.Errorf("reused ticket = %q", texts)
+	}
+}
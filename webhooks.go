@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookPending = "pending" // webhookPending deliveries are attempted when they are due
+	webhookFailed  = "failed"  // webhookFailed deliveries ran out of attempts and are kept for /webhooks
+)
+
+const (
+	webhookPollInterval = 5 * time.Second  // webhookPollInterval is how often the queue is checked for due deliveries
+	webhookTimeout      = 10 * time.Second // webhookTimeout limits a single POST
+	webhookBatchSize    = 50               // webhookBatchSize is the number of deliveries attempted per poll
+	webhookMaxAttempts  = 10               // webhookMaxAttempts is the number of attempts before a delivery fails
+	webhookFirstRetry   = 30 * time.Second // webhookFirstRetry is the delay after the first failed attempt, it doubles after each one
+	webhookMaxRetry     = 6 * time.Hour    // webhookMaxRetry caps the delay between attempts
+)
+
+// webhookTypes maps the audit actions that trigger a webhook to the type of the payload
+var webhookTypes = map[AuditAction]string{
+	AuditRegister:        "registration.created",
+	AuditProfileUpdate:   "registration.updated",
+	AuditCancel:          "registration.cancelled",
+	AuditDialogCancel:    "registration.cancelled",
+	AuditAdminRemove:     "registration.cancelled",
+	AuditWaitlistJoin:    "waitlist.joined",
+	AuditWaitlistBook:    "waitlist.promoted",
+	AuditWaitlistPromote: "waitlist.promoted",
+	AuditCheckin:         "checkin",
+	AuditCheckinUndo:     "checkin.undone",
+	AuditEventCreate:     "event.created",
+}
+
+// webhookPayload is the JSON body posted to the webhook URLs
+type webhookPayload struct {
+	Type      string        `json:"type"`
+	Action    string        `json:"action"`
+	CreatedAt time.Time     `json:"created_at"`
+	Event     *webhookEvent `json:"event,omitempty"`
+	User      *webhookUser  `json:"user,omitempty"`
+	Actor     *webhookUser  `json:"actor,omitempty"`
+	Details   string        `json:"details,omitempty"`
+}
+
+// webhookEvent describes the event in a payload
+type webhookEvent struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Date       time.Time `json:"date"`
+	Capacity   int       `json:"capacity"`
+	Registered int       `json:"registered"`
+}
+
+// webhookUser describes the affected user or the actor in a payload
+type webhookUser struct {
+	TelegramID int    `json:"telegram_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+// enqueueWebhooks queues a delivery to every webhook URL if the audit entry is a lifecycle event.
+// The queue is in the database, so actions of the command-line interface are delivered by the running bot.
+func enqueueWebhooks(ctx context.Context, db Repository, entry AuditEntry) {
+	typ, ok := webhookTypes[AuditAction(entry.Action)]
+	if !ok || AppConfig == nil || len(AppConfig.WebhookURLs) == 0 {
+		return
+	}
+	payload, err := newWebhookPayload(ctx, db, typ, entry)
+	if err != nil {
+		slog.Error("Failed to build webhook payload", "action", entry.Action, "event_id", entry.EventID, "error", err)
+		return
+	}
+	for _, u := range AppConfig.WebhookURLs {
+		delivery := WebhookDelivery{
+			URL:           u,
+			Type:          typ,
+			Payload:       string(payload),
+			Status:        webhookPending,
+			NextAttemptAt: entry.CreatedAt,
+			CreatedAt:     entry.CreatedAt,
+			UpdatedAt:     entry.CreatedAt,
+		}
+		if _, err := db.AddWebhookDelivery(ctx, delivery); err != nil {
+			slog.Error("Failed to queue webhook delivery", "type", typ, "url", webhookHost(u), "error", err)
+		}
+	}
+}
+
+// newWebhookPayload builds the JSON body for an audit entry with the details of the event and the user
+func newWebhookPayload(ctx context.Context, db Repository, typ string, entry AuditEntry) ([]byte, error) {
+	payload := webhookPayload{
+		Type:      typ,
+		Action:    entry.Action,
+		CreatedAt: entry.CreatedAt,
+		Details:   entry.Details,
+	}
+	if entry.ActorID != 0 || entry.ActorUsername != "" {
+		payload.Actor = &webhookUser{TelegramID: entry.ActorID, Username: entry.ActorUsername}
+	}
+	if entry.EventID != 0 {
+		events, err := db.GetEvents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if event.id == entry.EventID {
+				payload.Event = &webhookEvent{
+					ID:         event.id,
+					Name:       event.name,
+					Date:       event.date,
+					Capacity:   event.capacity,
+					Registered: event.registrationCount,
+				}
+			}
+		}
+	}
+	if entry.TargetID != 0 || entry.TargetUsername != "" {
+		payload.User = &webhookUser{TelegramID: entry.TargetID, Username: entry.TargetUsername}
+		if entry.TargetID != 0 && entry.EventID != 0 {
+			_, reg, err := db.IsUserRegistered(ctx, entry.TargetID, entry.EventID)
+			if err != nil {
+				return nil, err
+			}
+			if reg != nil {
+				payload.User.Name = reg.Name
+				payload.User.Email = reg.Email
+			}
+		}
+	}
+	return json.Marshal(payload)
+}
+
+// signWebhook returns the signature of a payload sent in the X-Meetupbot-Signature header
+func signWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay returns the delay before the next attempt after the given number of failed ones
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookFirstRetry
+	for i := 1; i < attempts && delay < webhookMaxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxRetry)
+}
+
+// webhookHost returns the host of a webhook URL, the rest may contain a secret
+func webhookHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "?"
+	}
+	return u.Host
+}
+
+// WebhookDispatcher posts the queued webhook deliveries and retries failed ones with exponential backoff
+type WebhookDispatcher struct {
+	db     Repository
+	secret string
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhookDispatcher creates a dispatcher signing payloads with the secret.
+// A nil client uses one with webhookTimeout.
+func NewWebhookDispatcher(db Repository, secret string, client *http.Client) *WebhookDispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	return &WebhookDispatcher{db: db, secret: secret, client: client, now: time.Now}
+}
+
+// Run delivers due webhooks until the context is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to load webhook deliveries", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts the pending deliveries that are due
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) error {
+	deliveries, err := d.db.GetDueWebhookDeliveries(ctx, d.now(), webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d.attempt(ctx, delivery)
+	}
+	return nil
+}
+
+// attempt posts a delivery, removes it from the queue on success and schedules a retry otherwise
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery WebhookDelivery) {
+	err := d.post(ctx, delivery)
+	if err == nil {
+		Metrics.WebhookDeliveries.Inc("delivered")
+		if err := d.db.DeleteWebhookDelivery(ctx, delivery.ID); err != nil {
+			slog.Error("Failed to remove delivered webhook", "delivery_id", delivery.ID, "error", err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the delivery is attempted again after the restart
+		return
+	}
+
+	now := d.now()
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	delivery.UpdatedAt = now
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = webhookFailed
+		Metrics.WebhookDeliveries.Inc("failed")
+		slog.Error("Webhook delivery failed", "delivery_id", delivery.ID, "type", delivery.Type, "url", webhookHost(delivery.URL), "attempts", delivery.Attempts, "error", err)
+	} else {
+		delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+		Metrics.WebhookDeliveries.Inc("retry")
+		slog.Warn("Webhook delivery attempt failed", "delivery_id", delivery.ID, "type", delivery.Type, "url", webhookHost(delivery.URL), "attempts", delivery.Attempts, "next_attempt", delivery.NextAttemptAt, "error", err)
+	}
+	if err := d.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		slog.Error("Failed to update webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// post sends the payload of a delivery, any 2xx status means the receiver accepted it
+func (d *WebhookDispatcher) post(ctx context.Context, delivery WebhookDelivery) error {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "meetupbot/"+buildVersion())
+	req.Header.Set("X-Meetupbot-Event", delivery.Type)
+	req.Header.Set("X-Meetupbot-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Meetupbot-Signature", signWebhook(d.secret, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		// The error of the client repeats the URL, which may contain a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// formatWebhookFailure formats a failed delivery as a single line for the /webhooks message
+func formatWebhookFailure(lang string, delivery WebhookDelivery) string {
+	status := T(lang, "webhook_retrying", delivery.Attempts, webhookMaxAttempts, delivery.NextAttemptAt.Local().Format("02.01 15:04"))
+	if delivery.Status == webhookFailed {
+		status = T(lang, "webhook_failed", delivery.Attempts)
+	}
+	return delivery.UpdatedAt.Local().Format("02.01.2006 15:04:05") + " " + delivery.Type + " → " + webhookHost(delivery.URL) +
+		" #" + strconv.FormatInt(delivery.ID, 10) + " (" + status + "): " + delivery.LastError
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local webhook endpoint that records what it receives
+type webhookReceiver struct {
+	mu       sync.Mutex
+	fail     bool
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	rv.requests = append(rv.requests, r)
+	rv.bodies = append(rv.bodies, body)
+	if rv.fail {
+		http.Error(w, "sheet is locked", http.StatusInternalServerError)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	sender := setupHandlers(t)
+	ctx := context.Background()
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	AppConfig.WebhookURLs = []string{server.URL + "/hook?token=s3cret"}
+	AppConfig.WebhookSecret = "shh"
+
+	db := NewMemoryRepository()
+	mustNoError(t, db.AddEvent(ctx, "Meetup", time.Now().AddDate(0, 0, 7), 10))
+	event := mustEvent(t, db)
+
+	// The clock of the dispatcher is ahead, so deliveries queued during the test are due
+	now := time.Now().Add(time.Minute)
+	dispatcher := NewWebhookDispatcher(db, "shh", server.Client())
+	dispatcher.now = func() time.Time { return now }
+
+	t.Run("Deliver", func(t *testing.T) {
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, data: "register"}.update(1))
+		Commands.HandleUpdate(ctx, sender, db, step{user: 1, text: "Ivanov Ivan"}.update(2))
+		// Removing a username nobody registered with cancels nothing
+		Metrics = NewBotMetrics()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/remove nobody"}.update(3))
+		mustNoError(t, dispatcher.DeliverDue(ctx))
+		if entries, _ := db.GetAuditLog(ctx, AuditFilter{Action: string(AuditAdminRemove)}); len(entries) != 1 || entries[0].Details != removedNotRegistered {
+			t.Errorf("audit log = %+v", entries)
+		}
+		if len(Metrics.Cancellations.series) != 0 {
+			t.Errorf("cancellations counted for a removal of nobody: %+v", Metrics.Cancellations.series)
+		}
+
+		if len(receiver.requests) != 2 {
+			t.Fatalf("received %d requests, want 2", len(receiver.requests))
+		}
+		for i, typ := range []string{"registration.created", "registration.updated"} {
+			r, body := receiver.requests[i], receiver.bodies[i]
+			if r.Header.Get("X-Meetupbot-Event") != typ || r.URL.Query().Get("token") != "s3cret" {
+				t.Errorf("request %d = %s %v", i, r.URL, r.Header)
+			}
+			mac := hmac.New(sha256.New, []byte("shh"))
+			mac.Write(body)
+			if r.Header.Get("X-Meetupbot-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("request %d has a wrong signature %q", i, r.Header.Get("X-Meetupbot-Signature"))
+			}
+		}
+
+		var payload webhookPayload
+		mustNoError(t, json.Unmarshal(receiver.bodies[1], &payload))
+		if payload.Type != "registration.updated" || payload.Event == nil || payload.Event.ID != event.id || payload.Event.Registered != 1 ||
+			payload.User == nil || payload.User.TelegramID != 1 || payload.User.Name != "Ivanov Ivan" {
+			t.Errorf("payload = %s", receiver.bodies[1])
+		}
+		if due, _ := db.GetDueWebhookDeliveries(ctx, now.Add(time.Hour), 10); len(due) != 0 {
+			t.Errorf("delivered webhooks are still queued: %+v", due)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		receiver.fail = true
+		receiver.requests, receiver.bodies = nil, nil
+		audit(ctx, db, AuditCheckin, testUsers[1], 1, "ivan", event.id, "registered")
+
+		mustNoError(t, dispatcher.DeliverDue(ctx))
+		failures, _ := db.GetWebhookFailures(ctx, 10)
+		if len(failures) != 1 || failures[0].Attempts != 1 || failures[0].Status != webhookPending || !failures[0].NextAttemptAt.Equal(now.Add(webhookFirstRetry)) {
+			t.Fatalf("failures after the first attempt = %+v", failures)
+		}
+		// Not due before the backoff has passed
+		mustNoError(t, dispatcher.DeliverDue(ctx))
+		if len(receiver.requests) != 1 {
+			t.Errorf("attempts before the retry is due = %d, want 1", len(receiver.requests))
+		}
+
+		for i := 1; i < webhookMaxAttempts; i++ {
+			now = now.Add(webhookRetryDelay(i))
+			mustNoError(t, dispatcher.DeliverDue(ctx))
+		}
+		failures, _ = db.GetWebhookFailures(ctx, 10)
+		if len(receiver.requests) != webhookMaxAttempts || len(failures) != 1 || failures[0].Status != webhookFailed {
+			t.Fatalf("after %d attempts failures = %+v", len(receiver.requests), failures)
+		}
+
+		// A failed delivery is not retried anymore
+		receiver.fail = false
+		now = now.Add(24 * time.Hour)
+		mustNoError(t, dispatcher.DeliverDue(ctx))
+		if len(receiver.requests) != webhookMaxAttempts {
+			t.Errorf("failed delivery attempted again")
+		}
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		sender.reset()
+		Commands.HandleUpdate(ctx, sender, db, step{user: 3, text: "/webhooks"}.update(3))
+		texts := sender.texts()
+		if len(texts) != 1 || !strings.Contains(texts[0], "checkin → 127.0.0.1") || !strings.Contains(texts[0], "500 Internal Server Error") ||
+			!strings.Contains(texts[0], T("en", "webhook_failed", webhookMaxAttempts)) || strings.Contains(texts[0], "s3cret") {
+			t.Errorf("/webhooks = %q", texts)
+		}
+	})
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: webhookMaxRetry} {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}